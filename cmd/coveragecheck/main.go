@@ -2,9 +2,11 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -12,35 +14,120 @@ import (
 func main() {
 	filePath := flag.String("file", "coverage.out", "path to go coverage profile")
 	min := flag.Float64("min", 90.0, "minimum required total coverage percent")
+	perPackage := flag.Bool("per-package", false, "also require every package to meet -per-package-min")
+	perPackageMin := flag.Float64("per-package-min", 90.0, "minimum required coverage percent per package")
+	jsonOut := flag.Bool("json", false, "write the result as JSON instead of text")
 	flag.Parse()
 
-	total, covered, err := readCoverage(*filePath)
+	packages, err := readCoverage(*filePath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "coveragecheck: %v\n", err)
 		os.Exit(1)
 	}
+	var total, covered float64
+	for _, c := range packages {
+		total += c.total
+		covered += c.covered
+	}
 	if total == 0 {
 		fmt.Fprintln(os.Stderr, "coveragecheck: no statements found in coverage profile")
 		os.Exit(1)
 	}
 
-	pct := (covered / total) * 100
-	fmt.Printf("total coverage: %.1f%% (min %.1f%%)\n", pct, *min)
-	if pct < *min {
+	report := coverageReport{
+		TotalStatements:   int64(total),
+		CoveredStatements: int64(covered),
+		Percent:           (covered / total) * 100,
+		Min:               *min,
+	}
+	report.Passed = report.Percent >= *min
+	if *perPackage {
+		names := make([]string, 0, len(packages))
+		for name := range packages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			c := packages[name]
+			pkg := packageReport{
+				Package:           name,
+				TotalStatements:   int64(c.total),
+				CoveredStatements: int64(c.covered),
+				Percent:           c.percent(),
+				Min:               *perPackageMin,
+			}
+			pkg.Passed = pkg.Percent >= *perPackageMin
+			if !pkg.Passed {
+				report.Passed = false
+			}
+			report.Packages = append(report.Packages, pkg)
+		}
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "coveragecheck: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		for _, pkg := range report.Packages {
+			mark := ""
+			if !pkg.Passed {
+				mark = "  below min"
+			}
+			fmt.Printf("%-60s %6.1f%%%s\n", pkg.Package, pkg.Percent, mark)
+		}
+		fmt.Printf("total coverage: %.1f%% (min %.1f%%)\n", report.Percent, *min)
+	}
+	if !report.Passed {
 		fmt.Fprintln(os.Stderr, "coveragecheck: threshold not met")
 		os.Exit(1)
 	}
 }
 
-func readCoverage(path string) (float64, float64, error) {
+// coverageReport is the -json output.
+type coverageReport struct {
+	TotalStatements   int64           `json:"total_statements"`
+	CoveredStatements int64           `json:"covered_statements"`
+	Percent           float64         `json:"percent"`
+	Min               float64         `json:"min"`
+	Passed            bool            `json:"passed"`
+	Packages          []packageReport `json:"packages,omitempty"`
+}
+
+type packageReport struct {
+	Package           string  `json:"package"`
+	TotalStatements   int64   `json:"total_statements"`
+	CoveredStatements int64   `json:"covered_statements"`
+	Percent           float64 `json:"percent"`
+	Min               float64 `json:"min"`
+	Passed            bool    `json:"passed"`
+}
+
+// stmtCounts tallies statements in one package of a coverage profile.
+type stmtCounts struct {
+	total   float64
+	covered float64
+}
+
+func (c *stmtCounts) percent() float64 {
+	if c.total == 0 {
+		return 100
+	}
+	return (c.covered / c.total) * 100
+}
+
+// readCoverage tallies statements per package, taking the package to be the
+// file path up to its last slash.
+func readCoverage(path string) (map[string]*stmtCounts, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
 	defer f.Close()
 
-	var total float64
-	var covered float64
+	packages := make(map[string]*stmtCounts)
 
 	scanner := bufio.NewScanner(f)
 	lineNo := 0
@@ -52,34 +139,44 @@ func readCoverage(path string) (float64, float64, error) {
 		}
 		if lineNo == 1 {
 			if !strings.HasPrefix(line, "mode:") {
-				return 0, 0, fmt.Errorf("invalid coverage profile header: %q", line)
+				return nil, fmt.Errorf("invalid coverage profile header: %q", line)
 			}
 			continue
 		}
 
 		fields := strings.Fields(line)
 		if len(fields) < 3 {
-			return 0, 0, fmt.Errorf("invalid coverage line %d: %q", lineNo, line)
+			return nil, fmt.Errorf("invalid coverage line %d: %q", lineNo, line)
 		}
 
 		numStmts, err := strconv.ParseFloat(fields[len(fields)-2], 64)
 		if err != nil {
-			return 0, 0, fmt.Errorf("invalid statement count at line %d: %w", lineNo, err)
+			return nil, fmt.Errorf("invalid statement count at line %d: %w", lineNo, err)
 		}
 		execCount, err := strconv.ParseFloat(fields[len(fields)-1], 64)
 		if err != nil {
-			return 0, 0, fmt.Errorf("invalid execution count at line %d: %w", lineNo, err)
+			return nil, fmt.Errorf("invalid execution count at line %d: %w", lineNo, err)
 		}
 
-		total += numStmts
+		file, _, _ := strings.Cut(fields[0], ":")
+		pkg := file
+		if i := strings.LastIndex(file, "/"); i >= 0 {
+			pkg = file[:i]
+		}
+		c, ok := packages[pkg]
+		if !ok {
+			c = &stmtCounts{}
+			packages[pkg] = c
+		}
+		c.total += numStmts
 		if execCount > 0 {
-			covered += numStmts
+			c.covered += numStmts
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return 0, 0, err
+		return nil, err
 	}
 
-	return total, covered, nil
+	return packages, nil
 }
@@ -3,12 +3,17 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -26,11 +31,138 @@ func main() {
 	if backendURL == "" {
 		backendURL = "http://localhost:8080"
 	}
+	requireHTTPS := true
+	if raw := os.Getenv("OCT_REQUIRE_HTTPS"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			log.Fatalf("invalid OCT_REQUIRE_HTTPS: %v", err)
+		}
+		requireHTTPS = v
+	}
+	if requireHTTPS {
+		if err := contracts.CheckSecureURL(backendURL); err != nil {
+			log.Fatalf("insecure OCT_BACKEND_URL: %v (set OCT_REQUIRE_HTTPS=false to allow it)", err)
+		}
+	}
 
 	daemon := agent.NewDaemon()
 	if agentID != "" {
 		daemon.SetAgentID(agentID)
 	}
+	if raw := os.Getenv("OCT_RUN_CONCURRENCY"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			log.Fatalf("invalid OCT_RUN_CONCURRENCY: %v", err)
+		}
+		daemon.SetRunConcurrency(n)
+	}
+	window, err := contracts.FreshnessWindowFromEnv(os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	daemon.SetFreshnessWindow(window)
+	if rawMin, rawMax := os.Getenv("OCT_PORT_MIN"), os.Getenv("OCT_PORT_MAX"); rawMin != "" || rawMax != "" {
+		minPort, errMin := strconv.Atoi(rawMin)
+		maxPort, errMax := strconv.Atoi(rawMax)
+		if errMin != nil || errMax != nil {
+			log.Fatalf("OCT_PORT_MIN and OCT_PORT_MAX must both be set to integers")
+		}
+		if err := daemon.SetPortRange(minPort, maxPort); err != nil {
+			log.Fatalf("invalid OCT_PORT_MIN/OCT_PORT_MAX: %v", err)
+		}
+	}
+	if raw := os.Getenv("OCT_SERVER_RESTARTS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			log.Fatalf("invalid OCT_SERVER_RESTARTS: %v", err)
+		}
+		daemon.SetServerRestarts(n)
+	}
+	if raw := os.Getenv("OCT_START_TIMEOUT"); raw != "" {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Fatalf("invalid OCT_START_TIMEOUT: %v", err)
+		}
+		daemon.SetStartTimeout(timeout)
+	}
+	idemSize, idemTTL := agent.DefaultIdempotencySize, agent.DefaultIdempotencyTTL
+	if raw := os.Getenv("OCT_IDEMPOTENCY_SIZE"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			log.Fatalf("invalid OCT_IDEMPOTENCY_SIZE: %v", err)
+		}
+		idemSize = n
+	}
+	if raw := os.Getenv("OCT_IDEMPOTENCY_TTL"); raw != "" {
+		ttl, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Fatalf("invalid OCT_IDEMPOTENCY_TTL: %v", err)
+		}
+		idemTTL = ttl
+	}
+	if err := daemon.SetIdempotency(idemSize, idemTTL); err != nil {
+		log.Fatalf("invalid idempotency cache config: %v", err)
+	}
+	backoffBase, backoffMax := agent.DefaultBackoffBase, agent.DefaultBackoffMax
+	if raw := os.Getenv("OCT_BACKOFF_BASE"); raw != "" {
+		base, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Fatalf("invalid OCT_BACKOFF_BASE: %v", err)
+		}
+		backoffBase = base
+	}
+	if raw := os.Getenv("OCT_BACKOFF_MAX"); raw != "" {
+		max, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Fatalf("invalid OCT_BACKOFF_MAX: %v", err)
+		}
+		backoffMax = max
+	}
+	if err := daemon.SetBackoff(backoffBase, backoffMax); err != nil {
+		log.Fatalf("invalid backoff config: %v", err)
+	}
+	if raw := os.Getenv("OCT_ENABLED_COMMANDS"); raw != "" {
+		// Skip blank entries so a stray or trailing comma is not read as a
+		// command type.
+		var types []string
+		for _, t := range strings.Split(raw, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, t)
+			}
+		}
+		if err := daemon.SetEnabledCommandTypes(types); err != nil {
+			log.Fatalf("invalid OCT_ENABLED_COMMANDS: %v", err)
+		}
+	}
+	if raw := os.Getenv("OCT_ALLOWED_ROOTS"); raw != "" {
+		if err := daemon.SetAllowedRoots(filepath.SplitList(raw)); err != nil {
+			log.Fatalf("invalid OCT_ALLOWED_ROOTS: %v", err)
+		}
+	}
+	if raw := os.Getenv("OCT_READINESS_PATH"); raw != "" {
+		daemon.SetReadinessPath(raw)
+	}
+	if raw := os.Getenv("OCT_PROGRESS_UPDATES"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			log.Fatalf("invalid OCT_PROGRESS_UPDATES: %v", err)
+		}
+		daemon.SetProgressUpdates(enabled)
+	}
+	if raw := os.Getenv("OCT_MAX_ATTACHMENT_BYTES"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			log.Fatalf("invalid OCT_MAX_ATTACHMENT_BYTES: %v", err)
+		}
+		daemon.SetMaxAttachmentBytes(n)
+	}
+	if bin := os.Getenv("OCT_OPENCODE_BIN"); bin != "" {
+		daemon.SetServeCommand(bin)
+		daemon.SetRunCommand(bin)
+	}
+	if err := daemon.CheckCommands(); err != nil {
+		log.Fatalf("%v (install opencode or set OCT_OPENCODE_BIN)", err)
+	}
 
 	// HTTP server for readiness check
 	mux := http.NewServeMux()
@@ -72,30 +204,27 @@ func main() {
 		}
 	}()
 
-	// Create poll client
+	// Create poll client; progress and result posts share its pooled connections
 	pollClient := &BackendPollClient{
 		backendURL: backendURL,
 		agentKey:   agentKey,
-		client:     &http.Client{Timeout: 60 * time.Second},
+		client:     &http.Client{Timeout: 60 * time.Second, Transport: transportFromEnv()},
 	}
 
-	// Start poll loop in a goroutine
-	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		log.Println("starting poll loop")
-		daemon.RunPollLoop(ctx, pollClient, 25)
-	}()
-
-	// Wait for shutdown signal
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
+	// Poll until a shutdown signal. The signal cancels ctx, which cancels
+	// running tasks; RunPollLoop returns once they have posted their results.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	log.Println("starting poll loop")
+	daemon.RunPollLoop(ctx, pollClient, 25)
 	log.Println("shutting down...")
 
 	// Graceful shutdown
-	cancel()
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
+	if err := daemon.Shutdown(shutdownCtx); err != nil {
+		log.Printf("opencode server shutdown error: %v", err)
+	}
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		log.Printf("HTTP server shutdown error: %v", err)
 	}
@@ -107,6 +236,17 @@ type BackendPollClient struct {
 	backendURL string
 	agentKey   string
 	client     *http.Client
+
+	// requestIDs maps a delivered command ID to the request ID of the poll
+	// that delivered it, so the command's progress and result posts reuse it.
+	mu         sync.Mutex
+	requestIDs map[string]string
+}
+
+func newPollRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return "poll-" + hex.EncodeToString(b[:])
 }
 
 func (c *BackendPollClient) PollCommand(ctx context.Context, timeoutSeconds int) (*contracts.Command, error) {
@@ -118,6 +258,9 @@ func (c *BackendPollClient) PollCommand(ctx context.Context, timeoutSeconds int)
 		return nil, err
 	}
 	req.Header.Set("Authorization", "Bearer "+c.agentKey)
+	req.Header.Set(contracts.APIVersionHeader, contracts.APIVersion)
+	requestID := newPollRequestID()
+	req.Header.Set(contracts.RequestIDHeader, requestID)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -128,6 +271,13 @@ func (c *BackendPollClient) PollCommand(ctx context.Context, timeoutSeconds int)
 	if resp.StatusCode == http.StatusNoContent {
 		return nil, nil
 	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := time.Second
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+		return nil, &agent.RateLimitedError{RetryAfter: retryAfter}
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, &httpError{StatusCode: resp.StatusCode}
 	}
@@ -138,6 +288,14 @@ func (c *BackendPollClient) PollCommand(ctx context.Context, timeoutSeconds int)
 	if err := json.NewDecoder(resp.Body).Decode(&pollResp); err != nil {
 		return nil, err
 	}
+	if pollResp.Command != nil {
+		c.mu.Lock()
+		if c.requestIDs == nil {
+			c.requestIDs = make(map[string]string)
+		}
+		c.requestIDs[pollResp.Command.CommandID] = requestID
+		c.mu.Unlock()
+	}
 	return pollResp.Command, nil
 }
 
@@ -153,6 +311,17 @@ func (c *BackendPollClient) PostResult(ctx context.Context, result contracts.Com
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+c.agentKey)
+	req.Header.Set(contracts.APIVersionHeader, contracts.APIVersion)
+	c.mu.Lock()
+	requestID, ok := c.requestIDs[result.CommandID]
+	if result.IsTerminal() {
+		delete(c.requestIDs, result.CommandID)
+	}
+	c.mu.Unlock()
+	if !ok {
+		requestID = newPollRequestID()
+	}
+	req.Header.Set(contracts.RequestIDHeader, requestID)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -166,6 +335,32 @@ func (c *BackendPollClient) PostResult(ctx context.Context, result contracts.Com
 	return nil
 }
 
+// PostProgress sends a partial run_task result; the backend keeps only the latest.
+func (c *BackendPollClient) PostProgress(ctx context.Context, result contracts.CommandResult) error {
+	result.InProgress = true
+	return c.PostResult(ctx, result)
+}
+
+func transportFromEnv() *http.Transport {
+	maxIdle := 0
+	if raw := os.Getenv("OCT_HTTP_MAX_IDLE_CONNS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			log.Fatalf("invalid OCT_HTTP_MAX_IDLE_CONNS: %v", err)
+		}
+		maxIdle = n
+	}
+	var idleTimeout time.Duration
+	if raw := os.Getenv("OCT_HTTP_IDLE_TIMEOUT"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Fatalf("invalid OCT_HTTP_IDLE_TIMEOUT: %v", err)
+		}
+		idleTimeout = d
+	}
+	return contracts.NewHTTPTransport(maxIdle, idleTimeout)
+}
+
 type httpError struct {
 	StatusCode int
 }
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"opencode-telegram/internal/backend"
+	"opencode-telegram/internal/proxy/contracts"
 )
 
+// defaultShutdownGrace bounds how long a shutdown waits for in-flight
+// requests; override with OCT_SHUTDOWN_GRACE.
+const defaultShutdownGrace = 30 * time.Second
+
 func main() {
 	addr := os.Getenv("OCT_BACKEND_ADDR")
 	if addr == "" {
@@ -15,6 +25,13 @@ func main() {
 	}
 
 	mem := backend.NewMemoryBackend()
+	if raw := os.Getenv("OCT_AGENT_ONLINE_WINDOW"); raw != "" {
+		window, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Fatalf("invalid OCT_AGENT_ONLINE_WINDOW: %v", err)
+		}
+		mem.SetOnlineWindow(window)
+	}
 	if dsn := os.Getenv("POSTGRES_DSN"); dsn != "" {
 		pgStore, err := backend.NewPostgresPairingStore(dsn)
 		if err != nil {
@@ -23,18 +40,141 @@ func main() {
 		mem.SetPairingPersistence(pgStore)
 		log.Printf("pairing store: postgres")
 	}
-	redisURL := os.Getenv("REDIS_URL")
-	if redisURL == "" {
-		redisURL = "redis://localhost:6379"
+	resultTTL := backend.DefaultResultTTL
+	if raw := os.Getenv("OCT_RESULT_TTL"); raw != "" {
+		ttl, err := time.ParseDuration(raw)
+		if err != nil || ttl <= 0 {
+			log.Fatalf("invalid OCT_RESULT_TTL %q: want a positive duration", raw)
+		}
+		resultTTL = ttl
 	}
-	redisClient, err := backend.NewRealRedisClient(redisURL)
-	if err != nil {
-		log.Fatalf("redis init error: %v", err)
+	redeliveryTTL := backend.DefaultRedeliveryTTL
+	if raw := os.Getenv("OCT_REDELIVERY_TTL"); raw != "" {
+		ttl, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Fatalf("invalid OCT_REDELIVERY_TTL: %v", err)
+		}
+		redeliveryTTL = ttl
+	}
+	if err := mem.SetRedeliveryTTL(redeliveryTTL); err != nil {
+		log.Fatalf("invalid OCT_REDELIVERY_TTL: %v", err)
+	}
+	var queue backend.CommandQueue
+	switch kind := os.Getenv("OCT_QUEUE_BACKEND"); kind {
+	case "", "redis":
+		redisURL := os.Getenv("REDIS_URL")
+		if redisURL == "" {
+			redisURL = "redis://localhost:6379"
+		}
+		redisClient, err := backend.NewRealRedisClient(redisURL)
+		if err != nil {
+			log.Fatalf("redis init error: %v", err)
+		}
+		redisQueue := backend.NewRedisQueue(redisClient)
+		redisQueue.SetResultTTL(resultTTL)
+		_ = redisQueue.SetRedeliveryTTL(redeliveryTTL)
+		queue = redisQueue
+	case "postgres":
+		dsn := os.Getenv("POSTGRES_DSN")
+		if dsn == "" {
+			log.Fatal("POSTGRES_DSN is required when OCT_QUEUE_BACKEND=postgres")
+		}
+		pgQueue, err := backend.NewPostgresQueue(dsn)
+		if err != nil {
+			log.Fatalf("postgres queue init error: %v", err)
+		}
+		pgQueue.SetResultTTL(resultTTL)
+		_ = pgQueue.SetRedeliveryTTL(redeliveryTTL)
+		queue = pgQueue
+		log.Printf("command queue: postgres")
+	case "memory":
+		// The in-memory queue loses pending commands on restart unless
+		// OCT_QUEUE_PERSISTENCE writes them through to PostgreSQL.
+		switch persist := os.Getenv("OCT_QUEUE_PERSISTENCE"); persist {
+		case "":
+			log.Printf("command queue: memory (not persisted)")
+		case "postgres":
+			dsn := os.Getenv("POSTGRES_DSN")
+			if dsn == "" {
+				log.Fatal("POSTGRES_DSN is required when OCT_QUEUE_PERSISTENCE=postgres")
+			}
+			queueStore, err := backend.NewPostgresQueueStore(dsn)
+			if err != nil {
+				log.Fatalf("postgres queue store init error: %v", err)
+			}
+			mem.SetQueuePersistence(queueStore)
+			if err := mem.RestoreQueue(); err != nil {
+				log.Fatalf("queue restore error: %v", err)
+			}
+			log.Printf("command queue: memory, persisted in postgres")
+		default:
+			log.Fatalf("invalid OCT_QUEUE_PERSISTENCE %q: want postgres", persist)
+		}
+		queue = mem
+	default:
+		log.Fatalf("invalid OCT_QUEUE_BACKEND %q: want redis, postgres or memory", kind)
 	}
-	queue := backend.NewRedisQueue(redisClient)
 	srv := backend.NewServer(mem, queue)
-	log.Printf("oct-backend listening on %s", addr)
-	if err := http.ListenAndServe(addr, srv); err != nil {
+	window, err := contracts.FreshnessWindowFromEnv(os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv.SetFreshnessWindow(window)
+	if rawRate, rawBurst := os.Getenv("OCT_POLL_RATE"), os.Getenv("OCT_POLL_BURST"); rawRate != "" || rawBurst != "" {
+		rate, burst := backend.DefaultPollRate, backend.DefaultPollBurst
+		var err error
+		if rawRate != "" {
+			if rate, err = strconv.ParseFloat(rawRate, 64); err != nil {
+				log.Fatalf("invalid OCT_POLL_RATE: %v", err)
+			}
+		}
+		if rawBurst != "" {
+			if burst, err = strconv.Atoi(rawBurst); err != nil {
+				log.Fatalf("invalid OCT_POLL_BURST: %v", err)
+			}
+		}
+		srv.SetPollRateLimit(rate, burst)
+	}
+	if raw := os.Getenv("OCT_MAX_COMMAND_BYTES"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid OCT_MAX_COMMAND_BYTES %q: want a positive byte count", raw)
+		}
+		srv.SetMaxCommandBytes(n)
+	}
+
+	grace := defaultShutdownGrace
+	if raw := os.Getenv("OCT_SHUTDOWN_GRACE"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Fatalf("invalid OCT_SHUTDOWN_GRACE: %v", err)
+		}
+		grace = d
+	}
+
+	// SIGINT/SIGTERM end long polls and result streams, then wait up to
+	// grace for the remaining requests before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	httpServer := &http.Server{Addr: addr, Handler: srv}
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("oct-backend listening on %s", addr)
+		errCh <- httpServer.ListenAndServe()
+	}()
+	select {
+	case err := <-errCh:
 		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutdown requested; draining requests (grace %s)", grace)
+	srv.Drain()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown incomplete after %s: %v", grace, err)
+		return
 	}
+	log.Printf("oct-backend stopped")
 }
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"opencode-telegram/internal/backend"
 	"opencode-telegram/internal/bot"
 	"opencode-telegram/pkg/store"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
@@ -14,29 +18,56 @@ func main() {
 		log.Fatal("TELEGRAM_BOT_TOKEN is required")
 	}
 
-	// store (memory for now)
-	st := store.NewMemoryStore()
+	// store: redis when configured so session mappings survive restarts
+	var st store.Store = store.NewMemoryStore()
+	if cfg.RedisURL != "" {
+		rc, err := backend.NewRealRedisClient(cfg.RedisURL)
+		if err != nil {
+			log.Fatalf("redis init error: %v", err)
+		}
+		rs := store.NewRedisStore(rc)
+		rs.SetSessionTTL(cfg.StoreSessionTTL)
+		st = rs
+	}
 
 	// opencode client
 	oc, err := bot.NewOpencodeClient(cfg.OpencodeBase, cfg.OpencodeAuth)
 	if err != nil {
 		log.Fatalf("opencode client init error: %v", err)
 	}
+	oc.SetRequestTimeout(cfg.OpencodeTimeout)
+	oc.SetHeartbeatTimeout(cfg.OpencodeHeartbeatTimeout)
+	oc.SetSessionCacheTTL(cfg.OpencodeSessionCacheTTL)
 
 	app, err := bot.NewBotApp(cfg, oc, st)
 	if err != nil {
 		log.Fatalf("telegram bot init error: %v", err)
 	}
 
+	// SIGINT/SIGTERM stop polling and interrupt outstanding Opencode requests
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// SIGHUP reloads the allowed/admin ID lists without a restart
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := app.ReloadConfig(); err != nil {
+				log.Printf("access reload failed: %v", err)
+			}
+		}
+	}()
+
 	fmt.Println("Starting Telegram bot in", cfg.TelegramMode, "mode")
 	// start event listener in background (best-effort)
 	go func() {
-		if err := app.StartEventListener(); err != nil {
+		if err := app.StartEventListenerContext(ctx); err != nil {
 			log.Printf("event listener error: %v", err)
 		}
 	}()
 	if cfg.TelegramMode == "polling" {
-		if err := app.StartPolling(); err != nil {
+		if err := app.StartPollingContext(ctx); err != nil {
 			log.Fatalf("polling error: %v", err)
 		}
 	} else {
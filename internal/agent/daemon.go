@@ -1,28 +1,103 @@
 package agent
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"opencode-telegram/internal/proxy/contracts"
 )
 
+// maxOutputBytes caps captured stdout/stderr per stream in run_task results.
+const maxOutputBytes = 8 * 1024
+
+// defaultReadinessPath is the Opencode health endpoint probed after start.
+const defaultReadinessPath = "/global/health"
+
+// defaultOpencodeCommand is the binary used for serve and run unless overridden.
+const defaultOpencodeCommand = "opencode"
+
+// DefaultIdempotencySize and DefaultIdempotencyTTL bound the replay cache of
+// idempotency keys unless SetIdempotency overrides them.
+const (
+	DefaultIdempotencySize = 1000
+	DefaultIdempotencyTTL  = 24 * time.Hour
+)
+
+// DefaultBackoffBase and DefaultBackoffMax shape the retry delay after a
+// failed poll or result post unless SetBackoff overrides them.
+const (
+	DefaultBackoffBase = 500 * time.Millisecond
+	DefaultBackoffMax  = 10 * time.Second
+)
+
+// resultPostTimeout bounds posting a final result. Results are posted
+// outside the poll context so those of runs cut short by shutdown still
+// reach the backend.
+const resultPostTimeout = 30 * time.Second
+
+// policySweepInterval is how often expired project policies are dropped.
+const policySweepInterval = time.Minute
+
+// Supervised servers wait restartBackoffBase before the first restart,
+// doubling per consecutive restart up to restartBackoffMax. A server that
+// stays up for restartResetAfter gets its full restart budget back.
+const (
+	restartBackoffBase = time.Second
+	restartBackoffMax  = 30 * time.Second
+	restartResetAfter  = 5 * time.Minute
+)
+
 type Handler func(ctx context.Context, cmd contracts.Command) (contracts.CommandResult, error)
 
 type PollClient interface {
 	PollCommand(ctx context.Context, timeoutSeconds int) (*contracts.Command, error)
 	PostResult(ctx context.Context, result contracts.CommandResult) error
+	PostProgress(ctx context.Context, result contracts.CommandResult) error
+}
+
+// RateLimitedError is returned by a PollClient when the backend throttles
+// polling. RunPollLoop waits RetryAfter before polling again instead of
+// treating it as a failure.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("poll rate limited, retry after %s", e.RetryAfter)
+}
+
+// ProgressFunc receives partial results while a command is still running.
+type ProgressFunc func(result contracts.CommandResult)
+
+type progressKey struct{}
+
+// WithProgress attaches fn to ctx so handlers can report partial results.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func progressFromContext(ctx context.Context) ProgressFunc {
+	fn, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	return fn
 }
 
 type Daemon struct {
@@ -33,23 +108,66 @@ type Daemon struct {
 
 	startTimeout   time.Duration
 	commandTimeout time.Duration
+	shutdownGrace  time.Duration
 	serveCommand   string
 	runCommand     string
 	headers        http.Header
 	client         *http.Client
 	execCommand    func(ctx context.Context, name string, args ...string) *exec.Cmd
 	readinessCheck func(ctx context.Context, port int) bool
+	readinessPath  string
+	// allowedRoots, when set, are the only directories projects may live
+	// under; the forbidden-path checks still apply inside them.
+	allowedRoots []string
 
 	mu             sync.RWMutex
 	handlers       map[string]Handler
 	mutatingTypes  map[string]bool
 	mutatingLocker sync.Mutex
+	runConcurrency int
+	runSlots       map[string]chan struct{}
+	// exclusiveRuns serializes exclusive acquires of a project's run slots,
+	// so two of them never each hold part of the slots.
+	exclusiveRuns map[string]*sync.Mutex
+	// tasks holds the cancel funcs of running run_task commands by command ID.
+	tasks map[string]context.CancelFunc
+	// runningTasks holds a channel per run_task being executed, by command
+	// ID, closed once its result is cached. A redelivered copy waits on it
+	// instead of starting a second run.
+	runningTasks map[string]chan struct{}
+	// enabledTypes, when set, are the only command types executed even if
+	// a handler is registered for others.
+	enabledTypes map[string]bool
+
+	progressUpdates  bool
+	progressInterval time.Duration
+
+	maxAttachmentBytes int64
+
+	freshness contracts.FreshnessWindow
 
 	idempotency *IdempotencyCache
+	processed   *ProcessedCommands
 	allocator   *PortAllocator
 	projects    map[string]string
+	// projectEnv holds each project's extra KEY=VALUE entries, sorted by key.
+	projectEnv map[string][]string
+	// runTimeouts holds the per-project run_task timeouts that replace
+	// commandTimeout.
+	runTimeouts map[string]time.Duration
 	policies    map[string]projectPolicy
 	servers     map[string]*serverState
+	// serverRestarts is how many consecutive times a crashed server is
+	// restarted; zero leaves crashed servers stopped.
+	serverRestarts int
+	restartBackoff time.Duration
+	// unhealthy holds the last exit error of servers that used up their
+	// restarts, by project ID, until the next successful start_server.
+	unhealthy map[string]string
+
+	// stopSweep ends the policy sweep goroutine; closed once by Shutdown.
+	stopSweep     chan struct{}
+	stopSweepOnce sync.Once
 
 	backoffBase time.Duration
 	backoffMax  time.Duration
@@ -61,6 +179,16 @@ type serverState struct {
 	ProjectPath string
 	Port        int
 	Cmd         *exec.Cmd
+
+	// done is closed once Cmd has exited.
+	done chan struct{}
+	// stopping is set before the daemon kills Cmd, so its exit is not
+	// mistaken for a crash.
+	stopping atomic.Bool
+	// restarting is set while a crashed server waits out its restart
+	// backoff and until its replacement passes the readiness check; such a
+	// server must not be handed out as ready.
+	restarting atomic.Bool
 }
 
 type projectPolicy struct {
@@ -76,32 +204,52 @@ func NewDaemon() *Daemon {
 		handlers:       make(map[string]Handler),
 		allocator:      NewPortAllocator(4096, 4196),
 		servers:        make(map[string]*serverState),
+		unhealthy:      make(map[string]string),
+		restartBackoff: restartBackoffBase,
 		projects:       make(map[string]string),
+		projectEnv:     make(map[string][]string),
+		runTimeouts:    make(map[string]time.Duration),
 		policies:       make(map[string]projectPolicy),
 		startTimeout:   10 * time.Second,
 		commandTimeout: 600 * time.Second,
-		serveCommand:   "opencode",
-		runCommand:     "opencode",
+		shutdownGrace:  5 * time.Second,
+		serveCommand:   defaultOpencodeCommand,
+		runCommand:     defaultOpencodeCommand,
 		client:         &http.Client{Timeout: 2 * time.Second},
 		execCommand:    exec.CommandContext,
 		readinessCheck: nil,
+		readinessPath:  defaultReadinessPath,
 		mutatingTypes: map[string]bool{
 			contracts.CommandTypeRegisterProject:    true,
 			contracts.CommandTypeApplyProjectPolicy: true,
 			contracts.CommandTypeStartServer:        true,
-			contracts.CommandTypeRunTask:            true,
+			contracts.CommandTypeStopServer:         true,
 		},
-		backoffBase: 500 * time.Millisecond,
-		backoffMax:  10 * time.Second,
-		jitter:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		runConcurrency:     1,
+		runSlots:           make(map[string]chan struct{}),
+		exclusiveRuns:      make(map[string]*sync.Mutex),
+		tasks:              make(map[string]context.CancelFunc),
+		runningTasks:       make(map[string]chan struct{}),
+		progressInterval:   2 * time.Second,
+		maxAttachmentBytes: contracts.DefaultMaxAttachmentBytes,
+		freshness:          contracts.DefaultFreshnessWindow,
+		backoffBase:        DefaultBackoffBase,
+		backoffMax:         DefaultBackoffMax,
+		jitter:             rand.New(rand.NewSource(time.Now().UnixNano())),
+		stopSweep:          make(chan struct{}),
 	}
-	d.idempotency = NewIdempotencyCache(1000, 24*time.Hour, d.now)
+	d.idempotency = NewIdempotencyCache(DefaultIdempotencySize, DefaultIdempotencyTTL, d.now)
+	d.processed = NewProcessedCommands(1000)
 	d.readinessCheck = d.waitForReady
 	d.handlers[contracts.CommandTypeRegisterProject] = d.handleRegisterProject
 	d.handlers[contracts.CommandTypeApplyProjectPolicy] = d.handleApplyProjectPolicy
 	d.handlers[contracts.CommandTypeStartServer] = d.handleStartServer
+	d.handlers[contracts.CommandTypeStopServer] = d.handleStopServer
 	d.handlers[contracts.CommandTypeRunTask] = d.handleRunTask
 	d.handlers[contracts.CommandTypeStatus] = d.handleStatus
+	d.handlers[contracts.CommandTypeCancelTask] = d.handleCancelTask
+	d.handlers[contracts.CommandTypeAbortSession] = d.handleAbortSession
+	go d.runPolicySweep(policySweepInterval)
 	return d
 }
 
@@ -111,22 +259,142 @@ func (d *Daemon) SetHandler(commandType string, handler Handler) {
 	d.handlers[commandType] = handler
 }
 
+// SetEnabledCommandTypes limits the daemon to the given command types; any
+// other type is refused with ErrValidationInvalidType, even when a handler is
+// registered for it. Every type must have a handler. An empty list enables
+// all registered types again.
+func (d *Daemon) SetEnabledCommandTypes(types []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(types) == 0 {
+		d.enabledTypes = nil
+		return nil
+	}
+	enabled := make(map[string]bool, len(types))
+	for _, t := range types {
+		t = strings.TrimSpace(t)
+		if _, ok := d.handlers[t]; !ok {
+			return fmt.Errorf("unknown command type %q", t)
+		}
+		enabled[t] = true
+	}
+	d.enabledTypes = enabled
+	return nil
+}
+
+func (d *Daemon) commandTypeEnabled(commandType string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.enabledTypes == nil || d.enabledTypes[commandType]
+}
+
 func (d *Daemon) SetAgentID(agentID string) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.agentID = agentID
 }
 
-func (d *Daemon) HandleCommand(ctx context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
-	if err := contracts.ValidateCommand(cmd); err != nil {
-		apiErr, ok := err.(contracts.APIError)
-		if !ok {
-			apiErr = contracts.APIError{Code: contracts.ErrInternal, Message: err.Error()}
+// SetRunConcurrency sets how many run_task commands may execute at once per
+// project (minimum 1). It applies to projects that have not run a task yet.
+func (d *Daemon) SetRunConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.runConcurrency = n
+}
+
+// acquireRunSlot blocks until a run_task slot for projectID is free and
+// returns the func that releases it. An exclusive acquire takes every slot
+// of the project, so no other run_task runs in it meanwhile.
+func (d *Daemon) acquireRunSlot(projectID string, exclusive bool) func() {
+	d.mu.Lock()
+	slots, ok := d.runSlots[projectID]
+	if !ok {
+		slots = make(chan struct{}, d.runConcurrency)
+		d.runSlots[projectID] = slots
+	}
+	gate, ok := d.exclusiveRuns[projectID]
+	if !ok {
+		gate = &sync.Mutex{}
+		d.exclusiveRuns[projectID] = gate
+	}
+	d.mu.Unlock()
+	if !exclusive {
+		slots <- struct{}{}
+		return func() { <-slots }
+	}
+	gate.Lock()
+	for i := 0; i < cap(slots); i++ {
+		slots <- struct{}{}
+	}
+	gate.Unlock()
+	return func() {
+		for i := 0; i < cap(slots); i++ {
+			<-slots
 		}
-		return contracts.CommandResult{CommandID: cmd.CommandID, OK: false, ErrorCode: apiErr.Code, Summary: apiErr.Message}, nil
 	}
+}
 
-	if cached, ok := d.idempotency.Get(cmd.IdempotencyKey); ok {
+// claimRunningTask registers commandID as executing. It reports false, with
+// the running copy's channel, when the command is already being executed.
+func (d *Daemon) claimRunningTask(commandID string) (chan struct{}, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if done, ok := d.runningTasks[commandID]; ok {
+		return done, false
+	}
+	done := make(chan struct{})
+	d.runningTasks[commandID] = done
+	return done, true
+}
+
+// finishRunningTask releases waiters on commandID once its result is cached.
+func (d *Daemon) finishRunningTask(commandID string, done chan struct{}) {
+	d.mu.Lock()
+	delete(d.runningTasks, commandID)
+	d.mu.Unlock()
+	close(done)
+}
+
+// joinRunningTask waits for the copy of commandID already executing and
+// returns its result, so a command redelivered mid-run is not run twice.
+func (d *Daemon) joinRunningTask(ctx context.Context, commandID string, done chan struct{}) contracts.CommandResult {
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return contracts.CommandResult{CommandID: commandID, OK: false, ErrorCode: contracts.ErrCancelled, Summary: "task cancelled"}
+	}
+	if res, ok := d.processed.Get(commandID); ok {
+		return res
+	}
+	return contracts.CommandResult{CommandID: commandID, OK: false, ErrorCode: contracts.ErrInternal, Summary: "duplicate delivery lost the running task's result"}
+}
+
+func (d *Daemon) HandleCommand(ctx context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
+	d.mu.RLock()
+	freshness := d.freshness
+	d.mu.RUnlock()
+	if err := contracts.ValidateCommandAt(cmd, d.now().UTC(), freshness); err != nil {
+		return errorResult(cmd.CommandID, err), nil
+	}
+	if !d.commandTypeEnabled(cmd.Type) {
+		return contracts.CommandResult{CommandID: cmd.CommandID, OK: false, ErrorCode: contracts.ErrValidationInvalidType, Summary: "command type disabled on this agent"}, nil
+	}
+	// A dry run executes nothing, so it skips the replay caches and locks:
+	// it must not replay a real result nor be replayed in place of one.
+	if cmd.DryRun {
+		return d.dryRun(cmd), nil
+	}
+
+	// A redelivered command (lost ack) replays its result even after the
+	// idempotency key has expired.
+	if done, ok := d.processed.Get(cmd.CommandID); ok {
+		return done, nil
+	}
+	idempotency := d.idempotencyCache()
+	if cached, ok := idempotency.Get(cmd.IdempotencyKey); ok {
 		return cached, nil
 	}
 
@@ -138,11 +406,7 @@ func (d *Daemon) HandleCommand(ctx context.Context, cmd contracts.Command) (cont
 	exec := func() contracts.CommandResult {
 		result, err := h(ctx, cmd)
 		if err != nil {
-			apiErr, ok := err.(contracts.APIError)
-			if !ok {
-				apiErr = contracts.APIError{Code: contracts.ErrInternal, Message: err.Error()}
-			}
-			return contracts.CommandResult{CommandID: cmd.CommandID, OK: false, ErrorCode: apiErr.Code, Summary: apiErr.Message}
+			return errorResult(cmd.CommandID, err)
 		}
 		if strings.TrimSpace(result.CommandID) == "" {
 			result.CommandID = cmd.CommandID
@@ -151,7 +415,24 @@ func (d *Daemon) HandleCommand(ctx context.Context, cmd contracts.Command) (cont
 	}
 
 	var out contracts.CommandResult
-	if d.mutatingTypes[cmd.Type] {
+	if cmd.Type == contracts.CommandTypeRunTask {
+		done, first := d.claimRunningTask(cmd.CommandID)
+		if !first {
+			return d.joinRunningTask(ctx, cmd.CommandID, done), nil
+		}
+		defer d.finishRunningTask(cmd.CommandID, done)
+		// run_task is limited per project instead of by the global mutating
+		// lock, so a long task does not block unrelated projects. A batch
+		// runs alone in its project: its later prompts --continue the
+		// project's most recent session, which must be its own. So does a
+		// task with a branch, or another run's checkout could switch the
+		// working tree under it.
+		var payload contracts.RunTaskPayload
+		_ = contracts.DecodeStrictJSON(cmd.Payload, &payload)
+		release := d.acquireRunSlot(payload.ProjectID, len(payload.Prompts) > 1 || payload.Branch != "")
+		out = exec()
+		release()
+	} else if d.mutatingTypes[cmd.Type] {
 		d.mutatingLocker.Lock()
 		out = exec()
 		d.mutatingLocker.Unlock()
@@ -159,11 +440,322 @@ func (d *Daemon) HandleCommand(ctx context.Context, cmd contracts.Command) (cont
 		out = exec()
 	}
 
-	d.idempotency.Put(cmd.IdempotencyKey, out)
+	idempotency.Put(cmd.IdempotencyKey, out)
+	d.processed.Put(cmd.CommandID, out)
 	return out, nil
 }
 
+// dryRun validates cmd, checks its policy and resolves its project the way
+// its handler would, and reports the project path, port and policy the
+// command would run with.
+func (d *Daemon) dryRun(cmd contracts.Command) contracts.CommandResult {
+	meta, err := d.planCommand(cmd)
+	if err != nil {
+		res := errorResult(cmd.CommandID, err)
+		if res.Meta == nil {
+			res.Meta = map[string]any{}
+		}
+		res.Meta["dry_run"] = true
+		return res
+	}
+	meta["dry_run"] = true
+	return contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "dry run: " + cmd.Type + " permitted", Meta: meta}
+}
+
+func (d *Daemon) planCommand(cmd contracts.Command) (map[string]any, error) {
+	var projectID string
+	meta := map[string]any{}
+	switch cmd.Type {
+	case contracts.CommandTypeStartServer:
+		payload, timeout, err := d.prepareStartServer(cmd)
+		if err != nil {
+			return nil, err
+		}
+		projectID = payload.ProjectID
+		meta["timeout_seconds"] = timeoutSeconds(timeout)
+	case contracts.CommandTypeRunTask:
+		prep, err := d.prepareRunTask(cmd)
+		if err != nil {
+			return nil, err
+		}
+		payload := prep.payload
+		// Nothing is checked out in a dry run, so with a branch the subdir
+		// can only be checked lexically; it may exist on that branch alone.
+		var dir string
+		if payload.Branch != "" {
+			if path, ok := d.projectPath(payload.ProjectID); ok {
+				dir = filepath.Join(path, payload.Subdir)
+			}
+		} else if dir, err = d.runDir(payload.ProjectID, payload.Subdir); err != nil {
+			return nil, err
+		}
+		projectID = payload.ProjectID
+		meta["run_dir"] = dir
+		meta["timeout_seconds"] = timeoutSeconds(prep.timeout)
+	default:
+		return nil, contracts.APIError{Code: contracts.ErrValidationInvalidType, Message: "dry run not supported for " + cmd.Type}
+	}
+	// The checks startServer makes before spawning anything.
+	if strings.TrimSpace(projectID) == "" {
+		return nil, contracts.RequiredFieldError("project_id")
+	}
+	if !d.policyAllows(projectID, contracts.ScopeStartServer) {
+		return nil, contracts.APIError{Code: contracts.ErrPolicyDenied, Message: "policy denied"}
+	}
+	path, ok := d.projectPath(projectID)
+	if !ok {
+		return nil, contracts.APIError{Code: contracts.ErrPathInvalid, Message: "project not registered"}
+	}
+	meta["project_id"] = projectID
+	meta["project_path"] = path
+	if current := d.readyServer(projectID); current != nil {
+		meta["port"] = current.Port
+		meta["server_running"] = true
+	} else {
+		port, err := d.allocator.Peek(projectID)
+		if err != nil {
+			return nil, err
+		}
+		meta["port"] = port
+		meta["server_running"] = false
+	}
+	d.mu.RLock()
+	policy := d.policies[projectID]
+	d.mu.RUnlock()
+	effective := map[string]any{"decision": policy.Decision, "scope": append([]string(nil), policy.Scope...)}
+	if policy.ExpiresAt != nil {
+		effective["expires_at"] = policy.ExpiresAt.Format(time.RFC3339Nano)
+	}
+	meta["policy"] = effective
+	return meta, nil
+}
+
+// errorResult turns a handler error into a failed result. An APIError keeps
+// its code and message and its Details become the result's Meta; any other
+// error is reported as ErrInternal.
+func errorResult(commandID string, err error) contracts.CommandResult {
+	apiErr, ok := err.(contracts.APIError)
+	if !ok {
+		apiErr = contracts.APIError{Code: contracts.ErrInternal, Message: err.Error()}
+	}
+	return contracts.CommandResult{CommandID: commandID, OK: false, ErrorCode: apiErr.Code, Summary: apiErr.Message, Meta: apiErr.Details}
+}
+
+// timeoutSeconds reports timeout in whole seconds, rounded up so a
+// sub-second timeout is not shown as 0.
+func timeoutSeconds(timeout time.Duration) int {
+	return int((timeout + time.Second - 1) / time.Second)
+}
+
+// payloadTimeout is the timeout a command asked for in timeout_seconds, or
+// limit when it asked for none. A request above limit is rejected rather
+// than silently shortened.
+func payloadTimeout(seconds int, limit time.Duration) (time.Duration, error) {
+	if seconds <= 0 {
+		return limit, nil
+	}
+	// Compare in seconds: converting a huge value to a Duration first
+	// would overflow and could slip under the limit.
+	if int64(seconds) > int64(limit/time.Second) {
+		return 0, contracts.APIError{
+			Code:    contracts.ErrValidationInvalidPayload,
+			Message: fmt.Sprintf("timeout_seconds exceeds the agent maximum of %d", timeoutSeconds(limit)),
+			Field:   "timeout_seconds",
+			Details: map[string]any{"max_seconds": timeoutSeconds(limit)},
+		}
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// SetPortRange sets the ports used for Opencode servers. The range must lie
+// within 1024-65535 with minPort < maxPort; running servers keep their ports.
+func (d *Daemon) SetPortRange(minPort, maxPort int) error {
+	if minPort < 1024 || maxPort > 65535 || minPort >= maxPort {
+		return fmt.Errorf("invalid port range %d-%d: need 1024 <= min < max <= 65535", minPort, maxPort)
+	}
+	d.allocator.SetRange(minPort, maxPort)
+	return nil
+}
+
+// SetReadinessPath sets the HTTP path probed on a started Opencode server
+// before start_server reports success. An empty path restores the default.
+func (d *Daemon) SetReadinessPath(path string) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		path = defaultReadinessPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.readinessPath = path
+}
+
+// SetAllowedRoots restricts register_project to paths under one of roots.
+// Each root is resolved like a project path and must exist; no roots lifts
+// the restriction.
+func (d *Daemon) SetAllowedRoots(roots []string) error {
+	resolved := make([]string, 0, len(roots))
+	for _, root := range roots {
+		if strings.TrimSpace(root) == "" {
+			continue
+		}
+		path, err := normalizeProjectPath(root, false)
+		if err != nil {
+			return fmt.Errorf("invalid allowed root %q: %w", root, err)
+		}
+		resolved = append(resolved, path)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.allowedRoots = resolved
+	return nil
+}
+
+// SetServerRestarts makes the daemon restart an Opencode server that exits
+// on its own with an error, up to n consecutive times with doubling backoff.
+// After that the server stays stopped and is reported unhealthy. n <= 0
+// disables restarts.
+func (d *Daemon) SetServerRestarts(n int) {
+	if n < 0 {
+		n = 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.serverRestarts = n
+}
+
+// SetStartTimeout sets how long start_server and run_task wait for a new
+// Opencode server to become ready; non-positive values are ignored.
+func (d *Daemon) SetStartTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.startTimeout = timeout
+}
+
+// SetServeCommand sets the binary started as `<name> serve`; empty restores
+// the default "opencode" looked up on PATH.
+func (d *Daemon) SetServeCommand(name string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultOpencodeCommand
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.serveCommand = name
+}
+
+// SetRunCommand sets the binary started as `<name> run`; empty restores the
+// default "opencode" looked up on PATH.
+func (d *Daemon) SetRunCommand(name string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultOpencodeCommand
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.runCommand = name
+}
+
+// CheckCommands reports an error if the serve or run binary cannot be found
+// or is not executable, so a misconfigured agent fails at startup rather
+// than on its first command.
+func (d *Daemon) CheckCommands() error {
+	d.mu.RLock()
+	names := []string{d.serveCommand, d.runCommand}
+	d.mu.RUnlock()
+	for _, name := range names {
+		if _, err := exec.LookPath(name); err != nil {
+			return fmt.Errorf("opencode binary %q is not usable: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// SetMaxAttachmentBytes caps the decoded size of a run_task's attachments;
+// larger tasks are rejected before anything is written. n <= 0 restores the default.
+func (d *Daemon) SetMaxAttachmentBytes(n int64) {
+	if n <= 0 {
+		n = contracts.DefaultMaxAttachmentBytes
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.maxAttachmentBytes = n
+}
+
+// SetIdempotency resizes the replay cache of idempotency keys: at most
+// maxEntries results are kept, each for ttl. It replaces the cache, dropping
+// the keys seen so far, so call it before the daemon handles commands.
+func (d *Daemon) SetIdempotency(maxEntries int, ttl time.Duration) error {
+	if maxEntries < 1 {
+		return fmt.Errorf("invalid idempotency size %d: must be positive", maxEntries)
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("invalid idempotency ttl %s: must be positive", ttl)
+	}
+	cache := NewIdempotencyCache(maxEntries, ttl, d.now)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.idempotency = cache
+	return nil
+}
+
+func (d *Daemon) idempotencyCache() *IdempotencyCache {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.idempotency
+}
+
+// SetBackoff sets the delay after a failed poll or result post: base,
+// doubled per consecutive failure up to max, plus up to 20% jitter. base must
+// be positive and max at least base.
+func (d *Daemon) SetBackoff(base, max time.Duration) error {
+	if base <= 0 {
+		return fmt.Errorf("invalid backoff base %s: must be positive", base)
+	}
+	if max < base {
+		return fmt.Errorf("invalid backoff max %s: must be at least the base %s", max, base)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.backoffBase = base
+	d.backoffMax = max
+	return nil
+}
+
+// SetFreshnessWindow sets how stale or far in the future a command's
+// created_at may be before it is rejected.
+func (d *Daemon) SetFreshnessWindow(window contracts.FreshnessWindow) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.freshness = window
+}
+
+// SetProgressUpdates makes RunPollLoop post run_task stdout as partial results,
+// at most once per progress interval.
+func (d *Daemon) SetProgressUpdates(enabled bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.progressUpdates = enabled
+}
+
+func (d *Daemon) progressEnabled() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.progressUpdates
+}
+
+// RunPollLoop polls for commands and posts their results until ctx is
+// cancelled. Every run_task it started runs under ctx, so cancelling ctx
+// cancels them too; RunPollLoop returns once they have finished and their
+// results are posted, after which Shutdown can stop the servers.
 func (d *Daemon) RunPollLoop(ctx context.Context, client PollClient, timeoutSeconds int) {
+	var running sync.WaitGroup
+	defer running.Wait()
 	attempt := 0
 	for {
 		if ctx.Err() != nil {
@@ -171,6 +763,14 @@ func (d *Daemon) RunPollLoop(ctx context.Context, client PollClient, timeoutSeco
 		}
 		cmd, err := client.PollCommand(ctx, timeoutSeconds)
 		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			var limited *RateLimitedError
+			if errors.As(err, &limited) && limited.RetryAfter > 0 {
+				d.sleep(limited.RetryAfter)
+				continue
+			}
 			d.sleep(d.nextBackoff(attempt))
 			attempt++
 			continue
@@ -179,14 +779,39 @@ func (d *Daemon) RunPollLoop(ctx context.Context, client PollClient, timeoutSeco
 		if cmd == nil {
 			continue
 		}
-		result, _ := d.HandleCommand(ctx, *cmd)
-		if err := client.PostResult(ctx, result); err != nil {
+		cmdCtx := ctx
+		if d.progressEnabled() {
+			cmdCtx = WithProgress(ctx, func(progress contracts.CommandResult) {
+				// Progress is best-effort; the final result is what counts.
+				_ = client.PostProgress(ctx, progress)
+			})
+		}
+		if cmd.Type == contracts.CommandTypeRunTask {
+			// run_task runs in the background so a cancel_task for it can still
+			// be polled. A result that fails to post is recovered by backend
+			// redelivery and the idempotency cache.
+			running.Add(1)
+			go func(cmd contracts.Command) {
+				defer running.Done()
+				result, _ := d.HandleCommand(cmdCtx, cmd)
+				_ = postResult(client, result)
+			}(*cmd)
+			continue
+		}
+		result, _ := d.HandleCommand(cmdCtx, *cmd)
+		if err := postResult(client, result); err != nil {
 			d.sleep(d.nextBackoff(attempt))
 			attempt++
 		}
 	}
 }
 
+func postResult(client PollClient, result contracts.CommandResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), resultPostTimeout)
+	defer cancel()
+	return client.PostResult(ctx, result)
+}
+
 func (d *Daemon) getHandler(commandType string) (Handler, bool) {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
@@ -195,9 +820,15 @@ func (d *Daemon) getHandler(commandType string) (Handler, bool) {
 }
 
 func (d *Daemon) nextBackoff(attempt int) time.Duration {
-	delta := d.backoffBase << attempt
-	if delta > d.backoffMax {
-		delta = d.backoffMax
+	d.mu.RLock()
+	base, max := d.backoffBase, d.backoffMax
+	d.mu.RUnlock()
+	delta := max
+	// Past the ceiling, or once the shift would overflow, stay at max.
+	if attempt < 32 {
+		if shifted := base << attempt; shifted > 0 && shifted < max {
+			delta = shifted
+		}
 	}
 	jitterMax := int64(delta / 5)
 	if jitterMax <= 0 {
@@ -214,13 +845,34 @@ func (d *Daemon) handleRegisterProject(_ context.Context, cmd contracts.Command)
 	if strings.TrimSpace(payload.ProjectPathRaw) == "" {
 		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrPathInvalid, Message: "project_path_raw is required"}
 	}
-	path, err := normalizeProjectPath(payload.ProjectPathRaw)
+	path, err := normalizeProjectPath(payload.ProjectPathRaw, true)
 	if err != nil {
 		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrPathInvalid, Message: err.Error()}
 	}
 	if isForbiddenPath(path) {
 		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrPathForbidden, Message: "project path forbidden"}
 	}
+	d.mu.RLock()
+	roots := d.allowedRoots
+	d.mu.RUnlock()
+	if len(roots) > 0 && !isUnderRoots(path, roots) {
+		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrPathForbidden, Message: "project path outside allowed roots"}
+	}
+	if err := contracts.ValidateProjectEnv(payload.Env); err != nil {
+		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrValidationInvalidPayload, Message: err.Error()}
+	}
+	if err := contracts.ValidateRunTimeout(payload.RunTimeoutSeconds); err != nil {
+		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrValidationInvalidPayload, Message: err.Error(), Field: "run_timeout_seconds"}
+	}
+	envKeys := make([]string, 0, len(payload.Env))
+	for key := range payload.Env {
+		envKeys = append(envKeys, key)
+	}
+	sort.Strings(envKeys)
+	env := make([]string, 0, len(envKeys))
+	for _, key := range envKeys {
+		env = append(env, key+"="+payload.Env[key])
+	}
 	agentID := d.agentID
 	if strings.TrimSpace(agentID) == "" {
 		agentID = "unknown"
@@ -228,9 +880,20 @@ func (d *Daemon) handleRegisterProject(_ context.Context, cmd contracts.Command)
 	projectID := computeProjectID(agentID, path)
 	d.mu.Lock()
 	d.projects[projectID] = path
+	d.projectEnv[projectID] = env
+	if payload.RunTimeoutSeconds > 0 {
+		d.runTimeouts[projectID] = time.Duration(payload.RunTimeoutSeconds) * time.Second
+	} else {
+		delete(d.runTimeouts, projectID)
+	}
 	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionDeny}
 	d.mu.Unlock()
-	return contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "project registered", Meta: map[string]any{"project_id": projectID, "project_path": path}}, nil
+	// Only key names are reported; values may be secrets.
+	meta := map[string]any{"project_id": projectID, "project_path": path, "env_keys": envKeys}
+	if payload.RunTimeoutSeconds > 0 {
+		meta["run_timeout_seconds"] = payload.RunTimeoutSeconds
+	}
+	return contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "project registered", Meta: meta}, nil
 }
 
 func (d *Daemon) handleApplyProjectPolicy(_ context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
@@ -251,41 +914,403 @@ func (d *Daemon) handleApplyProjectPolicy(_ context.Context, cmd contracts.Comma
 	return contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "policy applied", Meta: meta}, nil
 }
 
-func (d *Daemon) handleStartServer(_ context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
+// prepareStartServer decodes a start_server payload and resolves its
+// readiness timeout. handleStartServer and dry runs share it.
+func (d *Daemon) prepareStartServer(cmd contracts.Command) (contracts.StartServerPayload, time.Duration, error) {
 	var payload contracts.StartServerPayload
 	if err := contracts.DecodeStrictJSON(cmd.Payload, &payload); err != nil {
-		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrValidationInvalidPayload, Message: err.Error()}
+		return payload, 0, contracts.APIError{Code: contracts.ErrValidationInvalidPayload, Message: err.Error()}
 	}
-	return d.startServer(cmd.CommandID, payload.ProjectID)
+	d.mu.RLock()
+	startTimeout := d.startTimeout
+	d.mu.RUnlock()
+	timeout, err := payloadTimeout(payload.TimeoutSeconds, startTimeout)
+	if err != nil {
+		return payload, 0, err
+	}
+	return payload, timeout, nil
 }
 
-func (d *Daemon) handleRunTask(_ context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
-	var payload contracts.RunTaskPayload
+func (d *Daemon) handleStartServer(ctx context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
+	payload, timeout, err := d.prepareStartServer(cmd)
+	if err != nil {
+		return contracts.CommandResult{}, err
+	}
+	return d.startServer(ctx, cmd.CommandID, payload.ProjectID, timeout)
+}
+
+func (d *Daemon) handleStopServer(_ context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
+	var payload contracts.StopServerPayload
 	if err := contracts.DecodeStrictJSON(cmd.Payload, &payload); err != nil {
 		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrValidationInvalidPayload, Message: err.Error()}
 	}
+	if strings.TrimSpace(payload.ProjectID) == "" {
+		return contracts.CommandResult{}, contracts.RequiredFieldError("project_id")
+	}
+	state := d.serverForProject(payload.ProjectID)
+	if state == nil {
+		return contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "server not running"}, nil
+	}
+	state.stopping.Store(true)
+	if state.Cmd != nil && state.Cmd.Process != nil {
+		if err := state.Cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return contracts.CommandResult{}, err
+		}
+	}
+	d.clearServerState(payload.ProjectID, state)
+	return contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "server stopped", Meta: map[string]any{"port": state.Port}}, nil
+}
+
+// runTaskPrep is a run_task payload that passed the checks made before
+// anything runs, with its decoded attachments and resolved timeout.
+type runTaskPrep struct {
+	payload contracts.RunTaskPayload
+	files   []attachmentFile
+	timeout time.Duration
+}
+
+// prepareRunTask decodes a run_task payload and makes every check that
+// needs nothing started or checked out: policy, attachments, the subdir's
+// shape and the timeout. handleRunTask and dry runs share it.
+func (d *Daemon) prepareRunTask(cmd contracts.Command) (runTaskPrep, error) {
+	var prep runTaskPrep
+	if err := contracts.DecodeStrictJSON(cmd.Payload, &prep.payload); err != nil {
+		return prep, contracts.APIError{Code: contracts.ErrValidationInvalidPayload, Message: err.Error()}
+	}
+	payload := prep.payload
 	if !d.policyAllows(payload.ProjectID, contracts.ScopeRunTask) {
-		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrPolicyDenied, Message: "policy denied"}
+		return prep, contracts.APIError{Code: contracts.ErrPolicyDenied, Message: "policy denied"}
 	}
-	startRes, err := d.startServer(cmd.CommandID, payload.ProjectID)
+	files, err := d.decodeAttachments(payload.Attachments)
+	if err != nil {
+		return prep, err
+	}
+	if err := checkSubdir(payload.Subdir); err != nil {
+		return prep, err
+	}
+	timeout, err := payloadTimeout(payload.TimeoutSeconds, d.runTimeout(payload.ProjectID))
+	if err != nil {
+		return prep, err
+	}
+	prep.files = files
+	prep.timeout = timeout
+	return prep, nil
+}
+
+func (d *Daemon) handleRunTask(parent context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
+	prep, err := d.prepareRunTask(cmd)
+	if err != nil {
+		return contracts.CommandResult{}, err
+	}
+	payload, files, timeout := prep.payload, prep.files, prep.timeout
+	// Ensuring the server mutates shared state, so it still takes the global lock.
+	d.mutatingLocker.Lock()
+	startRes, err := d.startServer(parent, cmd.CommandID, payload.ProjectID, 0)
+	d.mutatingLocker.Unlock()
 	if err != nil {
 		return contracts.CommandResult{}, err
 	}
 	port, _ := startRes.Meta["port"].(int)
-	ctx, cancel := context.WithTimeout(context.Background(), d.commandTimeout)
+	// The run lives under parent, the poll loop's context, so agent
+	// shutdown cancels it along with its opencode processes.
+	ctx, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
+	d.trackTask(cmd.CommandID, cancel)
+	defer d.untrackTask(cmd.CommandID)
+	if payload.Branch != "" {
+		if failed := d.checkoutBranch(ctx, cmd.CommandID, payload.ProjectID, payload.Branch); failed != nil {
+			failed.Meta = map[string]any{"port": port}
+			return *failed, nil
+		}
+	}
+	dir, err := d.runDir(payload.ProjectID, payload.Subdir)
+	if err != nil {
+		return contracts.CommandResult{}, err
+	}
 	attach := fmt.Sprintf("http://127.0.0.1:%d", port)
-	command := d.execCommand(ctx, d.runCommand, "run", "--attach", attach, payload.Prompt)
-	if path, ok := d.projectPath(payload.ProjectID); ok {
-		command.Dir = path
+	base := []string{"run", "--attach", attach}
+	if payload.Model != "" {
+		base = append(base, "--model", payload.Model)
+	}
+	var fileArgs []string
+	if len(files) > 0 {
+		dir, err := writeAttachments(files)
+		if err != nil {
+			return contracts.CommandResult{}, err
+		}
+		defer os.RemoveAll(dir)
+		for _, f := range files {
+			fileArgs = append(fileArgs, "--file", filepath.Join(dir, f.name))
+		}
 	}
-	if err := command.Run(); err != nil {
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrStartTimeout, Message: "command timeout"}
+	prompts := payload.Prompts
+	if len(prompts) == 0 {
+		prompts = []string{payload.Prompt}
+	}
+	// A batch shares one session: later prompts continue the session the
+	// first one started, and attachments go with the first prompt only.
+	// HandleCommand gives a batch every run slot of the project, so no other
+	// run can start a newer session in between.
+	var stdout, stderr bytes.Buffer
+	for i, prompt := range prompts {
+		args := append([]string{}, base...)
+		if i == 0 {
+			args = append(args, fileArgs...)
+			if payload.Title != "" {
+				args = append(args, "--title", payload.Title)
+			}
+		} else {
+			args = append(args, "--continue")
 		}
+		args = append(args, prompt)
+		if err := d.runPrompt(parent, ctx, cmd.CommandID, payload.ProjectID, dir, args, &stdout, &stderr); err != nil {
+			result := contracts.CommandResult{
+				CommandID: cmd.CommandID,
+				OK:        false,
+				ErrorCode: contracts.ErrInternal,
+				Summary:   err.Error(),
+				Stdout:    truncateOutput(stdout.String()),
+				Stderr:    truncateOutput(stderr.String()),
+				Meta:      map[string]any{"port": port},
+			}
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+				code := exitErr.ExitCode()
+				result.ExitCode = &code
+			}
+			switch {
+			case errors.Is(ctx.Err(), context.DeadlineExceeded):
+				result.ErrorCode = contracts.ErrStartTimeout
+				result.Summary = "command timeout"
+				result.Meta["timeout_seconds"] = timeoutSeconds(timeout)
+				result.Meta["timeout_phase"] = "run"
+			case errors.Is(ctx.Err(), context.Canceled):
+				result.ErrorCode = contracts.ErrCancelled
+				result.Summary = "task cancelled"
+				// parent is only cancelled when the agent shuts down; a
+				// cancel_task cancels the run's own context.
+				if parent.Err() != nil {
+					result.Summary = "task cancelled: agent shutting down"
+				}
+			}
+			if len(prompts) > 1 {
+				result.Summary = fmt.Sprintf("prompt %d of %d failed: %s", i+1, len(prompts), result.Summary)
+				result.Meta["failed_prompt"] = i + 1
+			}
+			return result, nil
+		}
+	}
+	summary := "task completed"
+	if len(prompts) > 1 {
+		summary = fmt.Sprintf("%d prompts completed", len(prompts))
+	}
+	return contracts.CommandResult{
+		CommandID: cmd.CommandID,
+		OK:        true,
+		Summary:   summary,
+		Stdout:    truncateOutput(stdout.String()),
+		Stderr:    truncateOutput(stderr.String()),
+		Meta:      map[string]any{"port": port},
+	}, nil
+}
+
+// runPrompt runs one opencode invocation of a run_task in dir, appending its
+// output to stdout and stderr. Progress is reported through parent's
+// ProgressFunc with all output captured so far.
+func (d *Daemon) runPrompt(parent, ctx context.Context, commandID, projectID, dir string, args []string, stdout, stderr *bytes.Buffer) error {
+	command := d.execCommand(ctx, d.runCommand, args...)
+	command.Dir = dir
+	command.Env = d.commandEnv(projectID)
+	command.Stdout = stdout
+	command.Stderr = stderr
+	if report := progressFromContext(parent); report != nil {
+		command.Stdout = &progressWriter{
+			commandID: commandID,
+			out:       stdout,
+			report:    report,
+			now:       d.now,
+			interval:  d.progressInterval,
+		}
+	}
+	return command.Run()
+}
+
+type attachmentFile struct {
+	name string
+	data []byte
+}
+
+// checkoutBranch runs git checkout for a run_task's branch in the project
+// directory. It returns the failed result to report, or nil on success.
+func (d *Daemon) checkoutBranch(ctx context.Context, commandID, projectID, branch string) *contracts.CommandResult {
+	if err := contracts.ValidateBranchName(branch); err != nil {
+		return &contracts.CommandResult{CommandID: commandID, OK: false, ErrorCode: contracts.ErrValidationInvalidPayload, Summary: err.Error()}
+	}
+	path, ok := d.projectPath(projectID)
+	if !ok {
+		return &contracts.CommandResult{CommandID: commandID, OK: false, ErrorCode: contracts.ErrPathInvalid, Summary: "project not registered"}
+	}
+	// The trailing "--" makes git read branch as a ref, never as a path.
+	git := d.execCommand(ctx, "git", "-C", path, "checkout", branch, "--")
+	git.Env = d.commandEnv(projectID)
+	out, err := git.CombinedOutput()
+	if err != nil {
+		return &contracts.CommandResult{
+			CommandID: commandID,
+			OK:        false,
+			ErrorCode: contracts.ErrCheckoutFailed,
+			Summary:   fmt.Sprintf("git checkout %s failed: %v", branch, err),
+			Stderr:    truncateOutput(string(out)),
+		}
+	}
+	return nil
+}
+
+// decodeAttachments decodes run_task attachments, enforcing the size cap and
+// re-checking names since handlers may be called without ValidateCommand.
+func (d *Daemon) decodeAttachments(attachments []contracts.Attachment) ([]attachmentFile, error) {
+	d.mu.RLock()
+	limit := d.maxAttachmentBytes
+	d.mu.RUnlock()
+	files := make([]attachmentFile, 0, len(attachments))
+	var total int64
+	for _, a := range attachments {
+		if err := contracts.ValidateAttachmentName(a.Name); err != nil {
+			return nil, contracts.APIError{Code: contracts.ErrValidationInvalidPayload, Message: err.Error()}
+		}
+		data, err := base64.StdEncoding.DecodeString(a.ContentBase64)
+		if err != nil {
+			return nil, contracts.APIError{Code: contracts.ErrValidationInvalidPayload, Message: fmt.Sprintf("attachment %q is not valid base64", a.Name)}
+		}
+		total += int64(len(data))
+		if total > limit {
+			return nil, contracts.APIError{Code: contracts.ErrValidationInvalidPayload, Message: fmt.Sprintf("attachments exceed the %d byte limit", limit)}
+		}
+		files = append(files, attachmentFile{name: a.Name, data: data})
+	}
+	return files, nil
+}
+
+// writeAttachments stores files in a new temp dir, which the caller removes.
+// The project directory is left untouched.
+func writeAttachments(files []attachmentFile) (string, error) {
+	dir, err := os.MkdirTemp("", "oct-attachments-")
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f.name), f.data, 0o600); err != nil {
+			_ = os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// progressWriter captures stdout into out and reports it as a partial result
+// whenever a complete line arrives, throttled to one report per interval.
+type progressWriter struct {
+	commandID string
+	out       *bytes.Buffer
+	report    ProgressFunc
+	now       func() time.Time
+	interval  time.Duration
+	last      time.Time
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.out.Write(p)
+	if err != nil || !bytes.Contains(p, []byte{'\n'}) {
+		return n, err
+	}
+	now := w.now()
+	if !w.last.IsZero() && now.Sub(w.last) < w.interval {
+		return n, nil
+	}
+	w.last = now
+	w.report(contracts.CommandResult{
+		CommandID:  w.commandID,
+		InProgress: true,
+		Summary:    "running",
+		Stdout:     truncateOutput(w.out.String()),
+	})
+	return n, nil
+}
+
+// truncateOutput keeps the tail of captured output, where the final answer
+// and error messages usually are, within maxOutputBytes.
+func truncateOutput(s string) string {
+	if len(s) <= maxOutputBytes {
+		return s
+	}
+	start := len(s) - maxOutputBytes
+	for start < len(s) && !utf8.RuneStart(s[start]) {
+		start++
+	}
+	return "...(truncated)\n" + s[start:]
+}
+
+func (d *Daemon) trackTask(commandID string, cancel context.CancelFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tasks[commandID] = cancel
+}
+
+func (d *Daemon) untrackTask(commandID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.tasks, commandID)
+}
+
+func (d *Daemon) handleCancelTask(_ context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
+	var payload contracts.CancelTaskPayload
+	if err := contracts.DecodeStrictJSON(cmd.Payload, &payload); err != nil {
+		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrValidationInvalidPayload, Message: err.Error()}
+	}
+	d.mu.RLock()
+	cancel, ok := d.tasks[payload.CommandID]
+	d.mu.RUnlock()
+	meta := map[string]any{"command_id": payload.CommandID}
+	if !ok {
+		return contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "task not running", Meta: meta}, nil
+	}
+	cancel()
+	return contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "task cancelled", Meta: meta}, nil
+}
+
+// handleAbortSession asks the project's local Opencode server to abort a
+// session. Nothing is running without a server, so that counts as success.
+func (d *Daemon) handleAbortSession(ctx context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
+	var payload contracts.AbortSessionPayload
+	if err := contracts.DecodeStrictJSON(cmd.Payload, &payload); err != nil {
+		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrValidationInvalidPayload, Message: err.Error()}
+	}
+	if strings.TrimSpace(payload.ProjectID) == "" {
+		return contracts.CommandResult{}, contracts.RequiredFieldError("project_id")
+	}
+	if strings.TrimSpace(payload.SessionID) == "" {
+		return contracts.CommandResult{}, contracts.RequiredFieldError("session_id")
+	}
+	meta := map[string]any{"session_id": payload.SessionID}
+	state := d.readyServer(payload.ProjectID)
+	if state == nil {
+		return contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "server not running", Meta: meta}, nil
+	}
+	meta["port"] = state.Port
+	endpoint := fmt.Sprintf("http://127.0.0.1:%d/session/%s/abort", state.Port, url.PathEscape(payload.SessionID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
 		return contracts.CommandResult{}, err
 	}
-	return contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "task completed", Meta: map[string]any{"port": port}}, nil
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return contracts.CommandResult{}, fmt.Errorf("abort session %s: %w", payload.SessionID, err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return contracts.CommandResult{}, fmt.Errorf("abort session %s: opencode returned %s", payload.SessionID, resp.Status)
+	}
+	return contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "session aborted", Meta: meta}, nil
 }
 
 func (d *Daemon) handleStatus(_ context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
@@ -293,7 +1318,49 @@ func (d *Daemon) handleStatus(_ context.Context, cmd contracts.Command) (contrac
 	if err := contracts.DecodeStrictJSON(cmd.Payload, &payload); err != nil {
 		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrValidationInvalidPayload, Message: err.Error()}
 	}
-	return contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "agent healthy"}, nil
+	// Port usage helps diagnose port_exhausted without access to the host.
+	minPort, maxPort := d.allocator.Range()
+	res := contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "agent healthy", Meta: map[string]any{
+		"ports_used": d.allocator.SnapshotUsed(),
+		"port_range": fmt.Sprintf("%d-%d", minPort, maxPort),
+	}}
+	d.mu.RLock()
+	if d.agentID != "" {
+		res.Meta["agent_id"] = d.agentID
+	}
+	if len(d.unhealthy) > 0 {
+		res.Meta["unhealthy_servers"] = copyStringMap(d.unhealthy)
+	}
+	d.mu.RUnlock()
+	// Replay counts tell whether a command that "did nothing" was answered
+	// from the idempotency cache.
+	idempotency := d.idempotencyCache()
+	hits, misses := idempotency.Stats()
+	res.Meta["idempotency"] = map[string]any{
+		"entries": idempotency.Len(),
+		"hits":    hits,
+		"misses":  misses,
+	}
+	return res, nil
+}
+
+func copyStringMap(in map[string]string) map[string]string {
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+// runTimeout is the project's own run_task timeout, or commandTimeout when
+// it registered none.
+func (d *Daemon) runTimeout(projectID string) time.Duration {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if timeout, ok := d.runTimeouts[projectID]; ok {
+		return timeout
+	}
+	return d.commandTimeout
 }
 
 func (d *Daemon) projectPath(projectID string) (string, bool) {
@@ -303,6 +1370,18 @@ func (d *Daemon) projectPath(projectID string) (string, bool) {
 	return path, ok
 }
 
+// commandEnv returns the environment for a process spawned for projectID: the
+// daemon's own plus the project's variables, or nil to simply inherit it.
+func (d *Daemon) commandEnv(projectID string) []string {
+	d.mu.RLock()
+	extra := d.projectEnv[projectID]
+	d.mu.RUnlock()
+	if len(extra) == 0 {
+		return nil
+	}
+	return append(os.Environ(), extra...)
+}
+
 func (d *Daemon) policyAllows(projectID string, scope string) bool {
 	d.mu.RLock()
 	policy, ok := d.policies[projectID]
@@ -310,7 +1389,7 @@ func (d *Daemon) policyAllows(projectID string, scope string) bool {
 	if !ok || policy.Decision != contracts.DecisionAllow {
 		return false
 	}
-	if policy.ExpiresAt != nil && d.now().UTC().After(*policy.ExpiresAt) {
+	if policy.expired(d.now().UTC()) {
 		return false
 	}
 	for _, s := range policy.Scope {
@@ -321,7 +1400,101 @@ func (d *Daemon) policyAllows(projectID string, scope string) bool {
 	return false
 }
 
-func normalizeProjectPath(raw string) (string, error) {
+// ActivePolicies returns a copy of the policies that have not expired.
+func (d *Daemon) ActivePolicies() map[string]projectPolicy {
+	now := d.now().UTC()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	active := make(map[string]projectPolicy, len(d.policies))
+	for projectID, policy := range d.policies {
+		if policy.expired(now) {
+			continue
+		}
+		policy.Scope = append([]string(nil), policy.Scope...)
+		active[projectID] = policy
+	}
+	return active
+}
+
+func (p projectPolicy) expired(now time.Time) bool {
+	return p.ExpiresAt != nil && now.After(*p.ExpiresAt)
+}
+
+func (d *Daemon) runPolicySweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stopSweep:
+			return
+		case <-ticker.C:
+			d.sweepExpiredPolicies()
+		}
+	}
+}
+
+// sweepExpiredPolicies drops policies whose ExpiresAt has passed according to
+// d.now and returns the affected project IDs.
+func (d *Daemon) sweepExpiredPolicies() []string {
+	now := d.now().UTC()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var expired []string
+	for projectID, policy := range d.policies {
+		if !policy.expired(now) {
+			continue
+		}
+		delete(d.policies, projectID)
+		expired = append(expired, projectID)
+		log.Printf("policy for project %s expired at %s; removed", projectID, policy.ExpiresAt.Format(time.RFC3339))
+	}
+	return expired
+}
+
+// checkSubdir rejects absolute subdirs and ".." elements, the checks that
+// do not depend on what is checked out.
+func checkSubdir(subdir string) error {
+	if filepath.IsAbs(subdir) {
+		return contracts.APIError{Code: contracts.ErrPathForbidden, Message: "subdir must be relative to the project", Field: "subdir"}
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(subdir), "/") {
+		if elem == ".." {
+			return contracts.APIError{Code: contracts.ErrPathForbidden, Message: "subdir must not contain ..", Field: "subdir"}
+		}
+	}
+	return nil
+}
+
+// runDir resolves the directory a run_task runs in: the project path, or
+// subdir inside it. Besides checkSubdir, symlinks that lead out of the
+// project are forbidden. With a branch it must run after the checkout,
+// since the subdir may exist only on that branch.
+func (d *Daemon) runDir(projectID, subdir string) (string, error) {
+	path, _ := d.projectPath(projectID)
+	if subdir == "" || path == "" {
+		return path, nil
+	}
+	if err := checkSubdir(subdir); err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(filepath.Join(path, subdir))
+	if err != nil {
+		return "", contracts.APIError{Code: contracts.ErrPathInvalid, Message: "subdir not found", Field: "subdir"}
+	}
+	if !isUnderRoots(real, []string{path}) {
+		return "", contracts.APIError{Code: contracts.ErrPathForbidden, Message: "subdir resolves outside the project", Field: "subdir"}
+	}
+	if info, err := os.Stat(real); err != nil || !info.IsDir() {
+		return "", contracts.APIError{Code: contracts.ErrPathInvalid, Message: "subdir is not a directory", Field: "subdir"}
+	}
+	return real, nil
+}
+
+// normalizeProjectPath makes raw absolute and resolves its symlinks. With
+// allowMissing, a path whose trailing components do not exist yet resolves
+// its longest existing prefix and appends the rest, so a project can be
+// registered before it is cloned; the result is the same once it exists.
+func normalizeProjectPath(raw string, allowMissing bool) (string, error) {
 	path := strings.TrimSpace(raw)
 	if path == "" {
 		return "", errors.New("project_path_raw is required")
@@ -331,6 +1504,9 @@ func normalizeProjectPath(raw string) (string, error) {
 		return "", err
 	}
 	real, err := filepath.EvalSymlinks(abs)
+	if err != nil && allowMissing && errors.Is(err, os.ErrNotExist) {
+		real, err = resolveExistingPrefix(abs)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -340,6 +1516,29 @@ func normalizeProjectPath(raw string) (string, error) {
 	return real, nil
 }
 
+// resolveExistingPrefix resolves the symlinks of the longest existing prefix
+// of the clean absolute path abs and joins the missing components back on.
+func resolveExistingPrefix(abs string) (string, error) {
+	var missing []string
+	prefix := abs
+	for {
+		real, err := filepath.EvalSymlinks(prefix)
+		if err == nil {
+			parts := append([]string{real}, missing...)
+			return filepath.Join(parts...), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(prefix)
+		if parent == prefix {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(prefix)}, missing...)
+		prefix = parent
+	}
+}
+
 func isForbiddenPath(path string) bool {
 	if path == "/" {
 		return true
@@ -362,21 +1561,46 @@ func isForbiddenPath(path string) bool {
 	return false
 }
 
+// isUnderRoots reports whether path is one of roots or inside one. Both
+// sides are normalized, so a prefix match on whole path elements suffices.
+func isUnderRoots(path string, roots []string) bool {
+	for _, root := range roots {
+		if root == "/" || path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
 func computeProjectID(agentID, path string) string {
 	data := []byte(agentID + "\n" + path)
 	sum := sha256.Sum256(data)
 	return hex.EncodeToString(sum[:])
 }
 
-func (d *Daemon) startServer(commandID string, projectID string) (contracts.CommandResult, error) {
+// startServer starts projectID's Opencode server unless it is running and
+// waits up to timeout, or startTimeout when timeout is 0, for it to become
+// ready. Cancelling ctx abandons the wait with ErrCancelled; the server
+// itself outlives ctx.
+func (d *Daemon) startServer(ctx context.Context, commandID string, projectID string, timeout time.Duration) (contracts.CommandResult, error) {
 	if strings.TrimSpace(projectID) == "" {
-		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrValidationRequiredField, Message: "project_id is required"}
+		return contracts.CommandResult{}, contracts.RequiredFieldError("project_id")
 	}
 	if !d.policyAllows(projectID, contracts.ScopeStartServer) {
 		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrPolicyDenied, Message: "policy denied"}
 	}
 	if current := d.serverForProject(projectID); current != nil {
-		return contracts.CommandResult{CommandID: commandID, OK: true, Summary: "server ready", Meta: map[string]any{"port": current.Port}}, nil
+		if !current.restarting.Load() {
+			return contracts.CommandResult{CommandID: commandID, OK: true, Summary: "server ready", Meta: map[string]any{"port": current.Port}}, nil
+		}
+		// A server that crashed and is still being restarted is not ready;
+		// take over and start a fresh one now instead of waiting it out.
+		current.stopping.Store(true)
+		if current.Cmd != nil && current.Cmd.Process != nil {
+			_ = current.Cmd.Process.Kill()
+			<-current.done
+		}
+		d.clearServerState(projectID, current)
 	}
 	path, ok := d.projectPath(projectID)
 	if !ok {
@@ -386,30 +1610,191 @@ func (d *Daemon) startServer(commandID string, projectID string) (contracts.Comm
 	if err != nil {
 		return contracts.CommandResult{}, err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), d.startTimeout)
+	startTimeout := timeout
+	if startTimeout <= 0 {
+		d.mu.RLock()
+		startTimeout = d.startTimeout
+		d.mu.RUnlock()
+	}
+	readyCtx, cancel := context.WithTimeout(ctx, startTimeout)
 	defer cancel()
-	cmd := d.execCommand(ctx, d.serveCommand, "serve", "--hostname", "127.0.0.1", "--port", fmt.Sprintf("%d", port))
-	cmd.Dir = path
-	if err := cmd.Start(); err != nil {
+	state, err := d.spawnServer(projectID, path, port)
+	if err != nil {
 		return contracts.CommandResult{}, err
 	}
-	state := &serverState{ProjectID: projectID, ProjectPath: path, Port: port, Cmd: cmd}
 	d.setServer(projectID, state)
-	ready := d.readinessCheck(ctx, port)
+	ready := d.readinessCheck(readyCtx, port)
 	if !ready {
-		_ = cmd.Process.Kill()
+		// No supervisor runs yet, so reap the process and close done here
+		// for a Shutdown that may be waiting on it.
+		state.stopping.Store(true)
+		_ = state.Cmd.Process.Kill()
+		_ = state.Cmd.Wait()
+		close(state.done)
 		d.clearServer(projectID)
-		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrStartTimeout, Message: "start timeout"}
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrCancelled, Message: "start cancelled"}
+		}
+		return contracts.CommandResult{}, contracts.APIError{Code: contracts.ErrStartTimeout, Message: "start timeout", Details: map[string]any{"timeout_seconds": timeoutSeconds(startTimeout), "timeout_phase": "start"}}
 	}
-	go func() {
-		_ = cmd.Wait()
-		d.clearServer(projectID)
-	}()
+	d.mu.Lock()
+	delete(d.unhealthy, projectID)
+	d.mu.Unlock()
+	go d.superviseServer(state, 0)
 	return contracts.CommandResult{CommandID: commandID, OK: true, Summary: "server ready", Meta: map[string]any{"port": port}}, nil
 }
 
+// spawnServer starts `opencode serve` for projectID on port. The process is
+// not tied to any command's context so it outlives the start.
+func (d *Daemon) spawnServer(projectID, path string, port int) (*serverState, error) {
+	d.mu.RLock()
+	serve := d.serveCommand
+	d.mu.RUnlock()
+	cmd := d.execCommand(context.Background(), serve, "serve", "--hostname", "127.0.0.1", "--port", fmt.Sprintf("%d", port))
+	cmd.Dir = path
+	cmd.Env = d.commandEnv(projectID)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &serverState{ProjectID: projectID, ProjectPath: path, Port: port, Cmd: cmd, done: make(chan struct{})}, nil
+}
+
+// superviseServer waits for state's process to exit and clears it. A server
+// that fails on its own is restarted on the same port when restarts are
+// enabled; restarts counts the consecutive restarts that led to state.
+func (d *Daemon) superviseServer(state *serverState, restarts int) {
+	startedAt := d.now()
+	err := state.Cmd.Wait()
+	close(state.done)
+
+	d.mu.RLock()
+	limit, backoff := d.serverRestarts, d.restartBackoff
+	d.mu.RUnlock()
+	if err == nil || limit == 0 || state.stopping.Load() {
+		d.clearServerState(state.ProjectID, state)
+		return
+	}
+	if d.now().Sub(startedAt) >= restartResetAfter {
+		restarts = 0
+	}
+	if restarts >= limit {
+		log.Printf("opencode server for %s exited: %v; gave up after %d restarts", state.ProjectID, err, restarts)
+		d.markUnhealthy(state, err)
+		return
+	}
+	delay := backoff << restarts
+	if delay > restartBackoffMax || delay <= 0 {
+		delay = restartBackoffMax
+	}
+	log.Printf("opencode server for %s exited: %v; restarting in %s (%d/%d)", state.ProjectID, err, delay, restarts+1, limit)
+	state.restarting.Store(true)
+	d.sleep(delay)
+
+	// stop_server or Shutdown may have taken over during the backoff.
+	if state.stopping.Load() || d.serverForProject(state.ProjectID) != state {
+		return
+	}
+	next, err := d.spawnServer(state.ProjectID, state.ProjectPath, state.Port)
+	if err != nil {
+		log.Printf("restart opencode server for %s: %v", state.ProjectID, err)
+		d.markUnhealthy(state, err)
+		return
+	}
+	d.mu.Lock()
+	if d.servers[state.ProjectID] != state || state.stopping.Load() {
+		d.mu.Unlock()
+		next.stopping.Store(true)
+		_ = next.Cmd.Process.Kill()
+		_ = next.Cmd.Wait()
+		return
+	}
+	next.restarting.Store(true)
+	d.servers[state.ProjectID] = next
+	startTimeout := d.startTimeout
+	d.mu.Unlock()
+	go d.superviseServer(next, restarts+1)
+
+	// The replacement is only handed out once it answers; one that never
+	// does is killed and handled like another crash.
+	readyCtx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+	if !d.readinessCheck(readyCtx, next.Port) {
+		if !next.stopping.Load() {
+			log.Printf("restarted opencode server for %s not ready after %s", state.ProjectID, startTimeout)
+			_ = next.Cmd.Process.Kill()
+		}
+		return
+	}
+	next.restarting.Store(false)
+}
+
+// markUnhealthy clears state's server and records why it stopped.
+func (d *Daemon) markUnhealthy(state *serverState, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.servers[state.ProjectID] != state {
+		return
+	}
+	delete(d.servers, state.ProjectID)
+	d.allocator.Release(state.ProjectID)
+	d.unhealthy[state.ProjectID] = err.Error()
+}
+
+// Shutdown stops every running Opencode server so none outlive the daemon.
+// Each gets SIGTERM, then SIGKILL if it is still running after the grace
+// period; ctx bounds the whole wait. All allocated ports are released and the
+// policy sweep is stopped. Call it after RunPollLoop has returned, so no
+// run_task is left to start another server.
+func (d *Daemon) Shutdown(ctx context.Context) error {
+	d.stopSweepOnce.Do(func() { close(d.stopSweep) })
+
+	d.mu.RLock()
+	states := make([]*serverState, 0, len(d.servers))
+	for _, state := range d.servers {
+		states = append(states, state)
+	}
+	d.mu.RUnlock()
+
+	var errs []error
+	for _, state := range states {
+		if state.Cmd == nil || state.Cmd.Process == nil {
+			continue
+		}
+		state.stopping.Store(true)
+		if err := state.Cmd.Process.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			errs = append(errs, fmt.Errorf("terminate server for %s: %w", state.ProjectID, err))
+		}
+	}
+
+	graceCtx, cancel := context.WithTimeout(ctx, d.shutdownGrace)
+	defer cancel()
+	for _, state := range states {
+		if state.done != nil {
+			select {
+			case <-state.done:
+			case <-graceCtx.Done():
+				if err := state.Cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+					errs = append(errs, fmt.Errorf("kill server for %s: %w", state.ProjectID, err))
+				}
+				select {
+				case <-state.done:
+				case <-ctx.Done():
+				}
+			}
+		}
+		d.clearServerState(state.ProjectID, state)
+	}
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
 func (d *Daemon) waitForReady(ctx context.Context, port int) bool {
-	url := fmt.Sprintf("http://127.0.0.1:%d/global/health", port)
+	d.mu.RLock()
+	path := d.readinessPath
+	d.mu.RUnlock()
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", port, path)
 	for {
 		if ctx.Err() != nil {
 			return false
@@ -418,7 +1803,7 @@ func (d *Daemon) waitForReady(ctx context.Context, port int) bool {
 		resp, err := d.client.Do(req)
 		if err == nil && resp != nil {
 			_ = resp.Body.Close()
-			if resp.StatusCode == http.StatusOK {
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 				return true
 			}
 		}
@@ -432,6 +1817,15 @@ func (d *Daemon) serverForProject(projectID string) *serverState {
 	return d.servers[projectID]
 }
 
+// readyServer is the project's server unless it is absent or still being
+// restarted after a crash.
+func (d *Daemon) readyServer(projectID string) *serverState {
+	if state := d.serverForProject(projectID); state != nil && !state.restarting.Load() {
+		return state
+	}
+	return nil
+}
+
 func (d *Daemon) setServer(projectID string, state *serverState) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -444,3 +1838,15 @@ func (d *Daemon) clearServer(projectID string) {
 	delete(d.servers, projectID)
 	d.allocator.Release(projectID)
 }
+
+// clearServerState clears the project's server only if it is still the given
+// instance, so a late exit of a stopped server cannot evict its replacement.
+func (d *Daemon) clearServerState(projectID string, state *serverState) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.servers[projectID] != state {
+		return
+	}
+	delete(d.servers, projectID)
+	d.allocator.Release(projectID)
+}
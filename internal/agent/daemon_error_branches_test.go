@@ -15,7 +15,7 @@ func TestDaemon_RegisterProjectAndStartServerErrorBranches(t *testing.T) {
 
 	badPayload := contracts.Command{
 		CommandID:      "c-bad",
-		IdempotencyKey: "k-bad",
+		IdempotencyKey: "k-bad-00",
 		Type:           contracts.CommandTypeRegisterProject,
 		CreatedAt:      time.Now().UTC(),
 		Payload:        []byte(`{bad`),
@@ -27,7 +27,7 @@ func TestDaemon_RegisterProjectAndStartServerErrorBranches(t *testing.T) {
 
 	forbidden := contracts.Command{
 		CommandID:      "c-root",
-		IdempotencyKey: "k-root",
+		IdempotencyKey: "k-root-0",
 		Type:           contracts.CommandTypeRegisterProject,
 		CreatedAt:      time.Now().UTC(),
 		Payload:        mustPayload(t, contracts.RegisterProjectPayload{ProjectPathRaw: "/"}),
@@ -64,7 +64,7 @@ func TestDaemon_RegisterProjectAndStartServerErrorBranches(t *testing.T) {
 	exp := time.Now().UTC().Add(5 * time.Minute)
 	pol := contracts.Command{
 		CommandID:      "c-pol",
-		IdempotencyKey: "k-pol",
+		IdempotencyKey: "k-pol-00",
 		Type:           contracts.CommandTypeApplyProjectPolicy,
 		CreatedAt:      time.Now().UTC(),
 		Payload:        mustPayload(t, contracts.ApplyProjectPolicyPayload{ProjectID: projectID, Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer}, ExpiresAt: &exp}),
@@ -92,6 +92,9 @@ func TestDaemon_RegisterProjectAndStartServerErrorBranches(t *testing.T) {
 	if err != nil || res.OK || res.ErrorCode != contracts.ErrStartTimeout {
 		t.Fatalf("expected start timeout branch, err=%v res=%+v", err, res)
 	}
+	if res.Meta["timeout_seconds"] != 1 || res.Meta["timeout_phase"] != "start" {
+		t.Fatalf("expected timeout_seconds rounded up to 1, got %+v", res.Meta)
+	}
 }
 
 func TestDaemonPolicyAllowsExpiryAndScope(t *testing.T) {
@@ -115,3 +118,88 @@ func TestDaemonPolicyAllowsExpiryAndScope(t *testing.T) {
 		t.Fatal("expected matching scope to be allowed")
 	}
 }
+
+func TestDaemonSweepExpiredPolicies(t *testing.T) {
+	d := NewDaemon()
+	now := time.Date(2026, 2, 11, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+
+	expiresAt := now.Add(time.Minute)
+	d.mu.Lock()
+	d.policies["p1"] = projectPolicy{Decision: contracts.DecisionAllow, ExpiresAt: &expiresAt, Scope: []string{contracts.ScopeRunTask}}
+	d.policies["p2"] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer}}
+	d.mu.Unlock()
+
+	if got := d.sweepExpiredPolicies(); len(got) != 0 {
+		t.Fatalf("expected nothing to sweep before expiry, got %v", got)
+	}
+	if active := d.ActivePolicies(); len(active) != 2 {
+		t.Fatalf("expected 2 active policies, got %+v", active)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if active := d.ActivePolicies(); len(active) != 1 || active["p2"].Decision != contracts.DecisionAllow {
+		t.Fatalf("expected only p2 active after expiry, got %+v", active)
+	}
+	if got := d.sweepExpiredPolicies(); len(got) != 1 || got[0] != "p1" {
+		t.Fatalf("expected p1 swept, got %v", got)
+	}
+	d.mu.RLock()
+	_, stillThere := d.policies["p1"]
+	d.mu.RUnlock()
+	if stillThere {
+		t.Fatal("expected expired policy removed from daemon state")
+	}
+
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	select {
+	case <-d.stopSweep:
+	default:
+		t.Fatal("expected shutdown to stop the policy sweep")
+	}
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatalf("second shutdown: %v", err)
+	}
+}
+
+func TestDaemonStartServerReapsServerThatNeverReady(t *testing.T) {
+	d := NewDaemon()
+	projectID := "p1"
+	d.mu.Lock()
+	d.projects[projectID] = t.TempDir()
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer}}
+	d.mu.Unlock()
+	d.startTimeout = 100 * time.Millisecond
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sleep", "30")
+	}
+	var state *serverState
+	d.readinessCheck = func(context.Context, int) bool {
+		state = d.serverForProject(projectID)
+		return false
+	}
+
+	res, err := d.HandleCommand(context.Background(), contracts.Command{
+		CommandID:      "c-never-ready",
+		IdempotencyKey: "k-never-ready",
+		Type:           contracts.CommandTypeStartServer,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.StartServerPayload{ProjectID: projectID}),
+	})
+	if err != nil || res.ErrorCode != contracts.ErrStartTimeout {
+		t.Fatalf("expected start timeout, err=%v res=%+v", err, res)
+	}
+	if state == nil {
+		t.Fatal("expected the server registered during the readiness wait")
+	}
+	select {
+	case <-state.done:
+	default:
+		t.Fatal("expected done closed for a server that never became ready")
+	}
+	if state.Cmd.ProcessState == nil {
+		t.Fatal("expected the killed server to be reaped")
+	}
+}
@@ -2,10 +2,15 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -16,6 +21,7 @@ type fakePollClient struct {
 	commands []*contracts.Command
 	index    int32
 	results  []contracts.CommandResult
+	progress []contracts.CommandResult
 }
 
 func (f *fakePollClient) PollCommand(ctx context.Context, timeoutSeconds int) (*contracts.Command, error) {
@@ -34,6 +40,12 @@ func (f *fakePollClient) PostResult(ctx context.Context, result contracts.Comman
 	return nil
 }
 
+func (f *fakePollClient) PostProgress(ctx context.Context, result contracts.CommandResult) error {
+	_ = ctx
+	f.progress = append(f.progress, result)
+	return nil
+}
+
 func TestDaemonReadinessAndRestart(t *testing.T) {
 	call := int32(0)
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -75,7 +87,7 @@ func TestDaemonReadinessAndRestart(t *testing.T) {
 
 	cmd := contracts.Command{
 		CommandID:      "c1",
-		IdempotencyKey: "i1",
+		IdempotencyKey: "i1-00000",
 		Type:           contracts.CommandTypeStartServer,
 		CreatedAt:      time.Now().UTC(),
 		Payload:        mustPayload(t, contracts.StartServerPayload{ProjectID: projectID}),
@@ -101,3 +113,252 @@ func TestDaemonReadinessAndRestart(t *testing.T) {
 		t.Fatalf("expected exec command to be called")
 	}
 }
+
+func TestDaemonStartServerCancelled(t *testing.T) {
+	d := NewDaemon()
+	projectID := "p1"
+	d.mu.Lock()
+	d.projects[projectID] = t.TempDir()
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer}}
+	d.mu.Unlock()
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.Command("sleep", "5")
+	}
+	d.readinessCheck = func(ctx context.Context, port int) bool {
+		<-ctx.Done()
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, err := d.startServer(ctx, "start-1", projectID, 0)
+	var apiErr contracts.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != contracts.ErrCancelled {
+		t.Fatalf("expected ErrCancelled, got %v", err)
+	}
+	if d.serverForProject(projectID) != nil {
+		t.Fatal("expected cancelled server to be cleared")
+	}
+}
+
+// supervisedDaemon returns a daemon with one startable project whose serve
+// processes come from scripts in order, repeating the last one.
+func supervisedDaemon(t *testing.T, restarts int, scripts ...string) (*Daemon, string, *int32) {
+	t.Helper()
+	d := NewDaemon()
+	d.SetServerRestarts(restarts)
+	d.restartBackoff = time.Millisecond
+	d.readinessCheck = func(context.Context, int) bool { return true }
+	projectID := "p1"
+	d.mu.Lock()
+	d.projects[projectID] = t.TempDir()
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer}}
+	d.mu.Unlock()
+	var spawned int32
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		i := int(atomic.AddInt32(&spawned, 1)) - 1
+		if i >= len(scripts) {
+			i = len(scripts) - 1
+		}
+		return exec.Command("sh", "-c", scripts[i])
+	}
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+	return d, projectID, &spawned
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDaemonRestartsCrashedServer(t *testing.T) {
+	d, projectID, spawned := supervisedDaemon(t, 2, "exit 1", "exec sleep 30")
+	res, err := d.startServer(context.Background(), "start-1", projectID, 0)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitFor(t, "restart", func() bool { return atomic.LoadInt32(spawned) == 2 })
+	state := d.serverForProject(projectID)
+	if state == nil || state.Port != res.Meta["port"] {
+		t.Fatalf("expected restarted server on port %v, got %+v", res.Meta["port"], state)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(spawned); n != 2 {
+		t.Fatalf("expected a healthy server not to be restarted again, got %d spawns", n)
+	}
+
+	// stopping the server on purpose is not a crash
+	stop := contracts.Command{CommandID: "stop-1", IdempotencyKey: "idem-stop-1", Type: contracts.CommandTypeStopServer, CreatedAt: time.Now().UTC(), Payload: mustPayload(t, contracts.StopServerPayload{ProjectID: projectID})}
+	if res, err := d.HandleCommand(context.Background(), stop); err != nil || !res.OK {
+		t.Fatalf("stop: %+v %v", res, err)
+	}
+	<-state.done
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(spawned); n != 2 || d.serverForProject(projectID) != nil {
+		t.Fatalf("expected stopped server to stay down, got %d spawns", n)
+	}
+}
+
+func TestDaemonRestartingServerIsNotReported(t *testing.T) {
+	d, projectID, spawned := supervisedDaemon(t, 2, "exit 1", "exec sleep 30")
+	backingOff := make(chan struct{}, 1)
+	release := make(chan struct{})
+	d.sleep = func(time.Duration) {
+		backingOff <- struct{}{}
+		<-release
+	}
+	if _, err := d.startServer(context.Background(), "start-1", projectID, 0); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	<-backingOff
+	dead := d.serverForProject(projectID)
+	if dead == nil || d.readyServer(projectID) != nil {
+		t.Fatalf("expected the crashed server to be held back during backoff, got %+v", dead)
+	}
+
+	// start_server during the backoff spawns a fresh server instead of
+	// reporting the dead one
+	if _, err := d.startServer(context.Background(), "start-2", projectID, 0); err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	fresh := d.readyServer(projectID)
+	if fresh == nil || fresh == dead || atomic.LoadInt32(spawned) != 2 {
+		t.Fatalf("expected a fresh server, got %+v after %d spawns", fresh, atomic.LoadInt32(spawned))
+	}
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(spawned); n != 2 || d.serverForProject(projectID) != fresh {
+		t.Fatalf("expected the abandoned restart not to spawn, got %d spawns", n)
+	}
+}
+
+func TestDaemonRestartedServerMustBecomeReady(t *testing.T) {
+	d, projectID, spawned := supervisedDaemon(t, 1, "exit 1", "exec sleep 30")
+	var checks int32
+	d.readinessCheck = func(context.Context, int) bool {
+		// only the first start comes up; the restarted server never answers
+		return atomic.AddInt32(&checks, 1) == 1
+	}
+	if _, err := d.startServer(context.Background(), "start-1", projectID, 0); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitFor(t, "unhealthy server", func() bool {
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+		return d.unhealthy[projectID] != ""
+	})
+	if n := atomic.LoadInt32(spawned); n != 2 || d.serverForProject(projectID) != nil {
+		t.Fatalf("expected the unready restart killed and the server cleared, got %d spawns", n)
+	}
+}
+
+func TestDaemonMarksServerUnhealthyAfterRestarts(t *testing.T) {
+	d, projectID, spawned := supervisedDaemon(t, 2, "exit 3")
+	if _, err := d.startServer(context.Background(), "start-1", projectID, 0); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	status := contracts.Command{CommandID: "status-1", IdempotencyKey: "idem-status-1", Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: mustPayload(t, contracts.StatusPayload{})}
+	var unhealthy map[string]string
+	waitFor(t, "unhealthy server", func() bool {
+		res, err := d.handleStatus(context.Background(), status)
+		if err != nil {
+			t.Fatalf("status: %v", err)
+		}
+		unhealthy, _ = res.Meta["unhealthy_servers"].(map[string]string)
+		return unhealthy != nil
+	})
+	if n := atomic.LoadInt32(spawned); n != 3 {
+		t.Fatalf("expected the first start plus 2 restarts, got %d spawns", n)
+	}
+	if !strings.Contains(unhealthy[projectID], "exit status 3") || d.serverForProject(projectID) != nil {
+		t.Fatalf("expected project marked unhealthy and cleared, got %+v", unhealthy)
+	}
+
+	// with restarts disabled a crash only clears the server
+	d, projectID, spawned = supervisedDaemon(t, 0, "exit 1")
+	if _, err := d.startServer(context.Background(), "start-2", projectID, 0); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitFor(t, "cleared server", func() bool { return d.serverForProject(projectID) == nil })
+	if n := atomic.LoadInt32(spawned); n != 1 || len(d.unhealthy) != 0 {
+		t.Fatalf("expected no restart without supervision, got %d spawns unhealthy=%v", n, d.unhealthy)
+	}
+}
+
+func TestDaemonShutdownStopsAllServers(t *testing.T) {
+	d := NewDaemon()
+	d.shutdownGrace = 200 * time.Millisecond
+	d.readinessCheck = func(context.Context, int) bool { return true }
+	trapped := filepath.Join(t.TempDir(), "trapped")
+	scripts := map[string]string{
+		"polite":   "exec sleep 30",
+		"stubborn": `trap "" TERM; touch "` + trapped + `"; while :; do sleep 0.05; done`,
+	}
+	var starting string
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		// outlive startServer's context like a real serve process would
+		return exec.Command("sh", "-c", scripts[starting])
+	}
+	for projectID := range scripts {
+		d.mu.Lock()
+		d.projects[projectID] = t.TempDir()
+		d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer}}
+		d.mu.Unlock()
+		starting = projectID
+		if _, err := d.startServer(context.Background(), "start-"+projectID, projectID, 0); err != nil {
+			t.Fatalf("start %s: %v", projectID, err)
+		}
+	}
+	// SIGTERM must not land before the stubborn shell has installed its trap
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(trapped); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stubborn server never installed its TERM trap")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	states := map[string]*serverState{}
+	for projectID := range scripts {
+		states[projectID] = d.serverForProject(projectID)
+	}
+
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	for projectID, state := range states {
+		select {
+		case <-state.done:
+		default:
+			t.Fatalf("expected %s server to have exited", projectID)
+		}
+		ws, ok := state.Cmd.ProcessState.Sys().(syscall.WaitStatus)
+		if !ok || !ws.Signaled() {
+			t.Fatalf("expected %s server to be signaled, got %v", projectID, state.Cmd.ProcessState)
+		}
+		want := syscall.SIGTERM
+		if projectID == "stubborn" {
+			want = syscall.SIGKILL
+		}
+		if ws.Signal() != want {
+			t.Fatalf("expected %s server stopped by %v, got %v", projectID, want, ws.Signal())
+		}
+		if d.serverForProject(projectID) != nil {
+			t.Fatalf("expected %s server cleared", projectID)
+		}
+	}
+	if used := d.allocator.SnapshotUsed(); len(used) != 0 {
+		t.Fatalf("expected all ports released, got %v", used)
+	}
+}
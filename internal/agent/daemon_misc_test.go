@@ -2,6 +2,11 @@ package agent
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,7 +18,7 @@ func TestDaemonStatusAndStartServerPayloadValidation(t *testing.T) {
 
 	statusBad := contracts.Command{
 		CommandID:      "s1",
-		IdempotencyKey: "k-s1",
+		IdempotencyKey: "k-s1-000",
 		Type:           contracts.CommandTypeStatus,
 		CreatedAt:      time.Now().UTC(),
 		Payload:        []byte(`{"extra":1}`),
@@ -28,7 +33,7 @@ func TestDaemonStatusAndStartServerPayloadValidation(t *testing.T) {
 
 	startBad := contracts.Command{
 		CommandID:      "st1",
-		IdempotencyKey: "k-st1",
+		IdempotencyKey: "k-st1-00",
 		Type:           contracts.CommandTypeStartServer,
 		CreatedAt:      time.Now().UTC(),
 		Payload:        []byte(`{"project_id":""}`),
@@ -42,12 +47,45 @@ func TestDaemonStatusAndStartServerPayloadValidation(t *testing.T) {
 	}
 }
 
+func TestDaemonStatusReportsPortUsage(t *testing.T) {
+	d := NewDaemon()
+	if err := d.SetPortRange(21000, 21010); err != nil {
+		t.Fatalf("set port range: %v", err)
+	}
+	if _, err := d.allocator.Allocate("p2"); err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	if _, err := d.allocator.Allocate("p1"); err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	res, err := d.HandleCommand(context.Background(), contracts.Command{
+		CommandID:      "status-ports",
+		IdempotencyKey: "k-status-ports",
+		Type:           contracts.CommandTypeStatus,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        []byte(`{}`),
+	})
+	if err != nil || !res.OK {
+		t.Fatalf("status: %+v err=%v", res, err)
+	}
+	used, _ := res.Meta["ports_used"].([]int)
+	if len(used) != 2 || used[0] != 21000 || used[1] != 21001 {
+		t.Fatalf("expected ports 21000,21001 in use, got %v", res.Meta["ports_used"])
+	}
+	if res.Meta["port_range"] != "21000-21010" {
+		t.Fatalf("expected configured range, got %v", res.Meta["port_range"])
+	}
+	if ports := d.allocator.SnapshotUsed(); len(ports) != 2 {
+		t.Fatalf("expected status to leave allocations alone, got %v", ports)
+	}
+}
+
 func TestNormalizeProjectPathAndForbiddenPathHelpers(t *testing.T) {
-	if _, err := normalizeProjectPath(""); err == nil {
+	if _, err := normalizeProjectPath("", true); err == nil {
 		t.Fatal("expected error for empty project path")
 	}
-	if _, err := normalizeProjectPath("/definitely/nonexistent/path/for/opencode/telegram/tests"); err == nil {
-		t.Fatal("expected error for nonexistent path")
+	if _, err := normalizeProjectPath("/definitely/nonexistent/path/for/opencode/telegram/tests", false); err == nil {
+		t.Fatal("expected strict error for nonexistent path")
 	}
 
 	for _, p := range []string{"/", "/home", "/Users", "/etc", "/usr/local"} {
@@ -60,6 +98,63 @@ func TestNormalizeProjectPathAndForbiddenPathHelpers(t *testing.T) {
 	}
 }
 
+func TestDaemonAllowedRoots(t *testing.T) {
+	workspace := t.TempDir()
+	inside := filepath.Join(workspace, "repo")
+	if err := os.Mkdir(inside, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	outside := t.TempDir()
+	n := 0
+	register := func(d *Daemon, path string) (contracts.CommandResult, error) {
+		n++
+		return d.HandleCommand(context.Background(), contracts.Command{
+			CommandID:      fmt.Sprintf("reg-%d", n),
+			IdempotencyKey: fmt.Sprintf("idem-reg-%d", n),
+			Type:           contracts.CommandTypeRegisterProject,
+			CreatedAt:      time.Now().UTC(),
+			Payload:        mustPayload(t, contracts.RegisterProjectPayload{ProjectPathRaw: path}),
+		})
+	}
+
+	// without roots only the denylist applies
+	d := NewDaemon()
+	if res, err := register(d, outside); err != nil || !res.OK {
+		t.Fatalf("expected denylist-only mode to accept %s, got %+v err=%v", outside, res, err)
+	}
+
+	d = NewDaemon()
+	if err := d.SetAllowedRoots([]string{"", workspace + "/"}); err != nil {
+		t.Fatalf("set allowed roots: %v", err)
+	}
+	for _, path := range []string{workspace, inside} {
+		if res, err := register(d, path); err != nil || !res.OK {
+			t.Fatalf("expected %s under the allowed root to register, got %+v err=%v", path, res, err)
+		}
+	}
+	if res, _ := register(d, outside); res.OK || res.ErrorCode != contracts.ErrPathForbidden {
+		t.Fatalf("expected path outside the roots to be forbidden, got %+v", res)
+	}
+	// a sibling sharing the root's name as a prefix is still outside
+	if err := os.Mkdir(workspace+"-other", 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	defer os.Remove(workspace + "-other")
+	if res, _ := register(d, workspace+"-other"); res.OK || res.ErrorCode != contracts.ErrPathForbidden {
+		t.Fatalf("expected prefix sibling to be forbidden, got %+v", res)
+	}
+
+	if err := d.SetAllowedRoots([]string{"/definitely/nonexistent/root"}); err == nil {
+		t.Fatal("expected missing root to be rejected")
+	}
+	if err := d.SetAllowedRoots(nil); err != nil {
+		t.Fatalf("clear roots: %v", err)
+	}
+	if res, err := register(d, outside); err != nil || !res.OK {
+		t.Fatalf("expected cleared roots to accept %s, got %+v err=%v", outside, res, err)
+	}
+}
+
 func TestIdempotencyCacheDefaultClockBranch(t *testing.T) {
 	c := NewIdempotencyCache(2, time.Minute, nil)
 	c.Put("a", contracts.CommandResult{CommandID: "c1", OK: true})
@@ -67,3 +162,393 @@ func TestIdempotencyCacheDefaultClockBranch(t *testing.T) {
 		t.Fatal("expected cache entry to exist with default clock")
 	}
 }
+
+func TestIdempotencyCacheInspection(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := NewIdempotencyCache(2, time.Minute, func() time.Time { return now })
+	c.Put("a", contracts.CommandResult{CommandID: "c1"})
+	c.Put("b", contracts.CommandResult{CommandID: "c2"})
+	if c.Len() != 2 || !c.Contains("a") || !c.Contains("b") || c.Contains("") || c.Contains("missing") {
+		t.Fatalf("unexpected contents: len=%d", c.Len())
+	}
+	if hits, misses := c.Stats(); hits != 0 || misses != 0 {
+		t.Fatalf("expected Contains not to count lookups, got %d/%d", hits, misses)
+	}
+
+	// eviction is unchanged: a third key pushes out the oldest
+	c.Put("c", contracts.CommandResult{CommandID: "c3"})
+	if c.Len() != 2 || c.Contains("a") {
+		t.Fatal("expected the oldest key evicted")
+	}
+	_, _ = c.Get("b")
+	_, _ = c.Get("a")
+	_, _ = c.Get("")
+	if hits, misses := c.Stats(); hits != 1 || misses != 1 {
+		t.Fatalf("expected one hit and one miss, got %d/%d", hits, misses)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if c.Len() != 0 || c.Contains("b") {
+		t.Fatal("expected expired keys not counted")
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected expired key to miss")
+	}
+	if hits, misses := c.Stats(); hits != 1 || misses != 2 {
+		t.Fatalf("expected expired lookup counted as a miss, got %d/%d", hits, misses)
+	}
+}
+
+func TestDaemonStatusReportsIdempotencyStats(t *testing.T) {
+	d := NewDaemon()
+	status := contracts.Command{
+		CommandID:      "status-1",
+		IdempotencyKey: "idem-status",
+		Type:           contracts.CommandTypeStatus,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        []byte(`{}`),
+	}
+	if _, err := d.HandleCommand(context.Background(), status); err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	status.CommandID = "status-2"
+	replay, _ := d.HandleCommand(context.Background(), status)
+	if replay.CommandID != "status-1" {
+		t.Fatalf("expected the second status replayed, got %+v", replay)
+	}
+	status.CommandID, status.IdempotencyKey = "status-3", "idem-status-3"
+	res, err := d.HandleCommand(context.Background(), status)
+	if err != nil || !res.OK {
+		t.Fatalf("status: %v %+v", err, res)
+	}
+	stats, _ := res.Meta["idempotency"].(map[string]any)
+	if stats["entries"] != 1 || stats["hits"] != uint64(1) || stats["misses"] != uint64(2) {
+		t.Fatalf("unexpected idempotency stats: %+v", res.Meta["idempotency"])
+	}
+}
+
+func TestDaemonSetIdempotency(t *testing.T) {
+	d := NewDaemon()
+	if err := d.SetIdempotency(0, time.Hour); err == nil {
+		t.Fatal("expected zero size rejected")
+	}
+	if err := d.SetIdempotency(10, 0); err == nil {
+		t.Fatal("expected zero ttl rejected")
+	}
+
+	if err := d.SetIdempotency(1, time.Hour); err != nil {
+		t.Fatalf("set idempotency: %v", err)
+	}
+	d.idempotency.Put("key-a", contracts.CommandResult{CommandID: "a"})
+	d.idempotency.Put("key-b", contracts.CommandResult{CommandID: "b"})
+	if _, ok := d.idempotency.Get("key-a"); ok {
+		t.Fatal("expected size-1 cache to evict the older key")
+	}
+
+	if err := d.SetIdempotency(2, time.Hour); err != nil {
+		t.Fatalf("set idempotency: %v", err)
+	}
+	d.idempotency.Put("key-a", contracts.CommandResult{CommandID: "a"})
+	d.idempotency.Put("key-b", contracts.CommandResult{CommandID: "b"})
+	for _, key := range []string{"key-a", "key-b"} {
+		if _, ok := d.idempotency.Get(key); !ok {
+			t.Fatalf("expected larger cache to keep %s", key)
+		}
+	}
+}
+
+func TestDaemonSetIdempotencyWhileHandling(t *testing.T) {
+	d := NewDaemon()
+	start := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-start
+		for i := 0; i < 50; i++ {
+			cmd := contracts.Command{CommandID: fmt.Sprintf("s-%d", i), IdempotencyKey: fmt.Sprintf("status-key-%d", i), Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: mustPayload(t, contracts.StatusPayload{})}
+			if res, err := d.HandleCommand(context.Background(), cmd); err != nil || !res.OK {
+				t.Errorf("handle: %+v err=%v", res, err)
+				return
+			}
+		}
+	}()
+	close(start)
+	// Keep resizing until every command is handled, so the two overlap.
+	for i := 1; ; i++ {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if err := d.SetIdempotency(i, time.Hour); err != nil {
+			t.Fatalf("set idempotency: %v", err)
+		}
+	}
+}
+
+func TestDaemonReplaysProcessedCommandAfterCacheExpiry(t *testing.T) {
+	d := NewDaemon()
+	cacheNow := time.Now().UTC()
+	d.idempotency = NewIdempotencyCache(10, time.Minute, func() time.Time { return cacheNow })
+
+	calls := 0
+	d.SetHandler(contracts.CommandTypeStatus, func(_ context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
+		calls++
+		return contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "ran"}, nil
+	})
+	cmd := contracts.Command{
+		CommandID:      "redelivered",
+		IdempotencyKey: "idem-redelivered",
+		Type:           contracts.CommandTypeStatus,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        []byte(`{}`),
+	}
+	first, _ := d.HandleCommand(context.Background(), cmd)
+	cacheNow = cacheNow.Add(2 * time.Minute)
+	if _, ok := d.idempotency.Get(cmd.IdempotencyKey); ok {
+		t.Fatal("expected idempotency entry to expire")
+	}
+	second, _ := d.HandleCommand(context.Background(), cmd)
+	if calls != 1 {
+		t.Fatalf("expected handler to run once, ran %d times", calls)
+	}
+	if second.CommandID != first.CommandID || second.Summary != "ran" {
+		t.Fatalf("expected stored result replayed, got %+v want %+v", second, first)
+	}
+}
+
+func TestProcessedCommandsEvictsOldest(t *testing.T) {
+	p := NewProcessedCommands(2)
+	p.Put("c1", contracts.CommandResult{CommandID: "c1"})
+	p.Put("c2", contracts.CommandResult{CommandID: "c2"})
+	p.Put("c2", contracts.CommandResult{CommandID: "c2", OK: true})
+	p.Put("c3", contracts.CommandResult{CommandID: "c3"})
+	if _, ok := p.Get("c1"); ok {
+		t.Fatal("expected oldest command evicted")
+	}
+	if res, ok := p.Get("c2"); !ok || !res.OK {
+		t.Fatalf("expected updated c2 kept, got %+v ok=%v", res, ok)
+	}
+	if _, ok := p.Get("c3"); !ok {
+		t.Fatal("expected newest command kept")
+	}
+	if _, ok := p.Get(""); ok {
+		t.Fatal("expected empty command id to miss")
+	}
+}
+
+func TestDaemonServeAndRunCommandOverrides(t *testing.T) {
+	d := NewDaemon()
+	bin := filepath.Join(t.TempDir(), "opencode-custom")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("write fake binary: %v", err)
+	}
+	d.SetServeCommand(bin)
+	d.SetRunCommand(" " + bin + " ")
+	if d.serveCommand != bin || d.runCommand != bin {
+		t.Fatalf("expected both commands set to %s, got %q/%q", bin, d.serveCommand, d.runCommand)
+	}
+	if err := d.CheckCommands(); err != nil {
+		t.Fatalf("expected executable binary to pass: %v", err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	d.SetRunCommand(missing)
+	err := d.CheckCommands()
+	if err == nil || !strings.Contains(err.Error(), missing) {
+		t.Fatalf("expected error naming missing binary, got %v", err)
+	}
+
+	d.SetServeCommand("")
+	d.SetRunCommand("")
+	if d.serveCommand != defaultOpencodeCommand || d.runCommand != defaultOpencodeCommand {
+		t.Fatalf("expected empty to restore default, got %q/%q", d.serveCommand, d.runCommand)
+	}
+}
+
+func TestDaemonEnabledCommandTypes(t *testing.T) {
+	d := NewDaemon()
+	if err := d.SetEnabledCommandTypes([]string{"status", "launch_missiles"}); err == nil {
+		t.Fatal("expected an unknown command type rejected")
+	}
+	if err := d.SetEnabledCommandTypes([]string{contracts.CommandTypeStatus, " " + contracts.CommandTypeRegisterProject}); err != nil {
+		t.Fatalf("set enabled types: %v", err)
+	}
+	ran := false
+	d.SetHandler(contracts.CommandTypeRunTask, func(context.Context, contracts.Command) (contracts.CommandResult, error) {
+		ran = true
+		return contracts.CommandResult{OK: true}, nil
+	})
+	command := func(id, typ string, payload any) contracts.Command {
+		return contracts.Command{CommandID: id, IdempotencyKey: "idem-" + id, Type: typ, CreatedAt: time.Now().UTC(), Payload: mustPayload(t, payload)}
+	}
+
+	res, err := d.HandleCommand(context.Background(), command("run-1", contracts.CommandTypeRunTask, contracts.RunTaskPayload{ProjectID: "p1", Prompt: "hi"}))
+	if err != nil || res.OK || res.ErrorCode != contracts.ErrValidationInvalidType || ran {
+		t.Fatalf("expected run_task refused, err=%v res=%+v ran=%v", err, res, ran)
+	}
+	res, err = d.HandleCommand(context.Background(), command("status-1", contracts.CommandTypeStatus, contracts.StatusPayload{}))
+	if err != nil || !res.OK {
+		t.Fatalf("expected status to still run, err=%v res=%+v", err, res)
+	}
+
+	if err := d.SetEnabledCommandTypes(nil); err != nil {
+		t.Fatalf("clear enabled types: %v", err)
+	}
+	res, err = d.HandleCommand(context.Background(), command("run-2", contracts.CommandTypeRunTask, contracts.RunTaskPayload{ProjectID: "p1", Prompt: "hi"}))
+	if err != nil || !res.OK || !ran {
+		t.Fatalf("expected run_task enabled again, err=%v res=%+v", err, res)
+	}
+}
+
+func TestNormalizeProjectPathToleratesMissingLeaf(t *testing.T) {
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("eval temp dir: %v", err)
+	}
+	real := filepath.Join(base, "real")
+	if err := os.Mkdir(real, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	link := filepath.Join(base, "link")
+	if err := os.Symlink(real, link); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	got, err := normalizeProjectPath(link+"/", false)
+	if err != nil || got != real {
+		t.Fatalf("expected existing symlink to resolve strictly to %q, got %q err=%v", real, got, err)
+	}
+
+	missing := filepath.Join(link, "clone", "repo")
+	got, err = normalizeProjectPath(missing, true)
+	want := filepath.Join(real, "clone", "repo")
+	if err != nil || got != want {
+		t.Fatalf("expected missing leaf to resolve to %q, got %q err=%v", want, got, err)
+	}
+	if _, err := normalizeProjectPath(missing, false); err == nil {
+		t.Fatal("expected strict mode to reject the missing leaf")
+	}
+
+	// The ID computed before the clone matches the one after it.
+	if err := os.MkdirAll(want, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	after, err := normalizeProjectPath(missing, true)
+	if err != nil || after != got {
+		t.Fatalf("expected stable path %q once created, got %q err=%v", got, after, err)
+	}
+
+	d := NewDaemon()
+	if err := d.SetAllowedRoots([]string{real}); err != nil {
+		t.Fatalf("set allowed roots: %v", err)
+	}
+	for i, tc := range []struct {
+		path string
+		code string
+	}{
+		{filepath.Join(link, "not-cloned-yet"), ""},
+		{filepath.Join(real, "new", "..", "..", "escape"), contracts.ErrPathForbidden},
+		{"/etc/not-created-yet", contracts.ErrPathForbidden},
+	} {
+		res, err := d.HandleCommand(context.Background(), contracts.Command{
+			CommandID:      fmt.Sprintf("reg-missing-%d", i),
+			IdempotencyKey: fmt.Sprintf("idem-reg-missing-%d", i),
+			Type:           contracts.CommandTypeRegisterProject,
+			CreatedAt:      time.Now().UTC(),
+			Payload:        mustPayload(t, contracts.RegisterProjectPayload{ProjectPathRaw: tc.path}),
+		})
+		if err != nil || res.OK != (tc.code == "") || res.ErrorCode != tc.code {
+			t.Fatalf("register %s: expected code %q, got %+v err=%v", tc.path, tc.code, res, err)
+		}
+	}
+}
+
+func TestDaemonDryRun(t *testing.T) {
+	d := NewDaemon()
+	projectPath := t.TempDir()
+	d.mu.Lock()
+	d.projects["p1"] = projectPath
+	d.policies["p1"] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.projects["p2"] = projectPath
+	d.policies["p2"] = projectPolicy{Decision: contracts.DecisionDeny}
+	d.mu.Unlock()
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		t.Fatalf("dry run must not execute %s %v", name, args)
+		return nil
+	}
+	// Holding the global lock shows a dry run does not wait for it.
+	d.mutatingLocker.Lock()
+	defer d.mutatingLocker.Unlock()
+
+	run := contracts.Command{
+		CommandID:      "dry-1",
+		IdempotencyKey: "idem-dry-1",
+		Type:           contracts.CommandTypeRunTask,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: "p1", Prompt: "lint"}),
+		DryRun:         true,
+	}
+	res, err := d.HandleCommand(context.Background(), run)
+	if err != nil || !res.OK || res.Meta["dry_run"] != true {
+		t.Fatalf("expected permitted dry run, got %+v err=%v", res, err)
+	}
+	minPort, _ := d.allocator.Range()
+	if res.Meta["port"] != minPort || res.Meta["server_running"] != false || res.Meta["project_path"] != projectPath || res.Meta["run_dir"] != projectPath {
+		t.Fatalf("unexpected dry run meta: %+v", res.Meta)
+	}
+	if policy, _ := res.Meta["policy"].(map[string]any); policy["decision"] != contracts.DecisionAllow {
+		t.Fatalf("expected effective policy in meta, got %+v", res.Meta["policy"])
+	}
+	if used := d.allocator.SnapshotUsed(); len(used) != 0 {
+		t.Fatalf("expected no port reserved, got %v", used)
+	}
+	if d.idempotency.Contains(run.IdempotencyKey) {
+		t.Fatal("expected dry run to bypass the idempotency cache")
+	}
+	if _, ok := d.processed.Get(run.CommandID); ok {
+		t.Fatal("expected dry run not to be recorded as processed")
+	}
+
+	// with a branch the subdir may exist only there, so it is not looked up
+	onBranch := contracts.Command{
+		CommandID:      "dry-4",
+		IdempotencyKey: "idem-dry-4",
+		Type:           contracts.CommandTypeRunTask,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: "p1", Prompt: "lint", Branch: "feature", Subdir: "services/api"}),
+		DryRun:         true,
+	}
+	if res, _ := d.HandleCommand(context.Background(), onBranch); !res.OK || res.Meta["run_dir"] != filepath.Join(projectPath, "services", "api") {
+		t.Fatalf("expected the branch subdir reported unresolved, got %+v", res)
+	}
+	onBranch.CommandID, onBranch.Payload = "dry-5", mustPayload(t, contracts.RunTaskPayload{ProjectID: "p1", Prompt: "lint", Branch: "feature", Subdir: "../x"})
+	if res, _ := d.HandleCommand(context.Background(), onBranch); res.OK || res.ErrorCode != contracts.ErrPathForbidden {
+		t.Fatalf("expected an escaping subdir refused on a branch too, got %+v", res)
+	}
+
+	start := contracts.Command{
+		CommandID:      "dry-2",
+		IdempotencyKey: "idem-dry-2",
+		Type:           contracts.CommandTypeStartServer,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.StartServerPayload{ProjectID: "p2"}),
+		DryRun:         true,
+	}
+	res, err = d.HandleCommand(context.Background(), start)
+	if err != nil || res.OK || res.ErrorCode != contracts.ErrPolicyDenied || res.Meta["dry_run"] != true {
+		t.Fatalf("expected policy-denied dry run, got %+v err=%v", res, err)
+	}
+
+	status := contracts.Command{
+		CommandID:      "dry-3",
+		IdempotencyKey: "idem-dry-3",
+		Type:           contracts.CommandTypeStatus,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.StatusPayload{}),
+		DryRun:         true,
+	}
+	if res, _ := d.HandleCommand(context.Background(), status); res.OK || res.ErrorCode != contracts.ErrValidationInvalidType {
+		t.Fatalf("expected dry run of status to be unsupported, got %+v", res)
+	}
+}
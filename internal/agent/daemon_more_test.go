@@ -1,16 +1,27 @@
 package agent
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -29,7 +40,7 @@ func TestDaemonRunPollLoop_BackoffAndPostError(t *testing.T) {
 		mu.Unlock()
 	}
 
-	cmd := contracts.Command{CommandID: "c1", IdempotencyKey: "i1", Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(`{}`)}
+	cmd := contracts.Command{CommandID: "c1", IdempotencyKey: "i1-00000", Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(`{}`)}
 	pc := &sequencePollClient{
 		poll:      []pollStep{{err: errors.New("poll fail")}, {cmd: &cmd}, {stop: true}},
 		postErrAt: map[int]error{1: errors.New("post fail")},
@@ -66,6 +77,56 @@ func TestDaemonRunPollLoop_BackoffAndPostError(t *testing.T) {
 	}
 }
 
+func TestDaemonRunPollLoop_RateLimited(t *testing.T) {
+	d := NewDaemon()
+	d.jitter = rand.New(rand.NewSource(1))
+
+	var sleeps []time.Duration
+	var mu sync.Mutex
+	d.sleep = func(dur time.Duration) {
+		mu.Lock()
+		sleeps = append(sleeps, dur)
+		mu.Unlock()
+	}
+	pc := &sequencePollClient{
+		poll: []pollStep{
+			{err: &RateLimitedError{RetryAfter: 3 * time.Second}},
+			{err: fmt.Errorf("wrapped: %w", &RateLimitedError{RetryAfter: 3 * time.Second})},
+			{err: errors.New("poll fail")},
+			{stop: true},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.RunPollLoop(ctx, pc, 1)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for poll loop activity")
+		}
+		mu.Lock()
+		sleepCount := len(sleeps)
+		mu.Unlock()
+		if sleepCount >= 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if sleeps[0] != 3*time.Second || sleeps[1] != 3*time.Second {
+		t.Fatalf("expected to wait Retry-After when rate limited, got %v", sleeps)
+	}
+	// throttling must not grow the failure backoff
+	if sleeps[2] < d.backoffBase || sleeps[2] >= 2*d.backoffBase {
+		t.Fatalf("expected first-attempt backoff after rate limiting, got %v", sleeps[2])
+	}
+}
+
 func TestDaemonHandleRunTask_SuccessAndTimeout(t *testing.T) {
 	d := NewDaemon()
 	projectID := "p1"
@@ -94,6 +155,25 @@ func TestDaemonHandleRunTask_SuccessAndTimeout(t *testing.T) {
 		t.Fatalf("expected run_task success, err=%v res=%+v", err, res)
 	}
 
+	var gotArgs []string
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		gotArgs = args
+		return exec.Command("true")
+	}
+	modelCmd := contracts.Command{
+		CommandID:      "run-model",
+		IdempotencyKey: "idem-run-model",
+		Type:           contracts.CommandTypeRunTask,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "hello", Model: "anthropic/claude-sonnet"}),
+	}
+	if res, err := d.HandleCommand(context.Background(), modelCmd); err != nil || !res.OK {
+		t.Fatalf("expected run_task with model success, err=%v res=%+v", err, res)
+	}
+	if strings.Join(gotArgs, " ") != "run --attach http://127.0.0.1:4321 --model anthropic/claude-sonnet hello" {
+		t.Fatalf("unexpected run args: %v", gotArgs)
+	}
+
 	d.commandTimeout = 1 * time.Millisecond
 	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
 		_ = name
@@ -116,6 +196,422 @@ func TestDaemonHandleRunTask_SuccessAndTimeout(t *testing.T) {
 	}
 }
 
+func TestDaemonAbortSession(t *testing.T) {
+	var paths []string
+	status := http.StatusOK
+	oc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer oc.Close()
+	port := oc.Listener.Addr().(*net.TCPAddr).Port
+
+	d := NewDaemon()
+	abortCmd := func(id string) contracts.Command {
+		return contracts.Command{
+			CommandID:      "abort-" + id,
+			IdempotencyKey: "idem-abort-" + id,
+			Type:           contracts.CommandTypeAbortSession,
+			CreatedAt:      time.Now().UTC(),
+			Payload:        mustPayload(t, contracts.AbortSessionPayload{ProjectID: "p1", SessionID: "ses_1"}),
+		}
+	}
+	if res, _ := d.HandleCommand(context.Background(), abortCmd("idle")); !res.OK || res.Summary != "server not running" {
+		t.Fatalf("expected abort without a server to be a no-op, got %+v", res)
+	}
+
+	d.mu.Lock()
+	d.servers["p1"] = &serverState{ProjectID: "p1", Port: port}
+	d.mu.Unlock()
+	if res, _ := d.HandleCommand(context.Background(), abortCmd("ok")); !res.OK || res.Summary != "session aborted" {
+		t.Fatalf("expected session aborted, got %+v", res)
+	}
+	if len(paths) != 1 || paths[0] != "POST /session/ses_1/abort" {
+		t.Fatalf("expected abort request to opencode, got %v", paths)
+	}
+
+	status = http.StatusNotFound
+	if res, _ := d.HandleCommand(context.Background(), abortCmd("missing")); res.OK || res.ErrorCode != contracts.ErrInternal {
+		t.Fatalf("expected opencode failure to be reported, got %+v", res)
+	}
+}
+
+func TestDaemonCancelTask(t *testing.T) {
+	d := NewDaemon()
+	projectID := "p1"
+	d.mu.Lock()
+	d.projects[projectID] = t.TempDir()
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.servers[projectID] = &serverState{ProjectID: projectID, Port: 4321}
+	d.mu.Unlock()
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sleep", "30")
+	}
+
+	cancelCmd := func(target string) contracts.Command {
+		return contracts.Command{
+			CommandID:      "cancel-" + target,
+			IdempotencyKey: "idem-cancel-" + target,
+			Type:           contracts.CommandTypeCancelTask,
+			CreatedAt:      time.Now().UTC(),
+			Payload:        mustPayload(t, contracts.CancelTaskPayload{CommandID: target}),
+		}
+	}
+	if res, _ := d.HandleCommand(context.Background(), cancelCmd("missing")); !res.OK || res.Summary != "task not running" {
+		t.Fatalf("expected cancel of unknown task to be a no-op, got %+v", res)
+	}
+
+	done := make(chan contracts.CommandResult, 1)
+	go func() {
+		res, _ := d.HandleCommand(context.Background(), contracts.Command{
+			CommandID:      "run-long",
+			IdempotencyKey: "idem-run-long",
+			Type:           contracts.CommandTypeRunTask,
+			CreatedAt:      time.Now().UTC(),
+			Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "slow"}),
+		})
+		done <- res
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d.mu.RLock()
+		_, running := d.tasks["run-long"]
+		d.mu.RUnlock()
+		if running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("run_task never registered as running")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if res, _ := d.HandleCommand(context.Background(), cancelCmd("run-long")); !res.OK || res.Summary != "task cancelled" {
+		t.Fatalf("expected cancel to succeed, got %+v", res)
+	}
+	select {
+	case res := <-done:
+		if res.OK || res.ErrorCode != contracts.ErrCancelled {
+			t.Fatalf("expected cancelled run_task result, got %+v", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run_task did not stop after cancel")
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if len(d.tasks) != 0 {
+		t.Fatalf("expected finished task to be untracked, got %v", d.tasks)
+	}
+}
+
+// cancelPollClient delivers a run_task, then a cancel_task for it once the
+// task is running, and records posted results. Without a cancel_task it
+// only blocks after the run_task.
+type cancelPollClient struct {
+	d       *Daemon
+	run     contracts.Command
+	cancel  contracts.Command
+	mu      sync.Mutex
+	polls   int
+	results []contracts.CommandResult
+}
+
+func (c *cancelPollClient) PollCommand(ctx context.Context, timeoutSeconds int) (*contracts.Command, error) {
+	c.mu.Lock()
+	c.polls++
+	poll := c.polls
+	c.mu.Unlock()
+	switch {
+	case poll == 1:
+		return &c.run, nil
+	case poll == 2 && c.cancel.CommandID != "":
+		for {
+			c.d.mu.RLock()
+			_, running := c.d.tasks[c.run.CommandID]
+			c.d.mu.RUnlock()
+			if running {
+				return &c.cancel, nil
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c *cancelPollClient) PostResult(ctx context.Context, result contracts.CommandResult) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = append(c.results, result)
+	return nil
+}
+
+func (c *cancelPollClient) PostProgress(ctx context.Context, result contracts.CommandResult) error {
+	return nil
+}
+
+func (c *cancelPollClient) snapshot() []contracts.CommandResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]contracts.CommandResult(nil), c.results...)
+}
+
+func TestDaemonRunPollLoopCancelsRunningTask(t *testing.T) {
+	d := NewDaemon()
+	projectID := "p1"
+	d.mu.Lock()
+	d.projects[projectID] = t.TempDir()
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.servers[projectID] = &serverState{ProjectID: projectID, Port: 4321}
+	d.mu.Unlock()
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sleep", "30")
+	}
+	pc := &cancelPollClient{
+		d:      d,
+		run:    contracts.Command{CommandID: "run-1", IdempotencyKey: "idem-run-1", Type: contracts.CommandTypeRunTask, CreatedAt: time.Now().UTC(), Payload: mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "slow"})},
+		cancel: contracts.Command{CommandID: "cancel-1", IdempotencyKey: "idem-cancel-1", Type: contracts.CommandTypeCancelTask, CreatedAt: time.Now().UTC(), Payload: mustPayload(t, contracts.CancelTaskPayload{CommandID: "run-1"})},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		d.RunPollLoop(ctx, pc, 1)
+		close(stopped)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(pc.snapshot()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for results, got %+v", pc.snapshot())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-stopped
+
+	byID := map[string]contracts.CommandResult{}
+	for _, res := range pc.snapshot() {
+		byID[res.CommandID] = res
+	}
+	if !byID["cancel-1"].OK {
+		t.Fatalf("expected cancel_task to succeed, got %+v", byID)
+	}
+	if byID["run-1"].ErrorCode != contracts.ErrCancelled {
+		t.Fatalf("expected cancelled run_task result, got %+v", byID)
+	}
+}
+
+func TestDaemonRunPollLoopStopsRunningTaskOnSIGTERM(t *testing.T) {
+	d := NewDaemon()
+	projectID := "p1"
+	d.mu.Lock()
+	d.projects[projectID] = t.TempDir()
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.servers[projectID] = &serverState{ProjectID: projectID, Port: 4321}
+	d.mu.Unlock()
+	var mu sync.Mutex
+	var started *exec.Cmd
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		mu.Lock()
+		defer mu.Unlock()
+		started = exec.CommandContext(ctx, "sleep", "30")
+		return started
+	}
+	pc := &cancelPollClient{
+		d:   d,
+		run: contracts.Command{CommandID: "run-1", IdempotencyKey: "idem-run-1", Type: contracts.CommandTypeRunTask, CreatedAt: time.Now().UTC(), Payload: mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "slow"})},
+	}
+
+	// the agent's main loop: a signal cancels the poll context
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		d.RunPollLoop(ctx, pc, 1)
+		close(stopped)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d.mu.RLock()
+		_, running := d.tasks["run-1"]
+		d.mu.RUnlock()
+		if running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the run to start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("send SIGTERM: %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the poll loop to return once the run was cancelled")
+	}
+
+	results := pc.snapshot()
+	if len(results) != 1 || results[0].CommandID != "run-1" || results[0].ErrorCode != contracts.ErrCancelled {
+		t.Fatalf("expected the cancelled run's result posted, got %+v", results)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if started == nil || started.ProcessState == nil {
+		t.Fatal("expected the opencode process to be stopped and reaped")
+	}
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+}
+
+func TestDaemonHandleRunTask_CancelledByShutdown(t *testing.T) {
+	d := NewDaemon()
+	projectID := "p1"
+	d.mu.Lock()
+	d.projects[projectID] = t.TempDir()
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.servers[projectID] = &serverState{ProjectID: projectID, Port: 4321}
+	d.mu.Unlock()
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sleep", "30")
+	}
+
+	// shutdown cancels the context the poll loop handed the command
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for {
+			d.mu.RLock()
+			_, running := d.tasks["run-shutdown"]
+			d.mu.RUnlock()
+			if running {
+				cancel()
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	res, err := d.HandleCommand(ctx, contracts.Command{
+		CommandID:      "run-shutdown",
+		IdempotencyKey: "idem-run-shutdown",
+		Type:           contracts.CommandTypeRunTask,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "slow"}),
+	})
+	if err != nil || res.OK || res.ErrorCode != contracts.ErrCancelled || res.Summary != "task cancelled: agent shutting down" {
+		t.Fatalf("expected the run cancelled by shutdown, err=%v res=%+v", err, res)
+	}
+}
+
+func TestDaemonHandleRunTask_ReportsProgress(t *testing.T) {
+	d := NewDaemon()
+	d.progressInterval = 0
+	projectID := "p1"
+	d.mu.Lock()
+	d.projects[projectID] = t.TempDir()
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.servers[projectID] = &serverState{ProjectID: projectID, Port: 4321}
+	d.mu.Unlock()
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", "echo step one; echo step two")
+	}
+
+	var progress []contracts.CommandResult
+	ctx := WithProgress(context.Background(), func(res contracts.CommandResult) {
+		progress = append(progress, res)
+	})
+	cmd := contracts.Command{
+		CommandID:      "run-progress",
+		IdempotencyKey: "idem-run-progress",
+		Type:           contracts.CommandTypeRunTask,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "hello"}),
+	}
+	res, err := d.HandleCommand(ctx, cmd)
+	if err != nil || !res.OK || res.InProgress {
+		t.Fatalf("expected final run_task success, err=%v res=%+v", err, res)
+	}
+	if res.Stdout != "step one\nstep two\n" {
+		t.Fatalf("expected full stdout in final result, got %q", res.Stdout)
+	}
+	if len(progress) == 0 {
+		t.Fatal("expected at least one progress update")
+	}
+	first := progress[0]
+	if first.CommandID != "run-progress" || !first.InProgress || first.OK || !strings.HasPrefix(first.Stdout, "step one\n") {
+		t.Fatalf("unexpected progress update: %+v", first)
+	}
+}
+
+func TestProgressWriterThrottles(t *testing.T) {
+	now := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
+	var reports []contracts.CommandResult
+	var out bytes.Buffer
+	w := &progressWriter{
+		commandID: "c1",
+		out:       &out,
+		report:    func(res contracts.CommandResult) { reports = append(reports, res) },
+		now:       func() time.Time { return now },
+		interval:  time.Second,
+	}
+	_, _ = w.Write([]byte("partial"))
+	_, _ = w.Write([]byte(" line\n"))
+	_, _ = w.Write([]byte("second\n"))
+	now = now.Add(time.Second)
+	_, _ = w.Write([]byte("third\n"))
+	if len(reports) != 2 {
+		t.Fatalf("expected 2 throttled reports, got %d", len(reports))
+	}
+	if reports[0].Stdout != "partial line\n" || reports[1].Stdout != "partial line\nsecond\nthird\n" {
+		t.Fatalf("unexpected report output: %+v", reports)
+	}
+	if out.String() != "partial line\nsecond\nthird\n" {
+		t.Fatalf("expected all output captured, got %q", out.String())
+	}
+}
+
+func TestDaemonRejectsStaleCommands(t *testing.T) {
+	d := NewDaemon()
+	now := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+
+	cmd := contracts.Command{
+		CommandID:      "status-old",
+		IdempotencyKey: "idem-status-old",
+		Type:           contracts.CommandTypeStatus,
+		CreatedAt:      now.Add(-time.Hour),
+		Payload:        json.RawMessage(`{}`),
+	}
+	// by default only future-dated commands are refused: an old command may
+	// have waited in the queue for this agent
+	res, err := d.HandleCommand(context.Background(), cmd)
+	if err != nil || !res.OK {
+		t.Fatalf("expected queued command accepted by default, err=%v res=%+v", err, res)
+	}
+	d.SetFreshnessWindow(contracts.FreshnessWindow{MaxAge: 30 * time.Minute, MaxFutureSkew: 2 * time.Minute})
+	cmd.CommandID, cmd.IdempotencyKey = "status-stale", "idem-status-stale"
+	res, err = d.HandleCommand(context.Background(), cmd)
+	if err != nil || res.OK || res.ErrorCode != contracts.ErrValidationInvalidRequest {
+		t.Fatalf("expected stale command rejected, err=%v res=%+v", err, res)
+	}
+
+	cmd.CreatedAt = now.Add(5 * time.Minute)
+	if res, _ := d.HandleCommand(context.Background(), cmd); res.OK || res.ErrorCode != contracts.ErrValidationInvalidRequest {
+		t.Fatalf("expected future command rejected, got %+v", res)
+	}
+
+	d.SetFreshnessWindow(contracts.FreshnessWindow{MaxAge: 2 * time.Hour})
+	cmd.CreatedAt = now.Add(-time.Hour)
+	if res, _ := d.HandleCommand(context.Background(), cmd); !res.OK {
+		t.Fatalf("expected command accepted with wider window, got %+v", res)
+	}
+}
+
 func TestDaemonWaitForReadyAndHelpers(t *testing.T) {
 	var calls int
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -217,3 +713,696 @@ func (s *sequencePollClient) PostResult(ctx context.Context, result contracts.Co
 	}
 	return nil
 }
+
+func (s *sequencePollClient) PostProgress(ctx context.Context, result contracts.CommandResult) error {
+	_ = ctx
+	_ = result
+	return nil
+}
+
+func TestDaemonWaitForReadyCustomPath(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("parse test server url: %v", err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatalf("parse test server port: %v", err)
+	}
+
+	d := NewDaemon()
+	d.client = srv.Client()
+	d.sleep = func(time.Duration) {}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if d.waitForReady(ctx, port) {
+		t.Fatal("expected default path to never become ready")
+	}
+
+	d.SetReadinessPath("api/health")
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !d.waitForReady(ctx, port) {
+		t.Fatal("expected 204 on the configured path to count as ready")
+	}
+
+	d.SetReadinessPath("")
+	if d.readinessPath != defaultReadinessPath {
+		t.Fatalf("expected empty path to restore default, got %q", d.readinessPath)
+	}
+}
+
+func TestDaemonHandleStopServer(t *testing.T) {
+	d := NewDaemon()
+	projectID := "p1"
+	stopCmd := func(id string, payload any) contracts.Command {
+		return contracts.Command{
+			CommandID:      id,
+			IdempotencyKey: "idem-" + id,
+			Type:           contracts.CommandTypeStopServer,
+			CreatedAt:      time.Now().UTC(),
+			Payload:        mustPayload(t, payload),
+		}
+	}
+
+	res, err := d.HandleCommand(context.Background(), stopCmd("stop-missing", map[string]string{"project_id": ""}))
+	if err != nil || res.OK || res.ErrorCode != contracts.ErrValidationRequiredField {
+		t.Fatalf("expected required field error, err=%v res=%+v", err, res)
+	}
+
+	res, err = d.HandleCommand(context.Background(), stopCmd("stop-idle", contracts.StopServerPayload{ProjectID: projectID}))
+	if err != nil || !res.OK || res.Summary != "server not running" {
+		t.Fatalf("expected idempotent stop, err=%v res=%+v", err, res)
+	}
+
+	proc := exec.Command("sleep", "5")
+	if err := proc.Start(); err != nil {
+		t.Fatalf("start helper process: %v", err)
+	}
+	port, err := d.allocator.Allocate(projectID)
+	if err != nil {
+		t.Fatalf("allocate port: %v", err)
+	}
+	state := &serverState{ProjectID: projectID, Port: port, Cmd: proc}
+	d.setServer(projectID, state)
+
+	res, err = d.HandleCommand(context.Background(), stopCmd("stop-running", contracts.StopServerPayload{ProjectID: projectID}))
+	if err != nil || !res.OK || res.Summary != "server stopped" {
+		t.Fatalf("expected server stopped, err=%v res=%+v", err, res)
+	}
+	_ = proc.Wait()
+	if d.serverForProject(projectID) != nil {
+		t.Fatal("expected server state cleared")
+	}
+	if used := d.allocator.SnapshotUsed(); len(used) != 0 {
+		t.Fatalf("expected port released, got %v", used)
+	}
+
+	// a late exit of the stopped server must not evict a replacement
+	replacement := &serverState{ProjectID: projectID, Port: port}
+	d.setServer(projectID, replacement)
+	d.clearServerState(projectID, state)
+	if d.serverForProject(projectID) != replacement {
+		t.Fatal("expected replacement server to survive stale clear")
+	}
+}
+
+func TestDaemonHandleRunTask_CapturesOutput(t *testing.T) {
+	d := NewDaemon()
+	projectID := "p1"
+	d.mu.Lock()
+	d.projects[projectID] = t.TempDir()
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.servers[projectID] = &serverState{ProjectID: projectID, Port: 4321}
+	d.mu.Unlock()
+
+	script := "echo out; echo err >&2"
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", script)
+	}
+	runCmd := func(id string) contracts.Command {
+		return contracts.Command{
+			CommandID:      id,
+			IdempotencyKey: "idem-" + id,
+			Type:           contracts.CommandTypeRunTask,
+			CreatedAt:      time.Now().UTC(),
+			Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "hello"}),
+		}
+	}
+
+	res, err := d.HandleCommand(context.Background(), runCmd("run-ok"))
+	if err != nil || !res.OK {
+		t.Fatalf("expected success, err=%v res=%+v", err, res)
+	}
+	if res.Stdout != "out\n" || res.Stderr != "err\n" {
+		t.Fatalf("expected captured output, got stdout=%q stderr=%q", res.Stdout, res.Stderr)
+	}
+	if res.ExitCode != nil {
+		t.Fatalf("expected no exit code on success, got %d", *res.ExitCode)
+	}
+
+	script = "echo partial; echo boom >&2; exit 3"
+	res, err = d.HandleCommand(context.Background(), runCmd("run-fail"))
+	if err != nil || res.OK {
+		t.Fatalf("expected failure result, err=%v res=%+v", err, res)
+	}
+	if res.Stdout != "partial\n" || res.Stderr != "boom\n" {
+		t.Fatalf("expected output on failure, got stdout=%q stderr=%q", res.Stdout, res.Stderr)
+	}
+	if res.ExitCode == nil || *res.ExitCode != 3 {
+		t.Fatalf("expected exit code 3, got %v", res.ExitCode)
+	}
+
+	d.commandTimeout = 100 * time.Millisecond
+	script = "echo early; exec sleep 5"
+	res, err = d.HandleCommand(context.Background(), runCmd("run-timeout"))
+	if err != nil || res.ErrorCode != contracts.ErrStartTimeout {
+		t.Fatalf("expected timeout result, err=%v res=%+v", err, res)
+	}
+	if res.Stdout != "early\n" {
+		t.Fatalf("expected output captured before deadline, got %q", res.Stdout)
+	}
+	if res.Meta["timeout_seconds"] != 1 {
+		t.Fatalf("expected timeout_seconds in meta, got %+v", res.Meta)
+	}
+}
+
+func TestDaemonHandleRunTask_ProjectRunTimeout(t *testing.T) {
+	d := NewDaemon()
+	d.commandTimeout = 10 * time.Second
+	register := func(id string, seconds int) (contracts.CommandResult, error) {
+		return d.HandleCommand(context.Background(), contracts.Command{
+			CommandID:      id,
+			IdempotencyKey: "idem-" + id,
+			Type:           contracts.CommandTypeRegisterProject,
+			CreatedAt:      time.Now().UTC(),
+			Payload:        mustPayload(t, contracts.RegisterProjectPayload{ProjectPathRaw: t.TempDir(), RunTimeoutSeconds: seconds}),
+		})
+	}
+
+	res, err := register("reg-too-long", contracts.MaxRunTimeoutSeconds+1)
+	if err != nil || res.OK || res.ErrorCode != contracts.ErrValidationInvalidPayload {
+		t.Fatalf("expected oversized timeout rejected, err=%v res=%+v", err, res)
+	}
+
+	res, err = register("reg-timeout", 30)
+	if err != nil || !res.OK {
+		t.Fatalf("register failed: err=%v res=%+v", err, res)
+	}
+	if got, _ := res.Meta["run_timeout_seconds"].(int); got != 30 {
+		t.Fatalf("expected run_timeout_seconds echoed, got %v", res.Meta["run_timeout_seconds"])
+	}
+	projectID, _ := res.Meta["project_id"].(string)
+	if got := d.runTimeout(projectID); got != 30*time.Second {
+		t.Fatalf("expected 30s project timeout, got %s", got)
+	}
+	if got := d.runTimeout("other"); got != d.commandTimeout {
+		t.Fatalf("expected global timeout for other projects, got %s", got)
+	}
+
+	// a shorter project timeout trips before the global one
+	d.mu.Lock()
+	d.runTimeouts[projectID] = 100 * time.Millisecond
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.servers[projectID] = &serverState{ProjectID: projectID, Port: 4321}
+	d.mu.Unlock()
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", "exec sleep 5")
+	}
+	start := time.Now()
+	res, err = d.HandleCommand(context.Background(), contracts.Command{
+		CommandID:      "run-project-timeout",
+		IdempotencyKey: "idem-run-project-timeout",
+		Type:           contracts.CommandTypeRunTask,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "hello"}),
+	})
+	if err != nil || res.ErrorCode != contracts.ErrStartTimeout {
+		t.Fatalf("expected timeout result, err=%v res=%+v", err, res)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected project timeout to apply, took %s", elapsed)
+	}
+
+	// re-registering without a timeout falls back to the global one
+	d.mu.Lock()
+	path := d.projects[projectID]
+	d.mu.Unlock()
+	res, err = d.HandleCommand(context.Background(), contracts.Command{
+		CommandID:      "reg-clear",
+		IdempotencyKey: "idem-reg-clear",
+		Type:           contracts.CommandTypeRegisterProject,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.RegisterProjectPayload{ProjectPathRaw: path}),
+	})
+	if err != nil || !res.OK {
+		t.Fatalf("re-register failed: err=%v res=%+v", err, res)
+	}
+	if got := d.runTimeout(projectID); got != d.commandTimeout {
+		t.Fatalf("expected global timeout after clearing, got %s", got)
+	}
+}
+
+func TestDaemonHandleRunTask_RedeliveryDuringRunJoins(t *testing.T) {
+	d := NewDaemon()
+	d.runConcurrency = 2
+	projectID := "p1"
+	d.mu.Lock()
+	d.projects[projectID] = t.TempDir()
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.servers[projectID] = &serverState{ProjectID: projectID, Port: 4321}
+	d.mu.Unlock()
+	var runs atomic.Int32
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		runs.Add(1)
+		started <- struct{}{}
+		<-release
+		return exec.CommandContext(ctx, "sh", "-c", "echo done")
+	}
+	cmd := contracts.Command{
+		CommandID:      "run-long",
+		IdempotencyKey: "idem-run-long",
+		Type:           contracts.CommandTypeRunTask,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "hello"}),
+	}
+
+	results := make(chan contracts.CommandResult, 2)
+	go func() {
+		res, _ := d.HandleCommand(context.Background(), cmd)
+		results <- res
+	}()
+	<-started
+	// the backend redelivers the command while the first copy is running
+	go func() {
+		res, _ := d.HandleCommand(context.Background(), cmd)
+		results <- res
+	}()
+	select {
+	case <-started:
+		t.Fatal("expected the redelivered copy not to start a second run")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	first, second := <-results, <-results
+	if !first.OK || !second.OK || first.Stdout != "done\n" || second.Stdout != first.Stdout {
+		t.Fatalf("expected both deliveries to report the single run, got %+v and %+v", first, second)
+	}
+	if got := runs.Load(); got != 1 {
+		t.Fatalf("expected one run, got %d", got)
+	}
+	d.mu.RLock()
+	left := len(d.runningTasks)
+	d.mu.RUnlock()
+	if left != 0 {
+		t.Fatalf("expected running set cleared, got %d entries", left)
+	}
+}
+
+func TestDaemonHandleRunTask_PayloadTimeout(t *testing.T) {
+	d := NewDaemon()
+	d.commandTimeout = 10 * time.Second
+	projectID := "p1"
+	d.mu.Lock()
+	d.projects[projectID] = t.TempDir()
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.servers[projectID] = &serverState{ProjectID: projectID, Port: 4321}
+	d.mu.Unlock()
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", "exec sleep 5")
+	}
+	run := func(id string, seconds int) (contracts.CommandResult, error) {
+		return d.HandleCommand(context.Background(), contracts.Command{
+			CommandID:      id,
+			IdempotencyKey: "idem-" + id,
+			Type:           contracts.CommandTypeRunTask,
+			CreatedAt:      time.Now().UTC(),
+			Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "hello", TimeoutSeconds: seconds}),
+		})
+	}
+
+	// a shorter payload timeout trips before the daemon's
+	start := time.Now()
+	res, err := run("run-short", 1)
+	if err != nil || res.ErrorCode != contracts.ErrStartTimeout {
+		t.Fatalf("expected timeout result, err=%v res=%+v", err, res)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected payload timeout to apply, took %s", elapsed)
+	}
+	if res.Meta["timeout_seconds"] != 1 || res.Meta["timeout_phase"] != "run" {
+		t.Fatalf("expected payload timeout in meta, got %+v", res.Meta)
+	}
+
+	// a payload timeout above the project's run timeout is rejected
+	res, err = run("run-too-long", 11)
+	if err != nil || res.OK || res.ErrorCode != contracts.ErrValidationInvalidPayload {
+		t.Fatalf("expected oversized timeout rejected, err=%v res=%+v", err, res)
+	}
+	if res.Meta["max_seconds"] != 10 {
+		t.Fatalf("expected max_seconds in meta, got %+v", res.Meta)
+	}
+	// one large enough to overflow a Duration is rejected too
+	res, err = run("run-overflow", math.MaxInt64/int(time.Second)*2+1)
+	if err != nil || res.OK || res.ErrorCode != contracts.ErrValidationInvalidPayload {
+		t.Fatalf("expected overflowing timeout rejected, err=%v res=%+v", err, res)
+	}
+
+	// start_server is capped by the start timeout instead
+	res, err = d.HandleCommand(context.Background(), contracts.Command{
+		CommandID:      "start-too-long",
+		IdempotencyKey: "idem-start-too-long",
+		Type:           contracts.CommandTypeStartServer,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.StartServerPayload{ProjectID: projectID, TimeoutSeconds: 11}),
+	})
+	if err != nil || res.OK || res.ErrorCode != contracts.ErrValidationInvalidPayload {
+		t.Fatalf("expected oversized start timeout rejected, err=%v res=%+v", err, res)
+	}
+	if res.Meta["max_seconds"] != 10 {
+		t.Fatalf("expected start timeout as max_seconds, got %+v", res.Meta)
+	}
+}
+
+func TestDaemonHandleRunTask_Subdir(t *testing.T) {
+	d := NewDaemon()
+	projectID := "p1"
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "services", "api"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	d.projects[projectID] = root
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.servers[projectID] = &serverState{ProjectID: projectID, Port: 4321}
+	d.mu.Unlock()
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		if name == "git" {
+			// the branch brings a directory the working tree lacked
+			return exec.CommandContext(ctx, "mkdir", "-p", filepath.Join(root, "generated"))
+		}
+		return exec.CommandContext(ctx, "sh", "-c", "pwd")
+	}
+	branch := ""
+	run := func(id, subdir string) (contracts.CommandResult, error) {
+		return d.HandleCommand(context.Background(), contracts.Command{
+			CommandID:      id,
+			IdempotencyKey: "idem-" + id,
+			Type:           contracts.CommandTypeRunTask,
+			CreatedAt:      time.Now().UTC(),
+			Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "hello", Subdir: subdir, Branch: branch}),
+		})
+	}
+
+	res, err := run("run-root", "")
+	if err != nil || !res.OK || res.Stdout != root+"\n" {
+		t.Fatalf("expected run in project root, err=%v res=%+v", err, res)
+	}
+	res, err = run("run-subdir", "services/api")
+	if err != nil || !res.OK || res.Stdout != filepath.Join(root, "services", "api")+"\n" {
+		t.Fatalf("expected run in subdir, err=%v res=%+v", err, res)
+	}
+
+	for i, subdir := range []string{"../outside", "services/../../x", "/etc", "escape"} {
+		res, err = run(fmt.Sprintf("run-forbidden-%d", i), subdir)
+		if err != nil || res.OK || res.ErrorCode != contracts.ErrPathForbidden {
+			t.Fatalf("expected %q forbidden, err=%v res=%+v", subdir, err, res)
+		}
+	}
+	res, err = run("run-missing", "nope")
+	if err != nil || res.OK || res.ErrorCode != contracts.ErrPathInvalid {
+		t.Fatalf("expected missing subdir rejected, err=%v res=%+v", err, res)
+	}
+
+	// the subdir is resolved on the branch, after the checkout
+	branch = "feature"
+	res, err = run("run-branch-subdir", "generated")
+	if err != nil || !res.OK || res.Stdout != filepath.Join(root, "generated")+"\n" {
+		t.Fatalf("expected run in the branch's subdir, err=%v res=%+v", err, res)
+	}
+}
+
+func TestDaemonHandleRunTask_Attachments(t *testing.T) {
+	d := NewDaemon()
+	projectID := "p1"
+	d.mu.Lock()
+	d.projects[projectID] = t.TempDir()
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.servers[projectID] = &serverState{ProjectID: projectID, Port: 4321}
+	d.mu.Unlock()
+
+	var gotArgs []string
+	var gotContent string
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		gotArgs = args
+		for i, arg := range args {
+			if arg == "--file" {
+				data, _ := os.ReadFile(args[i+1])
+				gotContent = string(data)
+			}
+		}
+		return exec.Command("true")
+	}
+	runCmd := func(id string, attachments []contracts.Attachment) contracts.Command {
+		return contracts.Command{
+			CommandID:      id,
+			IdempotencyKey: "idem-" + id,
+			Type:           contracts.CommandTypeRunTask,
+			CreatedAt:      time.Now().UTC(),
+			Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "hello", Attachments: attachments}),
+		}
+	}
+
+	notes := []contracts.Attachment{{Name: "notes.txt", ContentBase64: base64.StdEncoding.EncodeToString([]byte("hello"))}}
+	res, err := d.HandleCommand(context.Background(), runCmd("run-files", notes))
+	if err != nil || !res.OK {
+		t.Fatalf("expected success, err=%v res=%+v", err, res)
+	}
+	if len(gotArgs) != 6 || gotArgs[3] != "--file" || filepath.Base(gotArgs[4]) != "notes.txt" || gotArgs[5] != "hello" {
+		t.Fatalf("unexpected run args: %v", gotArgs)
+	}
+	if gotContent != "hello" {
+		t.Fatalf("expected attachment written before run, got %q", gotContent)
+	}
+	if _, err := os.Stat(filepath.Dir(gotArgs[4])); !os.IsNotExist(err) {
+		t.Fatalf("expected attachment dir removed after run, stat err=%v", err)
+	}
+
+	d.SetMaxAttachmentBytes(4)
+	gotArgs = nil
+	res, err = d.HandleCommand(context.Background(), runCmd("run-big", notes))
+	if err != nil || res.OK || res.ErrorCode != contracts.ErrValidationInvalidPayload {
+		t.Fatalf("expected oversized attachments rejected, err=%v res=%+v", err, res)
+	}
+	if gotArgs != nil {
+		t.Fatal("expected opencode not to run for oversized attachments")
+	}
+
+	d.SetMaxAttachmentBytes(0)
+	if d.maxAttachmentBytes != contracts.DefaultMaxAttachmentBytes {
+		t.Fatalf("expected default limit restored, got %d", d.maxAttachmentBytes)
+	}
+}
+
+func TestTruncateOutput(t *testing.T) {
+	short := "hello"
+	if got := truncateOutput(short); got != short {
+		t.Fatalf("expected short output unchanged, got %q", got)
+	}
+	long := strings.Repeat("a", maxOutputBytes) + "tail"
+	got := truncateOutput(long)
+	if !strings.HasPrefix(got, "...(truncated)\n") || !strings.HasSuffix(got, "tail") {
+		t.Fatalf("expected truncated tail, got prefix %q", got[:20])
+	}
+	if len(got) > maxOutputBytes+len("...(truncated)\n") {
+		t.Fatalf("expected output within cap, got %d bytes", len(got))
+	}
+}
+
+func TestDaemonProjectEnvAppliedToRunTask(t *testing.T) {
+	d := NewDaemon()
+	d.SetAgentID("agent-1")
+	dir := t.TempDir()
+	register := contracts.Command{
+		CommandID:      "reg-env",
+		IdempotencyKey: "idem-reg-env",
+		Type:           contracts.CommandTypeRegisterProject,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.RegisterProjectPayload{ProjectPathRaw: dir, Env: map[string]string{"OCT_TEST_TOKEN": "s3cret", "A_FLAG": "1"}}),
+	}
+	res, err := d.HandleCommand(context.Background(), register)
+	if err != nil || !res.OK {
+		t.Fatalf("expected register success, err=%v res=%+v", err, res)
+	}
+	if keys, _ := res.Meta["env_keys"].([]string); strings.Join(keys, ",") != "A_FLAG,OCT_TEST_TOKEN" {
+		t.Fatalf("expected sorted env keys in meta, got %+v", res.Meta)
+	}
+	if strings.Contains(fmt.Sprint(res), "s3cret") {
+		t.Fatalf("env value leaked into result: %+v", res)
+	}
+
+	projectID, _ := res.Meta["project_id"].(string)
+	d.mu.Lock()
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.servers[projectID] = &serverState{ProjectID: projectID, Port: 4321}
+	d.mu.Unlock()
+
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.Command("sh", "-c", `printf %s "$OCT_TEST_TOKEN"`)
+	}
+	run := contracts.Command{
+		CommandID:      "run-env",
+		IdempotencyKey: "idem-run-env",
+		Type:           contracts.CommandTypeRunTask,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "hello"}),
+	}
+	out, err := d.HandleCommand(context.Background(), run)
+	if err != nil || !out.OK {
+		t.Fatalf("expected run_task success, err=%v res=%+v", err, out)
+	}
+	if out.Stdout != "s3cret" {
+		t.Fatalf("expected project env in run process, got stdout %q", out.Stdout)
+	}
+	env := d.commandEnv(projectID)
+	if len(env) < 2 || env[len(env)-2] != "A_FLAG=1" || env[len(env)-1] != "OCT_TEST_TOKEN=s3cret" {
+		t.Fatalf("expected inherited env followed by project vars, got %v", env)
+	}
+	if d.commandEnv("unknown") != nil {
+		t.Fatal("expected nil env for a project without variables")
+	}
+}
+
+func TestDaemonRunTaskChecksOutBranch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+		{"branch", "feature/x"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v %s", args, err, out)
+		}
+	}
+
+	d := NewDaemon()
+	projectID := "p-branch"
+	d.mu.Lock()
+	d.projects[projectID] = dir
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.servers[projectID] = &serverState{ProjectID: projectID, Port: 4321}
+	d.mu.Unlock()
+	var ran []string
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		ran = append(ran, name)
+		if name == "git" {
+			return exec.CommandContext(ctx, name, args...)
+		}
+		return exec.CommandContext(ctx, "git", "-C", dir, "branch", "--show-current")
+	}
+	run := func(id, branch string) contracts.CommandResult {
+		res, err := d.HandleCommand(context.Background(), contracts.Command{
+			CommandID:      id,
+			IdempotencyKey: "idem-" + id,
+			Type:           contracts.CommandTypeRunTask,
+			CreatedAt:      time.Now().UTC(),
+			Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "hello", Branch: branch}),
+		})
+		if err != nil {
+			t.Fatalf("run %s: %v", id, err)
+		}
+		return res
+	}
+
+	if res := run("run-branch", "feature/x"); !res.OK || strings.TrimSpace(res.Stdout) != "feature/x" {
+		t.Fatalf("expected task to run on feature/x, got %+v", res)
+	}
+	if res := run("run-missing", "no-such-branch"); res.OK || res.ErrorCode != contracts.ErrCheckoutFailed || !strings.Contains(res.Stderr, "no-such-branch") {
+		t.Fatalf("expected checkout failure with git output, got %+v", res)
+	}
+	ran = nil
+	if res := run("run-default", ""); !res.OK || strings.TrimSpace(res.Stdout) != "feature/x" || len(ran) != 1 {
+		t.Fatalf("expected no checkout without a branch, got %+v ran=%v", res, ran)
+	}
+}
+
+func TestDaemonRunTaskBatchedPrompts(t *testing.T) {
+	d := NewDaemon()
+	projectID := "p-batch"
+	d.mu.Lock()
+	d.projects[projectID] = t.TempDir()
+	d.policies[projectID] = projectPolicy{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeStartServer, contracts.ScopeRunTask}}
+	d.servers[projectID] = &serverState{ProjectID: projectID, Port: 4321}
+	d.mu.Unlock()
+	var calls [][]string
+	d.execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		calls = append(calls, args)
+		prompt := args[len(args)-1]
+		if prompt == "fail" {
+			return exec.CommandContext(ctx, "sh", "-c", "echo broken >&2; exit 3")
+		}
+		return exec.CommandContext(ctx, "echo", "out:"+prompt)
+	}
+	title := ""
+	run := func(id string, prompts ...string) contracts.CommandResult {
+		res, err := d.HandleCommand(context.Background(), contracts.Command{
+			CommandID:      id,
+			IdempotencyKey: "idem-" + id,
+			Type:           contracts.CommandTypeRunTask,
+			CreatedAt:      time.Now().UTC(),
+			Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompts: prompts, Title: title}),
+		})
+		if err != nil {
+			t.Fatalf("run %s: %v", id, err)
+		}
+		return res
+	}
+
+	res := run("batch-ok", "one", "two", "three")
+	if !res.OK || res.Summary != "3 prompts completed" || res.Stdout != "out:one\nout:two\nout:three\n" {
+		t.Fatalf("expected concatenated batch output, got %+v", res)
+	}
+	if len(calls) != 3 || strings.Contains(strings.Join(calls[0], " "), "--continue") || calls[1][len(calls[1])-2] != "--continue" || calls[2][len(calls[2])-2] != "--continue" {
+		t.Fatalf("expected later prompts to continue the first session, got %v", calls)
+	}
+
+	calls = nil
+	res = run("batch-fail", "one", "fail", "three")
+	if res.OK || len(calls) != 2 || !strings.HasPrefix(res.Summary, "prompt 2 of 3 failed:") || res.Meta["failed_prompt"] != 2 {
+		t.Fatalf("expected batch to stop at the failing prompt, got %+v calls=%v", res, calls)
+	}
+	if res.Stdout != "out:one\n" || !strings.Contains(res.Stderr, "broken") || res.ExitCode == nil || *res.ExitCode != 3 {
+		t.Fatalf("expected output up to the failure, got %+v", res)
+	}
+
+	calls = nil
+	title = "oct_1700000000"
+	if res = run("batch-title", "one", "two"); !res.OK {
+		t.Fatalf("expected titled batch to succeed, got %+v", res)
+	}
+	if first := strings.Join(calls[0], " "); !strings.HasSuffix(first, "--title oct_1700000000 one") || strings.Contains(strings.Join(calls[1], " "), "--title") {
+		t.Fatalf("expected only the first prompt to name the session, got %v", calls)
+	}
+}
+
+func TestDaemonSetBackoff(t *testing.T) {
+	d := NewDaemon()
+	if err := d.SetBackoff(0, time.Second); err == nil {
+		t.Fatal("expected zero base rejected")
+	}
+	if err := d.SetBackoff(time.Second, 500*time.Millisecond); err == nil {
+		t.Fatal("expected max below base rejected")
+	}
+	if err := d.SetBackoff(100*time.Millisecond, 750*time.Millisecond); err != nil {
+		t.Fatalf("set backoff: %v", err)
+	}
+	d.jitter = rand.New(rand.NewSource(1))
+	for attempt, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 750 * time.Millisecond, 750 * time.Millisecond} {
+		got := d.nextBackoff(attempt)
+		if got < want || got > want+want/5 {
+			t.Fatalf("attempt %d: expected %s plus up to 20%% jitter, got %s", attempt, want, got)
+		}
+	}
+	if got := d.nextBackoff(100); got < 750*time.Millisecond || got > 900*time.Millisecond {
+		t.Fatalf("expected a huge attempt to stay at the ceiling, got %s", got)
+	}
+}
@@ -3,6 +3,7 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -53,14 +54,14 @@ func TestACMVP03IdempotencyReplay(t *testing.T) {
 
 	cmdA := contracts.Command{
 		CommandID:      "cmd-1",
-		IdempotencyKey: "idem-1",
+		IdempotencyKey: "idem-1-0",
 		Type:           contracts.CommandTypeStartServer,
 		CreatedAt:      time.Now().UTC(),
 		Payload:        mustPayload(t, contracts.StartServerPayload{ProjectID: projectID}),
 	}
 	cmdB := contracts.Command{
 		CommandID:      "cmd-2",
-		IdempotencyKey: "idem-1",
+		IdempotencyKey: "idem-1-0",
 		Type:           contracts.CommandTypeStartServer,
 		CreatedAt:      time.Now().UTC(),
 		Payload:        mustPayload(t, contracts.StartServerPayload{ProjectID: projectID}),
@@ -107,6 +108,32 @@ func TestACMVP04PortAllocationExhaustion(t *testing.T) {
 	}
 }
 
+func TestDaemonSetPortRange(t *testing.T) {
+	d := NewDaemon()
+	for _, r := range [][2]int{{80, 2000}, {5000, 5000}, {6000, 5000}, {60000, 70000}} {
+		if err := d.SetPortRange(r[0], r[1]); err == nil {
+			t.Fatalf("expected range %d-%d to be rejected", r[0], r[1])
+		}
+	}
+
+	first, err := d.allocator.Allocate("p1")
+	if err != nil || first != 4096 {
+		t.Fatalf("expected default range allocation, got %d err=%v", first, err)
+	}
+	if err := d.SetPortRange(20000, 20001); err != nil {
+		t.Fatalf("set port range: %v", err)
+	}
+	if again, _ := d.allocator.Allocate("p1"); again != first {
+		t.Fatalf("expected existing allocation preserved, got %d", again)
+	}
+	if port, err := d.allocator.Allocate("p2"); err != nil || port != 20000 {
+		t.Fatalf("expected allocation from new range, got %d err=%v", port, err)
+	}
+	if used := d.allocator.SnapshotUsed(); len(used) != 2 || used[0] != first || used[1] != 20000 {
+		t.Fatalf("unexpected used ports: %v", used)
+	}
+}
+
 func TestACMVP04MutatingSerializationAndStatusImmediate(t *testing.T) {
 	d := NewDaemon()
 	d.SetAgentID("agent-1")
@@ -187,28 +214,201 @@ func TestACMVP04MutatingSerializationAndStatusImmediate(t *testing.T) {
 		t.Fatal("status should not wait on mutating lock")
 	}
 
+	stopEntered := make(chan struct{})
+	d.SetHandler(contracts.CommandTypeStopServer, func(_ context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
+		close(stopEntered)
+		return contracts.CommandResult{CommandID: cmd.CommandID, OK: true, Summary: "stop done"}, nil
+	})
+	stopCmd := contracts.Command{
+		CommandID:      "stop-1",
+		IdempotencyKey: "idem-stop-1",
+		Type:           contracts.CommandTypeStopServer,
+		CreatedAt:      time.Now().UTC(),
+		Payload:        mustPayload(t, contracts.StopServerPayload{ProjectID: projectID}),
+	}
+	stopDone := make(chan struct{})
+	go func() {
+		_, _ = d.HandleCommand(context.Background(), stopCmd)
+		close(stopDone)
+	}()
+
+	select {
+	case <-stopEntered:
+		t.Fatal("stop_server entered before start_server released")
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	// run_task is limited per project, not by the global mutating lock
 	runDone := make(chan struct{})
 	go func() {
 		_, _ = d.HandleCommand(context.Background(), runCmd)
 		close(runDone)
 	}()
-
 	select {
-	case <-runTaskEntered:
-		t.Fatal("run_task entered before start_server released")
-	case <-time.After(150 * time.Millisecond):
+	case <-runDone:
+	case <-time.After(300 * time.Millisecond):
+		t.Fatal("run_task should not wait on mutating lock")
 	}
 
 	close(releaseStart)
 
 	select {
-	case <-runTaskEntered:
+	case <-stopEntered:
 	case <-time.After(300 * time.Millisecond):
-		t.Fatal("run_task should enter after start_server release")
+		t.Fatal("stop_server should enter after start_server release")
 	}
 	select {
-	case <-runDone:
+	case <-stopDone:
 	case <-time.After(300 * time.Millisecond):
-		t.Fatal("run_task should complete")
+		t.Fatal("stop_server should complete")
+	}
+}
+
+func TestRunTaskConcurrencyIsPerProject(t *testing.T) {
+	d := NewDaemon()
+	release := make(chan struct{})
+	entered := make(chan string, 4)
+	d.SetHandler(contracts.CommandTypeRunTask, func(_ context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
+		entered <- cmd.CommandID
+		<-release
+		return contracts.CommandResult{CommandID: cmd.CommandID, OK: true}, nil
+	})
+	runCmd := func(id, projectID string) contracts.Command {
+		return contracts.Command{
+			CommandID:      id,
+			IdempotencyKey: "idem-" + id,
+			Type:           contracts.CommandTypeRunTask,
+			CreatedAt:      time.Now().UTC(),
+			Payload:        mustPayload(t, contracts.RunTaskPayload{ProjectID: projectID, Prompt: "hello"}),
+		}
 	}
+	var wg sync.WaitGroup
+	for _, c := range []contracts.Command{runCmd("a-1", "proj-a"), runCmd("b-1", "proj-b")} {
+		wg.Add(1)
+		go func(c contracts.Command) {
+			defer wg.Done()
+			_, _ = d.HandleCommand(context.Background(), c)
+		}(c)
+	}
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case id := <-entered:
+			got[id] = true
+		case <-time.After(300 * time.Millisecond):
+			t.Fatalf("different projects should run concurrently, entered=%v", got)
+		}
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _ = d.HandleCommand(context.Background(), runCmd("a-2", "proj-a"))
+	}()
+	select {
+	case id := <-entered:
+		t.Fatalf("same-project task %s should wait for the running one", id)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case id := <-entered:
+		if id != "a-2" {
+			t.Fatalf("expected a-2 after release, got %s", id)
+		}
+	case <-time.After(300 * time.Millisecond):
+		t.Fatal("same-project task should run after release")
+	}
+	wg.Wait()
+
+	d2 := NewDaemon()
+	d2.SetRunConcurrency(2)
+	release2 := make(chan struct{})
+	entered2 := make(chan string, 2)
+	d2.SetHandler(contracts.CommandTypeRunTask, func(_ context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
+		entered2 <- cmd.CommandID
+		<-release2
+		return contracts.CommandResult{CommandID: cmd.CommandID, OK: true}, nil
+	})
+	for _, id := range []string{"c-1", "c-2"} {
+		go func(id string) { _, _ = d2.HandleCommand(context.Background(), runCmd(id, "proj-c")) }(id)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-entered2:
+		case <-time.After(300 * time.Millisecond):
+			t.Fatal("expected two concurrent same-project tasks with concurrency 2")
+		}
+	}
+	close(release2)
+}
+
+func TestRunTaskBatchAndBranchRunAloneInProject(t *testing.T) {
+	d := NewDaemon()
+	d.SetRunConcurrency(2)
+	entered := make(chan string, 4)
+	releases := map[string]chan struct{}{}
+	for _, id := range []string{"single-1", "batch", "single-2", "branch", "single-3"} {
+		releases[id] = make(chan struct{})
+	}
+	d.SetHandler(contracts.CommandTypeRunTask, func(_ context.Context, cmd contracts.Command) (contracts.CommandResult, error) {
+		entered <- cmd.CommandID
+		<-releases[cmd.CommandID]
+		return contracts.CommandResult{CommandID: cmd.CommandID, OK: true}, nil
+	})
+	run := func(id string, payload contracts.RunTaskPayload) {
+		go func() {
+			_, _ = d.HandleCommand(context.Background(), contracts.Command{
+				CommandID:      id,
+				IdempotencyKey: "idem-" + id,
+				Type:           contracts.CommandTypeRunTask,
+				CreatedAt:      time.Now().UTC(),
+				Payload:        mustPayload(t, payload),
+			})
+		}()
+	}
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case id := <-entered:
+			if id != want {
+				t.Fatalf("expected %s to run, got %s", want, id)
+			}
+		case <-time.After(300 * time.Millisecond):
+			t.Fatalf("expected %s to run", want)
+		}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case id := <-entered:
+			t.Fatalf("expected %s to wait", id)
+		case <-time.After(150 * time.Millisecond):
+		}
+	}
+
+	run("single-1", contracts.RunTaskPayload{ProjectID: "p", Prompt: "one"})
+	expect("single-1")
+	// the batch waits for the running task even though a slot is free
+	run("batch", contracts.RunTaskPayload{ProjectID: "p", Prompts: []string{"a", "b"}})
+	expectNone()
+	close(releases["single-1"])
+	expect("batch")
+	// and nothing else starts in the project while the batch runs
+	run("single-2", contracts.RunTaskPayload{ProjectID: "p", Prompt: "two"})
+	expectNone()
+	close(releases["batch"])
+	expect("single-2")
+
+	// a task with a branch also waits for, and then excludes, other runs
+	run("branch", contracts.RunTaskPayload{ProjectID: "p", Prompt: "three", Branch: "feature/x"})
+	expectNone()
+	close(releases["single-2"])
+	expect("branch")
+	run("single-3", contracts.RunTaskPayload{ProjectID: "p", Prompt: "four"})
+	expectNone()
+	close(releases["branch"])
+	expect("single-3")
+	close(releases["single-3"])
 }
@@ -1,18 +1,24 @@
 package agent
 
 import (
+	"sync"
 	"time"
 
 	"opencode-telegram/internal/proxy/contracts"
 )
 
 type IdempotencyCache struct {
+	mu         sync.Mutex
 	maxEntries int
 	ttl        time.Duration
 	now        func() time.Time
 
 	entries map[string]cacheEntry
 	order   []string
+
+	// hits and misses count Get lookups with a non-empty key.
+	hits   uint64
+	misses uint64
 }
 
 type cacheEntry struct {
@@ -39,22 +45,63 @@ func (c *IdempotencyCache) Get(key string) (contracts.CommandResult, bool) {
 	if key == "" {
 		return contracts.CommandResult{}, false
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	now := c.now().UTC()
 	entry, ok := c.entries[key]
 	if !ok {
+		c.misses++
 		return contracts.CommandResult{}, false
 	}
 	if now.After(entry.ExpiresAt) {
 		delete(c.entries, key)
+		c.misses++
 		return contracts.CommandResult{}, false
 	}
+	c.hits++
 	return entry.Result, true
 }
 
+// Len returns how many unexpired keys the cache holds.
+func (c *IdempotencyCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now().UTC()
+	n := 0
+	for _, entry := range c.entries {
+		if !now.After(entry.ExpiresAt) {
+			n++
+		}
+	}
+	return n
+}
+
+// Contains reports whether key holds an unexpired result, without counting
+// as a lookup or evicting anything.
+func (c *IdempotencyCache) Contains(key string) bool {
+	if key == "" {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	return ok && !c.now().UTC().After(entry.ExpiresAt)
+}
+
+// Stats returns how many lookups replayed a cached result and how many found
+// nothing.
+func (c *IdempotencyCache) Stats() (hits, misses uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
 func (c *IdempotencyCache) Put(key string, result contracts.CommandResult) {
 	if key == "" {
 		return
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.pruneExpired()
 	if _, exists := c.entries[key]; !exists {
 		c.order = append(c.order, key)
@@ -77,3 +124,49 @@ func (c *IdempotencyCache) pruneExpired() {
 		}
 	}
 }
+
+// ProcessedCommands remembers the results of the most recently completed
+// commands by CommandID in a fixed-size ring. It has no TTL, so a command
+// redelivered after its idempotency key expired still gets its stored result.
+type ProcessedCommands struct {
+	mu      sync.Mutex
+	results map[string]contracts.CommandResult
+	ring    []string
+	next    int
+}
+
+func NewProcessedCommands(maxEntries int) *ProcessedCommands {
+	if maxEntries <= 0 {
+		maxEntries = 1
+	}
+	return &ProcessedCommands{
+		results: make(map[string]contracts.CommandResult),
+		ring:    make([]string, maxEntries),
+	}
+}
+
+func (p *ProcessedCommands) Get(commandID string) (contracts.CommandResult, bool) {
+	if commandID == "" {
+		return contracts.CommandResult{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result, ok := p.results[commandID]
+	return result, ok
+}
+
+func (p *ProcessedCommands) Put(commandID string, result contracts.CommandResult) {
+	if commandID == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, exists := p.results[commandID]; !exists {
+		if oldest := p.ring[p.next]; oldest != "" {
+			delete(p.results, oldest)
+		}
+		p.ring[p.next] = commandID
+		p.next = (p.next + 1) % len(p.ring)
+	}
+	p.results[commandID] = result
+}
@@ -26,16 +26,47 @@ func NewPortAllocator(minPort, maxPort int) *PortAllocator {
 	}
 }
 
+// SetRange changes the range used for new allocations. Ports already handed
+// out stay allocated to their projects even if they fall outside the new range.
+func (p *PortAllocator) SetRange(minPort, maxPort int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.min = minPort
+	p.max = maxPort
+}
+
+// Range returns the range used for new allocations.
+func (p *PortAllocator) Range() (minPort, maxPort int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.min, p.max
+}
+
 func (p *PortAllocator) Allocate(projectID string) (int, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	port, err := p.next(projectID)
+	if err != nil {
+		return 0, err
+	}
+	p.used[port] = true
+	p.projectPort[projectID] = port
+	return port, nil
+}
+
+// Peek returns the port Allocate would hand projectID without reserving it.
+func (p *PortAllocator) Peek(projectID string) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.next(projectID)
+}
+
+func (p *PortAllocator) next(projectID string) (int, error) {
 	if current, ok := p.projectPort[projectID]; ok {
 		return current, nil
 	}
 	for port := p.min; port <= p.max; port++ {
 		if !p.used[port] {
-			p.used[port] = true
-			p.projectPort[projectID] = port
 			return port, nil
 		}
 	}
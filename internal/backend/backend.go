@@ -5,6 +5,8 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -15,8 +17,35 @@ import (
 const (
 	DefaultPairingTTL    = 10 * time.Minute
 	DefaultRedeliveryTTL = 120 * time.Second
+	// DefaultResultTTL is how long the durable queues keep command results.
+	DefaultResultTTL = 14 * 24 * time.Hour
+	// DefaultOnlineWindow covers one maximum-length long poll plus reconnect slack.
+	DefaultOnlineWindow = 90 * time.Second
+	// DefaultHistoryLimit is how many recent commands are kept per user.
+	DefaultHistoryLimit = 20
+	// historyBacklogFactor caps a user's history, unfinished commands
+	// included, at this many times the history limit, so commands that never
+	// get a result (dead-lettered or purged) cannot grow it without bound.
+	historyBacklogFactor = 5
 )
 
+// Command history statuses.
+const (
+	CommandStatusQueued  = "queued"
+	CommandStatusRunning = "running"
+	CommandStatusOK      = "ok"
+	CommandStatusError   = "error"
+)
+
+const (
+	// pairCodeAlphabet is base32 without 0/O and 1/I so codes survive being read aloud or retyped.
+	pairCodeAlphabet    = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+	pairCodeLength      = 8
+	maxPairCodeAttempts = 5
+)
+
+var pairCodeRand io.Reader = rand.Reader
+
 type PairingStore interface {
 	StartPairing(telegramUserID string) (contracts.PairStartResponse, error)
 	ClaimPairing(req contracts.PairClaimRequest) (contracts.PairClaimResponse, error)
@@ -29,7 +58,13 @@ type CommandQueue interface {
 	Poll(ctx context.Context, agentID string, timeoutSeconds int) (*contracts.Command, error)
 	StoreResult(ctx context.Context, agentID string, result contracts.CommandResult) error
 	Enqueue(ctx context.Context, agentID string, cmd contracts.Command) error
+	// EnqueueBatch enqueues cmds in order as one unit: if any command is
+	// invalid or the write fails, none of them is queued.
+	EnqueueBatch(ctx context.Context, agentID string, cmds []contracts.Command) error
 	GetResult(ctx context.Context, agentID string, commandID string) (*contracts.CommandResult, error)
+	// Purge drops every queued and inflight command of agentID, as when its
+	// pairing is revoked. Stored results are kept until they expire.
+	Purge(ctx context.Context, agentID string) error
 }
 
 type MemoryBackend struct {
@@ -37,9 +72,10 @@ type MemoryBackend struct {
 	now             func() time.Time
 	pairingTTL      time.Duration
 	redeliveryAfter time.Duration
+	onlineWindow    time.Duration
+	historyLimit    int
 	pairingStore    PairingPersistence
-
-	pairCounter int
+	queueStore      QueuePersistence
 
 	pairCodes       map[string]pairCodeRecord
 	agentByUser     map[string]string
@@ -47,31 +83,54 @@ type MemoryBackend struct {
 	agentByKey      map[string]string
 
 	queued   map[string][]contracts.Command
-	inflight map[string][]inflightCommand
+	inflight map[string][]InflightCommand
 	results  map[string]map[string]contracts.CommandResult
 	projects map[string]map[string]*projectRecord
 	aliases  map[string]map[string]string
 	commands map[string]commandMeta
+	presence map[string]agentPresence
+	// history holds each user's command IDs, oldest first. Finished commands
+	// beyond historyLimit are evicted; see RegisterCommandMeta.
+	history map[string][]string
+}
+
+// agentPresence records the most recent poll from an agent.
+type agentPresence struct {
+	LastSeen           time.Time
+	PollTimeoutSeconds int
 }
 
 type PairingPersistence interface {
 	SavePairCode(code string, telegramUserID string, expiresAt time.Time) error
 	GetPairCode(code string) (telegramUserID string, expiresAt time.Time, ok bool, err error)
 	DeletePairCode(code string) error
+	// DeleteUserPairCodes removes every unclaimed code issued to the user.
+	DeleteUserPairCodes(telegramUserID string) error
 	SaveAgentBinding(telegramUserID string, agentID string, agentKey string) error
+	DeleteAgentBinding(telegramUserID string) error
 	GetAgentIDByKey(agentKey string) (agentID string, ok bool, err error)
 	GetAgentIDByUser(telegramUserID string) (agentID string, ok bool, err error)
 	GetUserIDByAgent(agentID string) (telegramUserID string, ok bool, err error)
 }
 
+// QueuePersistence stores MemoryBackend queue state so accepted commands
+// survive a backend restart. Save methods replace the agent's full list.
+type QueuePersistence interface {
+	SaveQueued(agentID string, cmds []contracts.Command) error
+	SaveInflight(agentID string, items []InflightCommand) error
+	SaveResult(agentID string, result contracts.CommandResult) error
+	LoadQueued() (queued map[string][]contracts.Command, inflight map[string][]InflightCommand, err error)
+}
+
 type pairCodeRecord struct {
 	TelegramUserID string
 	ExpiresAt      time.Time
 }
 
-type inflightCommand struct {
-	Command    contracts.Command
-	InflightAt time.Time
+// InflightCommand is a delivered command awaiting its result.
+type InflightCommand struct {
+	Command    contracts.Command `json:"command"`
+	InflightAt time.Time         `json:"inflight_at"`
 }
 
 type projectPolicy struct {
@@ -94,6 +153,20 @@ type commandMeta struct {
 	ProjectID      string
 	Alias          string
 	ProjectPath    string
+	CreatedAt      time.Time
+	Status         string
+	ErrorCode      string
+}
+
+// commandRecord is one entry of a user's command history.
+type commandRecord struct {
+	CommandID string    `json:"command_id"`
+	Type      string    `json:"type"`
+	ProjectID string    `json:"project_id,omitempty"`
+	Alias     string    `json:"alias,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Status    string    `json:"status"`
+	ErrorCode string    `json:"error_code,omitempty"`
 }
 
 func NewMemoryBackend() *MemoryBackend {
@@ -101,16 +174,20 @@ func NewMemoryBackend() *MemoryBackend {
 		now:             time.Now,
 		pairingTTL:      DefaultPairingTTL,
 		redeliveryAfter: DefaultRedeliveryTTL,
+		onlineWindow:    DefaultOnlineWindow,
+		historyLimit:    DefaultHistoryLimit,
 		pairCodes:       make(map[string]pairCodeRecord),
 		agentByUser:     make(map[string]string),
 		agentKeyByAgent: make(map[string]string),
 		agentByKey:      make(map[string]string),
 		queued:          make(map[string][]contracts.Command),
-		inflight:        make(map[string][]inflightCommand),
+		inflight:        make(map[string][]InflightCommand),
 		results:         make(map[string]map[string]contracts.CommandResult),
 		projects:        make(map[string]map[string]*projectRecord),
 		aliases:         make(map[string]map[string]string),
 		commands:        make(map[string]commandMeta),
+		presence:        make(map[string]agentPresence),
+		history:         make(map[string][]string),
 	}
 }
 
@@ -126,21 +203,159 @@ func (b *MemoryBackend) SetPairingTTL(ttl time.Duration) {
 	b.pairingTTL = ttl
 }
 
+// SetRedeliveryTTL sets how long a delivered command may go without a result
+// before it is delivered again. It should exceed the longest command an agent
+// runs, or that command is executed twice.
+func (b *MemoryBackend) SetRedeliveryTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("redelivery TTL must be positive, got %s", ttl)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.redeliveryAfter = ttl
+	return nil
+}
+
+// SetOnlineWindow sets how recently an agent must have polled to count as online.
+func (b *MemoryBackend) SetOnlineWindow(window time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onlineWindow = window
+}
+
+// MarkSeen records that agentID polled with the given long-poll timeout.
+func (b *MemoryBackend) MarkSeen(agentID string, pollTimeoutSeconds int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.presence[agentID] = agentPresence{LastSeen: b.now().UTC(), PollTimeoutSeconds: pollTimeoutSeconds}
+}
+
+// LastSeen returns when agentID last polled.
+func (b *MemoryBackend) LastSeen(agentID string) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.presence[agentID]
+	return p.LastSeen, ok
+}
+
+// AgentStatus reports whether agentID polled within the online window.
+func (b *MemoryBackend) AgentStatus(agentID string) contracts.AgentStatusResponse {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.presence[agentID]
+	if !ok {
+		return contracts.AgentStatusResponse{}
+	}
+	lastSeen := p.LastSeen
+	return contracts.AgentStatusResponse{
+		Online:             b.now().UTC().Sub(lastSeen) <= b.onlineWindow,
+		LastSeen:           &lastSeen,
+		PollTimeoutSeconds: p.PollTimeoutSeconds,
+	}
+}
+
+// QueueStats returns how many commands are queued and inflight for agentID;
+// unknown agents have none.
+func (b *MemoryBackend) QueueStats(agentID string) (queued int, inflight int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.queued[agentID]), len(b.inflight[agentID])
+}
+
+// PendingCommands lists up to limit of agentID's inflight commands, then its
+// queued ones in delivery order.
+func (b *MemoryBackend) PendingCommands(agentID string, limit int) []contracts.PendingCommand {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []contracts.PendingCommand
+	for _, item := range b.inflight[agentID] {
+		out = append(out, pendingCommand(item.Command, true))
+	}
+	for _, cmd := range b.queued[agentID] {
+		out = append(out, pendingCommand(cmd, false))
+	}
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func pendingCommand(cmd contracts.Command, inflight bool) contracts.PendingCommand {
+	return contracts.PendingCommand{CommandID: cmd.CommandID, Type: cmd.Type, CreatedAt: cmd.CreatedAt, Inflight: inflight}
+}
+
 func (b *MemoryBackend) SetPairingPersistence(store PairingPersistence) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	b.pairingStore = store
 }
 
+func (b *MemoryBackend) SetQueuePersistence(store QueuePersistence) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queueStore = store
+}
+
+// RestoreQueue reloads queued and inflight commands from the queue
+// persistence, replacing in-memory queue state. It is a no-op without one;
+// call it once after SetQueuePersistence, before serving.
+func (b *MemoryBackend) RestoreQueue() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.queueStore == nil {
+		return nil
+	}
+	queued, inflight, err := b.queueStore.LoadQueued()
+	if err != nil {
+		return err
+	}
+	b.queued = make(map[string][]contracts.Command, len(queued))
+	for agentID, cmds := range queued {
+		b.queued[agentID] = cmds
+	}
+	b.inflight = make(map[string][]InflightCommand, len(inflight))
+	for agentID, items := range inflight {
+		b.inflight[agentID] = items
+	}
+	return nil
+}
+
 func (b *MemoryBackend) StartPairing(telegramUserID string) (contracts.PairStartResponse, error) {
 	if strings.TrimSpace(telegramUserID) == "" {
-		return contracts.PairStartResponse{}, contracts.APIError{Code: contracts.ErrValidationRequiredField, Message: "telegram_user_id is required"}
+		return contracts.PairStartResponse{}, contracts.RequiredFieldError("telegram_user_id")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.startPairingLocked(telegramUserID)
+}
+
+// RotatePairing invalidates every unclaimed pairing code of the user and
+// issues a fresh one, so a leaked or lost code stops working before it
+// expires.
+func (b *MemoryBackend) RotatePairing(telegramUserID string) (contracts.PairStartResponse, error) {
+	if strings.TrimSpace(telegramUserID) == "" {
+		return contracts.PairStartResponse{}, contracts.RequiredFieldError("telegram_user_id")
 	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	for code, rec := range b.pairCodes {
+		if rec.TelegramUserID == telegramUserID {
+			delete(b.pairCodes, code)
+		}
+	}
+	if b.pairingStore != nil {
+		if err := b.pairingStore.DeleteUserPairCodes(telegramUserID); err != nil {
+			return contracts.PairStartResponse{}, err
+		}
+	}
+	return b.startPairingLocked(telegramUserID)
+}
 
-	b.pairCounter++
-	code := fmt.Sprintf("PAIR-%06d", b.pairCounter)
+func (b *MemoryBackend) startPairingLocked(telegramUserID string) (contracts.PairStartResponse, error) {
+	code, err := b.newPairCodeLocked()
+	if err != nil {
+		return contracts.PairStartResponse{}, err
+	}
 	expiresAt := b.now().UTC().Add(b.pairingTTL)
 	b.pairCodes[code] = pairCodeRecord{TelegramUserID: telegramUserID, ExpiresAt: expiresAt}
 	if b.pairingStore != nil {
@@ -153,7 +368,7 @@ func (b *MemoryBackend) StartPairing(telegramUserID string) (contracts.PairStart
 
 func (b *MemoryBackend) ClaimPairing(req contracts.PairClaimRequest) (contracts.PairClaimResponse, error) {
 	if strings.TrimSpace(req.PairingCode) == "" {
-		return contracts.PairClaimResponse{}, contracts.APIError{Code: contracts.ErrValidationRequiredField, Message: "pairing_code is required"}
+		return contracts.PairClaimResponse{}, contracts.RequiredFieldError("pairing_code")
 	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -209,6 +424,64 @@ func (b *MemoryBackend) ClaimPairing(req contracts.PairClaimRequest) (contracts.
 	return contracts.PairClaimResponse{AgentID: agentID, AgentKey: agentKey}, nil
 }
 
+// Unpair revokes telegramUserID's agent: its key stops authenticating and
+// the agent's queued, inflight and presence state is dropped.
+func (b *MemoryBackend) Unpair(telegramUserID string) error {
+	if strings.TrimSpace(telegramUserID) == "" {
+		return contracts.RequiredFieldError("telegram_user_id")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	agentID, ok := b.agentByUser[telegramUserID]
+	if b.pairingStore != nil {
+		stored, found, err := b.pairingStore.GetAgentIDByUser(telegramUserID)
+		if err != nil {
+			return err
+		}
+		if found {
+			agentID, ok = stored, true
+		}
+	}
+	if !ok {
+		return contracts.APIError{Code: contracts.ErrAuthUnauthorized, Message: "agent not paired"}
+	}
+	if b.pairingStore != nil {
+		if err := b.pairingStore.DeleteAgentBinding(telegramUserID); err != nil {
+			return err
+		}
+	}
+	if key, ok := b.agentKeyByAgent[agentID]; ok {
+		delete(b.agentByKey, key)
+	}
+	delete(b.agentKeyByAgent, agentID)
+	delete(b.agentByUser, telegramUserID)
+	delete(b.presence, agentID)
+	return b.purgeLocked(agentID)
+}
+
+// Purge satisfies CommandQueue; Unpair already purges the in-memory queue.
+func (b *MemoryBackend) Purge(ctx context.Context, agentID string) error {
+	_ = ctx
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.purgeLocked(agentID)
+}
+
+func (b *MemoryBackend) purgeLocked(agentID string) error {
+	if b.queueStore != nil && (len(b.queued[agentID]) > 0 || len(b.inflight[agentID]) > 0) {
+		if err := b.queueStore.SaveQueued(agentID, nil); err != nil {
+			return err
+		}
+		if err := b.queueStore.SaveInflight(agentID, nil); err != nil {
+			return err
+		}
+	}
+	delete(b.queued, agentID)
+	delete(b.inflight, agentID)
+	return nil
+}
+
 func (b *MemoryBackend) AuthenticateAgentKey(agentKey string) (string, bool) {
 	if b.pairingStore != nil {
 		agentID, ok, err := b.pairingStore.GetAgentIDByKey(agentKey)
@@ -269,7 +542,66 @@ func (b *MemoryBackend) Enqueue(ctx context.Context, agentID string, cmd contrac
 	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.queued[agentID] = append(b.queued[agentID], cmd)
+	next := insertByPriority(append([]contracts.Command(nil), b.queued[agentID]...), cmd)
+	if b.queueStore != nil {
+		if err := b.queueStore.SaveQueued(agentID, next); err != nil {
+			return err
+		}
+	}
+	b.queued[agentID] = next
+	return nil
+}
+
+// EnqueueBatch validates every command, then queues them all under one lock
+// and one persistence write.
+func (b *MemoryBackend) EnqueueBatch(ctx context.Context, agentID string, cmds []contracts.Command) error {
+	_ = ctx
+	if strings.TrimSpace(agentID) == "" {
+		return errors.New("agentID is required")
+	}
+	if err := validateBatch(cmds); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	next := append([]contracts.Command(nil), b.queued[agentID]...)
+	for _, cmd := range cmds {
+		next = insertByPriority(next, cmd)
+	}
+	if b.queueStore != nil {
+		if err := b.queueStore.SaveQueued(agentID, next); err != nil {
+			return err
+		}
+	}
+	b.queued[agentID] = next
+	return nil
+}
+
+// insertByPriority inserts cmd behind every queued command of equal or
+// higher priority.
+func insertByPriority(queue []contracts.Command, cmd contracts.Command) []contracts.Command {
+	i := len(queue)
+	for i > 0 && queue[i-1].Priority < cmd.Priority {
+		i--
+	}
+	queue = append(queue, contracts.Command{})
+	copy(queue[i+1:], queue[i:])
+	queue[i] = cmd
+	return queue
+}
+
+// validateBatch checks every command of a batch before any is queued. The
+// error names the first invalid command by its index.
+func validateBatch(cmds []contracts.Command) error {
+	for i, cmd := range cmds {
+		if err := contracts.ValidateCommand(cmd); err != nil {
+			if apiErr, ok := err.(contracts.APIError); ok {
+				apiErr.Message = fmt.Sprintf("commands[%d]: %s", i, apiErr.Message)
+				return apiErr
+			}
+			return fmt.Errorf("commands[%d]: %w", i, err)
+		}
+	}
 	return nil
 }
 
@@ -282,13 +614,21 @@ func (b *MemoryBackend) Poll(ctx context.Context, agentID string, timeoutSeconds
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	// State is persisted before memory changes, so a failed write leaves
+	// the command where it was.
 	now := b.now().UTC()
 	inflight := b.inflight[agentID]
 	for i := range inflight {
 		if now.Sub(inflight[i].InflightAt) >= b.redeliveryAfter {
-			inflight[i].InflightAt = now
-			b.inflight[agentID] = inflight
-			cmd := inflight[i].Command
+			next := append([]InflightCommand(nil), inflight...)
+			next[i].InflightAt = now
+			if b.queueStore != nil {
+				if err := b.queueStore.SaveInflight(agentID, next); err != nil {
+					return nil, err
+				}
+			}
+			b.inflight[agentID] = next
+			cmd := next[i].Command
 			return &cmd, nil
 		}
 	}
@@ -298,8 +638,21 @@ func (b *MemoryBackend) Poll(ctx context.Context, agentID string, timeoutSeconds
 		return nil, nil
 	}
 	cmd := queued[0]
-	b.queued[agentID] = queued[1:]
-	b.inflight[agentID] = append(b.inflight[agentID], inflightCommand{Command: cmd, InflightAt: now})
+	nextQueued := append([]contracts.Command(nil), queued[1:]...)
+	nextInflight := append(append([]InflightCommand(nil), inflight...), InflightCommand{Command: cmd, InflightAt: now})
+	if b.queueStore != nil {
+		// Inflight is written first: should the queue write fail, a restore
+		// would at worst deliver the command twice, never lose it.
+		if err := b.queueStore.SaveInflight(agentID, nextInflight); err != nil {
+			return nil, err
+		}
+		if err := b.queueStore.SaveQueued(agentID, nextQueued); err != nil {
+			_ = b.queueStore.SaveInflight(agentID, inflight)
+			return nil, err
+		}
+	}
+	b.queued[agentID] = nextQueued
+	b.inflight[agentID] = nextInflight
 	return &cmd, nil
 }
 
@@ -309,18 +662,30 @@ func (b *MemoryBackend) StoreResult(ctx context.Context, agentID string, result
 		return errors.New("agentID is required")
 	}
 	if strings.TrimSpace(result.CommandID) == "" {
-		return contracts.APIError{Code: contracts.ErrValidationRequiredField, Message: "command_id is required"}
+		return contracts.RequiredFieldError("command_id")
 	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	if result.InProgress {
+		return b.storeProgressLocked(agentID, result)
+	}
+
 	items := b.inflight[agentID]
-	out := items[:0]
+	out := make([]InflightCommand, 0, len(items))
 	for _, item := range items {
 		if item.Command.CommandID != result.CommandID {
 			out = append(out, item)
 		}
 	}
+	if b.queueStore != nil {
+		if err := b.queueStore.SaveResult(agentID, result); err != nil {
+			return err
+		}
+		if err := b.queueStore.SaveInflight(agentID, out); err != nil {
+			return err
+		}
+	}
 	b.inflight[agentID] = out
 	if _, ok := b.results[agentID]; !ok {
 		b.results[agentID] = make(map[string]contracts.CommandResult)
@@ -332,6 +697,40 @@ func (b *MemoryBackend) StoreResult(ctx context.Context, agentID string, result
 	return nil
 }
 
+// storeProgressLocked overwrites the latest partial result for a command that
+// is still running. The command stays inflight with its delivery time
+// refreshed, so a long run is not redelivered while it reports progress, and
+// a final result is never replaced by a late progress update.
+func (b *MemoryBackend) storeProgressLocked(agentID string, result contracts.CommandResult) error {
+	if existing, ok := b.results[agentID][result.CommandID]; ok && existing.IsTerminal() {
+		return nil
+	}
+	inflight := b.inflight[agentID]
+	for i := range inflight {
+		if inflight[i].Command.CommandID == result.CommandID {
+			next := append([]InflightCommand(nil), inflight...)
+			next[i].InflightAt = b.now().UTC()
+			if b.queueStore != nil {
+				if err := b.queueStore.SaveInflight(agentID, next); err != nil {
+					return err
+				}
+			}
+			b.inflight[agentID] = next
+			break
+		}
+	}
+	if b.queueStore != nil {
+		if err := b.queueStore.SaveResult(agentID, result); err != nil {
+			return err
+		}
+	}
+	if _, ok := b.results[agentID]; !ok {
+		b.results[agentID] = make(map[string]contracts.CommandResult)
+	}
+	b.results[agentID][result.CommandID] = result
+	return nil
+}
+
 func (b *MemoryBackend) GetResult(ctx context.Context, agentID string, commandID string) (*contracts.CommandResult, error) {
 	_ = ctx
 	b.mu.Lock()
@@ -348,9 +747,116 @@ func (b *MemoryBackend) GetResult(ctx context.Context, agentID string, commandID
 func (b *MemoryBackend) RegisterCommandMeta(commandID string, meta commandMeta) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	if meta.Status == "" {
+		meta.Status = CommandStatusQueued
+	}
+	if _, exists := b.commands[commandID]; !exists && meta.TelegramUserID != "" {
+		b.history[meta.TelegramUserID] = b.trimHistoryLocked(append(b.history[meta.TelegramUserID], commandID))
+	}
 	b.commands[commandID] = meta
 }
 
+// trimHistoryLocked evicts the oldest finished commands until ids is within
+// historyLimit, dropping their metadata too. Queued and running commands
+// are kept, since their results still need the metadata, unless ids
+// exceeds the backlog cap. b.mu must be held.
+func (b *MemoryBackend) trimHistoryLocked(ids []string) []string {
+	excess := len(ids) - b.historyLimit
+	if excess <= 0 {
+		return ids
+	}
+	kept := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if excess > 0 {
+			if status := b.commands[id].Status; status == CommandStatusOK || status == CommandStatusError {
+				delete(b.commands, id)
+				excess--
+				continue
+			}
+		}
+		kept = append(kept, id)
+	}
+	for len(kept) > b.historyLimit*historyBacklogFactor {
+		delete(b.commands, kept[0])
+		kept = kept[1:]
+	}
+	return kept
+}
+
+func (b *MemoryBackend) commandMetaFor(commandID string) (commandMeta, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	meta, ok := b.commands[commandID]
+	return meta, ok
+}
+
+// SetHistoryLimit sets how many recent commands are kept per user (minimum 1).
+func (b *MemoryBackend) SetHistoryLimit(limit int) {
+	if limit < 1 {
+		limit = 1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.historyLimit = limit
+}
+
+// RecordCommandResult updates the history status of the result's command.
+func (b *MemoryBackend) RecordCommandResult(result contracts.CommandResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	meta, ok := b.commands[result.CommandID]
+	if !ok {
+		return
+	}
+	switch {
+	case result.InProgress:
+		if meta.Status != CommandStatusQueued {
+			// never downgrade a finished command
+			return
+		}
+		meta.Status = CommandStatusRunning
+	case result.OK:
+		meta.Status = CommandStatusOK
+		meta.ErrorCode = ""
+	default:
+		meta.Status = CommandStatusError
+		meta.ErrorCode = result.ErrorCode
+	}
+	b.commands[result.CommandID] = meta
+}
+
+// ListCommands returns up to limit of the user's most recent commands,
+// newest first, and never more than the history limit.
+func (b *MemoryBackend) ListCommands(userID string, limit int) []commandRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ids := b.history[userID]
+	if limit <= 0 || limit > b.historyLimit {
+		limit = b.historyLimit
+	}
+	out := make([]commandRecord, 0, limit)
+	for i := len(ids) - 1; i >= 0 && len(out) < limit; i-- {
+		meta, ok := b.commands[ids[i]]
+		if !ok {
+			continue
+		}
+		alias := meta.Alias
+		if rec, ok := b.projects[userID][meta.ProjectID]; ok && alias == "" {
+			alias = rec.Alias
+		}
+		out = append(out, commandRecord{
+			CommandID: ids[i],
+			Type:      meta.CommandType,
+			ProjectID: meta.ProjectID,
+			Alias:     alias,
+			CreatedAt: meta.CreatedAt,
+			Status:    meta.Status,
+			ErrorCode: meta.ErrorCode,
+		})
+	}
+	return out
+}
+
 func (b *MemoryBackend) SetProject(userID string, record projectRecord) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -420,9 +926,32 @@ func (b *MemoryBackend) ListProjects(userID string) []projectRecord {
 	for _, rec := range projects {
 		out = append(out, *rec)
 	}
+	// map order is random; sort so pages are stable
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Alias != out[j].Alias {
+			return out[i].Alias < out[j].Alias
+		}
+		return out[i].ProjectID < out[j].ProjectID
+	})
 	return out
 }
 
+// DeleteProject removes a registered project and any alias pointing at it.
+func (b *MemoryBackend) DeleteProject(userID, projectID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.projects[userID][projectID]; !ok {
+		return contracts.APIError{Code: contracts.ErrProjectNotFound, Message: "project not found"}
+	}
+	delete(b.projects[userID], projectID)
+	for alias, pid := range b.aliases[userID] {
+		if pid == projectID {
+			delete(b.aliases[userID], alias)
+		}
+	}
+	return nil
+}
+
 func (b *MemoryBackend) applyResultToProject(meta commandMeta, result contracts.CommandResult) {
 	if meta.ProjectID == "" || meta.TelegramUserID == "" {
 		if meta.CommandType != contracts.CommandTypeRegisterProject {
@@ -470,6 +999,44 @@ func (b *MemoryBackend) applyResultToProject(meta commandMeta, result contracts.
 	}
 }
 
+// newPairCodeLocked draws a random code, regenerating on the rare collision
+// with a code that is still outstanding in memory or in the pairing store.
+func (b *MemoryBackend) newPairCodeLocked() (string, error) {
+	for attempt := 0; attempt < maxPairCodeAttempts; attempt++ {
+		code, err := newPairCode()
+		if err != nil {
+			return "", contracts.APIError{Code: contracts.ErrInternal, Message: "failed to generate pairing code"}
+		}
+		if _, exists := b.pairCodes[code]; exists {
+			continue
+		}
+		if b.pairingStore != nil {
+			_, _, found, err := b.pairingStore.GetPairCode(code)
+			if err != nil {
+				return "", err
+			}
+			if found {
+				continue
+			}
+		}
+		return code, nil
+	}
+	return "", contracts.APIError{Code: contracts.ErrInternal, Message: "failed to generate unique pairing code"}
+}
+
+func newPairCode() (string, error) {
+	var buf [pairCodeLength]byte
+	if _, err := io.ReadFull(pairCodeRand, buf[:]); err != nil {
+		return "", err
+	}
+	out := make([]byte, pairCodeLength)
+	for i, v := range buf {
+		// 256 is a multiple of 32, so the modulo keeps the distribution uniform.
+		out[i] = pairCodeAlphabet[int(v)%len(pairCodeAlphabet)]
+	}
+	return "PAIR-" + string(out), nil
+}
+
 func newUUIDv4() (string, error) {
 	var b [16]byte
 	if _, err := rand.Read(b[:]); err != nil {
@@ -1,6 +1,9 @@
 package backend
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -11,7 +14,9 @@ type fakePairingStore struct {
 	savePairCodeFn   func(code, telegramUserID string, expiresAt time.Time) error
 	getPairCodeFn    func(code string) (string, time.Time, bool, error)
 	deletePairCodeFn func(code string) error
+	deleteUserCodeFn func(telegramUserID string) error
 	saveBindingFn    func(telegramUserID, agentID, agentKey string) error
+	deleteBindingFn  func(telegramUserID string) error
 	getAgentByKeyFn  func(agentKey string) (string, bool, error)
 	getAgentByUserFn func(telegramUserID string) (string, bool, error)
 	getUserByAgentFn func(agentID string) (string, bool, error)
@@ -35,12 +40,24 @@ func (f fakePairingStore) DeletePairCode(code string) error {
 	}
 	return nil
 }
+func (f fakePairingStore) DeleteUserPairCodes(telegramUserID string) error {
+	if f.deleteUserCodeFn != nil {
+		return f.deleteUserCodeFn(telegramUserID)
+	}
+	return nil
+}
 func (f fakePairingStore) SaveAgentBinding(telegramUserID, agentID, agentKey string) error {
 	if f.saveBindingFn != nil {
 		return f.saveBindingFn(telegramUserID, agentID, agentKey)
 	}
 	return nil
 }
+func (f fakePairingStore) DeleteAgentBinding(telegramUserID string) error {
+	if f.deleteBindingFn != nil {
+		return f.deleteBindingFn(telegramUserID)
+	}
+	return nil
+}
 func (f fakePairingStore) GetAgentIDByKey(agentKey string) (string, bool, error) {
 	if f.getAgentByKeyFn != nil {
 		return f.getAgentByKeyFn(agentKey)
@@ -67,19 +84,22 @@ func TestMemoryBackendPairingStorePaths(t *testing.T) {
 	b.SetPairingPersistence(fakePairingStore{})
 
 	calledSavePair := false
+	savedCode := ""
 	calledDelete := false
 	calledBinding := false
 	b.SetPairingPersistence(fakePairingStore{
 		savePairCodeFn: func(code, telegramUserID string, expiresAt time.Time) error {
 			calledSavePair = true
+			savedCode = code
 			if code == "" || telegramUserID != "u1" || expiresAt.IsZero() {
 				t.Fatalf("unexpected save pair args code=%q user=%q exp=%v", code, telegramUserID, expiresAt)
 			}
 			return nil
 		},
 		getPairCodeFn: func(code string) (string, time.Time, bool, error) {
-			if code != "PAIR-000001" {
-				t.Fatalf("unexpected pair code lookup: %q", code)
+			if savedCode == "" || code != savedCode {
+				// collision probe before the code is saved
+				return "", time.Time{}, false, nil
 			}
 			return "u1", now.Add(10 * time.Minute), true, nil
 		},
@@ -100,7 +120,7 @@ func TestMemoryBackendPairingStorePaths(t *testing.T) {
 	if err != nil {
 		t.Fatalf("start pairing: %v", err)
 	}
-	if start.PairingCode != "PAIR-000001" || !calledSavePair {
+	if start.PairingCode != savedCode || !calledSavePair {
 		t.Fatalf("expected persisted pair start, got %+v called=%v", start, calledSavePair)
 	}
 
@@ -175,6 +195,36 @@ func TestMemoryBackendPairingStoreErrorBranches(t *testing.T) {
 	}
 }
 
+func TestMemoryBackendUnpairWithPairingStore(t *testing.T) {
+	b := NewMemoryBackend()
+	var deleted []string
+	b.SetPairingPersistence(fakePairingStore{
+		getAgentByUserFn: func(telegramUserID string) (string, bool, error) { return "a1", telegramUserID == "u1", nil },
+		deleteBindingFn: func(telegramUserID string) error {
+			deleted = append(deleted, telegramUserID)
+			return nil
+		},
+	})
+	if err := b.Unpair("u1"); err != nil {
+		t.Fatalf("unpair: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "u1" {
+		t.Fatalf("expected persisted binding deleted, got %v", deleted)
+	}
+	var apiErr contracts.APIError
+	if err := b.Unpair("u2"); !errors.As(err, &apiErr) || apiErr.Code != contracts.ErrAuthUnauthorized {
+		t.Fatalf("expected not paired error, got %v", err)
+	}
+
+	b.SetPairingPersistence(fakePairingStore{
+		getAgentByUserFn: func(string) (string, bool, error) { return "a1", true, nil },
+		deleteBindingFn:  func(string) error { return errors.New("db down") },
+	})
+	if err := b.Unpair("u1"); err == nil || !strings.Contains(err.Error(), "db down") {
+		t.Fatalf("expected delete error, got %v", err)
+	}
+}
+
 func TestMemoryBackendPairingStoreLookupsAndFallbacks(t *testing.T) {
 	b := NewMemoryBackend()
 
@@ -231,3 +281,172 @@ func TestMemoryBackendPairingStoreLookupsAndFallbacks(t *testing.T) {
 		t.Fatalf("expected memory fallback for agent lookup, uid=%q ok=%v", uid, ok)
 	}
 }
+
+type fakeQueueStore struct {
+	queued   map[string][]contracts.Command
+	inflight map[string][]InflightCommand
+	results  map[string]contracts.CommandResult
+	saveErr  error
+}
+
+func newFakeQueueStore() *fakeQueueStore {
+	return &fakeQueueStore{
+		queued:   make(map[string][]contracts.Command),
+		inflight: make(map[string][]InflightCommand),
+		results:  make(map[string]contracts.CommandResult),
+	}
+}
+
+func (f *fakeQueueStore) SaveQueued(agentID string, cmds []contracts.Command) error {
+	if f.saveErr != nil {
+		return f.saveErr
+	}
+	f.queued[agentID] = append([]contracts.Command(nil), cmds...)
+	return nil
+}
+
+func (f *fakeQueueStore) SaveInflight(agentID string, items []InflightCommand) error {
+	if f.saveErr != nil {
+		return f.saveErr
+	}
+	f.inflight[agentID] = append([]InflightCommand(nil), items...)
+	return nil
+}
+
+func (f *fakeQueueStore) SaveResult(agentID string, result contracts.CommandResult) error {
+	if f.saveErr != nil {
+		return f.saveErr
+	}
+	f.results[agentID+"/"+result.CommandID] = result
+	return nil
+}
+
+func (f *fakeQueueStore) LoadQueued() (map[string][]contracts.Command, map[string][]InflightCommand, error) {
+	return f.queued, f.inflight, nil
+}
+
+func TestMemoryBackendQueuePersistenceSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeQueueStore()
+	now := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
+
+	b := NewMemoryBackend()
+	b.SetClock(func() time.Time { return now })
+	b.SetQueuePersistence(store)
+	for _, id := range []string{"c1", "c2"} {
+		if err := b.Enqueue(ctx, "a1", contracts.Command{CommandID: id}); err != nil {
+			t.Fatalf("enqueue %s: %v", id, err)
+		}
+	}
+	polled, err := b.Poll(ctx, "a1", 1)
+	if err != nil || polled == nil || polled.CommandID != "c1" {
+		t.Fatalf("poll mismatch cmd=%+v err=%v", polled, err)
+	}
+
+	// NewServer leaves restoring to the caller
+	restarted := NewMemoryBackend()
+	restarted.SetClock(func() time.Time { return now.Add(DefaultRedeliveryTTL) })
+	restarted.SetQueuePersistence(store)
+	_ = NewServer(restarted, restarted)
+	if n := len(restarted.inflight["a1"]) + len(restarted.queued["a1"]); n != 0 {
+		t.Fatalf("expected no state before RestoreQueue, got %d commands", n)
+	}
+	// simulate restart: the fresh backend reloads the persisted state
+	if err := restarted.RestoreQueue(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+
+	redelivered, err := restarted.Poll(ctx, "a1", 1)
+	if err != nil || redelivered == nil || redelivered.CommandID != "c1" {
+		t.Fatalf("expected stale inflight redelivery, got cmd=%+v err=%v", redelivered, err)
+	}
+	if err := restarted.StoreResult(ctx, "a1", contracts.CommandResult{CommandID: "c1", OK: true}); err != nil {
+		t.Fatalf("store result: %v", err)
+	}
+	next, err := restarted.Poll(ctx, "a1", 1)
+	if err != nil || next == nil || next.CommandID != "c2" {
+		t.Fatalf("expected queued command after restart, got cmd=%+v err=%v", next, err)
+	}
+	if _, ok := store.results["a1/c1"]; !ok {
+		t.Fatal("expected result written through")
+	}
+	if len(store.queued["a1"]) != 0 || len(store.inflight["a1"]) != 1 {
+		t.Fatalf("unexpected persisted state queued=%+v inflight=%+v", store.queued, store.inflight)
+	}
+}
+
+func TestMemoryBackendQueuePersistenceEnqueueError(t *testing.T) {
+	store := newFakeQueueStore()
+	store.saveErr = errors.New("db down")
+	b := NewMemoryBackend()
+	b.SetQueuePersistence(store)
+	if err := b.Enqueue(context.Background(), "a1", contracts.Command{CommandID: "c1"}); err == nil {
+		t.Fatal("expected enqueue to fail when persistence fails")
+	}
+	store.saveErr = nil
+	if cmd, _ := b.Poll(context.Background(), "a1", 1); cmd != nil {
+		t.Fatalf("expected failed enqueue to be rolled back, got %+v", cmd)
+	}
+}
+
+func TestMemoryBackendQueuePersistencePollError(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeQueueStore()
+	now := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
+	b := NewMemoryBackend()
+	b.SetClock(func() time.Time { return now })
+	b.SetQueuePersistence(store)
+	if err := b.Enqueue(ctx, "a1", contracts.Command{CommandID: "c1"}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	// a failed write leaves the command queued, in memory and in the store
+	store.saveErr = errors.New("db down")
+	if cmd, err := b.Poll(ctx, "a1", 1); err == nil || cmd != nil {
+		t.Fatalf("expected poll to fail, got %+v err=%v", cmd, err)
+	}
+	if len(b.queued["a1"]) != 1 || len(b.inflight["a1"]) != 0 {
+		t.Fatalf("expected memory untouched, queued=%+v inflight=%+v", b.queued["a1"], b.inflight["a1"])
+	}
+	store.saveErr = nil
+	if cmd, err := b.Poll(ctx, "a1", 1); err != nil || cmd == nil || cmd.CommandID != "c1" {
+		t.Fatalf("expected c1 after recovery, got %+v err=%v", cmd, err)
+	}
+
+	// nor does a failed redelivery restamp the inflight entry
+	now = now.Add(DefaultRedeliveryTTL)
+	store.saveErr = errors.New("db down")
+	if _, err := b.Poll(ctx, "a1", 1); err == nil {
+		t.Fatal("expected redelivery poll to fail")
+	}
+	if got := b.inflight["a1"][0].InflightAt; !got.Equal(now.Add(-DefaultRedeliveryTTL)) {
+		t.Fatalf("expected delivery time unchanged, got %s", got)
+	}
+}
+
+func TestMemoryBackendRotatePairingWithPairingStore(t *testing.T) {
+	b := NewMemoryBackend()
+	var cleared []string
+	var saved []string
+	b.SetPairingPersistence(fakePairingStore{
+		deleteUserCodeFn: func(telegramUserID string) error {
+			cleared = append(cleared, telegramUserID)
+			return nil
+		},
+		savePairCodeFn: func(code, telegramUserID string, expiresAt time.Time) error {
+			saved = append(saved, telegramUserID)
+			return nil
+		},
+	})
+	if _, err := b.RotatePairing("u1"); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if len(cleared) != 1 || cleared[0] != "u1" || len(saved) != 1 || saved[0] != "u1" {
+		t.Fatalf("expected old codes cleared then a new one saved, cleared=%v saved=%v", cleared, saved)
+	}
+
+	b.SetPairingPersistence(fakePairingStore{deleteUserCodeFn: func(string) error { return errors.New("db down") }})
+	if _, err := b.RotatePairing("u1"); err == nil || !strings.Contains(err.Error(), "db down") {
+		t.Fatalf("expected store error, got %v", err)
+	}
+}
@@ -1,9 +1,12 @@
 package backend
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -41,6 +44,63 @@ func TestACMVP05PairingCodeTTLExpiry(t *testing.T) {
 	}
 }
 
+func TestPairingCodesAreRandom(t *testing.T) {
+	b := NewMemoryBackend()
+	b.SetClock(func() time.Time { return time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC) })
+
+	pattern := regexp.MustCompile(`^PAIR-[` + pairCodeAlphabet + `]{8}$`)
+	seen := make(map[string]bool)
+	chars := make(map[rune]bool)
+	for i := 0; i < 200; i++ {
+		start, err := b.StartPairing("tg-user")
+		if err != nil {
+			t.Fatalf("start pairing: %v", err)
+		}
+		if !pattern.MatchString(start.PairingCode) {
+			t.Fatalf("unexpected pairing code format: %q", start.PairingCode)
+		}
+		if seen[start.PairingCode] {
+			t.Fatalf("duplicate pairing code %q", start.PairingCode)
+		}
+		seen[start.PairingCode] = true
+		for _, r := range strings.TrimPrefix(start.PairingCode, "PAIR-") {
+			chars[r] = true
+		}
+	}
+	if len(chars) < len(pairCodeAlphabet)-4 {
+		t.Fatalf("expected codes to cover the alphabet, saw %d distinct chars", len(chars))
+	}
+}
+
+func TestPairingCodeRegeneratesOnCollision(t *testing.T) {
+	old := pairCodeRand
+	t.Cleanup(func() { pairCodeRand = old })
+
+	b := NewMemoryBackend()
+	b.SetClock(func() time.Time { return time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC) })
+
+	// first two draws are identical, third differs
+	pairCodeRand = bytes.NewReader(append(append(make([]byte, 8), make([]byte, 8)...), []byte{1, 1, 1, 1, 1, 1, 1, 1}...))
+	first, err := b.StartPairing("u1")
+	if err != nil {
+		t.Fatalf("first start: %v", err)
+	}
+	second, err := b.StartPairing("u2")
+	if err != nil {
+		t.Fatalf("second start: %v", err)
+	}
+	if first.PairingCode != "PAIR-22222222" || second.PairingCode != "PAIR-33333333" {
+		t.Fatalf("expected regeneration on collision, got %q and %q", first.PairingCode, second.PairingCode)
+	}
+
+	pairCodeRand = bytes.NewReader(make([]byte, 8*maxPairCodeAttempts))
+	_, err = b.StartPairing("u3")
+	apiErr, ok := err.(contracts.APIError)
+	if !ok || apiErr.Code != contracts.ErrInternal {
+		t.Fatalf("expected internal error after exhausting attempts, got %v", err)
+	}
+}
+
 func TestACMVP05OneActiveAgentReplacement(t *testing.T) {
 	clk := &fakeClock{now: time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)}
 	b := NewMemoryBackend()
@@ -107,7 +167,7 @@ func TestMemoryBackendQueueAndResultsLifecycle(t *testing.T) {
 
 	cmd := contracts.Command{
 		CommandID:      "cmd-1",
-		IdempotencyKey: "key-1",
+		IdempotencyKey: "key-1-00",
 		Type:           contracts.CommandTypeStatus,
 		CreatedAt:      clk.now,
 		Payload:        json.RawMessage(`{}`),
@@ -159,6 +219,70 @@ func TestMemoryBackendQueueAndResultsLifecycle(t *testing.T) {
 	}
 }
 
+func TestMemoryBackendProgressKeepsCommandInflight(t *testing.T) {
+	b := NewMemoryBackend()
+	ctx := context.Background()
+	cmd := contracts.Command{CommandID: "cmd-p", IdempotencyKey: "key-p-00", Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(`{}`)}
+	if err := b.Enqueue(ctx, "agent-1", cmd); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if _, err := b.Poll(ctx, "agent-1", 1); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+
+	for _, out := range []string{"one", "one\ntwo"} {
+		if err := b.StoreResult(ctx, "agent-1", contracts.CommandResult{CommandID: "cmd-p", InProgress: true, Stdout: out}); err != nil {
+			t.Fatalf("store progress: %v", err)
+		}
+	}
+	if len(b.inflight["agent-1"]) != 1 {
+		t.Fatalf("expected command to stay inflight, got %+v", b.inflight["agent-1"])
+	}
+	got, _ := b.GetResult(ctx, "agent-1", "cmd-p")
+	if got == nil || !got.InProgress || got.Stdout != "one\ntwo" {
+		t.Fatalf("expected latest progress, got %+v", got)
+	}
+
+	if err := b.StoreResult(ctx, "agent-1", contracts.CommandResult{CommandID: "cmd-p", OK: true, Summary: "done"}); err != nil {
+		t.Fatalf("store final: %v", err)
+	}
+	if err := b.StoreResult(ctx, "agent-1", contracts.CommandResult{CommandID: "cmd-p", InProgress: true, Stdout: "late"}); err != nil {
+		t.Fatalf("store late progress: %v", err)
+	}
+	got, _ = b.GetResult(ctx, "agent-1", "cmd-p")
+	if got == nil || got.InProgress || got.Summary != "done" || len(b.inflight["agent-1"]) != 0 {
+		t.Fatalf("expected final result to win, got %+v inflight=%+v", got, b.inflight["agent-1"])
+	}
+}
+
+func TestMemoryBackendProgressDefersRedelivery(t *testing.T) {
+	b := NewMemoryBackend()
+	now := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
+	b.SetClock(func() time.Time { return now })
+	ctx := context.Background()
+	cmd := contracts.Command{CommandID: "cmd-long", IdempotencyKey: "key-long-0", Type: contracts.CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{}`)}
+	if err := b.Enqueue(ctx, "agent-1", cmd); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if _, err := b.Poll(ctx, "agent-1", 1); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+
+	// progress just before the TTL restarts the clock
+	now = now.Add(100 * time.Second)
+	if err := b.StoreResult(ctx, "agent-1", contracts.CommandResult{CommandID: "cmd-long", InProgress: true, Stdout: "step"}); err != nil {
+		t.Fatalf("store progress: %v", err)
+	}
+	now = now.Add(100 * time.Second)
+	if got, err := b.Poll(ctx, "agent-1", 1); err != nil || got != nil {
+		t.Fatalf("expected no redelivery while progressing, got %+v err=%v", got, err)
+	}
+	now = now.Add(30 * time.Second)
+	if got, err := b.Poll(ctx, "agent-1", 1); err != nil || got == nil || got.CommandID != "cmd-long" {
+		t.Fatalf("expected redelivery once progress stops, got %+v err=%v", got, err)
+	}
+}
+
 func TestMemoryBackendApplyResultToProjectUpdatesState(t *testing.T) {
 	b := NewMemoryBackend()
 	now := time.Date(2026, 2, 11, 11, 0, 0, 0, time.UTC)
@@ -229,13 +353,41 @@ func TestMemoryBackendApplyResultToProjectUpdatesState(t *testing.T) {
 	}
 }
 
+func TestMemoryBackendAppliesResultPastHistoryLimit(t *testing.T) {
+	b := NewMemoryBackend()
+	b.RegisterCommandMeta("cmd-register", commandMeta{
+		TelegramUserID: "u1",
+		CommandType:    contracts.CommandTypeRegisterProject,
+		Alias:          "demo",
+		ProjectPath:    "/tmp/demo",
+	})
+	// the user queues a full history of newer commands while it is pending
+	for i := 0; i < DefaultHistoryLimit; i++ {
+		b.RegisterCommandMeta(fmt.Sprintf("cmd-%d", i), commandMeta{TelegramUserID: "u1", CommandType: contracts.CommandTypeStatus})
+	}
+	err := b.StoreResult(context.Background(), "agent-1", contracts.CommandResult{
+		CommandID: "cmd-register",
+		OK:        true,
+		Meta:      map[string]any{"project_id": "p1", "project_path": "/tmp/demo"},
+	})
+	if err != nil {
+		t.Fatalf("store register result: %v", err)
+	}
+	if _, ok := b.ResolveProject("u1", "demo"); !ok {
+		t.Fatal("expected the pending register_project result applied")
+	}
+	if got := len(b.ListCommands("u1", 0)); got != DefaultHistoryLimit {
+		t.Fatalf("expected history listing capped at %d, got %d", DefaultHistoryLimit, got)
+	}
+}
+
 func TestMemoryBackendPollRedeliveryBranch(t *testing.T) {
 	b := NewMemoryBackend()
 	clk := &fakeClock{now: time.Date(2026, 2, 11, 12, 0, 0, 0, time.UTC)}
 	b.SetClock(clk.Now)
 	b.redeliveryAfter = 2 * time.Second
 
-	cmd := contracts.Command{CommandID: "cmd-r", IdempotencyKey: "key-r", Type: contracts.CommandTypeStatus, CreatedAt: clk.now, Payload: json.RawMessage(`{}`)}
+	cmd := contracts.Command{CommandID: "cmd-r", IdempotencyKey: "key-r-00", Type: contracts.CommandTypeStatus, CreatedAt: clk.now, Payload: json.RawMessage(`{}`)}
 	if err := b.Enqueue(context.Background(), "agent-r", cmd); err != nil {
 		t.Fatalf("enqueue: %v", err)
 	}
@@ -250,3 +402,32 @@ func TestMemoryBackendPollRedeliveryBranch(t *testing.T) {
 		t.Fatalf("expected redelivery of cmd-r, got cmd=%+v err=%v", second, err)
 	}
 }
+
+func TestMemoryBackendSetRedeliveryTTL(t *testing.T) {
+	b := NewMemoryBackend()
+	clk := &fakeClock{now: time.Date(2026, 2, 11, 12, 0, 0, 0, time.UTC)}
+	b.SetClock(clk.Now)
+	if err := b.SetRedeliveryTTL(0); err == nil {
+		t.Fatal("expected zero TTL to be rejected")
+	}
+	if err := b.SetRedeliveryTTL(15 * time.Minute); err != nil {
+		t.Fatalf("set redelivery TTL: %v", err)
+	}
+
+	cmd := contracts.Command{CommandID: "cmd-long", IdempotencyKey: "key-long", Type: contracts.CommandTypeStatus, CreatedAt: clk.now, Payload: json.RawMessage(`{}`)}
+	if err := b.Enqueue(context.Background(), "agent-r", cmd); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if first, err := b.Poll(context.Background(), "agent-r", 0); err != nil || first == nil {
+		t.Fatalf("first poll failed: cmd=%+v err=%v", first, err)
+	}
+
+	clk.now = clk.now.Add(DefaultRedeliveryTTL + 10*time.Minute)
+	if again, err := b.Poll(context.Background(), "agent-r", 0); err != nil || again != nil {
+		t.Fatalf("expected no redelivery within the TTL, got cmd=%+v err=%v", again, err)
+	}
+	clk.now = clk.now.Add(5 * time.Minute)
+	if again, err := b.Poll(context.Background(), "agent-r", 0); err != nil || again == nil || again.CommandID != "cmd-long" {
+		t.Fatalf("expected redelivery once the TTL passed, got cmd=%+v err=%v", again, err)
+	}
+}
@@ -1,45 +1,162 @@
 package backend
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"opencode-telegram/internal/proxy/contracts"
 )
 
 type Server struct {
-	backend  PairingStore
-	queue    CommandQueue
-	mux      *http.ServeMux
-	notifier ResultNotifier
+	backend   PairingStore
+	queue     CommandQueue
+	mux       *http.ServeMux
+	handler   http.Handler
+	notifier  ResultNotifier
+	now       func() time.Time
+	freshness contracts.FreshnessWindow
+
+	// notifyRetries and notifyRetryBase bound the retries of a failed
+	// result notification; the delay doubles after each attempt.
+	notifyRetries   int
+	notifyRetryBase time.Duration
+
+	pollLimiter *rateLimiter
+
+	maxCommandBytes int64
+
+	// draining is closed by Drain so long polls and result streams end
+	// without waiting for their timeouts.
+	draining  chan struct{}
+	drainOnce sync.Once
 }
 
+// ResultNotifier tells a Telegram user that a command finished. A returned
+// error makes the server retry the notification.
 type ResultNotifier interface {
-	NotifyResult(telegramUserID string, result contracts.CommandResult)
+	NotifyResult(telegramUserID string, result contracts.CommandResult) error
+}
+
+// ResultSubscriber is implemented by queues that can push results as soon as
+// they are stored, backing the /v1/result/stream endpoint.
+type ResultSubscriber interface {
+	Subscribe(ctx context.Context, agentID, commandID string) (<-chan contracts.CommandResult, error)
+}
+
+// queueStatsReporter is implemented by the durable queues, RedisQueue and
+// PostgresQueue.
+type queueStatsReporter interface {
+	QueueStats(ctx context.Context, agentID string) (queued int, inflight int, err error)
+	PendingCommands(ctx context.Context, agentID string, limit int) ([]contracts.PendingCommand, error)
 }
 
+// queuePinger is implemented by queues backed by an external store, which
+// /readyz checks.
+type queuePinger interface {
+	Ping(ctx context.Context) error
+}
+
+// DefaultMaxCommandBytes caps a POST /v1/command body. It leaves room for a
+// run_task carrying contracts.DefaultMaxAttachmentBytes of base64 attachments.
+const DefaultMaxCommandBytes = 16 << 20
+
+// readyCheckTimeout bounds the queue ping behind /readyz.
+const readyCheckTimeout = 2 * time.Second
+
+// DefaultNotifyRetries and DefaultNotifyRetryBase bound the retries of a
+// result notification that failed.
+const (
+	DefaultNotifyRetries   = 3
+	DefaultNotifyRetryBase = 500 * time.Millisecond
+)
+
 type noopNotifier struct{}
 
-func (n noopNotifier) NotifyResult(string, contracts.CommandResult) {}
+func (n noopNotifier) NotifyResult(string, contracts.CommandResult) error { return nil }
 
 func NewServer(backend PairingStore, queue CommandQueue) *Server {
 	mux := http.NewServeMux()
-	s := &Server{backend: backend, queue: queue, mux: mux, notifier: noopNotifier{}}
+	s := &Server{backend: backend, queue: queue, mux: mux, notifier: noopNotifier{}, notifyRetries: DefaultNotifyRetries, notifyRetryBase: DefaultNotifyRetryBase, now: time.Now, freshness: contracts.DefaultFreshnessWindow, pollLimiter: newRateLimiter(DefaultPollRate, DefaultPollBurst), maxCommandBytes: DefaultMaxCommandBytes, draining: make(chan struct{})}
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
 	mux.HandleFunc("/v1/pair/start", s.handlePairStart)
 	mux.HandleFunc("/v1/pair/claim", s.handlePairClaim)
+	mux.HandleFunc("/v1/pair/revoke", s.handlePairRevoke)
+	mux.HandleFunc("/v1/pair/rotate", s.handlePairRotate)
 	mux.HandleFunc("/v1/command", s.handleCommand)
 	mux.HandleFunc("/v1/poll", s.handlePoll)
 	mux.HandleFunc("/v1/result", s.handleResult)
 	mux.HandleFunc("/v1/projects", s.handleProjects)
 	mux.HandleFunc("/v1/result/status", s.handleResultStatus)
+	mux.HandleFunc("/v1/result/stream", s.handleResultStream)
+	mux.HandleFunc("/v1/agent/status", s.handleAgentStatus)
+	mux.HandleFunc("/v1/agent/queue", s.handleAgentQueue)
+	mux.HandleFunc("/v1/commands", s.handleCommands)
+	s.handler = withRequestLogging(mux)
 	return s
 }
 
+// Drain ends outstanding long polls with 204 No Content and closes result
+// streams. Call it before http.Server.Shutdown, which waits for active
+// requests but does not cancel them.
+func (s *Server) Drain() {
+	s.drainOnce.Do(func() { close(s.draining) })
+}
+
+// drainContext derives a context from the request's that is also cancelled
+// by Drain.
+func (s *Server) drainContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(r.Context())
+	go func() {
+		select {
+		case <-s.draining:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// handleHealthz reports liveness: the process is up and serving.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("OK"))
+}
+
+// handleReadyz reports readiness: the command queue's store answers a ping.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if pinger, ok := s.queue.(queuePinger); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+		defer cancel()
+		if err := pinger.Ping(ctx); err != nil {
+			log.Printf("readiness check failed: queue unreachable: %v", err)
+			writeError(w, http.StatusServiceUnavailable, contracts.APIError{Code: contracts.ErrInternal, Message: "queue unreachable"})
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("Ready"))
+}
+
+// SetMaxCommandBytes caps POST /v1/command bodies; larger ones get 413.
+// Values below 1 restore DefaultMaxCommandBytes.
+func (s *Server) SetMaxCommandBytes(n int64) {
+	if n < 1 {
+		n = DefaultMaxCommandBytes
+	}
+	s.maxCommandBytes = n
+}
+
 func (s *Server) SetNotifier(notifier ResultNotifier) {
 	if notifier == nil {
 		s.notifier = noopNotifier{}
@@ -48,8 +165,20 @@ func (s *Server) SetNotifier(notifier ResultNotifier) {
 	s.notifier = notifier
 }
 
+// SetFreshnessWindow sets how stale or far in the future a submitted
+// command's created_at may be before /v1/command rejects it.
+func (s *Server) SetFreshnessWindow(window contracts.FreshnessWindow) {
+	s.freshness = window
+}
+
+// SetPollRateLimit limits each agent to perSecond polls with bursts of up to
+// burst; excess polls get 429. A non-positive perSecond disables the limit.
+func (s *Server) SetPollRateLimit(perSecond float64, burst int) {
+	s.pollLimiter.setRate(perSecond, burst)
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.mux.ServeHTTP(w, r)
+	s.handler.ServeHTTP(w, r)
 }
 
 func (s *Server) handlePairStart(w http.ResponseWriter, r *http.Request) {
@@ -86,6 +215,66 @@ func (s *Server) handlePairClaim(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, resp)
 }
 
+func (s *Server) handlePairRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "method not allowed"})
+		return
+	}
+	backend, ok := s.backend.(*MemoryBackend)
+	if !ok {
+		writeError(w, http.StatusBadRequest, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "pairing revocation not supported"})
+		return
+	}
+	req, ok := decodeJSONBody[contracts.PairRevokeRequest](w, r)
+	if !ok {
+		return
+	}
+	if !s.authPairedUser(w, r, backend, req.TelegramUserID) {
+		return
+	}
+	agentID, _ := backend.AgentIDForUser(req.TelegramUserID)
+	if err := backend.Unpair(req.TelegramUserID); err != nil {
+		var apiErr contracts.APIError
+		if errors.As(err, &apiErr) && apiErr.Code == contracts.ErrAuthUnauthorized {
+			writeError(w, http.StatusNotFound, apiErr)
+			return
+		}
+		writeServerError(w, err)
+		return
+	}
+	// The agent's key is already gone, so leftover commands can never be
+	// polled; a failed purge only leaves garbage behind and is logged.
+	if err := s.queue.Purge(r.Context(), agentID); err != nil {
+		log.Printf("purge queue of unpaired agent %s: %v", agentID, err)
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func (s *Server) handlePairRotate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "method not allowed"})
+		return
+	}
+	backend, ok := s.backend.(*MemoryBackend)
+	if !ok {
+		writeError(w, http.StatusBadRequest, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "pairing rotation not supported"})
+		return
+	}
+	req, ok := decodeJSONBody[contracts.PairRotateRequest](w, r)
+	if !ok {
+		return
+	}
+	if !s.authPairedUser(w, r, backend, req.TelegramUserID) {
+		return
+	}
+	resp, err := backend.RotatePairing(req.TelegramUserID)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
 func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeError(w, http.StatusMethodNotAllowed, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "method not allowed"})
@@ -97,8 +286,13 @@ func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var cmd contracts.Command
-	body, err := io.ReadAll(r.Body)
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxCommandBytes))
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit)})
+			return
+		}
 		writeError(w, http.StatusBadRequest, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: err.Error()})
 		return
 	}
@@ -107,13 +301,13 @@ func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := contracts.ValidateCommand(cmd); err != nil {
+	if err := contracts.ValidateCommandAt(cmd, s.now().UTC(), s.freshness); err != nil {
 		writeServerError(w, err)
 		return
 	}
 	if backend, ok := s.backend.(*MemoryBackend); ok {
 		if userID, ok := backend.UserIDForAgent(agentID); ok {
-			meta := commandMeta{TelegramUserID: userID, CommandType: cmd.Type}
+			meta := commandMeta{TelegramUserID: userID, CommandType: cmd.Type, CreatedAt: cmd.CreatedAt}
 			if cmd.Type == contracts.CommandTypeRegisterProject {
 				var payload contracts.RegisterProjectPayload
 				_ = contracts.DecodeStrictJSON(cmd.Payload, &payload)
@@ -123,13 +317,24 @@ func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
 					meta.Alias = fmt.Sprintf("project-%d", time.Now().Unix())
 				}
 			}
-			if cmd.Type == contracts.CommandTypeStartServer || cmd.Type == contracts.CommandTypeRunTask || cmd.Type == contracts.CommandTypeApplyProjectPolicy {
+			if cmd.Type == contracts.CommandTypeStartServer || cmd.Type == contracts.CommandTypeStopServer || cmd.Type == contracts.CommandTypeRunTask || cmd.Type == contracts.CommandTypeApplyProjectPolicy || cmd.Type == contracts.CommandTypeAbortSession {
 				var payload struct {
 					ProjectID string `json:"project_id"`
 				}
 				_ = contracts.DecodeStrictJSON(cmd.Payload, &payload)
 				meta.ProjectID = payload.ProjectID
 			}
+			if cmd.Type == contracts.CommandTypeCancelTask {
+				var payload contracts.CancelTaskPayload
+				_ = contracts.DecodeStrictJSON(cmd.Payload, &payload)
+				// Only the user who issued a command may cancel it.
+				target, known := backend.commandMetaFor(payload.CommandID)
+				if known && target.TelegramUserID != userID {
+					writeError(w, http.StatusForbidden, contracts.APIError{Code: contracts.ErrAuthUnauthorized, Message: "command belongs to another user"})
+					return
+				}
+				meta.ProjectID = target.ProjectID
+			}
 			backend.RegisterCommandMeta(cmd.CommandID, meta)
 		}
 	}
@@ -150,6 +355,11 @@ func (s *Server) handlePoll(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
+	if allowed, wait := s.pollLimiter.allow(agentID, s.now()); !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeError(w, http.StatusTooManyRequests, contracts.APIError{Code: contracts.ErrRateLimited, Message: "poll rate limit exceeded"})
+		return
+	}
 	timeoutSeconds := 25
 	if raw := r.URL.Query().Get("timeout_seconds"); raw != "" {
 		v, err := strconv.Atoi(raw)
@@ -159,8 +369,13 @@ func (s *Server) handlePoll(w http.ResponseWriter, r *http.Request) {
 		}
 		timeoutSeconds = v
 	}
-	cmd, err := s.queue.Poll(r.Context(), agentID, timeoutSeconds)
-	if err != nil {
+	if backend, ok := s.backend.(*MemoryBackend); ok {
+		backend.MarkSeen(agentID, timeoutSeconds)
+	}
+	ctx, cancel := s.drainContext(r)
+	defer cancel()
+	cmd, err := s.queue.Poll(ctx, agentID, timeoutSeconds)
+	if err != nil && ctx.Err() == nil {
 		writeServerError(w, err)
 		return
 	}
@@ -185,7 +400,7 @@ func (s *Server) handleResult(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if strings.TrimSpace(result.CommandID) == "" {
-		writeError(w, http.StatusBadRequest, contracts.APIError{Code: contracts.ErrValidationRequiredField, Message: "command_id is required"})
+		writeError(w, http.StatusBadRequest, contracts.RequiredFieldError("command_id"))
 		return
 	}
 	if err := s.queue.StoreResult(r.Context(), agentID, result); err != nil {
@@ -193,14 +408,47 @@ func (s *Server) handleResult(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if backend, ok := s.backend.(*MemoryBackend); ok {
-		if userID, ok := backend.UserIDForAgent(agentID); ok {
-			s.notifier.NotifyResult(userID, result)
+		backend.RecordCommandResult(result)
+		if userID, ok := backend.UserIDForAgent(agentID); ok && result.IsTerminal() {
+			s.notifyResult(userID, result)
 		}
 	}
 	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
 }
 
+// notifyResult notifies userID of result. The first attempt is made inline;
+// when it fails, up to notifyRetries more are made in the background with
+// doubling backoff, and a final failure is logged. Drain stops the retries.
+func (s *Server) notifyResult(userID string, result contracts.CommandResult) {
+	err := s.notifier.NotifyResult(userID, result)
+	if err == nil {
+		return
+	}
+	notifier, retries, delay := s.notifier, s.notifyRetries, s.notifyRetryBase
+	go func() {
+		for attempt := 1; attempt <= retries; attempt++ {
+			timer := time.NewTimer(delay)
+			select {
+			case <-s.draining:
+				timer.Stop()
+				log.Printf("result notification for %s dropped on shutdown: %v", result.CommandID, err)
+				return
+			case <-timer.C:
+			}
+			if err = notifier.NotifyResult(userID, result); err == nil {
+				return
+			}
+			delay *= 2
+		}
+		log.Printf("result notification for %s failed after %d attempts: %v", result.CommandID, retries+1, err)
+	}()
+}
+
 func (s *Server) handleProjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		s.handleProjectDelete(w, r)
+		return
+	}
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "method not allowed"})
 		return
@@ -212,11 +460,167 @@ func (s *Server) handleProjects(w http.ResponseWriter, r *http.Request) {
 	}
 	userID := strings.TrimSpace(r.URL.Query().Get("telegram_user_id"))
 	if userID == "" {
-		writeError(w, http.StatusBadRequest, contracts.APIError{Code: contracts.ErrValidationRequiredField, Message: "telegram_user_id is required"})
+		writeError(w, http.StatusBadRequest, contracts.RequiredFieldError("telegram_user_id"))
+		return
+	}
+	offset, ok := queryInt(w, r, "offset", 0, 0)
+	if !ok {
+		return
+	}
+	limit, ok := queryInt(w, r, "limit", 0, 1)
+	if !ok {
 		return
 	}
 	projects := backend.ListProjects(userID)
-	writeJSON(w, http.StatusOK, map[string]any{"projects": projects})
+	query := r.URL.Query()
+	if !query.Has("offset") && !query.Has("limit") {
+		// Unpaged requests keep the original response shape.
+		writeJSON(w, http.StatusOK, map[string]any{"projects": projects})
+		return
+	}
+	total := len(projects)
+	if offset > total {
+		offset = total
+	}
+	projects = projects[offset:]
+	if limit > 0 && limit < len(projects) {
+		projects = projects[:limit]
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"projects": projects, "total": total, "offset": offset})
+}
+
+// queryInt parses the optional integer query parameter name, which must be at
+// least min, writing a 400 and returning false when it is malformed.
+func queryInt(w http.ResponseWriter, r *http.Request, name string, def, min int) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, true
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < min {
+		writeError(w, http.StatusBadRequest, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: fmt.Sprintf("%s must be an integer >= %d", name, min)})
+		return 0, false
+	}
+	return v, true
+}
+
+func (s *Server) handleProjectDelete(w http.ResponseWriter, r *http.Request) {
+	agentID, ok := s.authAgent(w, r)
+	if !ok {
+		return
+	}
+	backend, ok := s.backend.(*MemoryBackend)
+	if !ok {
+		writeError(w, http.StatusBadRequest, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "projects not supported"})
+		return
+	}
+	userID := strings.TrimSpace(r.URL.Query().Get("telegram_user_id"))
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, contracts.RequiredFieldError("telegram_user_id"))
+		return
+	}
+	projectID := strings.TrimSpace(r.URL.Query().Get("project_id"))
+	if projectID == "" {
+		writeError(w, http.StatusBadRequest, contracts.RequiredFieldError("project_id"))
+		return
+	}
+	// An agent may only delete projects of the user it is paired with.
+	if owner, ok := backend.UserIDForAgent(agentID); !ok || owner != userID {
+		writeError(w, http.StatusForbidden, contracts.APIError{Code: contracts.ErrAuthUnauthorized, Message: "agent not paired with user"})
+		return
+	}
+	if err := backend.DeleteProject(userID, projectID); err != nil {
+		writeServerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func (s *Server) handleAgentStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "method not allowed"})
+		return
+	}
+	backend, ok := s.backend.(*MemoryBackend)
+	if !ok {
+		writeError(w, http.StatusBadRequest, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "agent status not supported"})
+		return
+	}
+	userID := strings.TrimSpace(r.URL.Query().Get("telegram_user_id"))
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, contracts.RequiredFieldError("telegram_user_id"))
+		return
+	}
+	agentID, ok := backend.AgentIDForUser(userID)
+	if !ok {
+		writeError(w, http.StatusNotFound, contracts.APIError{Code: contracts.ErrAuthUnauthorized, Message: "agent not paired"})
+		return
+	}
+	writeJSON(w, http.StatusOK, backend.AgentStatus(agentID))
+}
+
+func (s *Server) handleAgentQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "method not allowed"})
+		return
+	}
+	userID := strings.TrimSpace(r.URL.Query().Get("telegram_user_id"))
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, contracts.RequiredFieldError("telegram_user_id"))
+		return
+	}
+	agentID, ok := s.backend.AgentIDForUser(userID)
+	if !ok {
+		writeError(w, http.StatusNotFound, contracts.APIError{Code: contracts.ErrAuthUnauthorized, Message: "agent not paired"})
+		return
+	}
+	var stats contracts.QueueStatsResponse
+	switch q := s.queue.(type) {
+	case *MemoryBackend:
+		stats.Queued, stats.Inflight = q.QueueStats(agentID)
+		stats.Commands = q.PendingCommands(agentID, contracts.MaxPendingCommands)
+	case queueStatsReporter:
+		queued, inflight, err := q.QueueStats(r.Context(), agentID)
+		if err == nil {
+			stats.Commands, err = q.PendingCommands(r.Context(), agentID, contracts.MaxPendingCommands)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, contracts.APIError{Code: contracts.ErrInternal, Message: err.Error()})
+			return
+		}
+		stats.Queued, stats.Inflight = queued, inflight
+	default:
+		writeError(w, http.StatusBadRequest, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "queue stats not supported"})
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *Server) handleCommands(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "method not allowed"})
+		return
+	}
+	backend, ok := s.backend.(*MemoryBackend)
+	if !ok {
+		writeError(w, http.StatusBadRequest, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "command history not supported"})
+		return
+	}
+	userID := strings.TrimSpace(r.URL.Query().Get("telegram_user_id"))
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, contracts.RequiredFieldError("telegram_user_id"))
+		return
+	}
+	limit := DefaultHistoryLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 1 {
+			writeError(w, http.StatusBadRequest, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "limit must be a positive integer"})
+			return
+		}
+		limit = v
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"commands": backend.ListCommands(userID, limit)})
 }
 
 func (s *Server) handleResultStatus(w http.ResponseWriter, r *http.Request) {
@@ -231,12 +635,12 @@ func (s *Server) handleResultStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	userID := strings.TrimSpace(r.URL.Query().Get("telegram_user_id"))
 	if userID == "" {
-		writeError(w, http.StatusBadRequest, contracts.APIError{Code: contracts.ErrValidationRequiredField, Message: "telegram_user_id is required"})
+		writeError(w, http.StatusBadRequest, contracts.RequiredFieldError("telegram_user_id"))
 		return
 	}
 	commandID := strings.TrimSpace(r.URL.Query().Get("command_id"))
 	if commandID == "" {
-		writeError(w, http.StatusBadRequest, contracts.APIError{Code: contracts.ErrValidationRequiredField, Message: "command_id is required"})
+		writeError(w, http.StatusBadRequest, contracts.RequiredFieldError("command_id"))
 		return
 	}
 	agentID, ok := backend.AgentIDForUser(userID)
@@ -253,17 +657,117 @@ func (s *Server) handleResultStatus(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	if meta, ok := backend.commands[commandID]; ok && meta.CommandType == contracts.CommandTypeApplyProjectPolicy {
+	applyPolicyResult(backend, commandID, *result)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleResultStream is a server-sent-events variant of /v1/result/status: it
+// sends each progress update and ends after the command's final result or when
+// the client goes away.
+func (s *Server) handleResultStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "method not allowed"})
+		return
+	}
+	subscriber, ok := s.queue.(ResultSubscriber)
+	if !ok {
+		writeError(w, http.StatusBadRequest, contracts.APIError{Code: contracts.ErrValidationInvalidRequest, Message: "result stream not supported"})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, contracts.APIError{Code: contracts.ErrInternal, Message: "streaming not supported"})
+		return
+	}
+	// Results are only streamed to the agent or the bot acting for its
+	// user, as for /v1/result.
+	agentID, ok := s.authAgent(w, r)
+	if !ok {
+		return
+	}
+	commandID := strings.TrimSpace(r.URL.Query().Get("command_id"))
+	if commandID == "" {
+		writeError(w, http.StatusBadRequest, contracts.RequiredFieldError("command_id"))
+		return
+	}
+	ctx, cancel := s.drainContext(r)
+	defer cancel()
+	results, err := subscriber.Subscribe(ctx, agentID, commandID)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case result, ok := <-results:
+			if !ok {
+				return
+			}
+			if backend, ok := s.backend.(*MemoryBackend); ok && result.IsTerminal() {
+				applyPolicyResult(backend, commandID, result)
+			}
+			data, err := json.Marshal(result)
+			if err != nil {
+				return
+			}
+			_, _ = fmt.Fprintf(w, "event: result\ndata: %s\n\n", data)
+			flusher.Flush()
+			if result.IsTerminal() {
+				return
+			}
+		}
+	}
+}
+
+func applyPolicyResult(backend *MemoryBackend, commandID string, result contracts.CommandResult) {
+	if meta, ok := backend.commandMetaFor(commandID); ok && meta.CommandType == contracts.CommandTypeApplyProjectPolicy {
 		backend.UpdateProjectPolicy(meta.TelegramUserID, meta.ProjectID, projectPolicy{
 			Decision:  stringFromMeta(result.Meta["decision"], contracts.DecisionAllow),
 			Scope:     scopeFromMeta(result.Meta["scope"]),
 			ExpiresAt: expiresAtFromMeta(result.Meta["expires_at"]),
 		})
 	}
-	writeJSON(w, http.StatusOK, result)
+}
+
+// authPairedUser guards changes to a user's pairing: once the user has an
+// agent, only that agent's bearer key may revoke or rotate it. A user without
+// an agent has nothing to protect and passes.
+func (s *Server) authPairedUser(w http.ResponseWriter, r *http.Request, backend *MemoryBackend, userID string) bool {
+	agentID, ok := backend.AgentIDForUser(userID)
+	if !ok {
+		return true
+	}
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	if !strings.HasPrefix(header, "Bearer ") {
+		writeError(w, http.StatusUnauthorized, contracts.APIError{Code: contracts.ErrAuthUnauthorized, Message: "missing bearer token"})
+		return false
+	}
+	keyAgentID, ok := s.backend.AuthenticateAgentKey(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	if !ok {
+		writeError(w, http.StatusUnauthorized, contracts.APIError{Code: contracts.ErrAuthUnauthorized, Message: "invalid bearer token"})
+		return false
+	}
+	if keyAgentID != agentID {
+		writeError(w, http.StatusForbidden, contracts.APIError{Code: contracts.ErrAuthUnauthorized, Message: "agent not paired with user"})
+		return false
+	}
+	return true
 }
 
 func (s *Server) authAgent(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if err := contracts.CheckAPIVersion(r.Header.Get(contracts.APIVersionHeader)); err != nil {
+		writeError(w, http.StatusBadRequest, err.(contracts.APIError))
+		return "", false
+	}
 	header := strings.TrimSpace(r.Header.Get("Authorization"))
 	if strings.HasPrefix(header, "Bearer ") {
 		token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
@@ -354,7 +858,7 @@ func writeServerError(w http.ResponseWriter, err error) {
 	apiErr, ok := err.(contracts.APIError)
 	if ok {
 		status := http.StatusBadRequest
-		if apiErr.Code == contracts.ErrPairingExpired || apiErr.Code == contracts.ErrPairingInvalidCode {
+		if apiErr.Code == contracts.ErrPairingExpired || apiErr.Code == contracts.ErrPairingInvalidCode || apiErr.Code == contracts.ErrProjectNotFound {
 			status = http.StatusNotFound
 		}
 		writeError(w, status, apiErr)
@@ -369,6 +873,12 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+// writeError includes the request ID set by the logging middleware so a
+// client can quote it when reporting a failure.
 func writeError(w http.ResponseWriter, status int, apiErr contracts.APIError) {
-	writeJSON(w, status, map[string]any{"ok": false, "error": apiErr})
+	body := map[string]any{"ok": false, "error": apiErr}
+	if id := w.Header().Get(contracts.RequestIDHeader); id != "" {
+		body["request_id"] = id
+	}
+	writeJSON(w, status, body)
 }
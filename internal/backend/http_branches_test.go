@@ -1,10 +1,16 @@
 package backend
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -17,10 +23,11 @@ type captureNotifier struct {
 	result contracts.CommandResult
 }
 
-func (n *captureNotifier) NotifyResult(userID string, result contracts.CommandResult) {
+func (n *captureNotifier) NotifyResult(userID string, result contracts.CommandResult) error {
 	n.called = true
 	n.userID = userID
 	n.result = result
+	return nil
 }
 
 func TestHTTPHandlers_MethodNotAllowedCoverage(t *testing.T) {
@@ -28,7 +35,7 @@ func TestHTTPHandlers_MethodNotAllowedCoverage(t *testing.T) {
 	q := NewRedisQueue(NewInMemoryRedisClient())
 	srv := NewServer(b, q)
 
-	paths := []string{"/v1/pair/start", "/v1/pair/claim", "/v1/command", "/v1/poll", "/v1/result", "/v1/projects", "/v1/result/status"}
+	paths := []string{"/v1/pair/start", "/v1/pair/claim", "/v1/command", "/v1/poll", "/v1/result", "/v1/projects", "/v1/result/status", "/v1/result/stream"}
 	for _, p := range paths {
 		req := httptest.NewRequest(http.MethodPatch, p, nil)
 		rec := httptest.NewRecorder()
@@ -87,7 +94,7 @@ func TestHTTPResultNotifierAndProjectsBranches(t *testing.T) {
 	srv.SetNotifier(n)
 	agentKey := pairAgent(t, srv, "tg-notify")
 
-	cmd := contracts.Command{CommandID: "cmd-n", IdempotencyKey: "k-n", Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(`{}`)}
+	cmd := contracts.Command{CommandID: "cmd-n", IdempotencyKey: "k-n-0000", Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(`{}`)}
 	cmdReq := httptest.NewRequest(http.MethodPost, "/v1/command", mustJSON(t, cmd))
 	cmdReq.Header.Set("Authorization", "Bearer "+agentKey)
 	cmdReq.Header.Set("Content-Type", "application/json")
@@ -198,3 +205,192 @@ func TestHTTPAuthViaTelegramHeaderAndPolicyProjectionBranches(t *testing.T) {
 		t.Fatalf("expected 400 for malformed command body, got %d", badRec.Code)
 	}
 }
+
+func TestHTTPResultStreamDeliversPublishedResult(t *testing.T) {
+	b := NewMemoryBackend()
+	q := NewRedisQueue(NewInMemoryRedisClient())
+	srv := NewServer(b, q)
+	agentKey := pairAgent(t, srv, "tg-stream")
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/v1/result/stream?command_id=c-stream", nil)
+	req.Header.Set("X-Telegram-User-ID", "tg-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("stream request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected stream response status=%d type=%q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	resultReq := httptest.NewRequest(http.MethodPost, "/v1/result", mustJSON(t, contracts.CommandResult{CommandID: "c-stream", OK: true, Summary: "done"}))
+	resultReq.Header.Set("Authorization", "Bearer "+agentKey)
+	resultRec := httptest.NewRecorder()
+	srv.ServeHTTP(resultRec, resultReq)
+	if resultRec.Code != http.StatusOK {
+		t.Fatalf("post result status=%d body=%s", resultRec.Code, resultRec.Body.String())
+	}
+
+	var data string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+			data = strings.TrimPrefix(line, "data: ")
+			break
+		}
+	}
+	var got contracts.CommandResult
+	if err := json.Unmarshal([]byte(data), &got); err != nil {
+		t.Fatalf("decode event %q: %v", data, err)
+	}
+	if got.CommandID != "c-stream" || !got.OK || got.Summary != "done" {
+		t.Fatalf("unexpected streamed result: %+v", got)
+	}
+}
+
+func TestApplyPolicyResultWhileCommandsRegister(t *testing.T) {
+	b := NewMemoryBackend()
+	b.SetProject("u1", projectRecord{Alias: "demo", ProjectID: "p1"})
+	b.RegisterCommandMeta("cmd-policy", commandMeta{TelegramUserID: "u1", CommandType: contracts.CommandTypeApplyProjectPolicy, ProjectID: "p1"})
+	// result streams apply policies while /v1/command registers metadata;
+	// run with -race
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		<-start
+		for i := 0; i < 200; i++ {
+			b.RegisterCommandMeta(fmt.Sprintf("cmd-%d", i), commandMeta{TelegramUserID: "u2", CommandType: contracts.CommandTypeStatus})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		<-start
+		for i := 0; i < 200; i++ {
+			applyPolicyResult(b, "cmd-policy", contracts.CommandResult{CommandID: "cmd-policy", OK: true, Meta: map[string]any{"decision": contracts.DecisionAllow}})
+		}
+	}()
+	close(start)
+	wg.Wait()
+	if proj, ok := b.ResolveProject("u1", "demo"); !ok || proj.Policy.Decision != contracts.DecisionAllow {
+		t.Fatalf("expected policy applied, got %+v", proj)
+	}
+}
+
+func TestHTTPResultStreamValidationBranches(t *testing.T) {
+	b := NewMemoryBackend()
+	srv := NewServer(b, NewRedisQueue(NewInMemoryRedisClient()))
+
+	pairAgent(t, srv, "u1")
+
+	cases := []struct {
+		path string
+		user string
+		code int
+	}{
+		{"/v1/result/stream?command_id=c1", "", http.StatusUnauthorized},
+		{"/v1/result/stream?command_id=c1", "unpaired", http.StatusUnauthorized},
+		{"/v1/result/stream", "u1", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.user != "" {
+			req.Header.Set("X-Telegram-User-ID", tc.user)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Fatalf("%s as %q: expected %d, got %d", tc.path, tc.user, tc.code, rec.Code)
+		}
+	}
+
+	memSrv := NewServer(b, b)
+	rec := httptest.NewRecorder()
+	memSrv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/result/stream?command_id=c1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for queue without subscribe support, got %d", rec.Code)
+	}
+}
+
+func TestHTTPDrainEndsLongPoll(t *testing.T) {
+	b := NewMemoryBackend()
+	q := NewRedisQueue(NewInMemoryRedisClient())
+	srv := NewServer(b, q)
+	agentKey := pairAgent(t, srv, "tg-drain")
+
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "/v1/poll?timeout_seconds=30", nil)
+		req.Header.Set("Authorization", "Bearer "+agentKey)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		done <- rec
+	}()
+	time.Sleep(50 * time.Millisecond)
+	srv.Drain()
+	srv.Drain() // idempotent
+
+	select {
+	case rec := <-done:
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("expected drained poll to return 204, got %d body=%s", rec.Code, rec.Body.String())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("poll did not return after Drain")
+	}
+}
+
+func TestHTTPHealthzAndReadyz(t *testing.T) {
+	get := func(srv *Server, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	b := NewMemoryBackend()
+
+	down := NewServer(b, NewRedisQueue(&stubRedisClient{pingFn: func(context.Context) error { return errors.New("connection refused") }}))
+	if rec := get(down, "/healthz"); rec.Code != http.StatusOK || rec.Body.String() != "OK" {
+		t.Fatalf("expected healthz to stay live, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := get(down, "/readyz"); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "queue unreachable") {
+		t.Fatalf("expected readyz 503 with the queue down, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	for name, queue := range map[string]CommandQueue{"memory": b, "redis": NewRedisQueue(NewInMemoryRedisClient())} {
+		if rec := get(NewServer(b, queue), "/readyz"); rec.Code != http.StatusOK || rec.Body.String() != "Ready" {
+			t.Fatalf("%s: expected readyz 200, got %d %q", name, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestHTTPCommandBodyLimit(t *testing.T) {
+	b := NewMemoryBackend()
+	srv := NewServer(b, b)
+	srv.SetMaxCommandBytes(512)
+	agentKey := pairAgent(t, srv, "tg-limit")
+
+	post := func(prompt string) *httptest.ResponseRecorder {
+		cmd := contracts.Command{CommandID: "c-limit", IdempotencyKey: "key-limit-1", Type: contracts.CommandTypeRunTask, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(fmt.Sprintf(`{"project_id":"p1","prompt":%q}`, prompt))}
+		req := httptest.NewRequest(http.MethodPost, "/v1/command", mustJSON(t, cmd))
+		req.Header.Set("Authorization", "Bearer "+agentKey)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := post(strings.Repeat("x", 1024)); rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "exceeds 512 bytes") {
+		t.Fatalf("expected 413 for oversized body, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if rec := post("hello"); rec.Code != http.StatusAccepted {
+		t.Fatalf("expected small command accepted, got %d body=%s", rec.Code, rec.Body.String())
+	}
+
+	srv.SetMaxCommandBytes(0)
+	if srv.maxCommandBytes != DefaultMaxCommandBytes {
+		t.Fatalf("expected default limit restored, got %d", srv.maxCommandBytes)
+	}
+}
@@ -43,9 +43,15 @@ func (q stubQueue) StoreResult(ctx context.Context, agentID string, result contr
 func (q stubQueue) Enqueue(ctx context.Context, agentID string, cmd contracts.Command) error {
 	return q.enqueueErr
 }
+func (q stubQueue) EnqueueBatch(ctx context.Context, agentID string, cmds []contracts.Command) error {
+	return q.enqueueErr
+}
 func (q stubQueue) GetResult(ctx context.Context, agentID string, commandID string) (*contracts.CommandResult, error) {
 	return q.getRes, q.getErr
 }
+func (q stubQueue) Purge(ctx context.Context, agentID string) error {
+	return nil
+}
 
 func TestHTTPNonMemoryBackendBranches(t *testing.T) {
 	s := NewServer(stubPairingStore{}, stubQueue{})
@@ -72,7 +78,7 @@ func TestHTTPQueueErrorBranches(t *testing.T) {
 
 	cmd := contracts.Command{
 		CommandID:      "cmd-e",
-		IdempotencyKey: "id-e",
+		IdempotencyKey: "id-e-000",
 		Type:           contracts.CommandTypeStatus,
 		CreatedAt:      time.Now().UTC(),
 		Payload:        json.RawMessage(`{}`),
@@ -1,10 +1,12 @@
 package backend
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
@@ -73,10 +75,11 @@ func TestServer_SetNotifierAndResultNotification(t *testing.T) {
 	notified := 0
 	var gotUser string
 	var gotResult contracts.CommandResult
-	srv.SetNotifier(resultNotifierFunc(func(user string, result contracts.CommandResult) {
+	srv.SetNotifier(resultNotifierFunc(func(user string, result contracts.CommandResult) error {
 		notified++
 		gotUser = user
 		gotResult = result
+		return nil
 	}))
 
 	resultReq := httptest.NewRequest(http.MethodPost, "/v1/result", mustJSON(t, contracts.CommandResult{CommandID: "cmd-notify", OK: true, Summary: "ok"}))
@@ -92,10 +95,53 @@ func TestServer_SetNotifierAndResultNotification(t *testing.T) {
 	}
 }
 
-type resultNotifierFunc func(telegramUserID string, result contracts.CommandResult)
+func TestServer_RetriesFailedResultNotification(t *testing.T) {
+	b := NewMemoryBackend()
+	srv := NewServer(b, b)
+	srv.notifyRetryBase = time.Millisecond
+	agentKey := pairAgent(t, srv, "tg-retry")
+
+	var mu sync.Mutex
+	calls := 0
+	delivered := make(chan contracts.CommandResult, 1)
+	srv.SetNotifier(resultNotifierFunc(func(user string, result contracts.CommandResult) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			return errors.New("telegram unavailable")
+		}
+		delivered <- result
+		return nil
+	}))
 
-func (f resultNotifierFunc) NotifyResult(telegramUserID string, result contracts.CommandResult) {
-	f(telegramUserID, result)
+	req := httptest.NewRequest(http.MethodPost, "/v1/result", mustJSON(t, contracts.CommandResult{CommandID: "cmd-retry", OK: true, Summary: "ok"}))
+	req.Header.Set("Authorization", "Bearer "+agentKey)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("result status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	select {
+	case result := <-delivered:
+		if result.CommandID != "cmd-retry" {
+			t.Fatalf("unexpected result delivered: %+v", result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the notification to be retried after a failure")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 {
+		t.Fatalf("expected one failed and one successful attempt, got %d", calls)
+	}
+}
+
+type resultNotifierFunc func(telegramUserID string, result contracts.CommandResult) error
+
+func (f resultNotifierFunc) NotifyResult(telegramUserID string, result contracts.CommandResult) error {
+	return f(telegramUserID, result)
 }
 
 func TestMemoryBackend_UpdateProjectPolicyPublicMethod(t *testing.T) {
@@ -174,7 +220,7 @@ func TestHTTPHandlers_AdditionalErrorBranches(t *testing.T) {
 		t.Fatalf("expected result/status no pair 204, got %d", statusNoPairRec.Code)
 	}
 
-	xHeaderCmd := contracts.Command{CommandID: "cmd-x", IdempotencyKey: "k-x", Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(`{}`)}
+	xHeaderCmd := contracts.Command{CommandID: "cmd-x", IdempotencyKey: "k-x-0000", Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(`{}`)}
 	xReq := httptest.NewRequest(http.MethodPost, "/v1/command", mustJSON(t, xHeaderCmd))
 	xReq.Header.Set("X-Telegram-User-ID", "tg-http-more")
 	xReq.Header.Set("Content-Type", "application/json")
@@ -184,3 +230,89 @@ func TestHTTPHandlers_AdditionalErrorBranches(t *testing.T) {
 		t.Fatalf("expected x-telegram auth command accepted, got %d body=%s", xRec.Code, xRec.Body.String())
 	}
 }
+
+func TestServerRequestIDPropagation(t *testing.T) {
+	b := NewMemoryBackend()
+	srv := NewServer(b, b)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/pair/start", nil)
+	req.Header.Set(contracts.RequestIDHeader, "poll-abc123")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if got := rec.Header().Get(contracts.RequestIDHeader); got != "poll-abc123" {
+		t.Fatalf("expected incoming request id echoed, got %q", got)
+	}
+	var body map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode error body: %v", err)
+	}
+	if body["request_id"] != "poll-abc123" {
+		t.Fatalf("expected request_id in error payload, got %+v", body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/pair/start", nil)
+	req.Header.Set(contracts.RequestIDHeader, "bad id\nwith newline")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	generated := rec.Header().Get(contracts.RequestIDHeader)
+	if generated == "" || generated == "bad id\nwith newline" || !requestIDPattern.MatchString(generated) {
+		t.Fatalf("expected a generated request id for invalid input, got %q", generated)
+	}
+
+	var seen string
+	handler := withRequestLogging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+		w.(http.Flusher).Flush()
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if seen == "" || seen != rec.Header().Get(contracts.RequestIDHeader) {
+		t.Fatalf("expected context id %q to match response header %q", seen, rec.Header().Get(contracts.RequestIDHeader))
+	}
+	if RequestIDFromContext(context.Background()) != "" {
+		t.Fatal("expected no request id outside a request")
+	}
+}
+
+func TestServerAPIVersionHeader(t *testing.T) {
+	b := NewMemoryBackend()
+	q := NewRedisQueue(NewInMemoryRedisClient())
+	srv := NewServer(b, q)
+	agentKey := pairAgent(t, srv, "tg-version")
+
+	poll := func(version string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/poll?timeout_seconds=1", nil)
+		req.Header.Set("Authorization", "Bearer "+agentKey)
+		if version != "" {
+			req.Header.Set(contracts.APIVersionHeader, version)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, version := range []string{"", contracts.APIVersion, "1.9"} {
+		rec := poll(version)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("expected version %q accepted, got %d body=%s", version, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get(contracts.APIVersionHeader); got != contracts.APIVersion {
+			t.Fatalf("expected backend version echoed, got %q", got)
+		}
+	}
+
+	rec := poll("2.0")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected incompatible version rejected, got %d", rec.Code)
+	}
+	var body struct {
+		Error contracts.APIError `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode error body: %v", err)
+	}
+	if body.Error.Code != contracts.ErrValidationInvalidRequest || body.Error.Details["api_version"] != contracts.APIVersion {
+		t.Fatalf("expected invalid request naming the backend version, got %+v", body.Error)
+	}
+}
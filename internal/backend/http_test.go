@@ -2,9 +2,13 @@ package backend
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -81,7 +85,7 @@ func TestHTTPCommandPollResultFlow(t *testing.T) {
 
 	cmd := contracts.Command{
 		CommandID:      "cmd-1",
-		IdempotencyKey: "idem-1",
+		IdempotencyKey: "idem-1-0",
 		Type:           contracts.CommandTypeStatus,
 		CreatedAt:      time.Now().UTC(),
 		Payload:        json.RawMessage(`{}`),
@@ -229,7 +233,7 @@ func TestHTTPAuthAndValidationErrors(t *testing.T) {
 	q := NewRedisQueue(NewInMemoryRedisClient())
 	srv := NewServer(b, q)
 
-	cmd := contracts.Command{CommandID: "cmd", IdempotencyKey: "k", Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(`{}`)}
+	cmd := contracts.Command{CommandID: "cmd", IdempotencyKey: "k-000000", Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(`{}`)}
 	req := httptest.NewRequest(http.MethodPost, "/v1/command", mustJSON(t, cmd))
 	rec := httptest.NewRecorder()
 	srv.ServeHTTP(rec, req)
@@ -237,6 +241,26 @@ func TestHTTPAuthAndValidationErrors(t *testing.T) {
 		t.Fatalf("expected unauthorized without auth, got %d", rec.Code)
 	}
 
+	agentKey := pairAgent(t, srv, "tg-stale")
+	srv.SetFreshnessWindow(contracts.FreshnessWindow{MaxAge: 10 * time.Minute})
+	stale := cmd
+	stale.CreatedAt = time.Now().UTC().Add(-time.Hour)
+	staleReq := httptest.NewRequest(http.MethodPost, "/v1/command", mustJSON(t, stale))
+	staleReq.Header.Set("Authorization", "Bearer "+agentKey)
+	staleRec := httptest.NewRecorder()
+	srv.ServeHTTP(staleRec, staleReq)
+	if staleRec.Code != http.StatusBadRequest || !strings.Contains(staleRec.Body.String(), contracts.ErrValidationInvalidRequest) {
+		t.Fatalf("expected stale command rejected, got %d body=%s", staleRec.Code, staleRec.Body.String())
+	}
+	srv.SetFreshnessWindow(contracts.FreshnessWindow{})
+	staleReq = httptest.NewRequest(http.MethodPost, "/v1/command", mustJSON(t, stale))
+	staleReq.Header.Set("Authorization", "Bearer "+agentKey)
+	staleRec = httptest.NewRecorder()
+	srv.ServeHTTP(staleRec, staleReq)
+	if staleRec.Code != http.StatusAccepted {
+		t.Fatalf("expected stale command accepted with check disabled, got %d body=%s", staleRec.Code, staleRec.Body.String())
+	}
+
 	reqBadTimeout := httptest.NewRequest(http.MethodGet, "/v1/poll?timeout_seconds=99", nil)
 	reqBadTimeout.Header.Set("Authorization", "Bearer bad")
 	recBadTimeout := httptest.NewRecorder()
@@ -245,3 +269,484 @@ func TestHTTPAuthAndValidationErrors(t *testing.T) {
 		t.Fatalf("expected unauthorized for bad key, got %d", recBadTimeout.Code)
 	}
 }
+
+func TestHTTPProjectDelete(t *testing.T) {
+	b := NewMemoryBackend()
+	srv := NewServer(b, b)
+	agentKey := pairAgent(t, srv, "tg-del")
+	otherKey := pairAgent(t, srv, "tg-other")
+	b.SetProject("tg-del", projectRecord{Alias: "demo", ProjectID: "pid-del", ProjectPath: "/tmp/demo"})
+
+	doDelete := func(key, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/v1/projects?"+query, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := doDelete("", "telegram_user_id=tg-del&project_id=pid-del"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized without key, got %d", rec.Code)
+	}
+	if rec := doDelete(otherKey, "telegram_user_id=tg-del&project_id=pid-del"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected forbidden for another user's agent, got %d", rec.Code)
+	}
+	if rec := doDelete(agentKey, "telegram_user_id=tg-del"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected bad request without project_id, got %d", rec.Code)
+	}
+	if rec := doDelete(agentKey, "telegram_user_id=tg-del&project_id=pid-del"); rec.Code != http.StatusOK {
+		t.Fatalf("delete status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if _, ok := b.ResolveProject("tg-del", "demo"); ok {
+		t.Fatal("expected alias removed with project")
+	}
+	rec := doDelete(agentKey, "telegram_user_id=tg-del&project_id=pid-del")
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), contracts.ErrProjectNotFound) {
+		t.Fatalf("expected not found for deleted project, got %d body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHTTPAgentQueueStats(t *testing.T) {
+	b := NewMemoryBackend()
+	redisQueue := NewRedisQueue(NewInMemoryRedisClient())
+	for name, queue := range map[string]CommandQueue{"memory": b, "redis": redisQueue} {
+		t.Run(name, func(t *testing.T) {
+			srv := NewServer(b, queue)
+			userID := "tg-queue-" + name
+			pairAgent(t, srv, userID)
+			agentID, _ := b.AgentIDForUser(userID)
+			for i := 0; i < 3; i++ {
+				cmd := contracts.Command{CommandID: fmt.Sprintf("q-%d", i), IdempotencyKey: fmt.Sprintf("key-queue-%d", i), Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(`{}`)}
+				if err := queue.Enqueue(context.Background(), agentID, cmd); err != nil {
+					t.Fatalf("enqueue: %v", err)
+				}
+			}
+			if _, err := queue.Poll(context.Background(), agentID, 0); err != nil {
+				t.Fatalf("poll: %v", err)
+			}
+
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/agent/queue?telegram_user_id="+userID, nil))
+			var got contracts.QueueStatsResponse
+			_ = json.Unmarshal(rec.Body.Bytes(), &got)
+			if rec.Code != http.StatusOK || got.Queued != 2 || got.Inflight != 1 {
+				t.Fatalf("expected 2 queued and 1 inflight, got %d %+v", rec.Code, got)
+			}
+			var order []string
+			for _, c := range got.Commands {
+				order = append(order, fmt.Sprintf("%s:%v", c.CommandID, c.Inflight))
+			}
+			if strings.Join(order, ",") != "q-0:true,q-1:false,q-2:false" || got.Commands[0].Type != contracts.CommandTypeStatus {
+				t.Fatalf("expected inflight then queued commands in delivery order, got %+v", got.Commands)
+			}
+			rec = httptest.NewRecorder()
+			srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/agent/queue?telegram_user_id=tg-nobody", nil))
+			if rec.Code != http.StatusNotFound {
+				t.Fatalf("expected not found for unpaired user, got %d", rec.Code)
+			}
+		})
+	}
+	if queued, inflight := b.QueueStats("agent-unknown"); queued != 0 || inflight != 0 {
+		t.Fatalf("expected zeros for unknown agent, got %d/%d", queued, inflight)
+	}
+}
+
+func TestHTTPAgentStatusTracksPolls(t *testing.T) {
+	b := NewMemoryBackend()
+	clk := &fakeClock{now: time.Date(2026, 2, 11, 10, 0, 0, 0, time.UTC)}
+	b.SetClock(clk.Now)
+	srv := NewServer(b, b)
+	agentKey := pairAgent(t, srv, "tg-hb")
+
+	status := func(userID string) (*httptest.ResponseRecorder, contracts.AgentStatusResponse) {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/agent/status?telegram_user_id="+userID, nil))
+		var out contracts.AgentStatusResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec, out
+	}
+
+	if rec, _ := status("tg-unknown"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected not found for unpaired user, got %d", rec.Code)
+	}
+	if rec, got := status("tg-hb"); rec.Code != http.StatusOK || got.Online || got.LastSeen != nil {
+		t.Fatalf("expected never-seen agent offline, got %d %+v", rec.Code, got)
+	}
+
+	pollReq := httptest.NewRequest(http.MethodGet, "/v1/poll?timeout_seconds=5", nil)
+	pollReq.Header.Set("Authorization", "Bearer "+agentKey)
+	srv.ServeHTTP(httptest.NewRecorder(), pollReq)
+
+	_, got := status("tg-hb")
+	if !got.Online || got.LastSeen == nil || !got.LastSeen.Equal(clk.now) || got.PollTimeoutSeconds != 5 {
+		t.Fatalf("expected online after poll, got %+v", got)
+	}
+
+	clk.now = clk.now.Add(DefaultOnlineWindow + time.Second)
+	if _, got := status("tg-hb"); got.Online || got.LastSeen == nil {
+		t.Fatalf("expected offline after window, got %+v", got)
+	}
+	b.SetOnlineWindow(time.Hour)
+	if _, got := status("tg-hb"); !got.Online {
+		t.Fatalf("expected online with wider window, got %+v", got)
+	}
+}
+
+func TestHTTPCommandHistory(t *testing.T) {
+	b := NewMemoryBackend()
+	b.SetHistoryLimit(3)
+	srv := NewServer(b, b)
+	agentKey := pairAgent(t, srv, "tg-hist")
+	b.SetProject("tg-hist", projectRecord{Alias: "demo", ProjectID: "pid-1"})
+
+	post := func(path string, v any) int {
+		req := httptest.NewRequest(http.MethodPost, path, mustJSON(t, v))
+		req.Header.Set("Authorization", "Bearer "+agentKey)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for i, id := range []string{"c1", "c2", "c3", "c4"} {
+		cmd := contracts.Command{CommandID: id, IdempotencyKey: "key-hist-" + id, Type: contracts.CommandTypeStartServer, CreatedAt: time.Now().UTC().Add(time.Duration(i) * time.Second), Payload: json.RawMessage(`{"project_id":"pid-1"}`)}
+		if code := post("/v1/command", cmd); code != http.StatusAccepted {
+			t.Fatalf("enqueue %s status=%d", id, code)
+		}
+	}
+	post("/v1/result", contracts.CommandResult{CommandID: "c2", OK: false, ErrorCode: contracts.ErrStartTimeout})
+	post("/v1/result", contracts.CommandResult{CommandID: "c3", InProgress: true})
+	post("/v1/result", contracts.CommandResult{CommandID: "c4", OK: true})
+	post("/v1/result", contracts.CommandResult{CommandID: "c4", InProgress: true})
+
+	list := func(query string) (int, []commandRecord) {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/commands?"+query, nil))
+		var out struct {
+			Commands []commandRecord `json:"commands"`
+		}
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec.Code, out.Commands
+	}
+	code, cmds := list("telegram_user_id=tg-hist")
+	if code != http.StatusOK || len(cmds) != 3 {
+		t.Fatalf("expected 3 bounded entries, got %d %+v", code, cmds)
+	}
+	if cmds[0].CommandID != "c4" || cmds[0].Status != CommandStatusOK || cmds[0].Alias != "demo" {
+		t.Fatalf("unexpected newest entry: %+v", cmds[0])
+	}
+	if cmds[1].Status != CommandStatusRunning || cmds[2].Status != CommandStatusError || cmds[2].ErrorCode != contracts.ErrStartTimeout {
+		t.Fatalf("unexpected statuses: %+v", cmds)
+	}
+	// c1 is still queued, so its metadata outlives the history limit
+	if _, ok := b.commands["c1"]; !ok {
+		t.Fatal("expected queued command metadata kept past the history limit")
+	}
+
+	if code, cmds := list("telegram_user_id=tg-hist&limit=1"); code != http.StatusOK || len(cmds) != 1 || cmds[0].CommandID != "c4" {
+		t.Fatalf("expected limit to apply, got %d %+v", code, cmds)
+	}
+	if code, _ := list("telegram_user_id=tg-hist&limit=0"); code != http.StatusBadRequest {
+		t.Fatalf("expected bad request for invalid limit, got %d", code)
+	}
+	if code, _ := list(""); code != http.StatusBadRequest {
+		t.Fatalf("expected bad request without user, got %d", code)
+	}
+
+	post("/v1/result", contracts.CommandResult{CommandID: "c1", OK: true})
+	if b.commands["c1"].Status != CommandStatusOK {
+		t.Fatal("expected c1's result recorded against its metadata")
+	}
+	// once finished, the next command evicts it
+	cmd := contracts.Command{CommandID: "c5", IdempotencyKey: "key-hist-c5", Type: contracts.CommandTypeStartServer, CreatedAt: time.Now().UTC().Add(5 * time.Second), Payload: json.RawMessage(`{"project_id":"pid-1"}`)}
+	if code := post("/v1/command", cmd); code != http.StatusAccepted {
+		t.Fatalf("enqueue c5 status=%d", code)
+	}
+	if _, ok := b.commands["c1"]; ok {
+		t.Fatal("expected finished evicted command metadata dropped")
+	}
+}
+
+func TestHTTPCancelTaskOwnership(t *testing.T) {
+	b := NewMemoryBackend()
+	srv := NewServer(b, b)
+	ownerKey := pairAgent(t, srv, "tg-owner")
+	otherKey := pairAgent(t, srv, "tg-other")
+
+	post := func(agentKey string, cmd contracts.Command) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/command", mustJSON(t, cmd))
+		req.Header.Set("Authorization", "Bearer "+agentKey)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	run := contracts.Command{CommandID: "run-1", IdempotencyKey: "key-run-1", Type: contracts.CommandTypeRunTask, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(`{"project_id":"pid-1","prompt":"hi"}`)}
+	if code := post(ownerKey, run); code != http.StatusAccepted {
+		t.Fatalf("enqueue run_task status=%d", code)
+	}
+	cancelFor := func(id string) contracts.Command {
+		return contracts.Command{CommandID: id, IdempotencyKey: "key-" + id, Type: contracts.CommandTypeCancelTask, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(`{"command_id":"run-1"}`)}
+	}
+	if code := post(otherKey, cancelFor("cancel-other")); code != http.StatusForbidden {
+		t.Fatalf("expected 403 cancelling another user's command, got %d", code)
+	}
+	if code := post(ownerKey, cancelFor("cancel-own")); code != http.StatusAccepted {
+		t.Fatalf("expected owner cancel accepted, got %d", code)
+	}
+	if meta, ok := b.commandMetaFor("cancel-own"); !ok || meta.ProjectID != "pid-1" {
+		t.Fatalf("expected cancel to inherit the task's project, got %+v ok=%v", meta, ok)
+	}
+}
+
+func TestHTTPPollRateLimit(t *testing.T) {
+	b := NewMemoryBackend()
+	srv := NewServer(b, b)
+	now := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+	srv.SetPollRateLimit(0.5, 2)
+	agentKey := pairAgent(t, srv, "tg-rate")
+	otherKey := pairAgent(t, srv, "tg-rate-other")
+
+	poll := func(agentKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/poll?timeout_seconds=1", nil)
+		req.Header.Set("Authorization", "Bearer "+agentKey)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+	for i := 0; i < 2; i++ {
+		if rec := poll(agentKey); rec.Code != http.StatusNoContent {
+			t.Fatalf("poll %d within burst: status=%d", i, rec.Code)
+		}
+	}
+	rec := poll(agentKey)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Fatalf("expected 429 with Retry-After 2, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	var body struct {
+		Error contracts.APIError `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Code != contracts.ErrRateLimited {
+		t.Fatalf("expected %s, got %s", contracts.ErrRateLimited, rec.Body.String())
+	}
+	if rec := poll(otherKey); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected other agent unaffected, got %d", rec.Code)
+	}
+
+	now = now.Add(2 * time.Second)
+	if rec := poll(agentKey); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected poll allowed after refill, got %d", rec.Code)
+	}
+
+	srv.SetPollRateLimit(0, 0)
+	for i := 0; i < 20; i++ {
+		if rec := poll(agentKey); rec.Code != http.StatusNoContent {
+			t.Fatalf("expected unlimited polls when disabled, got %d", rec.Code)
+		}
+	}
+}
+
+func TestHTTPProjectsPagination(t *testing.T) {
+	b := NewMemoryBackend()
+	srv := NewServer(b, b)
+	for _, alias := range []string{"delta", "alpha", "charlie", "bravo", "echo"} {
+		b.SetProject("tg-page", projectRecord{Alias: alias, ProjectID: "pid-" + alias})
+	}
+
+	list := func(query string) (int, []string, int) {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/projects?telegram_user_id=tg-page"+query, nil))
+		var out struct {
+			Projects []projectRecord `json:"projects"`
+			Total    int             `json:"total"`
+		}
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		aliases := []string{}
+		for _, p := range out.Projects {
+			aliases = append(aliases, p.Alias)
+		}
+		return rec.Code, aliases, out.Total
+	}
+
+	if code, aliases, total := list(""); code != http.StatusOK || total != 0 || strings.Join(aliases, ",") != "alpha,bravo,charlie,delta,echo" {
+		t.Fatalf("expected all projects sorted by alias in the unpaged shape, got %d %v total=%d", code, aliases, total)
+	}
+	if _, aliases, total := list("&offset=0"); total != 5 || len(aliases) != 5 {
+		t.Fatalf("expected offset alone to page without a limit, got %v total=%d", aliases, total)
+	}
+	if _, aliases, total := list("&limit=2&offset=2"); total != 5 || strings.Join(aliases, ",") != "charlie,delta" {
+		t.Fatalf("unexpected page: %v total=%d", aliases, total)
+	}
+	if _, aliases, _ := list("&limit=2&offset=4"); strings.Join(aliases, ",") != "echo" {
+		t.Fatalf("expected short last page, got %v", aliases)
+	}
+	if code, aliases, total := list("&offset=9"); code != http.StatusOK || len(aliases) != 0 || total != 5 {
+		t.Fatalf("expected empty page past the end, got %d %v total=%d", code, aliases, total)
+	}
+	for _, query := range []string{"&limit=0", "&limit=x", "&offset=-1"} {
+		if code, _, _ := list(query); code != http.StatusBadRequest {
+			t.Fatalf("expected bad request for %q, got %d", query, code)
+		}
+	}
+}
+
+func TestHTTPPairRevoke(t *testing.T) {
+	b := NewMemoryBackend()
+	srv := NewServer(b, b)
+	agentKey := pairAgent(t, srv, "tg-revoke")
+	agentID, _ := b.AgentIDForUser("tg-revoke")
+	cmd := contracts.Command{CommandID: "c-revoke", IdempotencyKey: "key-revoke", Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(`{}`)}
+	if err := b.Enqueue(context.Background(), agentID, cmd); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	poll := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/poll?timeout_seconds=1", nil)
+		req.Header.Set("Authorization", "Bearer "+agentKey)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	otherKey := pairAgent(t, srv, "tg-other")
+	revoke := func(method, userID, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v1/pair/revoke", mustJSON(t, contracts.PairRevokeRequest{TelegramUserID: userID}))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+	if code := poll(); code != http.StatusOK {
+		t.Fatalf("expected poll to succeed before revoke, got %d", code)
+	}
+
+	if rec := revoke(http.MethodGet, "tg-revoke", agentKey); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+	if rec := revoke(http.MethodPost, "", agentKey); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without telegram_user_id, got %d", rec.Code)
+	}
+	if rec := revoke(http.MethodPost, "tg-revoke", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without the agent key, got %d", rec.Code)
+	}
+	if rec := revoke(http.MethodPost, "tg-revoke", "bogus"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an unknown key, got %d", rec.Code)
+	}
+	if rec := revoke(http.MethodPost, "tg-revoke", otherKey); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another agent's key, got %d", rec.Code)
+	}
+	if queued, inflight := b.QueueStats(agentID); queued+inflight != 1 {
+		t.Fatalf("expected refused revokes to keep the queue, got %d/%d", queued, inflight)
+	}
+	if rec := revoke(http.MethodPost, "tg-revoke", agentKey); rec.Code != http.StatusOK {
+		t.Fatalf("expected revoke ok, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if code := poll(); code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked key to be rejected, got %d", code)
+	}
+	if _, ok := b.AgentIDForUser("tg-revoke"); ok {
+		t.Fatal("expected user binding removed")
+	}
+	if queued, inflight := b.QueueStats(agentID); queued != 0 || inflight != 0 {
+		t.Fatalf("expected agent queues dropped, got %d/%d", queued, inflight)
+	}
+	if rec := revoke(http.MethodPost, "tg-revoke", agentKey); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 once unpaired, got %d", rec.Code)
+	}
+
+	// re-pairing after a revoke issues a working key
+	agentKey = pairAgent(t, srv, "tg-revoke")
+	if code := poll(); code != http.StatusNoContent {
+		t.Fatalf("expected new key to poll, got %d", code)
+	}
+}
+
+func TestHTTPPairRevokePurgesRedisQueue(t *testing.T) {
+	b := NewMemoryBackend()
+	q := NewRedisQueue(NewInMemoryRedisClient())
+	srv := NewServer(b, q)
+	agentKey := pairAgent(t, srv, "tg-revoke")
+	agentID, _ := b.AgentIDForUser("tg-revoke")
+	ctx := context.Background()
+	for _, id := range []string{"c-1", "c-2"} {
+		cmd := contracts.Command{CommandID: id, IdempotencyKey: "key-" + id, Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: json.RawMessage(`{}`)}
+		if err := q.Enqueue(ctx, agentID, cmd); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	if cmd, err := q.Poll(ctx, agentID, 0); err != nil || cmd == nil {
+		t.Fatalf("expected a command inflight, got %+v err=%v", cmd, err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/pair/revoke", mustJSON(t, contracts.PairRevokeRequest{TelegramUserID: "tg-revoke"}))
+	req.Header.Set("Authorization", "Bearer "+agentKey)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected revoke ok, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if queued, inflight, err := q.QueueStats(ctx, agentID); err != nil || queued != 0 || inflight != 0 {
+		t.Fatalf("expected redis queues purged, got %d/%d err=%v", queued, inflight, err)
+	}
+}
+
+func TestHTTPPairRotate(t *testing.T) {
+	b := NewMemoryBackend()
+	srv := NewServer(b, b)
+	start, err := b.StartPairing("tg-rotate")
+	if err != nil {
+		t.Fatalf("start pairing: %v", err)
+	}
+	other, err := b.StartPairing("tg-other")
+	if err != nil {
+		t.Fatalf("start pairing: %v", err)
+	}
+	rotate := func(method, userID string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(method, "/v1/pair/rotate", mustJSON(t, contracts.PairRotateRequest{TelegramUserID: userID})))
+		return rec
+	}
+
+	if rec := rotate(http.MethodGet, "tg-rotate"); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+	if rec := rotate(http.MethodPost, ""); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"field":"telegram_user_id"`) {
+		t.Fatalf("expected 400 naming telegram_user_id, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	rec := rotate(http.MethodPost, "tg-rotate")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected rotate ok, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	var rotated contracts.PairStartResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &rotated); err != nil {
+		t.Fatalf("unmarshal rotate: %v", err)
+	}
+	if rotated.PairingCode == "" || rotated.PairingCode == start.PairingCode {
+		t.Fatalf("expected a fresh code, got %q (old %q)", rotated.PairingCode, start.PairingCode)
+	}
+
+	_, err = b.ClaimPairing(contracts.PairClaimRequest{PairingCode: start.PairingCode})
+	var apiErr contracts.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != contracts.ErrPairingInvalidCode {
+		t.Fatalf("expected old code rejected as invalid, got %v", err)
+	}
+	if _, err := b.ClaimPairing(contracts.PairClaimRequest{PairingCode: rotated.PairingCode}); err != nil {
+		t.Fatalf("expected rotated code to claim, got %v", err)
+	}
+	if _, err := b.ClaimPairing(contracts.PairClaimRequest{PairingCode: other.PairingCode}); err != nil {
+		t.Fatalf("expected other users' codes untouched, got %v", err)
+	}
+
+	// once paired, only the user's agent key may rotate their codes
+	pairedKey := pairAgent(t, srv, "tg-paired")
+	if rec := rotate(http.MethodPost, "tg-paired"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without the agent key, got %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/pair/rotate", mustJSON(t, contracts.PairRotateRequest{TelegramUserID: "tg-paired"}))
+	req.Header.Set("Authorization", "Bearer "+pairedKey)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected rotate with the agent key ok, got %d body=%s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,466 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"opencode-telegram/internal/proxy/contracts"
+)
+
+// defaultPostgresPollInterval is how often a long poll re-checks the queue
+// table while it waits for a command.
+const defaultPostgresPollInterval = time.Second
+
+// PostgresQueue implements CommandQueue on Postgres for at-least-once
+// delivery. Poll claims rows with SELECT ... FOR UPDATE SKIP LOCKED, so
+// several backend replicas can share the tables.
+type PostgresQueue struct {
+	db            *sql.DB
+	redeliveryTTL time.Duration
+	maxAttempts   int
+	resultTTL     time.Duration
+	pollInterval  time.Duration
+	now           func() time.Time
+}
+
+// NewPostgresQueue connects to dsn and creates the queue tables if needed.
+func NewPostgresQueue(dsn string) (*PostgresQueue, error) {
+	db, err := sqlOpen("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	q := newPostgresQueue(db)
+	if err := q.ensureSchema(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func newPostgresQueue(db *sql.DB) *PostgresQueue {
+	return &PostgresQueue{
+		db:            db,
+		redeliveryTTL: DefaultRedeliveryTTL,
+		maxAttempts:   DefaultMaxDeliveryAttempts,
+		resultTTL:     DefaultResultTTL,
+		pollInterval:  defaultPostgresPollInterval,
+		now:           time.Now,
+	}
+}
+
+// SetClock sets the clock function (for testing)
+func (q *PostgresQueue) SetClock(nowFn func() time.Time) {
+	q.now = nowFn
+}
+
+// SetMaxAttempts sets how many deliveries a command gets before it is
+// dead-lettered. Values below 1 are treated as 1.
+func (q *PostgresQueue) SetMaxAttempts(maxAttempts int) {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	q.maxAttempts = maxAttempts
+}
+
+// SetResultTTL sets how long stored results are kept. Values below 1
+// restore DefaultResultTTL.
+func (q *PostgresQueue) SetResultTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultResultTTL
+	}
+	q.resultTTL = ttl
+}
+
+// SetRedeliveryTTL sets how long an inflight command may go without a
+// result before it is redelivered; see MemoryBackend.SetRedeliveryTTL.
+func (q *PostgresQueue) SetRedeliveryTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("redelivery TTL must be positive, got %s", ttl)
+	}
+	q.redeliveryTTL = ttl
+	return nil
+}
+
+func (q *PostgresQueue) ensureSchema() error {
+	const schema = `
+CREATE TABLE IF NOT EXISTS oct_command_queue (
+  id BIGSERIAL PRIMARY KEY,
+  agent_id TEXT NOT NULL,
+  command_id TEXT NOT NULL,
+  priority INTEGER NOT NULL DEFAULT 0,
+  command JSONB NOT NULL,
+  enqueued_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS oct_command_queue_agent_idx ON oct_command_queue (agent_id, priority DESC, id);
+CREATE TABLE IF NOT EXISTS oct_command_inflight (
+  agent_id TEXT NOT NULL,
+  command_id TEXT NOT NULL,
+  command JSONB NOT NULL,
+  delivered_at TIMESTAMPTZ NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 1,
+  PRIMARY KEY (agent_id, command_id)
+);
+CREATE TABLE IF NOT EXISTS oct_command_dead_letters (
+  id BIGSERIAL PRIMARY KEY,
+  agent_id TEXT NOT NULL,
+  command_id TEXT NOT NULL,
+  command JSONB NOT NULL,
+  dead_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS oct_command_dead_letters_agent_idx ON oct_command_dead_letters (agent_id, id);
+CREATE TABLE IF NOT EXISTS oct_command_queue_results (
+  agent_id TEXT NOT NULL,
+  command_id TEXT NOT NULL,
+  result JSONB NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (agent_id, command_id)
+);
+CREATE INDEX IF NOT EXISTS oct_command_queue_results_expiry_idx ON oct_command_queue_results (expires_at);
+`
+	_, err := q.db.Exec(schema)
+	return err
+}
+
+// Enqueue appends a command; higher priorities are delivered first.
+func (q *PostgresQueue) Enqueue(ctx context.Context, agentID string, cmd contracts.Command) error {
+	if agentID == "" {
+		return errors.New("agentID is required")
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return fmt.Errorf("marshal command: %w", err)
+	}
+	_, err = q.db.ExecContext(ctx, `
+INSERT INTO oct_command_queue(agent_id, command_id, priority, command, enqueued_at)
+VALUES($1,$2,$3,$4,$5)
+`, agentID, cmd.CommandID, cmd.Priority, data, q.now().UTC())
+	return err
+}
+
+// EnqueueBatch validates and encodes every command, then inserts them in
+// order in one transaction.
+func (q *PostgresQueue) EnqueueBatch(ctx context.Context, agentID string, cmds []contracts.Command) error {
+	if agentID == "" {
+		return errors.New("agentID is required")
+	}
+	if err := validateBatch(cmds); err != nil {
+		return err
+	}
+	rows := make([][]byte, len(cmds))
+	for i, cmd := range cmds {
+		data, err := json.Marshal(cmd)
+		if err != nil {
+			return fmt.Errorf("marshal command: %w", err)
+		}
+		rows[i] = data
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	now := q.now().UTC()
+	for i, cmd := range cmds {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO oct_command_queue(agent_id, command_id, priority, command, enqueued_at)
+VALUES($1,$2,$3,$4,$5)
+`, agentID, cmd.CommandID, cmd.Priority, rows[i], now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Poll returns a stale inflight command for redelivery or claims the next
+// queued one. Stale commands that already used up their delivery attempts
+// are moved to the dead-letter table instead. With nothing to deliver it
+// re-checks every poll interval until timeoutSeconds elapse.
+func (q *PostgresQueue) Poll(ctx context.Context, agentID string, timeoutSeconds int) (*contracts.Command, error) {
+	if agentID == "" {
+		return nil, errors.New("agentID is required")
+	}
+	deadline := time.Now().Add(time.Duration(timeoutSeconds) * time.Second)
+	for {
+		cmd, err := q.claim(ctx, agentID)
+		if err != nil || cmd != nil {
+			return cmd, err
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, nil
+		}
+		if wait > q.pollInterval {
+			wait = q.pollInterval
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (q *PostgresQueue) claim(ctx context.Context, agentID string) (*contracts.Command, error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	now := q.now().UTC()
+
+	var commandID string
+	var data []byte
+	for {
+		var attempts int
+		err = tx.QueryRowContext(ctx, `
+SELECT command_id, command, attempts FROM oct_command_inflight
+WHERE agent_id=$1 AND delivered_at <= $2
+ORDER BY delivered_at LIMIT 1
+FOR UPDATE SKIP LOCKED
+`, agentID, now.Add(-q.redeliveryTTL)).Scan(&commandID, &data, &attempts)
+		if err != nil || attempts < q.maxAttempts {
+			break
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM oct_command_inflight WHERE agent_id=$1 AND command_id=$2`, agentID, commandID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO oct_command_dead_letters(agent_id, command_id, command, dead_at)
+VALUES($1,$2,$3,$4)
+`, agentID, commandID, data, now); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case err == nil:
+		if _, err := tx.ExecContext(ctx, `UPDATE oct_command_inflight SET delivered_at=$3, attempts=attempts+1 WHERE agent_id=$1 AND command_id=$2`, agentID, commandID, now); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		var id int64
+		err = tx.QueryRowContext(ctx, `
+SELECT id, command_id, command FROM oct_command_queue
+WHERE agent_id=$1
+ORDER BY priority DESC, id LIMIT 1
+FOR UPDATE SKIP LOCKED
+`, agentID).Scan(&id, &commandID, &data)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM oct_command_queue WHERE id=$1`, id); err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO oct_command_inflight(agent_id, command_id, command, delivered_at, attempts)
+VALUES($1,$2,$3,$4,1)
+ON CONFLICT (agent_id, command_id) DO UPDATE SET command=EXCLUDED.command, delivered_at=EXCLUDED.delivered_at, attempts=1
+`, agentID, commandID, data, now); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	var cmd contracts.Command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return nil, fmt.Errorf("unmarshal command: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &cmd, nil
+}
+
+// StoreResult saves result for resultTTL. A final result also acknowledges
+// the inflight command and prunes expired results.
+func (q *PostgresQueue) StoreResult(ctx context.Context, agentID string, result contracts.CommandResult) error {
+	if agentID == "" {
+		return errors.New("agentID is required")
+	}
+	if result.CommandID == "" {
+		return contracts.RequiredFieldError("command_id")
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("marshal result: %w", err)
+	}
+	now := q.now().UTC()
+
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if result.InProgress {
+		// Progress leaves the command inflight and must not clobber a final result.
+		existing, err := q.getResult(ctx, tx, agentID, result.CommandID, now)
+		if err != nil {
+			return err
+		}
+		if existing != nil && existing.IsTerminal() {
+			return nil
+		}
+		// Restamp delivery so a run still reporting progress is not redelivered.
+		if _, err := tx.ExecContext(ctx, `UPDATE oct_command_inflight SET delivered_at=$3 WHERE agent_id=$1 AND command_id=$2`, agentID, result.CommandID, now); err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM oct_command_inflight WHERE agent_id=$1 AND command_id=$2`, agentID, result.CommandID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM oct_command_queue_results WHERE expires_at <= $1`, now); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO oct_command_queue_results(agent_id, command_id, result, expires_at)
+VALUES($1,$2,$3,$4)
+ON CONFLICT (agent_id, command_id) DO UPDATE SET result=EXCLUDED.result, expires_at=EXCLUDED.expires_at
+`, agentID, result.CommandID, data, now.Add(q.resultTTL)); err != nil {
+		return fmt.Errorf("store result: %w", err)
+	}
+	return tx.Commit()
+}
+
+// Purge deletes agentID's queued and inflight commands in one
+// transaction. Results and dead letters are kept.
+func (q *PostgresQueue) Purge(ctx context.Context, agentID string) error {
+	if agentID == "" {
+		return errors.New("agentID is required")
+	}
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM oct_command_queue WHERE agent_id=$1`, agentID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM oct_command_inflight WHERE agent_id=$1`, agentID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (q *PostgresQueue) GetResult(ctx context.Context, agentID string, commandID string) (*contracts.CommandResult, error) {
+	if agentID == "" || commandID == "" {
+		return nil, nil
+	}
+	return q.getResult(ctx, q.db, agentID, commandID, q.now().UTC())
+}
+
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (q *PostgresQueue) getResult(ctx context.Context, db rowQuerier, agentID, commandID string, now time.Time) (*contracts.CommandResult, error) {
+	var data []byte
+	err := db.QueryRowContext(ctx, `
+SELECT result FROM oct_command_queue_results
+WHERE agent_id=$1 AND command_id=$2 AND expires_at > $3
+`, agentID, commandID, now).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out contracts.CommandResult
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeadLetters returns commands that exceeded the delivery attempt limit, oldest first.
+func (q *PostgresQueue) DeadLetters(ctx context.Context, agentID string) ([]contracts.Command, error) {
+	if agentID == "" {
+		return nil, errors.New("agentID is required")
+	}
+	rows, err := q.db.QueryContext(ctx, `SELECT command FROM oct_command_dead_letters WHERE agent_id=$1 ORDER BY id`, agentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []contracts.Command{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var cmd contracts.Command
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			continue // Skip malformed entries
+		}
+		out = append(out, cmd)
+	}
+	return out, rows.Err()
+}
+
+// Ping reports whether the database is reachable.
+func (q *PostgresQueue) Ping(ctx context.Context) error {
+	return q.db.PingContext(ctx)
+}
+
+// PendingCommands lists up to limit of agentID's inflight commands, oldest
+// delivery first, then its queued ones in delivery order.
+func (q *PostgresQueue) PendingCommands(ctx context.Context, agentID string, limit int) ([]contracts.PendingCommand, error) {
+	var out []contracts.PendingCommand
+	for _, list := range []struct {
+		query    string
+		inflight bool
+	}{
+		{`SELECT command FROM oct_command_inflight WHERE agent_id=$1 ORDER BY delivered_at LIMIT $2`, true},
+		{`SELECT command FROM oct_command_queue WHERE agent_id=$1 ORDER BY priority DESC, id LIMIT $2`, false},
+	} {
+		if len(out) >= limit {
+			break
+		}
+		rows, err := q.db.QueryContext(ctx, list.query, agentID, limit-len(out))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var data []byte
+			if err := rows.Scan(&data); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			var cmd contracts.Command
+			if err := json.Unmarshal(data, &cmd); err != nil {
+				continue
+			}
+			out = append(out, pendingCommand(cmd, list.inflight))
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// QueueStats counts agentID's queued and inflight commands.
+func (q *PostgresQueue) QueueStats(ctx context.Context, agentID string) (queued int, inflight int, err error) {
+	err = q.db.QueryRowContext(ctx, `
+SELECT
+  (SELECT COUNT(*) FROM oct_command_queue WHERE agent_id=$1),
+  (SELECT COUNT(*) FROM oct_command_inflight WHERE agent_id=$1)
+`, agentID).Scan(&queued, &inflight)
+	return queued, inflight, err
+}
@@ -0,0 +1,380 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+
+	"opencode-telegram/internal/proxy/contracts"
+)
+
+func TestNewPostgresQueue(t *testing.T) {
+	t.Run("fails when sql open fails", func(t *testing.T) {
+		oldOpen := sqlOpen
+		sqlOpen = func(driverName, dataSourceName string) (*sql.DB, error) { return nil, sql.ErrConnDone }
+		t.Cleanup(func() { sqlOpen = oldOpen })
+
+		if _, err := NewPostgresQueue("postgres://x"); err == nil {
+			t.Fatal("expected sql open error")
+		}
+	})
+
+	t.Run("initializes schema", func(t *testing.T) {
+		db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		if err != nil {
+			t.Fatalf("sqlmock new: %v", err)
+		}
+		defer db.Close()
+
+		oldOpen := sqlOpen
+		sqlOpen = func(driverName, dataSourceName string) (*sql.DB, error) { return db, nil }
+		t.Cleanup(func() { sqlOpen = oldOpen })
+
+		mock.ExpectPing()
+		mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS oct_command_queue (")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		q, err := NewPostgresQueue("postgres://x")
+		if err != nil {
+			t.Fatalf("new queue: %v", err)
+		}
+		if q.redeliveryTTL != DefaultRedeliveryTTL {
+			t.Fatalf("expected default redelivery TTL, got %v", q.redeliveryTTL)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("expectations: %v", err)
+		}
+	})
+}
+
+func TestPostgresQueueDeliveryLifecycle(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock new: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	now := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
+	q := newPostgresQueue(db)
+	q.SetClock(func() time.Time { return now })
+	cmd := contracts.Command{CommandID: "c1", IdempotencyKey: "k1-00000", Type: contracts.CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{}`), Priority: contracts.PriorityHigh}
+	cmdJSON, _ := json.Marshal(cmd)
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO oct_command_queue(")).WithArgs("a1", "c1", contracts.PriorityHigh, cmdJSON, now).WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := q.Enqueue(ctx, "a1", cmd); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	// first poll claims the queued row
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT command_id, command, attempts FROM oct_command_inflight")).WithArgs("a1", now.Add(-DefaultRedeliveryTTL)).WillReturnRows(sqlmock.NewRows([]string{"command_id", "command", "attempts"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, command_id, command FROM oct_command_queue")).WithArgs("a1").WillReturnRows(sqlmock.NewRows([]string{"id", "command_id", "command"}).AddRow(int64(7), "c1", cmdJSON))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM oct_command_queue WHERE id=$1")).WithArgs(int64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO oct_command_inflight(")).WithArgs("a1", "c1", cmdJSON, now).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	got, err := q.Poll(ctx, "a1", 0)
+	if err != nil || got == nil || got.CommandID != "c1" || got.Priority != contracts.PriorityHigh {
+		t.Fatalf("expected c1 delivered, got %+v err=%v", got, err)
+	}
+
+	// after the redelivery TTL the unacknowledged command is delivered again
+	now = now.Add(DefaultRedeliveryTTL)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT command_id, command, attempts FROM oct_command_inflight")).WithArgs("a1", now.Add(-DefaultRedeliveryTTL)).WillReturnRows(sqlmock.NewRows([]string{"command_id", "command", "attempts"}).AddRow("c1", cmdJSON, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE oct_command_inflight SET delivered_at=$3, attempts=attempts+1")).WithArgs("a1", "c1", now).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	got, err = q.Poll(ctx, "a1", 0)
+	if err != nil || got == nil || got.CommandID != "c1" {
+		t.Fatalf("expected c1 redelivered, got %+v err=%v", got, err)
+	}
+
+	// an empty queue returns nothing once the timeout is spent
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT command_id, command, attempts FROM oct_command_inflight")).WillReturnRows(sqlmock.NewRows([]string{"command_id", "command", "attempts"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, command_id, command FROM oct_command_queue")).WillReturnRows(sqlmock.NewRows([]string{"id", "command_id", "command"}))
+	mock.ExpectRollback()
+	if got, err := q.Poll(ctx, "a1", 0); err != nil || got != nil {
+		t.Fatalf("expected empty poll, got %+v err=%v", got, err)
+	}
+
+	// progress restamps the delivery so a long run is not redelivered
+	progress := contracts.CommandResult{CommandID: "c1", InProgress: true, Stdout: "step"}
+	progressJSON, _ := json.Marshal(progress)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT result FROM oct_command_queue_results")).WithArgs("a1", "c1", now).WillReturnRows(sqlmock.NewRows([]string{"result"}))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE oct_command_inflight SET delivered_at=$3 WHERE agent_id=$1 AND command_id=$2")).WithArgs("a1", "c1", now).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO oct_command_queue_results(")).WithArgs("a1", "c1", progressJSON, now.Add(14*24*time.Hour)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := q.StoreResult(ctx, "a1", progress); err != nil {
+		t.Fatalf("store progress: %v", err)
+	}
+
+	final := contracts.CommandResult{CommandID: "c1", OK: true, Summary: "done"}
+	finalJSON, _ := json.Marshal(final)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM oct_command_inflight WHERE agent_id=$1 AND command_id=$2")).WithArgs("a1", "c1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM oct_command_queue_results WHERE expires_at <= $1")).WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO oct_command_queue_results(")).WithArgs("a1", "c1", finalJSON, now.Add(14*24*time.Hour)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := q.StoreResult(ctx, "a1", final); err != nil {
+		t.Fatalf("store final result: %v", err)
+	}
+
+	// late progress must not replace the final result
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT result FROM oct_command_queue_results")).WithArgs("a1", "c1", now).WillReturnRows(sqlmock.NewRows([]string{"result"}).AddRow(finalJSON))
+	mock.ExpectRollback()
+	if err := q.StoreResult(ctx, "a1", contracts.CommandResult{CommandID: "c1", InProgress: true, Stdout: "late"}); err != nil {
+		t.Fatalf("store progress: %v", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT result FROM oct_command_queue_results")).WithArgs("a1", "c1", now).WillReturnRows(sqlmock.NewRows([]string{"result"}).AddRow(finalJSON))
+	res, err := q.GetResult(ctx, "a1", "c1")
+	if err != nil || res == nil || !res.OK || res.Summary != "done" {
+		t.Fatalf("expected final result, got %+v err=%v", res, err)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT result FROM oct_command_queue_results")).WithArgs("a1", "missing", now).WillReturnRows(sqlmock.NewRows([]string{"result"}))
+	if res, err := q.GetResult(ctx, "a1", "missing"); err != nil || res != nil {
+		t.Fatalf("expected no result, got %+v err=%v", res, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT")).WithArgs("a1").WillReturnRows(sqlmock.NewRows([]string{"queued", "inflight"}).AddRow(2, 1))
+	queued, inflight, err := q.QueueStats(ctx, "a1")
+	if err != nil || queued != 2 || inflight != 1 {
+		t.Fatalf("expected 2 queued and 1 inflight, got %d/%d err=%v", queued, inflight, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
+
+func TestPostgresQueueErrorsAndCancellation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock new: %v", err)
+	}
+	defer db.Close()
+
+	q := newPostgresQueue(db)
+	q.pollInterval = time.Hour
+	if err := q.Enqueue(context.Background(), "", contracts.Command{}); err == nil {
+		t.Fatal("expected agentID error on enqueue")
+	}
+	if _, err := q.Poll(context.Background(), "", 1); err == nil {
+		t.Fatal("expected agentID error on poll")
+	}
+	var apiErr contracts.APIError
+	if err := q.StoreResult(context.Background(), "a1", contracts.CommandResult{}); !errors.As(err, &apiErr) || apiErr.Code != contracts.ErrValidationRequiredField || apiErr.Field != "command_id" {
+		t.Fatalf("expected command_id required, got %v", err)
+	}
+	if res, err := q.GetResult(context.Background(), "a1", ""); err != nil || res != nil {
+		t.Fatalf("expected empty lookup to be a no-op, got %+v err=%v", res, err)
+	}
+
+	mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
+	if _, err := q.Poll(context.Background(), "a1", 1); !errors.Is(err, sql.ErrConnDone) {
+		t.Fatalf("expected begin error, got %v", err)
+	}
+
+	// a long poll waiting for the next check stops when its context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT command_id, command, attempts FROM oct_command_inflight")).WillReturnRows(sqlmock.NewRows([]string{"command_id", "command", "attempts"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, command_id, command FROM oct_command_queue")).WillReturnRows(sqlmock.NewRows([]string{"id", "command_id", "command"}))
+	mock.ExpectRollback()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := q.Poll(ctx, "a1", 30); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
+
+func TestPostgresQueueDeadLettersExhaustedCommand(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock new: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	now := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
+	q := newPostgresQueue(db)
+	q.SetClock(func() time.Time { return now })
+	q.SetMaxAttempts(0)
+	if q.maxAttempts != 1 {
+		t.Fatalf("expected max attempts floored to 1, got %d", q.maxAttempts)
+	}
+	q.SetMaxAttempts(2)
+	dead, _ := json.Marshal(contracts.Command{CommandID: "c1", IdempotencyKey: "k1-00000", Type: contracts.CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{}`)})
+	retry, _ := json.Marshal(contracts.Command{CommandID: "c2", IdempotencyKey: "k2-00000", Type: contracts.CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{}`)})
+
+	// c1 used both deliveries and is dead-lettered; c2 gets its second one
+	cutoff := now.Add(-DefaultRedeliveryTTL)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT command_id, command, attempts FROM oct_command_inflight")).WithArgs("a1", cutoff).WillReturnRows(sqlmock.NewRows([]string{"command_id", "command", "attempts"}).AddRow("c1", dead, 2))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM oct_command_inflight WHERE agent_id=$1 AND command_id=$2")).WithArgs("a1", "c1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO oct_command_dead_letters(")).WithArgs("a1", "c1", dead, now).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT command_id, command, attempts FROM oct_command_inflight")).WithArgs("a1", cutoff).WillReturnRows(sqlmock.NewRows([]string{"command_id", "command", "attempts"}).AddRow("c2", retry, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE oct_command_inflight SET delivered_at=$3, attempts=attempts+1")).WithArgs("a1", "c2", now).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	got, err := q.Poll(ctx, "a1", 0)
+	if err != nil || got == nil || got.CommandID != "c2" {
+		t.Fatalf("expected c2 redelivered, got %+v err=%v", got, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT command FROM oct_command_dead_letters")).WithArgs("a1").WillReturnRows(sqlmock.NewRows([]string{"command"}).AddRow(dead).AddRow([]byte(`{bad`)))
+	letters, err := q.DeadLetters(ctx, "a1")
+	if err != nil || len(letters) != 1 || letters[0].CommandID != "c1" {
+		t.Fatalf("expected c1 dead-lettered, got %+v err=%v", letters, err)
+	}
+	if _, err := q.DeadLetters(ctx, ""); err == nil {
+		t.Fatal("expected empty agent id error")
+	}
+
+	// a failed dead-letter insert rolls the claim back
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT command_id, command, attempts FROM oct_command_inflight")).WillReturnRows(sqlmock.NewRows([]string{"command_id", "command", "attempts"}).AddRow("c2", retry, 2))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM oct_command_inflight")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO oct_command_dead_letters(")).WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+	if _, err := q.Poll(ctx, "a1", 0); !errors.Is(err, sql.ErrConnDone) {
+		t.Fatalf("expected dead-letter error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
+
+func TestPostgresQueueEnqueueBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock new: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	now := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
+	q := newPostgresQueue(db)
+	q.SetClock(func() time.Time { return now })
+	c1 := contracts.Command{CommandID: "c1", IdempotencyKey: "k1-00000", Type: contracts.CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{}`)}
+	c2 := c1
+	c2.CommandID, c2.IdempotencyKey = "c2", "k2-00000"
+	c1JSON, _ := json.Marshal(c1)
+	c2JSON, _ := json.Marshal(c2)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO oct_command_queue(")).WithArgs("a1", "c1", 0, c1JSON, now).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO oct_command_queue(")).WithArgs("a1", "c2", 0, c2JSON, now).WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+	if err := q.EnqueueBatch(ctx, "a1", []contracts.Command{c1, c2}); err != nil {
+		t.Fatalf("enqueue batch: %v", err)
+	}
+
+	// a failed insert rolls back the rows already written
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO oct_command_queue(")).WithArgs("a1", "c1", 0, c1JSON, now).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO oct_command_queue(")).WithArgs("a1", "c2", 0, c2JSON, now).WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+	if err := q.EnqueueBatch(ctx, "a1", []contracts.Command{c1, c2}); !errors.Is(err, sql.ErrConnDone) {
+		t.Fatalf("expected insert error, got %v", err)
+	}
+
+	// invalid commands are rejected before the database is touched
+	bad := c2
+	bad.CreatedAt = time.Time{}
+	if err := q.EnqueueBatch(ctx, "a1", []contracts.Command{c1, bad}); err == nil {
+		t.Fatal("expected validation error")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
+
+func TestPostgresQueuePendingCommands(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock new: %v", err)
+	}
+	defer db.Close()
+
+	q := newPostgresQueue(db)
+	ctx := context.Background()
+	inflight, _ := json.Marshal(contracts.Command{CommandID: "c1", Type: contracts.CommandTypeRunTask})
+	queued, _ := json.Marshal(contracts.Command{CommandID: "c2", Type: contracts.CommandTypeStatus})
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT command FROM oct_command_inflight")).WithArgs("a1", 2).WillReturnRows(sqlmock.NewRows([]string{"command"}).AddRow(inflight))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT command FROM oct_command_queue")).WithArgs("a1", 1).WillReturnRows(sqlmock.NewRows([]string{"command"}).AddRow(queued))
+	got, err := q.PendingCommands(ctx, "a1", 2)
+	if err != nil || len(got) != 2 || got[0].CommandID != "c1" || !got[0].Inflight || got[1].CommandID != "c2" || got[1].Inflight {
+		t.Fatalf("expected inflight c1 then queued c2, got %+v err=%v", got, err)
+	}
+
+	// the limit is spent on inflight commands, so the queue is not read
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT command FROM oct_command_inflight")).WithArgs("a1", 1).WillReturnRows(sqlmock.NewRows([]string{"command"}).AddRow(inflight))
+	if got, err := q.PendingCommands(ctx, "a1", 1); err != nil || len(got) != 1 {
+		t.Fatalf("expected one command, got %+v err=%v", got, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT command FROM oct_command_inflight")).WillReturnError(sql.ErrConnDone)
+	if _, err := q.PendingCommands(ctx, "a1", 1); !errors.Is(err, sql.ErrConnDone) {
+		t.Fatalf("expected query error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
+
+func TestPostgresQueuePing(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("sqlmock new: %v", err)
+	}
+	defer db.Close()
+
+	q := newPostgresQueue(db)
+	mock.ExpectPing()
+	if err := q.Ping(context.Background()); err != nil {
+		t.Fatalf("expected ping ok, got %v", err)
+	}
+	mock.ExpectPing().WillReturnError(sql.ErrConnDone)
+	if err := q.Ping(context.Background()); !errors.Is(err, sql.ErrConnDone) {
+		t.Fatalf("expected ping error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
+
+func TestPostgresQueuePurge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock new: %v", err)
+	}
+	defer db.Close()
+
+	q := newPostgresQueue(db)
+	if err := q.Purge(context.Background(), ""); err == nil {
+		t.Fatal("expected agentID error")
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM oct_command_queue WHERE agent_id=$1")).WithArgs("a1").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM oct_command_inflight WHERE agent_id=$1")).WithArgs("a1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := q.Purge(context.Background(), "a1"); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
@@ -73,6 +73,11 @@ func (s *PostgresPairingStore) DeletePairCode(code string) error {
 	return err
 }
 
+func (s *PostgresPairingStore) DeleteUserPairCodes(telegramUserID string) error {
+	_, err := s.db.Exec(`DELETE FROM oct_pair_codes WHERE telegram_user_id=$1`, telegramUserID)
+	return err
+}
+
 func (s *PostgresPairingStore) SaveAgentBinding(telegramUserID string, agentID string, agentKey string) error {
 	_, err := s.db.Exec(`
 INSERT INTO oct_agents(telegram_user_id, agent_id, agent_key, updated_at)
@@ -83,6 +88,11 @@ DO UPDATE SET agent_id=EXCLUDED.agent_id, agent_key=EXCLUDED.agent_key, updated_
 	return err
 }
 
+func (s *PostgresPairingStore) DeleteAgentBinding(telegramUserID string) error {
+	_, err := s.db.Exec(`DELETE FROM oct_agents WHERE telegram_user_id=$1`, telegramUserID)
+	return err
+}
+
 func (s *PostgresPairingStore) GetAgentIDByKey(agentKey string) (string, bool, error) {
 	var agentID string
 	err := s.db.QueryRow(`SELECT agent_id FROM oct_agents WHERE agent_key=$1`, agentKey).Scan(&agentID)
@@ -115,11 +115,21 @@ func TestPostgresPairingStoreMethods(t *testing.T) {
 		t.Fatalf("delete pair code: %v", err)
 	}
 
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM oct_pair_codes WHERE telegram_user_id=$1")).WithArgs("u1").WillReturnResult(sqlmock.NewResult(0, 2))
+	if err := store.DeleteUserPairCodes("u1"); err != nil {
+		t.Fatalf("delete user pair codes: %v", err)
+	}
+
 	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO oct_agents(telegram_user_id, agent_id, agent_key, updated_at)")).WithArgs("u1", "a1", "k1").WillReturnResult(sqlmock.NewResult(1, 1))
 	if err := store.SaveAgentBinding("u1", "a1", "k1"); err != nil {
 		t.Fatalf("save agent binding: %v", err)
 	}
 
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM oct_agents WHERE telegram_user_id=$1")).WithArgs("u2").WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := store.DeleteAgentBinding("u2"); err != nil {
+		t.Fatalf("delete agent binding: %v", err)
+	}
+
 	mock.ExpectQuery(regexp.QuoteMeta("SELECT agent_id FROM oct_agents WHERE agent_key=$1")).WithArgs("k1").WillReturnRows(sqlmock.NewRows([]string{"agent_id"}).AddRow("a1"))
 	agentID, ok, err := store.GetAgentIDByKey("k1")
 	if err != nil || !ok || agentID != "a1" {
@@ -0,0 +1,162 @@
+package backend
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"opencode-telegram/internal/proxy/contracts"
+)
+
+type PostgresQueueStore struct {
+	db *sql.DB
+}
+
+func NewPostgresQueueStore(dsn string) (*PostgresQueueStore, error) {
+	db, err := sqlOpen("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	store := &PostgresQueueStore{db: db}
+	if err := store.ensureSchema(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *PostgresQueueStore) ensureSchema() error {
+	const schema = `
+CREATE TABLE IF NOT EXISTS oct_queued_commands (
+  agent_id TEXT NOT NULL,
+  position INTEGER NOT NULL,
+  command JSONB NOT NULL,
+  PRIMARY KEY (agent_id, position)
+);
+CREATE TABLE IF NOT EXISTS oct_inflight_commands (
+  agent_id TEXT NOT NULL,
+  position INTEGER NOT NULL,
+  command JSONB NOT NULL,
+  inflight_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (agent_id, position)
+);
+CREATE TABLE IF NOT EXISTS oct_command_results (
+  agent_id TEXT NOT NULL,
+  command_id TEXT NOT NULL,
+  result JSONB NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (agent_id, command_id)
+);
+`
+	_, err := s.db.Exec(schema)
+	return err
+}
+
+func (s *PostgresQueueStore) SaveQueued(agentID string, cmds []contracts.Command) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM oct_queued_commands WHERE agent_id=$1`, agentID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	for i, cmd := range cmds {
+		data, err := json.Marshal(cmd)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO oct_queued_commands(agent_id, position, command) VALUES($1,$2,$3)`, agentID, i, data); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresQueueStore) SaveInflight(agentID string, items []InflightCommand) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM oct_inflight_commands WHERE agent_id=$1`, agentID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	for i, item := range items {
+		data, err := json.Marshal(item.Command)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO oct_inflight_commands(agent_id, position, command, inflight_at) VALUES($1,$2,$3,$4)`, agentID, i, data, item.InflightAt.UTC()); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresQueueStore) SaveResult(agentID string, result contracts.CommandResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+INSERT INTO oct_command_results(agent_id, command_id, result, updated_at)
+VALUES($1,$2,$3,NOW())
+ON CONFLICT (agent_id, command_id) DO UPDATE SET result=EXCLUDED.result, updated_at=NOW()
+`, agentID, result.CommandID, data)
+	return err
+}
+
+func (s *PostgresQueueStore) LoadQueued() (map[string][]contracts.Command, map[string][]InflightCommand, error) {
+	queued := make(map[string][]contracts.Command)
+	rows, err := s.db.Query(`SELECT agent_id, command FROM oct_queued_commands ORDER BY agent_id, position`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var agentID string
+		var data []byte
+		if err := rows.Scan(&agentID, &data); err != nil {
+			return nil, nil, err
+		}
+		var cmd contracts.Command
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			return nil, nil, err
+		}
+		queued[agentID] = append(queued[agentID], cmd)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	inflight := make(map[string][]InflightCommand)
+	inflightRows, err := s.db.Query(`SELECT agent_id, command, inflight_at FROM oct_inflight_commands ORDER BY agent_id, position`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer inflightRows.Close()
+	for inflightRows.Next() {
+		var agentID string
+		var data []byte
+		var inflightAt time.Time
+		if err := inflightRows.Scan(&agentID, &data, &inflightAt); err != nil {
+			return nil, nil, err
+		}
+		var cmd contracts.Command
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			return nil, nil, err
+		}
+		inflight[agentID] = append(inflight[agentID], InflightCommand{Command: cmd, InflightAt: inflightAt.UTC()})
+	}
+	if err := inflightRows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return queued, inflight, nil
+}
@@ -0,0 +1,101 @@
+package backend
+
+import (
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+
+	"opencode-telegram/internal/proxy/contracts"
+)
+
+func TestNewPostgresQueueStore(t *testing.T) {
+	t.Run("fails when sql open fails", func(t *testing.T) {
+		oldOpen := sqlOpen
+		sqlOpen = func(driverName, dataSourceName string) (*sql.DB, error) { return nil, sql.ErrConnDone }
+		t.Cleanup(func() { sqlOpen = oldOpen })
+
+		if _, err := NewPostgresQueueStore("postgres://x"); err == nil {
+			t.Fatal("expected sql open error")
+		}
+	})
+
+	t.Run("initializes schema", func(t *testing.T) {
+		db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		if err != nil {
+			t.Fatalf("sqlmock new: %v", err)
+		}
+		defer db.Close()
+
+		oldOpen := sqlOpen
+		sqlOpen = func(driverName, dataSourceName string) (*sql.DB, error) { return db, nil }
+		t.Cleanup(func() { sqlOpen = oldOpen })
+
+		mock.ExpectPing()
+		mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS oct_queued_commands (")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		store, err := NewPostgresQueueStore("postgres://x")
+		if err != nil {
+			t.Fatalf("new store: %v", err)
+		}
+		if store == nil || store.db == nil {
+			t.Fatal("expected initialized store")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("expectations: %v", err)
+		}
+	})
+}
+
+func TestPostgresQueueStoreMethods(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock new: %v", err)
+	}
+	defer db.Close()
+
+	store := &PostgresQueueStore{db: db}
+	now := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
+	cmd := contracts.Command{CommandID: "c1", IdempotencyKey: "k1-00000", Type: contracts.CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{}`)}
+	cmdJSON, _ := json.Marshal(cmd)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM oct_queued_commands WHERE agent_id=$1")).WithArgs("a1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO oct_queued_commands")).WithArgs("a1", 0, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	if err := store.SaveQueued("a1", []contracts.Command{cmd}); err != nil {
+		t.Fatalf("save queued: %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM oct_inflight_commands WHERE agent_id=$1")).WithArgs("a1").WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+	if err := store.SaveInflight("a1", []InflightCommand{{Command: cmd, InflightAt: now}}); err == nil {
+		t.Fatal("expected save inflight error")
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO oct_command_results")).WithArgs("a1", "c1", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := store.SaveResult("a1", contracts.CommandResult{CommandID: "c1", OK: true}); err != nil {
+		t.Fatalf("save result: %v", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT agent_id, command FROM oct_queued_commands")).WillReturnRows(sqlmock.NewRows([]string{"agent_id", "command"}).AddRow("a1", cmdJSON))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT agent_id, command, inflight_at FROM oct_inflight_commands")).WillReturnRows(sqlmock.NewRows([]string{"agent_id", "command", "inflight_at"}).AddRow("a2", cmdJSON, now))
+	queued, inflight, err := store.LoadQueued()
+	if err != nil {
+		t.Fatalf("load queued: %v", err)
+	}
+	if len(queued["a1"]) != 1 || queued["a1"][0].CommandID != "c1" {
+		t.Fatalf("unexpected queued: %+v", queued)
+	}
+	if len(inflight["a2"]) != 1 || !inflight["a2"][0].InflightAt.Equal(now) {
+		t.Fatalf("unexpected inflight: %+v", inflight)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package backend
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Default poll limits comfortably cover long-polling agents, which poll at
+// most once per returned command.
+const (
+	DefaultPollRate  = 5.0
+	DefaultPollBurst = 10
+)
+
+// rateLimiter is a token bucket per key. A non-positive rate disables it.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	l := &rateLimiter{buckets: make(map[string]*tokenBucket)}
+	l.setRate(rate, burst)
+	return l
+}
+
+func (l *rateLimiter) setRate(rate float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.burst = float64(burst)
+	l.buckets = make(map[string]*tokenBucket)
+}
+
+// allow takes a token for key and reports whether one was available. When it
+// was not, it also returns how long until the next token arrives.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return true, 0
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
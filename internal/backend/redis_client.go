@@ -27,6 +27,10 @@ func (c *RealRedisClient) BRPopLPush(ctx context.Context, source, destination st
 	return c.client.BRPopLPush(ctx, source, destination, timeout).Result()
 }
 
+func (c *RealRedisClient) RPopLPush(ctx context.Context, source, destination string) (string, error) {
+	return c.client.RPopLPush(ctx, source, destination).Result()
+}
+
 func (c *RealRedisClient) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
 	return c.client.LRange(ctx, key, start, stop).Result()
 }
@@ -59,6 +63,39 @@ func (c *RealRedisClient) HDel(ctx context.Context, key string, fields ...string
 	return c.client.HDel(ctx, key, fields...).Err()
 }
 
+func (c *RealRedisClient) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
 func (c *RealRedisClient) Expire(ctx context.Context, key string, expiration time.Duration) error {
 	return c.client.Expire(ctx, key, expiration).Err()
 }
+
+func (c *RealRedisClient) Publish(ctx context.Context, channel string, message interface{}) error {
+	return c.client.Publish(ctx, channel, message).Err()
+}
+
+func (c *RealRedisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return c.client.Eval(ctx, script, keys, args...).Result()
+}
+
+func (c *RealRedisClient) Subscribe(ctx context.Context, channel string) (<-chan string, func() error, error) {
+	pubsub := c.client.Subscribe(ctx, channel)
+	// Wait for the subscription confirmation so no publish is missed afterwards.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, nil, err
+	}
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		for msg := range pubsub.Channel() {
+			select {
+			case out <- msg.Payload:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, pubsub.Close, nil
+}
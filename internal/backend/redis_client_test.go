@@ -34,6 +34,11 @@ func TestRealRedisClient_NewAndDelegates(t *testing.T) {
 	_, _ = rc.HGet(ctx, "h", "f")
 	_ = rc.HDel(ctx, "h", "f")
 	_ = rc.Expire(ctx, "k", time.Second)
+	_ = rc.Publish(ctx, "ch", "v")
+	_, _ = rc.Eval(ctx, "return 1", nil)
+	if _, closeSub, err := rc.Subscribe(ctx, "ch"); err == nil {
+		_ = closeSub()
+	}
 }
 
 func TestRealRedisClient_DelegatesToUnderlyingClient(t *testing.T) {
@@ -44,6 +49,9 @@ func TestRealRedisClient_DelegatesToUnderlyingClient(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
 	defer cancel()
 
+	if err := rc.Ping(ctx); err == nil {
+		t.Fatal("expected ping to fail without redis")
+	}
 	if err := rc.LPush(ctx, "k", "v"); err == nil {
 		t.Fatal("expected lpush to fail without redis")
 	}
@@ -77,6 +85,12 @@ func TestRealRedisClient_DelegatesToUnderlyingClient(t *testing.T) {
 	if err := rc.Expire(ctx, "k", time.Second); err == nil {
 		t.Fatal("expected expire to fail without redis")
 	}
+	if err := rc.Publish(ctx, "ch", "v"); err == nil {
+		t.Fatal("expected publish to fail without redis")
+	}
+	if _, _, err := rc.Subscribe(ctx, "ch"); err == nil {
+		t.Fatal("expected subscribe to fail without redis")
+	}
 
 	if err := rc.Del(ctx, "k"); err != nil && !strings.Contains(err.Error(), "dial tcp") && !strings.Contains(err.Error(), "deadline") {
 		t.Fatalf("expected dial tcp style error, got %v", err)
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
@@ -17,13 +18,102 @@ const (
 	inflightKeyPrefix   = "oct:inflight:"
 	inflightAtKeyPrefix = "oct:inflight_at:"
 	resultKeyPrefix     = "oct:result:"
+	attemptsKeyPrefix   = "oct:attempts:"
+	deadLetterKeyPrefix = "oct:dlq:"
+
+	// Redis pub/sub channels
+	resultChannelPrefix = "oct:result_ch:"
 )
 
+// DefaultMaxDeliveryAttempts is how many times a command may be delivered
+// before it is moved to the dead-letter list.
+const DefaultMaxDeliveryAttempts = 5
+
+// inflightAtLayout is RFC3339 with fixed-width nanoseconds, so UTC
+// timestamps compare correctly as strings inside Lua.
+const inflightAtLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// claimStaleInflightScript finds the command with the oldest delivery time
+// (KEYS[2]) before the cutoff (ARGV[1]) and claims it by stamping ARGV[2]
+// and counting the attempt (KEYS[3]). Stale commands already delivered
+// ARGV[3] times move from the inflight list (KEYS[1]) to the dead-letter
+// list (KEYS[4]). ARGV[4] is the timestamp hash TTL in milliseconds. The
+// small timestamp hash is scanned first, so the inflight list is only read
+// when something is actually stale. Stamps written with RFC3339Nano before
+// inflightAtLayout existed are padded to the fixed width before comparing.
+// Running as one script keeps concurrent polls from claiming the same
+// command.
+const claimStaleInflightScript = `
+local stale = {}
+local found = false
+local stamps = redis.call('HGETALL', KEYS[2])
+for i = 1, #stamps, 2 do
+  local at = stamps[i + 1]
+  local base, frac, zone = string.match(at, '^(%d+%-%d+%-%d+T%d+:%d+:%d+)%.?(%d*)(.*)$')
+  if base then
+    at = base .. '.' .. string.sub(frac .. '000000000', 1, 9) .. zone
+  end
+  if at < ARGV[1] then
+    stale[stamps[i]] = at
+    found = true
+  end
+end
+if not found then
+  return false
+end
+local claimed, claimedID, claimedAt
+for _, item in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+  local id = string.match(item, '^{"command_id":"([^"\\]*)"')
+  if not id then
+    local ok, cmd = pcall(cjson.decode, item)
+    if ok and type(cmd) == 'table' and type(cmd.command_id) == 'string' then
+      id = cmd.command_id
+    end
+  end
+  local at = id and stale[id]
+  if at then
+    local attempts = tonumber(redis.call('HGET', KEYS[3], id)) or 0
+    if attempts >= tonumber(ARGV[3]) then
+      redis.call('LPUSH', KEYS[4], item)
+      redis.call('LREM', KEYS[1], 1, item)
+      redis.call('HDEL', KEYS[2], id)
+      redis.call('HDEL', KEYS[3], id)
+    elseif not claimed or at < claimedAt then
+      claimed, claimedID, claimedAt = item, id, at
+    end
+  end
+end
+if not claimed then
+  return false
+end
+local attempts = tonumber(redis.call('HGET', KEYS[3], claimedID)) or 0
+redis.call('HSET', KEYS[2], claimedID, ARGV[2])
+redis.call('PEXPIRE', KEYS[2], ARGV[4])
+redis.call('HSET', KEYS[3], claimedID, tostring(attempts + 1))
+return claimed
+`
+
+// enqueueBatchScript pushes the first ARGV[1] values of the remaining ARGV
+// onto the priority queue (KEYS[1]) and the rest onto the normal queue
+// (KEYS[2]), so a batch spanning both lists is queued atomically.
+const enqueueBatchScript = `
+local split = tonumber(ARGV[1]) + 1
+for i = 2, #ARGV do
+  if i <= split then
+    redis.call('LPUSH', KEYS[1], ARGV[i])
+  else
+    redis.call('LPUSH', KEYS[2], ARGV[i])
+  end
+end
+return #ARGV - 1
+`
+
 // RedisClient defines the interface for Redis-like operations
 // This allows swapping between real Redis and in-memory implementations
 type RedisClient interface {
 	LPush(ctx context.Context, key string, values ...interface{}) error
 	BRPopLPush(ctx context.Context, source, destination string, timeout time.Duration) (string, error)
+	RPopLPush(ctx context.Context, source, destination string) (string, error)
 	LRange(ctx context.Context, key string, start, stop int64) ([]string, error)
 	LRem(ctx context.Context, key string, count int64, value interface{}) error
 	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
@@ -33,6 +123,12 @@ type RedisClient interface {
 	HGet(ctx context.Context, key, field string) (string, error)
 	HDel(ctx context.Context, key string, fields ...string) error
 	Expire(ctx context.Context, key string, expiration time.Duration) error
+	Publish(ctx context.Context, channel string, message interface{}) error
+	// Subscribe delivers channel payloads until the returned close func is called.
+	Subscribe(ctx context.Context, channel string) (<-chan string, func() error, error)
+	Ping(ctx context.Context) error
+	// Eval runs a Lua script atomically; a nil reply is the "redis: nil" error.
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
 }
 
 // InMemoryRedisClient provides an in-memory implementation of RedisClient for testing
@@ -42,6 +138,7 @@ type InMemoryRedisClient struct {
 	values   map[string]string
 	hashes   map[string]map[string]string
 	expiries map[string]time.Time
+	subs     map[string]map[chan string]struct{}
 	now      func() time.Time
 }
 
@@ -52,6 +149,7 @@ func NewInMemoryRedisClient() *InMemoryRedisClient {
 		values:   make(map[string]string),
 		hashes:   make(map[string]map[string]string),
 		expiries: make(map[string]time.Time),
+		subs:     make(map[string]map[chan string]struct{}),
 		now:      time.Now,
 	}
 }
@@ -67,30 +165,28 @@ func (c *InMemoryRedisClient) LPush(ctx context.Context, key string, values ...i
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	vals := make([]string, len(values))
-	for i, v := range values {
-		switch val := v.(type) {
+	c.lpushLocked(key, values)
+	return nil
+}
+
+// lpushLocked adds values to the head (left) of the list one by one, so
+// LPUSH x a b c results in [c, b, a] as in Redis.
+func (c *InMemoryRedisClient) lpushLocked(key string, values []interface{}) {
+	for _, v := range values {
+		var val string
+		switch v := v.(type) {
 		case []byte:
-			vals[i] = string(val)
+			val = string(v)
 		case string:
-			vals[i] = val
+			val = v
 		default:
-			vals[i] = fmt.Sprintf("%v", v)
+			val = fmt.Sprintf("%v", v)
 		}
+		c.lists[key] = append([]string{val}, c.lists[key]...)
 	}
-
-	// LPUSH adds values to the HEAD (left) of the list
-	// Redis behavior: LPUSH x a b c results in [c, b, a]
-	// We iterate values in reverse order to achieve this
-	for i := len(vals) - 1; i >= 0; i-- {
-		c.lists[key] = append([]string{vals[i]}, c.lists[key]...)
-	}
-	return nil
 }
 
 func (c *InMemoryRedisClient) BRPopLPush(ctx context.Context, source, destination string, timeout time.Duration) (string, error) {
-	_ = ctx
-
 	start := time.Now()
 	for {
 		c.mu.Lock()
@@ -108,8 +204,26 @@ func (c *InMemoryRedisClient) BRPopLPush(ctx context.Context, source, destinatio
 		if time.Since(start) >= timeout {
 			return "", errors.New("redis: nil")
 		}
-		time.Sleep(10 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (c *InMemoryRedisClient) RPopLPush(ctx context.Context, source, destination string) (string, error) {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := c.lists[source]
+	if len(list) == 0 {
+		return "", errors.New("redis: nil")
 	}
+	val := list[len(list)-1]
+	c.lists[source] = list[:len(list)-1]
+	c.lists[destination] = append([]string{val}, c.lists[destination]...)
+	return val, nil
 }
 
 func (c *InMemoryRedisClient) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
@@ -285,21 +399,135 @@ func (c *InMemoryRedisClient) HGet(ctx context.Context, key, field string) (stri
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	val, ok := c.hashLocked(key)[field]
+	if !ok {
+		return "", errors.New("redis: nil")
+	}
+	return val, nil
+}
+
+// hashLocked returns key's hash, dropping it first if it has expired. The
+// caller must hold c.mu.
+func (c *InMemoryRedisClient) hashLocked(key string) map[string]string {
 	if expiry, ok := c.expiries[key]; ok && c.now().After(expiry) {
 		delete(c.hashes, key)
 		delete(c.expiries, key)
-		return "", errors.New("redis: nil")
 	}
+	return c.hashes[key]
+}
 
-	fields, ok := c.hashes[key]
-	if !ok {
-		return "", errors.New("redis: nil")
+// Eval holds the lock for the whole call, like Redis runs a script. Lua is
+// not available in memory, so only the scripts RedisQueue uses are
+// supported, each emulated in Go.
+func (c *InMemoryRedisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch script {
+	case claimStaleInflightScript:
+		return c.claimStaleInflight(keys, args)
+	case enqueueBatchScript:
+		return c.enqueueBatch(keys, args)
 	}
-	val, ok := fields[field]
-	if !ok {
-		return "", errors.New("redis: nil")
+	return nil, errors.New("eval: unsupported script")
+}
+
+// enqueueBatch emulates enqueueBatchScript.
+func (c *InMemoryRedisClient) enqueueBatch(keys []string, args []interface{}) (interface{}, error) {
+	if len(keys) != 2 || len(args) < 1 {
+		return nil, errors.New("eval: wrong number of keys or args")
 	}
-	return val, nil
+	split, err := strconv.Atoi(fmt.Sprint(args[0]))
+	if err != nil || split < 0 || split > len(args)-1 {
+		return nil, errors.New("eval: invalid split")
+	}
+	values := args[1:]
+	c.lpushLocked(keys[0], values[:split])
+	c.lpushLocked(keys[1], values[split:])
+	return int64(len(values)), nil
+}
+
+// claimStaleInflight emulates claimStaleInflightScript.
+func (c *InMemoryRedisClient) claimStaleInflight(keys []string, args []interface{}) (interface{}, error) {
+	if len(keys) != 4 || len(args) != 4 {
+		return nil, errors.New("eval: wrong number of keys or args")
+	}
+	inflightKey, atKey, attemptsKey, dlqKey := keys[0], keys[1], keys[2], keys[3]
+	cutoff, now := fmt.Sprint(args[0]), fmt.Sprint(args[1])
+	maxAttempts, _ := strconv.Atoi(fmt.Sprint(args[2]))
+	ttlMillis, _ := strconv.ParseInt(fmt.Sprint(args[3]), 10, 64)
+	attemptsOf := func(id string) int {
+		n, _ := strconv.Atoi(c.hashLocked(attemptsKey)[id])
+		return n
+	}
+
+	stale := make(map[string]string)
+	for id, at := range c.hashLocked(atKey) {
+		if at = normalizeInflightAt(at); at < cutoff {
+			stale[id] = at
+		}
+	}
+	if len(stale) == 0 {
+		return nil, errors.New("redis: nil")
+	}
+
+	var claimed, claimedID, claimedAt string
+	for _, item := range append([]string(nil), c.lists[inflightKey]...) {
+		var cmd struct {
+			CommandID string `json:"command_id"`
+		}
+		if err := json.Unmarshal([]byte(item), &cmd); err != nil || cmd.CommandID == "" {
+			continue
+		}
+		at, ok := stale[cmd.CommandID]
+		if !ok {
+			continue
+		}
+		if attemptsOf(cmd.CommandID) >= maxAttempts {
+			c.lists[dlqKey] = append([]string{item}, c.lists[dlqKey]...)
+			for i, v := range c.lists[inflightKey] {
+				if v == item {
+					c.lists[inflightKey] = append(c.lists[inflightKey][:i:i], c.lists[inflightKey][i+1:]...)
+					break
+				}
+			}
+			delete(c.hashes[atKey], cmd.CommandID)
+			delete(c.hashes[attemptsKey], cmd.CommandID)
+			continue
+		}
+		if claimed == "" || at < claimedAt {
+			claimed, claimedID, claimedAt = item, cmd.CommandID, at
+		}
+	}
+	if claimed == "" {
+		return nil, errors.New("redis: nil")
+	}
+	attempts := attemptsOf(claimedID)
+	for _, key := range []string{atKey, attemptsKey} {
+		if c.hashes[key] == nil {
+			c.hashes[key] = make(map[string]string)
+		}
+	}
+	c.hashes[atKey][claimedID] = now
+	c.expiries[atKey] = c.now().Add(time.Duration(ttlMillis) * time.Millisecond)
+	c.hashes[attemptsKey][claimedID] = strconv.Itoa(attempts + 1)
+	return claimed, nil
+}
+
+// normalizeInflightAt pads the fraction of an RFC3339Nano stamp to the fixed
+// width of inflightAtLayout, as claimStaleInflightScript does.
+func normalizeInflightAt(at string) string {
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return at
+	}
+	return t.Format(inflightAtLayout)
+}
+
+// Ping always succeeds; there is no connection to lose.
+func (c *InMemoryRedisClient) Ping(ctx context.Context) error {
+	return ctx.Err()
 }
 
 func (c *InMemoryRedisClient) HDel(ctx context.Context, key string, fields ...string) error {
@@ -331,10 +559,63 @@ func (c *InMemoryRedisClient) Expire(ctx context.Context, key string, expiration
 	return nil
 }
 
+func (c *InMemoryRedisClient) Publish(ctx context.Context, channel string, message interface{}) error {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var msg string
+	switch val := message.(type) {
+	case []byte:
+		msg = string(val)
+	case string:
+		msg = val
+	default:
+		msg = fmt.Sprintf("%v", message)
+	}
+	for ch := range c.subs[channel] {
+		// Like Redis, slow subscribers miss messages rather than block publishers.
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+	return nil
+}
+
+func (c *InMemoryRedisClient) Subscribe(ctx context.Context, channel string) (<-chan string, func() error, error) {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan string, 16)
+	if c.subs[channel] == nil {
+		c.subs[channel] = make(map[chan string]struct{})
+	}
+	c.subs[channel][ch] = struct{}{}
+
+	var once sync.Once
+	closeFn := func() error {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			delete(c.subs[channel], ch)
+			if len(c.subs[channel]) == 0 {
+				delete(c.subs, channel)
+			}
+			close(ch)
+		})
+		return nil
+	}
+	return ch, closeFn, nil
+}
+
 // RedisQueue implements CommandQueue using Redis for at-least-once delivery
 type RedisQueue struct {
 	client        RedisClient
 	redeliveryTTL time.Duration
+	maxAttempts   int
+	resultTTL     time.Duration
 	now           func() time.Time
 }
 
@@ -343,6 +624,8 @@ func NewRedisQueue(client RedisClient) *RedisQueue {
 	return &RedisQueue{
 		client:        client,
 		redeliveryTTL: DefaultRedeliveryTTL,
+		maxAttempts:   DefaultMaxDeliveryAttempts,
+		resultTTL:     DefaultResultTTL,
 		now:           time.Now,
 	}
 }
@@ -352,10 +635,44 @@ func (q *RedisQueue) SetClock(nowFn func() time.Time) {
 	q.now = nowFn
 }
 
+// SetMaxAttempts sets how many deliveries a command gets before it is
+// dead-lettered. Values below 1 are treated as 1.
+func (q *RedisQueue) SetMaxAttempts(maxAttempts int) {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	q.maxAttempts = maxAttempts
+}
+
+// SetResultTTL sets how long stored results are kept. Values below 1
+// restore DefaultResultTTL.
+func (q *RedisQueue) SetResultTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultResultTTL
+	}
+	q.resultTTL = ttl
+}
+
+// SetRedeliveryTTL sets how long an inflight command may go without a
+// result before it is redelivered; see MemoryBackend.SetRedeliveryTTL.
+func (q *RedisQueue) SetRedeliveryTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("redelivery TTL must be positive, got %s", ttl)
+	}
+	q.redeliveryTTL = ttl
+	return nil
+}
+
 func (q *RedisQueue) queueKey(agentID string) string {
 	return queueKeyPrefix + agentID
 }
 
+// priorityQueueKey holds commands with a priority above normal; Poll drains
+// it before the regular queue.
+func (q *RedisQueue) priorityQueueKey(agentID string) string {
+	return q.queueKey(agentID) + ":priority"
+}
+
 func (q *RedisQueue) inflightKey(agentID string) string {
 	return inflightKeyPrefix + agentID
 }
@@ -364,6 +681,18 @@ func (q *RedisQueue) inflightAtKey(agentID string) string {
 	return inflightAtKeyPrefix + agentID
 }
 
+func (q *RedisQueue) attemptsKey(agentID string) string {
+	return attemptsKeyPrefix + agentID
+}
+
+func (q *RedisQueue) deadLetterKey(agentID string) string {
+	return deadLetterKeyPrefix + agentID
+}
+
+func (q *RedisQueue) resultChannel(agentID string) string {
+	return resultChannelPrefix + agentID
+}
+
 func (q *RedisQueue) resultKey(agentID, commandID string) string {
 	return fmt.Sprintf("%s%s:%s", resultKeyPrefix, agentID, commandID)
 }
@@ -377,9 +706,47 @@ func (q *RedisQueue) Enqueue(ctx context.Context, agentID string, cmd contracts.
 	if err != nil {
 		return fmt.Errorf("marshal command: %w", err)
 	}
+	if cmd.Priority > contracts.PriorityNormal {
+		return q.client.LPush(ctx, q.priorityQueueKey(agentID), data)
+	}
 	return q.client.LPush(ctx, q.queueKey(agentID), data)
 }
 
+// EnqueueBatch validates and encodes every command before queueing any. Each
+// list gets a single LPUSH; a batch that mixes priority and normal commands
+// is pushed by one script so it is still all-or-nothing.
+func (q *RedisQueue) EnqueueBatch(ctx context.Context, agentID string, cmds []contracts.Command) error {
+	if agentID == "" {
+		return errors.New("agentID is required")
+	}
+	if err := validateBatch(cmds); err != nil {
+		return err
+	}
+	var priority, normal []interface{}
+	for _, cmd := range cmds {
+		data, err := json.Marshal(cmd)
+		if err != nil {
+			return fmt.Errorf("marshal command: %w", err)
+		}
+		if cmd.Priority > contracts.PriorityNormal {
+			priority = append(priority, data)
+		} else {
+			normal = append(normal, data)
+		}
+	}
+	switch {
+	case len(priority) == 0 && len(normal) == 0:
+		return nil
+	case len(priority) == 0:
+		return q.client.LPush(ctx, q.queueKey(agentID), normal...)
+	case len(normal) == 0:
+		return q.client.LPush(ctx, q.priorityQueueKey(agentID), priority...)
+	}
+	args := append([]interface{}{len(priority)}, priority...)
+	_, err := q.client.Eval(ctx, enqueueBatchScript, []string{q.priorityQueueKey(agentID), q.queueKey(agentID)}, append(args, normal...)...)
+	return err
+}
+
 // Poll waits for a command using BRPOPLPUSH from queue to inflight with timeout
 // It also checks for stale inflight commands (older than redeliveryTTL) and returns them first
 func (q *RedisQueue) Poll(ctx context.Context, agentID string, timeoutSeconds int) (*contracts.Command, error) {
@@ -393,16 +760,29 @@ func (q *RedisQueue) Poll(ctx context.Context, agentID string, timeoutSeconds in
 		return nil, err
 	}
 	if staleCmd != nil {
-		// Update inflight timestamp
-		if err := q.setInflightTimestamp(ctx, agentID, staleCmd.CommandID); err != nil {
-			return nil, err
-		}
 		return staleCmd, nil
 	}
 
-	// Use BRPOPLPUSH to atomically move from queue to inflight with timeout
-	timeout := time.Duration(timeoutSeconds) * time.Second
-	result, err := q.client.BRPopLPush(ctx, q.queueKey(agentID), q.inflightKey(agentID), timeout)
+	// Queued commands, priority first, are taken without blocking; otherwise
+	// use BRPOPLPUSH to atomically move from queue to inflight with timeout.
+	// The wait is split into one-second calls because go-redis does not
+	// interrupt a blocking read when ctx is cancelled, so a dropped or
+	// draining request stops waiting within a second. BRPOPLPUSH watches a
+	// single list, so the priority list is rechecked after every call and a
+	// priority command waits at most a second.
+	result, err := q.client.RPopLPush(ctx, q.priorityQueueKey(agentID), q.inflightKey(agentID))
+	if err != nil && err.Error() == "redis: nil" {
+		result, err = q.client.RPopLPush(ctx, q.queueKey(agentID), q.inflightKey(agentID))
+	}
+	for i := 0; i < timeoutSeconds && err != nil && err.Error() == "redis: nil"; i++ {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		result, err = q.client.BRPopLPush(ctx, q.queueKey(agentID), q.inflightKey(agentID), time.Second)
+		if err != nil && err.Error() == "redis: nil" {
+			result, err = q.client.RPopLPush(ctx, q.priorityQueueKey(agentID), q.inflightKey(agentID))
+		}
+	}
 	if err != nil && err.Error() == "redis: nil" {
 		// Timeout with no command available
 		return nil, nil
@@ -430,30 +810,101 @@ func (q *RedisQueue) StoreResult(ctx context.Context, agentID string, result con
 		return errors.New("agentID is required")
 	}
 	if result.CommandID == "" {
-		return contracts.APIError{Code: contracts.ErrValidationRequiredField, Message: "command_id is required"}
+		return contracts.RequiredFieldError("command_id")
 	}
 
-	// Remove from inflight list
-	_, err := q.removeFromInflight(ctx, agentID, result.CommandID)
-	if err != nil {
-		return err
-	}
+	if result.InProgress {
+		// Progress leaves the command inflight and must not clobber a final result.
+		if existing, err := q.GetResult(ctx, agentID, result.CommandID); err == nil && existing != nil && existing.IsTerminal() {
+			return nil
+		}
+		if err := q.touchInflight(ctx, agentID, result.CommandID); err != nil {
+			return err
+		}
+	} else {
+		// Remove from inflight list
+		_, err := q.removeFromInflight(ctx, agentID, result.CommandID)
+		if err != nil {
+			return err
+		}
 
-	// Delete inflight timestamp from hash
-	_ = q.client.HDel(ctx, q.inflightAtKey(agentID), result.CommandID)
+		// Delete inflight timestamp and delivery counter from hashes
+		_ = q.client.HDel(ctx, q.inflightAtKey(agentID), result.CommandID)
+		_ = q.client.HDel(ctx, q.attemptsKey(agentID), result.CommandID)
+	}
 
 	// Store result with TTL
 	data, err := json.Marshal(result)
 	if err != nil {
 		return fmt.Errorf("marshal result: %w", err)
 	}
-	if err := q.client.Set(ctx, q.resultKey(agentID, result.CommandID), data, 14*24*time.Hour); err != nil {
+	if err := q.client.Set(ctx, q.resultKey(agentID, result.CommandID), data, q.resultTTL); err != nil {
 		return fmt.Errorf("store result: %w", err)
 	}
 
+	// The result is already durable; a failed publish only means subscribers
+	// fall back to reading it via GetResult.
+	_ = q.client.Publish(ctx, q.resultChannel(agentID), data)
+
 	return nil
 }
 
+// Subscribe returns a channel that receives progress updates and then the
+// final result for commandID, after which it closes. It also closes when ctx
+// is cancelled.
+func (q *RedisQueue) Subscribe(ctx context.Context, agentID, commandID string) (<-chan contracts.CommandResult, error) {
+	if agentID == "" {
+		return nil, errors.New("agentID is required")
+	}
+	if commandID == "" {
+		return nil, contracts.RequiredFieldError("command_id")
+	}
+	msgs, closeSub, err := q.client.Subscribe(ctx, q.resultChannel(agentID))
+	if err != nil {
+		return nil, fmt.Errorf("subscribe results: %w", err)
+	}
+
+	out := make(chan contracts.CommandResult, 1)
+	go func() {
+		defer close(out)
+		defer func() { _ = closeSub() }()
+
+		// send reports whether the stream should continue.
+		send := func(res contracts.CommandResult) bool {
+			select {
+			case out <- res:
+				return res.InProgress
+			case <-ctx.Done():
+				return false
+			}
+		}
+		// A result stored before the subscription started is never published again.
+		if res, err := q.GetResult(ctx, agentID, commandID); err == nil && res != nil {
+			if !send(*res) {
+				return
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				var res contracts.CommandResult
+				if err := json.Unmarshal([]byte(msg), &res); err != nil {
+					continue
+				}
+				if res.CommandID == commandID && !send(res) {
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
 func (q *RedisQueue) GetResult(ctx context.Context, agentID string, commandID string) (*contracts.CommandResult, error) {
 	if agentID == "" || commandID == "" {
 		return nil, nil
@@ -472,62 +923,110 @@ func (q *RedisQueue) GetResult(ctx context.Context, agentID string, commandID st
 	return &out, nil
 }
 
-// findStaleInflight looks for inflight commands older than redeliveryTTL and returns the first one
-func (q *RedisQueue) findStaleInflight(ctx context.Context, agentID string) (*contracts.Command, error) {
-	now := q.now().UTC()
-	cutoff := now.Add(-q.redeliveryTTL)
-
-	// Get all inflight commands
-	// LRange 0 -1 returns items from head (left) to tail (right)
-	items, err := q.client.LRange(ctx, q.inflightKey(agentID), 0, -1)
-	if err != nil {
-		return nil, fmt.Errorf("lrange inflight: %w", err)
+// Purge deletes agentID's queues and inflight bookkeeping in one DEL.
+// Results and dead letters are kept.
+func (q *RedisQueue) Purge(ctx context.Context, agentID string) error {
+	if agentID == "" {
+		return errors.New("agentID is required")
 	}
+	return q.client.Del(ctx, q.queueKey(agentID), q.priorityQueueKey(agentID), q.inflightKey(agentID), q.inflightAtKey(agentID), q.attemptsKey(agentID))
+}
 
-	// Track the oldest stale command
-	var oldestStale *contracts.Command
-	var oldestInflightAt time.Time
-
-	for _, item := range items {
-		var cmd contracts.Command
-		if err := json.Unmarshal([]byte(item), &cmd); err != nil {
-			continue // Skip malformed entries
-		}
+// Ping reports whether Redis is reachable.
+func (q *RedisQueue) Ping(ctx context.Context) error {
+	return q.client.Ping(ctx)
+}
 
-		// Check inflight timestamp
-		timestampStr, err := q.client.HGet(ctx, q.inflightAtKey(agentID), cmd.CommandID)
-		if err != nil && err.Error() != "redis: nil" {
-			continue // Skip on error
-		}
-		if timestampStr == "" {
-			continue // No timestamp, assume fresh
-		}
+// QueueStats returns the lengths of agentID's queue and inflight lists.
+func (q *RedisQueue) QueueStats(ctx context.Context, agentID string) (queued int, inflight int, err error) {
+	items, err := q.client.LRange(ctx, q.queueKey(agentID), 0, -1)
+	if err != nil {
+		return 0, 0, err
+	}
+	priority, err := q.client.LRange(ctx, q.priorityQueueKey(agentID), 0, -1)
+	if err != nil {
+		return 0, 0, err
+	}
+	delivered, err := q.client.LRange(ctx, q.inflightKey(agentID), 0, -1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(items) + len(priority), len(delivered), nil
+}
 
-		inflightAt, err := time.Parse(time.RFC3339Nano, timestampStr)
+// PendingCommands lists up to limit of agentID's inflight commands, then its
+// queued ones in delivery order, priority first.
+func (q *RedisQueue) PendingCommands(ctx context.Context, agentID string, limit int) ([]contracts.PendingCommand, error) {
+	var out []contracts.PendingCommand
+	for _, list := range []struct {
+		key      string
+		inflight bool
+	}{
+		{q.inflightKey(agentID), true},
+		{q.priorityQueueKey(agentID), false},
+		{q.queueKey(agentID), false},
+	} {
+		items, err := q.client.LRange(ctx, list.key, 0, -1)
 		if err != nil {
-			continue // Skip malformed timestamp
+			return nil, fmt.Errorf("lrange: %w", err)
 		}
-
-		if inflightAt.Before(cutoff) {
-			// Found stale command - it's eligible for redelivery
-			// Track the oldest one
-			if oldestStale == nil || inflightAt.Before(oldestInflightAt) {
-				oldestStale = &cmd
-				oldestInflightAt = inflightAt
+		// Commands are pushed at the head and taken from the tail.
+		for i := len(items) - 1; i >= 0 && len(out) < limit; i-- {
+			var cmd contracts.Command
+			if err := json.Unmarshal([]byte(items[i]), &cmd); err != nil {
+				continue // Skip malformed entries
 			}
+			out = append(out, pendingCommand(cmd, list.inflight))
 		}
 	}
+	return out, nil
+}
 
-	if oldestStale != nil {
-		// Update inflight timestamp and return it
-		// The command stays in the inflight list - this ensures consistent state
-		if err := q.setInflightTimestamp(ctx, agentID, oldestStale.CommandID); err != nil {
-			return nil, err
+// DeadLetters returns commands that exceeded the delivery attempt limit, oldest first.
+func (q *RedisQueue) DeadLetters(ctx context.Context, agentID string) ([]contracts.Command, error) {
+	if agentID == "" {
+		return nil, errors.New("agentID is required")
+	}
+	items, err := q.client.LRange(ctx, q.deadLetterKey(agentID), 0, -1)
+	if err != nil {
+		return nil, fmt.Errorf("lrange dlq: %w", err)
+	}
+	out := make([]contracts.Command, 0, len(items))
+	// LPUSH puts the newest entry at the head, so walk from the tail.
+	for i := len(items) - 1; i >= 0; i-- {
+		var cmd contracts.Command
+		if err := json.Unmarshal([]byte(items[i]), &cmd); err != nil {
+			continue // Skip malformed entries
 		}
-		return oldestStale, nil
+		out = append(out, cmd)
 	}
+	return out, nil
+}
 
-	return nil, nil
+// findStaleInflight claims the oldest inflight command older than redeliveryTTL, if any.
+// Stale commands that already used up their delivery attempts are moved to the dead-letter list.
+// The scan and claim run as one script, so concurrent polls never claim the same command.
+func (q *RedisQueue) findStaleInflight(ctx context.Context, agentID string) (*contracts.Command, error) {
+	now := q.now().UTC()
+	keys := []string{q.inflightKey(agentID), q.inflightAtKey(agentID), q.attemptsKey(agentID), q.deadLetterKey(agentID)}
+	reply, err := q.client.Eval(ctx, claimStaleInflightScript, keys,
+		now.Add(-q.redeliveryTTL).Format(inflightAtLayout), now.Format(inflightAtLayout),
+		q.maxAttempts, (q.redeliveryTTL * 2).Milliseconds())
+	if err != nil {
+		if err.Error() == "redis: nil" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("claim stale inflight: %w", err)
+	}
+	item, ok := reply.(string)
+	if !ok {
+		return nil, fmt.Errorf("claim stale inflight: unexpected reply %T", reply)
+	}
+	var cmd contracts.Command
+	if err := json.Unmarshal([]byte(item), &cmd); err != nil {
+		return nil, fmt.Errorf("unmarshal command: %w", err)
+	}
+	return &cmd, nil
 }
 
 // removeFromInflight removes a command by CommandID from the inflight list
@@ -557,8 +1056,48 @@ func (q *RedisQueue) removeFromInflight(ctx context.Context, agentID, commandID
 
 func (q *RedisQueue) setInflightTimestamp(ctx context.Context, agentID, commandID string) error {
 	key := q.inflightAtKey(agentID)
-	if err := q.client.HSet(ctx, key, commandID, q.now().UTC().Format(time.RFC3339Nano)); err != nil {
+	if err := q.client.HSet(ctx, key, commandID, q.now().UTC().Format(inflightAtLayout)); err != nil {
+		return err
+	}
+	if err := q.client.Expire(ctx, key, q.redeliveryTTL*2); err != nil {
+		return err
+	}
+	attempts, err := q.deliveryAttempts(ctx, agentID, commandID)
+	if err != nil {
+		return err
+	}
+	return q.client.HSet(ctx, q.attemptsKey(agentID), commandID, strconv.Itoa(attempts+1))
+}
+
+// touchInflight restamps a command's delivery time so a run that is still
+// reporting progress is not redelivered. Commands no longer inflight are left
+// alone, and the attempt counter is unchanged.
+func (q *RedisQueue) touchInflight(ctx context.Context, agentID, commandID string) error {
+	key := q.inflightAtKey(agentID)
+	if _, err := q.client.HGet(ctx, key, commandID); err != nil {
+		if err.Error() == "redis: nil" {
+			return nil
+		}
+		return fmt.Errorf("hget inflight_at: %w", err)
+	}
+	if err := q.client.HSet(ctx, key, commandID, q.now().UTC().Format(inflightAtLayout)); err != nil {
 		return err
 	}
 	return q.client.Expire(ctx, key, q.redeliveryTTL*2)
 }
+
+// deliveryAttempts returns how many times a command has been delivered so far.
+func (q *RedisQueue) deliveryAttempts(ctx context.Context, agentID, commandID string) (int, error) {
+	raw, err := q.client.HGet(ctx, q.attemptsKey(agentID), commandID)
+	if err != nil {
+		if err.Error() == "redis: nil" {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("hget attempts: %w", err)
+	}
+	attempts, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, nil // Treat malformed counter as fresh
+	}
+	return attempts, nil
+}
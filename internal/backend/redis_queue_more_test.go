@@ -71,7 +71,7 @@ func TestRedisQueue_ErrorPaths(t *testing.T) {
 
 func TestRedisQueue_MarshalAndGetBranches(t *testing.T) {
 	s := &stubRedisClient{
-		lrangeFn: func(ctx context.Context, key string, start, stop int64) ([]string, error) {
+		evalFn: func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
 			return nil, errors.New("boom")
 		},
 	}
@@ -79,6 +79,12 @@ func TestRedisQueue_MarshalAndGetBranches(t *testing.T) {
 	if _, err := q.Poll(context.Background(), "a1", 1); err == nil {
 		t.Fatal("expected poll stale lookup error")
 	}
+	s.evalFn = func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+		return int64(1), nil
+	}
+	if _, err := q.Poll(context.Background(), "a1", 1); err == nil {
+		t.Fatal("expected unexpected reply error")
+	}
 
 	s = &stubRedisClient{
 		lrangeFn: func(ctx context.Context, key string, start, stop int64) ([]string, error) { return []string{}, nil },
@@ -114,6 +120,7 @@ func TestRedisQueue_MarshalAndGetBranches(t *testing.T) {
 
 type stubRedisClient struct {
 	lpushFn      func(ctx context.Context, key string, values ...interface{}) error
+	pingFn       func(ctx context.Context) error
 	brpoplpushFn func(ctx context.Context, source, destination string, timeout time.Duration) (string, error)
 	lrangeFn     func(ctx context.Context, key string, start, stop int64) ([]string, error)
 	lremFn       func(ctx context.Context, key string, count int64, value interface{}) error
@@ -124,6 +131,31 @@ type stubRedisClient struct {
 	hgetFn       func(ctx context.Context, key, field string) (string, error)
 	hdelFn       func(ctx context.Context, key string, fields ...string) error
 	expireFn     func(ctx context.Context, key string, expiration time.Duration) error
+	publishFn    func(ctx context.Context, channel string, message interface{}) error
+	subscribeFn  func(ctx context.Context, channel string) (<-chan string, func() error, error)
+	evalFn       func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+func (s *stubRedisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	if s.evalFn != nil {
+		return s.evalFn(ctx, script, keys, args...)
+	}
+	return nil, errors.New("redis: nil")
+}
+
+func (s *stubRedisClient) Publish(ctx context.Context, channel string, message interface{}) error {
+	if s.publishFn != nil {
+		return s.publishFn(ctx, channel, message)
+	}
+	return nil
+}
+
+func (s *stubRedisClient) Subscribe(ctx context.Context, channel string) (<-chan string, func() error, error) {
+	if s.subscribeFn != nil {
+		return s.subscribeFn(ctx, channel)
+	}
+	ch := make(chan string)
+	return ch, func() error { return nil }, nil
 }
 
 func (s *stubRedisClient) LPush(ctx context.Context, key string, values ...interface{}) error {
@@ -140,6 +172,10 @@ func (s *stubRedisClient) BRPopLPush(ctx context.Context, source, destination st
 	return "", errors.New("redis: nil")
 }
 
+func (s *stubRedisClient) RPopLPush(ctx context.Context, source, destination string) (string, error) {
+	return "", errors.New("redis: nil")
+}
+
 func (s *stubRedisClient) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
 	if s.lrangeFn != nil {
 		return s.lrangeFn(ctx, key, start, stop)
@@ -189,6 +225,13 @@ func (s *stubRedisClient) HGet(ctx context.Context, key, field string) (string,
 	return "", errors.New("redis: nil")
 }
 
+func (s *stubRedisClient) Ping(ctx context.Context) error {
+	if s.pingFn != nil {
+		return s.pingFn(ctx)
+	}
+	return nil
+}
+
 func (s *stubRedisClient) HDel(ctx context.Context, key string, fields ...string) error {
 	if s.hdelFn != nil {
 		return s.hdelFn(ctx, key, fields...)
@@ -2,6 +2,11 @@ package backend
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -27,7 +32,7 @@ func TestRedisQueueRedelivery(t *testing.T) {
 	// Create a test command
 	cmd := contracts.Command{
 		CommandID:      "cmd-001",
-		IdempotencyKey: "key-001",
+		IdempotencyKey: "key-001-",
 		Type:           contracts.CommandTypeStatus,
 		CreatedAt:      clk.now,
 		Payload:        []byte(`{}`),
@@ -116,9 +121,9 @@ func TestRedisQueueMultipleCommands(t *testing.T) {
 
 	// Enqueue multiple commands
 	commands := []contracts.Command{
-		{CommandID: "cmd-001", IdempotencyKey: "key-001", Type: contracts.CommandTypeStatus, CreatedAt: clk.now, Payload: []byte(`{}`)},
-		{CommandID: "cmd-002", IdempotencyKey: "key-002", Type: contracts.CommandTypeStatus, CreatedAt: clk.now, Payload: []byte(`{}`)},
-		{CommandID: "cmd-003", IdempotencyKey: "key-003", Type: contracts.CommandTypeStatus, CreatedAt: clk.now, Payload: []byte(`{}`)},
+		{CommandID: "cmd-001", IdempotencyKey: "key-001-", Type: contracts.CommandTypeStatus, CreatedAt: clk.now, Payload: []byte(`{}`)},
+		{CommandID: "cmd-002", IdempotencyKey: "key-002-", Type: contracts.CommandTypeStatus, CreatedAt: clk.now, Payload: []byte(`{}`)},
+		{CommandID: "cmd-003", IdempotencyKey: "key-003-", Type: contracts.CommandTypeStatus, CreatedAt: clk.now, Payload: []byte(`{}`)},
 	}
 
 	for _, cmd := range commands {
@@ -151,6 +156,94 @@ func TestRedisQueueMultipleCommands(t *testing.T) {
 	}
 }
 
+func TestQueuesDeliverHighPriorityFirst(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
+	queues := map[string]CommandQueue{"memory": NewMemoryBackend(), "redis": NewRedisQueue(NewInMemoryRedisClient())}
+	for name, queue := range queues {
+		t.Run(name, func(t *testing.T) {
+			cmd := func(id, typ string, priority int) contracts.Command {
+				return contracts.Command{CommandID: id, IdempotencyKey: "key-" + id, Type: typ, CreatedAt: now, Payload: []byte(`{}`), Priority: priority}
+			}
+			for _, c := range []contracts.Command{
+				cmd("run-1", contracts.CommandTypeRunTask, contracts.PriorityNormal),
+				cmd("run-2", contracts.CommandTypeRunTask, contracts.PriorityNormal),
+				cmd("status-1", contracts.CommandTypeStatus, contracts.PriorityHigh),
+				cmd("status-2", contracts.CommandTypeStatus, contracts.PriorityHigh),
+				cmd("run-3", contracts.CommandTypeRunTask, contracts.PriorityNormal),
+			} {
+				if err := queue.Enqueue(ctx, "agent-prio", c); err != nil {
+					t.Fatalf("enqueue %s: %v", c.CommandID, err)
+				}
+			}
+			var got []string
+			for i := 0; i < 5; i++ {
+				polled, err := queue.Poll(ctx, "agent-prio", 1)
+				if err != nil || polled == nil {
+					t.Fatalf("poll %d: cmd=%v err=%v", i, polled, err)
+				}
+				got = append(got, polled.CommandID)
+			}
+			if strings.Join(got, ",") != "status-1,status-2,run-1,run-2,run-3" {
+				t.Fatalf("unexpected delivery order: %v", got)
+			}
+		})
+	}
+}
+
+func TestQueuesEnqueueBatch(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
+	queues := map[string]CommandQueue{"memory": NewMemoryBackend(), "redis": NewRedisQueue(NewInMemoryRedisClient())}
+	for name, queue := range queues {
+		t.Run(name, func(t *testing.T) {
+			cmd := func(id, typ string, priority int) contracts.Command {
+				return contracts.Command{CommandID: id, IdempotencyKey: "key-" + id, Type: typ, CreatedAt: now, Payload: []byte(`{}`), Priority: priority}
+			}
+			if err := queue.Enqueue(ctx, "agent-batch", cmd("status-0", contracts.CommandTypeStatus, contracts.PriorityNormal)); err != nil {
+				t.Fatalf("enqueue: %v", err)
+			}
+			if err := queue.EnqueueBatch(ctx, "agent-batch", []contracts.Command{
+				cmd("status-1", contracts.CommandTypeStatus, contracts.PriorityNormal),
+				cmd("status-2", contracts.CommandTypeStatus, contracts.PriorityHigh),
+				cmd("status-3", contracts.CommandTypeStatus, contracts.PriorityNormal),
+			}); err != nil {
+				t.Fatalf("enqueue batch: %v", err)
+			}
+
+			// an invalid command rejects the whole batch
+			invalid := cmd("status-5", contracts.CommandTypeStatus, contracts.PriorityNormal)
+			invalid.IdempotencyKey = ""
+			err := queue.EnqueueBatch(ctx, "agent-batch", []contracts.Command{cmd("status-4", contracts.CommandTypeStatus, contracts.PriorityHigh), invalid})
+			var apiErr contracts.APIError
+			if !errors.As(err, &apiErr) || !strings.HasPrefix(apiErr.Message, "commands[1]:") {
+				t.Fatalf("expected batch rejected at commands[1], got %v", err)
+			}
+			if err := queue.EnqueueBatch(ctx, "", nil); err == nil {
+				t.Fatal("expected agentID error")
+			}
+
+			var got []string
+			for {
+				polled, err := queue.Poll(ctx, "agent-batch", 0)
+				if err != nil {
+					t.Fatalf("poll: %v", err)
+				}
+				if polled == nil {
+					break
+				}
+				got = append(got, polled.CommandID)
+				if err := queue.StoreResult(ctx, "agent-batch", contracts.CommandResult{CommandID: polled.CommandID, OK: true}); err != nil {
+					t.Fatalf("store result: %v", err)
+				}
+			}
+			if strings.Join(got, ",") != "status-2,status-0,status-1,status-3" {
+				t.Fatalf("unexpected delivery order: %v", got)
+			}
+		})
+	}
+}
+
 // TestRedisQueueStoreResultRemovesFromInflight tests that storing result removes from inflight
 func TestRedisQueueStoreResultRemovesFromInflight(t *testing.T) {
 	clk := &testClock{now: time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)}
@@ -164,7 +257,7 @@ func TestRedisQueueStoreResultRemovesFromInflight(t *testing.T) {
 
 	cmd := contracts.Command{
 		CommandID:      "cmd-001",
-		IdempotencyKey: "key-001",
+		IdempotencyKey: "key-001-",
 		Type:           contracts.CommandTypeStatus,
 		CreatedAt:      clk.now,
 		Payload:        []byte(`{}`),
@@ -205,3 +298,426 @@ func TestRedisQueueStoreResultRemovesFromInflight(t *testing.T) {
 		t.Fatalf("after store poll: expected nil, got command_id %s", afterStore.CommandID)
 	}
 }
+
+func TestRedisQueueResultTTL(t *testing.T) {
+	clk := &testClock{now: time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)}
+	client := NewInMemoryRedisClient()
+	client.SetClock(clk.Now)
+	queue := NewRedisQueue(client)
+	queue.SetClock(clk.Now)
+	ctx := context.Background()
+
+	store := func(commandID string) time.Time {
+		t.Helper()
+		if err := queue.StoreResult(ctx, "agent-ttl", contracts.CommandResult{CommandID: commandID, OK: true}); err != nil {
+			t.Fatalf("store result: %v", err)
+		}
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.expiries[queue.resultKey("agent-ttl", commandID)]
+	}
+
+	if got := store("c-default"); !got.Equal(clk.now.Add(DefaultResultTTL)) {
+		t.Fatalf("expected default 14 day TTL, got expiry %v", got)
+	}
+	queue.SetResultTTL(time.Hour)
+	if got := store("c-short"); !got.Equal(clk.now.Add(time.Hour)) {
+		t.Fatalf("expected configured TTL, got expiry %v", got)
+	}
+	clk.now = clk.now.Add(time.Hour + time.Second)
+	if res, err := queue.GetResult(ctx, "agent-ttl", "c-short"); err != nil || res != nil {
+		t.Fatalf("expected result expired after the TTL, got %+v err=%v", res, err)
+	}
+	queue.SetResultTTL(0)
+	if queue.resultTTL != DefaultResultTTL {
+		t.Fatalf("expected non-positive TTL to restore the default, got %v", queue.resultTTL)
+	}
+}
+
+func TestRedisQueueStoreProgress(t *testing.T) {
+	clk := &testClock{now: time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)}
+	client := NewInMemoryRedisClient()
+	client.SetClock(clk.Now)
+	queue := NewRedisQueue(client)
+	queue.SetClock(clk.Now)
+	ctx := context.Background()
+
+	cmd := contracts.Command{CommandID: "cmd-p", IdempotencyKey: "key-p-00", Type: contracts.CommandTypeStatus, CreatedAt: clk.now, Payload: []byte(`{}`)}
+	if err := queue.Enqueue(ctx, "agent-p", cmd); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if _, err := queue.Poll(ctx, "agent-p", 5); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+
+	ch, err := queue.Subscribe(ctx, "agent-p", "cmd-p")
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	if err := queue.StoreResult(ctx, "agent-p", contracts.CommandResult{CommandID: "cmd-p", InProgress: true, Stdout: "step"}); err != nil {
+		t.Fatalf("store progress: %v", err)
+	}
+	if res := <-ch; !res.InProgress || res.Stdout != "step" {
+		t.Fatalf("expected progress first, got %+v", res)
+	}
+	if got, _ := queue.GetResult(ctx, "agent-p", "cmd-p"); got == nil || !got.InProgress {
+		t.Fatalf("expected progress stored, got %+v", got)
+	}
+
+	// progress does not complete the command, so it is still redelivered
+	clk.now = clk.now.Add(121 * time.Second)
+	redelivered, err := queue.Poll(ctx, "agent-p", 5)
+	if err != nil || redelivered == nil || redelivered.CommandID != "cmd-p" {
+		t.Fatalf("expected command still inflight, got %+v err=%v", redelivered, err)
+	}
+
+	if err := queue.StoreResult(ctx, "agent-p", contracts.CommandResult{CommandID: "cmd-p", OK: true, Summary: "done"}); err != nil {
+		t.Fatalf("store final: %v", err)
+	}
+	// the stored progress may be seen again before the final result
+	res := <-ch
+	for res.InProgress {
+		res = <-ch
+	}
+	if res.Summary != "done" {
+		t.Fatalf("expected final result, got %+v", res)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("expected channel closed after final result")
+	}
+
+	if err := queue.StoreResult(ctx, "agent-p", contracts.CommandResult{CommandID: "cmd-p", InProgress: true, Stdout: "late"}); err != nil {
+		t.Fatalf("store late progress: %v", err)
+	}
+	if got, _ := queue.GetResult(ctx, "agent-p", "cmd-p"); got == nil || got.InProgress || got.Summary != "done" {
+		t.Fatalf("expected final result kept, got %+v", got)
+	}
+}
+
+// TestRedisQueueDeadLetterAfterMaxAttempts tests that a command redelivered too often is dead-lettered
+func TestRedisQueueDeadLetterAfterMaxAttempts(t *testing.T) {
+	clk := &testClock{now: time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)}
+	client := NewInMemoryRedisClient()
+	client.SetClock(clk.Now)
+
+	queue := NewRedisQueue(client)
+	queue.SetClock(clk.Now)
+	queue.SetMaxAttempts(2)
+	agentID := "agent-dlq"
+	ctx := context.Background()
+
+	cmd := contracts.Command{
+		CommandID:      "cmd-poison",
+		IdempotencyKey: "key-poison",
+		Type:           contracts.CommandTypeStatus,
+		CreatedAt:      clk.now,
+		Payload:        []byte(`{}`),
+	}
+	if err := queue.Enqueue(ctx, agentID, cmd); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	// Delivery 1 (fresh) and 2 (redelivery) are allowed.
+	for i := 1; i <= 2; i++ {
+		polled, err := queue.Poll(ctx, agentID, 1)
+		if err != nil {
+			t.Fatalf("poll %d: %v", i, err)
+		}
+		if polled == nil || polled.CommandID != cmd.CommandID {
+			t.Fatalf("poll %d: expected %s, got %+v", i, cmd.CommandID, polled)
+		}
+		attempts, _ := client.HGet(ctx, "oct:attempts:"+agentID, cmd.CommandID)
+		if attempts != strconv.Itoa(i) {
+			t.Fatalf("poll %d: expected attempts %d, got %q", i, i, attempts)
+		}
+		clk.now = clk.now.Add(121 * time.Second)
+	}
+
+	// Third delivery exceeds the limit: command is dead-lettered instead.
+	polled, err := queue.Poll(ctx, agentID, 1)
+	if err != nil {
+		t.Fatalf("final poll: %v", err)
+	}
+	if polled != nil {
+		t.Fatalf("expected no redelivery after max attempts, got %+v", polled)
+	}
+	inflight, _ := client.LRange(ctx, "oct:inflight:"+agentID, 0, -1)
+	if len(inflight) != 0 {
+		t.Fatalf("expected inflight to be empty, got %v", inflight)
+	}
+	if _, err := client.HGet(ctx, "oct:attempts:"+agentID, cmd.CommandID); err == nil {
+		t.Fatal("expected attempts counter to be cleared")
+	}
+
+	dead, err := queue.DeadLetters(ctx, agentID)
+	if err != nil {
+		t.Fatalf("dead letters: %v", err)
+	}
+	if len(dead) != 1 || dead[0].CommandID != cmd.CommandID {
+		t.Fatalf("expected dead-lettered command, got %+v", dead)
+	}
+	if _, err := queue.DeadLetters(ctx, ""); err == nil {
+		t.Fatal("expected empty agent id error")
+	}
+}
+
+// TestRedisQueueStaleClaimedOnce tests that concurrent polls redeliver a stale command exactly once
+func TestRedisQueueStaleClaimedOnce(t *testing.T) {
+	clk := &testClock{now: time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)}
+	client := NewInMemoryRedisClient()
+	client.SetClock(clk.Now)
+	queue := NewRedisQueue(client)
+	queue.SetClock(clk.Now)
+	agentID := "agent-race"
+	ctx := context.Background()
+
+	cmd := contracts.Command{CommandID: "cmd-race", IdempotencyKey: "key-race", Type: contracts.CommandTypeStatus, CreatedAt: clk.now, Payload: []byte(`{}`)}
+	if err := queue.Enqueue(ctx, agentID, cmd); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if polled, err := queue.Poll(ctx, agentID, 0); err != nil || polled == nil {
+		t.Fatalf("first poll: %+v %v", polled, err)
+	}
+	clk.now = clk.now.Add(121 * time.Second)
+
+	var wg sync.WaitGroup
+	var claims int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			polled, err := queue.Poll(ctx, agentID, 0)
+			if err != nil {
+				t.Errorf("poll: %v", err)
+				return
+			}
+			if polled != nil && polled.CommandID == cmd.CommandID {
+				atomic.AddInt32(&claims, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if claims != 1 {
+		t.Fatalf("expected the stale command to be claimed once, got %d", claims)
+	}
+	if attempts, _ := client.HGet(ctx, "oct:attempts:"+agentID, cmd.CommandID); attempts != "2" {
+		t.Fatalf("expected two delivery attempts, got %q", attempts)
+	}
+	if _, err := client.Eval(ctx, "return 1", nil); err == nil {
+		t.Fatal("expected unsupported script error from the in-memory client")
+	}
+}
+
+// TestRedisQueueStoreResultClearsAttempts tests that acknowledged commands drop their delivery counter
+func TestRedisQueueStoreResultClearsAttempts(t *testing.T) {
+	client := NewInMemoryRedisClient()
+	queue := NewRedisQueue(client)
+	ctx := context.Background()
+	cmd := contracts.Command{CommandID: "cmd-ok", IdempotencyKey: "k-000000", Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: []byte(`{}`)}
+	if err := queue.Enqueue(ctx, "a1", cmd); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if _, err := queue.Poll(ctx, "a1", 1); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if err := queue.StoreResult(ctx, "a1", contracts.CommandResult{CommandID: "cmd-ok", OK: true}); err != nil {
+		t.Fatalf("store result: %v", err)
+	}
+	if _, err := client.HGet(ctx, "oct:attempts:a1", "cmd-ok"); err == nil {
+		t.Fatal("expected attempts counter to be cleared after result")
+	}
+}
+
+func TestRedisQueueSubscribe(t *testing.T) {
+	ctx := context.Background()
+	q := NewRedisQueue(NewInMemoryRedisClient())
+
+	if _, err := q.Subscribe(ctx, "", "c1"); err == nil {
+		t.Fatal("expected error for empty agent id")
+	}
+	if _, err := q.Subscribe(ctx, "agent-1", ""); err == nil {
+		t.Fatal("expected error for empty command id")
+	}
+
+	// published after subscribing; results for other commands are skipped
+	ch, err := q.Subscribe(ctx, "agent-1", "c2")
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	if err := q.StoreResult(ctx, "agent-1", contracts.CommandResult{CommandID: "c1", OK: true}); err != nil {
+		t.Fatalf("store c1: %v", err)
+	}
+	if err := q.StoreResult(ctx, "agent-1", contracts.CommandResult{CommandID: "c2", OK: true, Summary: "second"}); err != nil {
+		t.Fatalf("store c2: %v", err)
+	}
+	select {
+	case res := <-ch:
+		if res.CommandID != "c2" || res.Summary != "second" {
+			t.Fatalf("unexpected result: %+v", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for published result")
+	}
+
+	// already stored before subscribing
+	early, err := q.Subscribe(ctx, "agent-1", "c1")
+	if err != nil {
+		t.Fatalf("subscribe stored: %v", err)
+	}
+	if res, ok := <-early; !ok || res.CommandID != "c1" {
+		t.Fatalf("expected stored result, got %+v ok=%v", res, ok)
+	}
+
+	// cancellation closes the channel
+	cctx, cancel := context.WithCancel(ctx)
+	pending, err := q.Subscribe(cctx, "agent-1", "c3")
+	if err != nil {
+		t.Fatalf("subscribe pending: %v", err)
+	}
+	cancel()
+	select {
+	case _, ok := <-pending:
+		if ok {
+			t.Fatal("expected closed channel after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+}
+
+func TestRedisQueueRedeliversLegacyInflightStamp(t *testing.T) {
+	clk := &testClock{now: time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)}
+	client := NewInMemoryRedisClient()
+	client.SetClock(clk.Now)
+	queue := NewRedisQueue(client)
+	queue.SetClock(clk.Now)
+	ctx := context.Background()
+	cmd := contracts.Command{CommandID: "cmd-old", IdempotencyKey: "key-old", Type: contracts.CommandTypeStatus, CreatedAt: clk.now, Payload: []byte(`{}`)}
+	if err := queue.Enqueue(ctx, "agent-1", cmd); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if got, err := queue.Poll(ctx, "agent-1", 0); err != nil || got == nil {
+		t.Fatalf("first poll: %+v err=%v", got, err)
+	}
+
+	// RFC3339Nano drops a zero fraction, so "...:00Z" sorts after the
+	// fixed-width cutoff "...:00.500000000Z" unless it is normalized.
+	if err := client.HSet(ctx, queue.inflightAtKey("agent-1"), "cmd-old", clk.now.Format(time.RFC3339Nano)); err != nil {
+		t.Fatalf("hset: %v", err)
+	}
+	clk.now = clk.now.Add(DefaultRedeliveryTTL + 500*time.Millisecond)
+	if got, err := queue.Poll(ctx, "agent-1", 0); err != nil || got == nil || got.CommandID != "cmd-old" {
+		t.Fatalf("expected legacy stamp to be redelivered, got %+v err=%v", got, err)
+	}
+	if at, _ := client.HGet(ctx, queue.inflightAtKey("agent-1"), "cmd-old"); at != clk.now.Format(inflightAtLayout) {
+		t.Fatalf("expected the claim to restamp in the fixed layout, got %q", at)
+	}
+}
+
+func TestRedisQueueSetRedeliveryTTL(t *testing.T) {
+	clk := &testClock{now: time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)}
+	client := NewInMemoryRedisClient()
+	client.SetClock(clk.Now)
+	queue := NewRedisQueue(client)
+	queue.SetClock(clk.Now)
+	ctx := context.Background()
+
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		if err := queue.SetRedeliveryTTL(ttl); err == nil {
+			t.Fatalf("expected TTL %s to be rejected", ttl)
+		}
+	}
+	if err := queue.SetRedeliveryTTL(15 * time.Minute); err != nil {
+		t.Fatalf("set redelivery TTL: %v", err)
+	}
+
+	cmd := contracts.Command{CommandID: "cmd-long", IdempotencyKey: "key-long", Type: contracts.CommandTypeStatus, CreatedAt: clk.now, Payload: []byte(`{}`)}
+	if err := queue.Enqueue(ctx, "agent-long", cmd); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if polled, err := queue.Poll(ctx, "agent-long", 0); err != nil || polled == nil {
+		t.Fatalf("first poll: cmd=%+v err=%v", polled, err)
+	}
+
+	// A 10-minute build is past the default TTL but within the configured one.
+	clk.now = clk.now.Add(10 * time.Minute)
+	if polled, err := queue.Poll(ctx, "agent-long", 0); err != nil || polled != nil {
+		t.Fatalf("expected no redelivery within the TTL, got cmd=%+v err=%v", polled, err)
+	}
+
+	clk.now = clk.now.Add(6 * time.Minute)
+	if polled, err := queue.Poll(ctx, "agent-long", 0); err != nil || polled == nil || polled.CommandID != "cmd-long" {
+		t.Fatalf("expected redelivery once the TTL passed, got cmd=%+v err=%v", polled, err)
+	}
+}
+
+func TestRedisQueueProgressDefersRedelivery(t *testing.T) {
+	clk := &testClock{now: time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)}
+	client := NewInMemoryRedisClient()
+	client.SetClock(clk.Now)
+	queue := NewRedisQueue(client)
+	queue.SetClock(clk.Now)
+	ctx := context.Background()
+	cmd := contracts.Command{CommandID: "cmd-long", IdempotencyKey: "key-long-0", Type: contracts.CommandTypeStatus, CreatedAt: clk.now, Payload: []byte(`{}`)}
+	if err := queue.Enqueue(ctx, "agent-1", cmd); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if got, err := queue.Poll(ctx, "agent-1", 0); err != nil || got == nil {
+		t.Fatalf("first poll: %+v err=%v", got, err)
+	}
+
+	clk.now = clk.now.Add(100 * time.Second)
+	if err := queue.StoreResult(ctx, "agent-1", contracts.CommandResult{CommandID: "cmd-long", InProgress: true, Stdout: "step"}); err != nil {
+		t.Fatalf("store progress: %v", err)
+	}
+	clk.now = clk.now.Add(100 * time.Second)
+	if got, err := queue.Poll(ctx, "agent-1", 0); err != nil || got != nil {
+		t.Fatalf("expected no redelivery while progressing, got %+v err=%v", got, err)
+	}
+	if attempts, _ := queue.deliveryAttempts(ctx, "agent-1", "cmd-long"); attempts != 1 {
+		t.Fatalf("expected progress not to count a delivery, got %d", attempts)
+	}
+	clk.now = clk.now.Add(30 * time.Second)
+	if got, err := queue.Poll(ctx, "agent-1", 0); err != nil || got == nil || got.CommandID != "cmd-long" {
+		t.Fatalf("expected redelivery once progress stops, got %+v err=%v", got, err)
+	}
+
+	// progress for a command that is no longer inflight stamps nothing
+	if err := queue.StoreResult(ctx, "agent-1", contracts.CommandResult{CommandID: "cmd-long", OK: true}); err != nil {
+		t.Fatalf("store final: %v", err)
+	}
+	if err := queue.StoreResult(ctx, "agent-1", contracts.CommandResult{CommandID: "other", InProgress: true}); err != nil {
+		t.Fatalf("store stray progress: %v", err)
+	}
+	if _, err := client.HGet(ctx, queue.inflightAtKey("agent-1"), "other"); err == nil {
+		t.Fatal("expected no inflight stamp for a command that was never delivered")
+	}
+}
+
+func TestRedisQueuePollWakesForPriorityCommand(t *testing.T) {
+	queue := NewRedisQueue(NewInMemoryRedisClient())
+	ctx := context.Background()
+	type polled struct {
+		cmd *contracts.Command
+		err error
+	}
+	done := make(chan polled, 1)
+	start := time.Now()
+	go func() {
+		cmd, err := queue.Poll(ctx, "agent-1", 5)
+		done <- polled{cmd, err}
+	}()
+	// the agent is already blocked on the normal list when status arrives
+	time.Sleep(200 * time.Millisecond)
+	status := contracts.Command{CommandID: "cmd-status", IdempotencyKey: "key-status", Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC(), Payload: []byte(`{}`), Priority: 1}
+	if err := queue.Enqueue(ctx, "agent-1", status); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	got := <-done
+	if got.err != nil || got.cmd == nil || got.cmd.CommandID != "cmd-status" {
+		t.Fatalf("expected priority command, got %+v err=%v", got.cmd, got.err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected priority command within about a second, took %s", elapsed)
+	}
+}
@@ -1,9 +1,34 @@
 package bot
 
 import (
+	"fmt"
+	"log"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	DefaultDebounceMillis = 500
+	MinDebounceMillis     = 100
+	// DefaultMaxRunsPerUser caps how many runs one user may have going at
+	// once unless Config.MaxRunsPerUser overrides it.
+	DefaultMaxRunsPerUser = 3
+	// DefaultMaxPromptChars caps the characters in one prompt unless
+	// Config.MaxPromptChars overrides it.
+	DefaultMaxPromptChars = 16000
+	// DefaultMaxOutputChars caps each output stream shown with a result
+	// unless Config.MaxOutputChars overrides it.
+	DefaultMaxOutputChars = 2048
+	// DefaultEditRetryMax and DefaultEditRetryBaseDelay bound retries of
+	// rate-limited Telegram requests unless Config overrides them.
+	DefaultEditRetryMax       = 3
+	DefaultEditRetryBaseDelay = 100 * time.Millisecond
+	// DefaultResultPollTimeout and DefaultResultPollInterval bound the
+	// fallback polling for a result when the backend cannot stream it.
+	DefaultResultPollTimeout  = 2 * time.Second
+	DefaultResultPollInterval = 200 * time.Millisecond
 )
 
 type Config struct {
@@ -12,28 +37,143 @@ type Config struct {
 	OpencodeAuth  string
 	AllowedIDs    map[int64]bool
 	AdminIDs      map[int64]bool
+	// AccessFile optionally holds ALLOWED_TELEGRAM_IDS and ADMIN_TELEGRAM_IDS
+	// lines that override the env; BotApp.ReloadConfig re-reads it.
+	AccessFile    string
 	RedisURL      string
 	TelegramMode  string
 	Port          string
 	SessionPrefix string
 	BackendURL    string
+	// CaseInsensitivePrefix matches SessionPrefix against session titles
+	// ignoring case; titles the bot creates still use SessionPrefix as is.
+	CaseInsensitivePrefix bool
+	// TelegramAPIEndpoint is the base URL of a Bot API server, such as a
+	// self-hosted one or a test double; empty uses api.telegram.org.
+	TelegramAPIEndpoint string
+	// DebounceMillis is the delay used to coalesce Telegram message edits per
+	// session. Lower values make output feel more live but send more edits and
+	// risk Telegram rate limits; higher values batch more SSE updates into one
+	// edit at the cost of latency. Values below MinDebounceMillis are clamped.
+	DebounceMillis int
+	// MaxAttachmentBytes caps files sent with a task; zero uses
+	// contracts.DefaultMaxAttachmentBytes.
+	MaxAttachmentBytes int64
+	// OpencodeTimeout bounds each Opencode API request; zero uses the
+	// client's 30 second default.
+	OpencodeTimeout time.Duration
+	// OpencodeHeartbeatTimeout is how long the event stream may stay silent
+	// before it is reconnected; zero uses the client's 90 second default and
+	// a negative value disables the check.
+	OpencodeHeartbeatTimeout time.Duration
+	// OpencodeSessionCacheTTL is how long the Opencode session list is
+	// reused; zero uses the client's 5 second default and a negative value
+	// disables the cache.
+	OpencodeSessionCacheTTL time.Duration
+	// StoreSessionTTL expires the Redis store's session mappings, selections
+	// and recent-session lists this long after their last write; zero keeps
+	// them forever.
+	StoreSessionTTL time.Duration
+	// EventTypes replaces DefaultEventTypes as the Opencode events that
+	// update Telegram messages; empty keeps the defaults.
+	EventTypes []string
+	// HTTPMaxIdleConns and HTTPIdleTimeout tune the connection pool used for
+	// backend calls; zero uses the contracts defaults.
+	HTTPMaxIdleConns int
+	HTTPIdleTimeout  time.Duration
+	// MaxRunsPerUser caps one user's concurrent runs across all sessions and
+	// projects; zero uses DefaultMaxRunsPerUser.
+	MaxRunsPerUser int
+	// EditRetryMax is how many times a rate-limited Telegram request is
+	// attempted, and EditRetryBaseDelay the first backoff, doubled after each
+	// attempt. Zero uses DefaultEditRetryMax and DefaultEditRetryBaseDelay.
+	EditRetryMax       int
+	EditRetryBaseDelay time.Duration
+	// ResultPollTimeout bounds how long a result is polled for when the
+	// backend cannot stream it, and ResultPollInterval spaces the polls.
+	// Zero uses DefaultResultPollTimeout and DefaultResultPollInterval.
+	ResultPollTimeout  time.Duration
+	ResultPollInterval time.Duration
+	// MaxPromptChars is the longest prompt, in characters, that /run and
+	// /new accept; zero uses DefaultMaxPromptChars.
+	MaxPromptChars int
+	// MaxOutputChars caps the stdout and stderr shown with a result, each;
+	// zero uses DefaultMaxOutputChars. Longer results are split across
+	// messages.
+	MaxOutputChars int
+	// RequireHTTPS rejects OpencodeBase, BackendURL and TelegramAPIEndpoint
+	// unless they use https or point at localhost.
+	RequireHTTPS bool
 }
 
 func LoadConfig() *Config {
 	c := &Config{}
 	c.TelegramToken = os.Getenv("TELEGRAM_BOT_TOKEN")
+	c.TelegramAPIEndpoint = os.Getenv("TELEGRAM_API_ENDPOINT")
 	c.OpencodeBase = getenvOr("OPENCODE_BASE_URL", "http://localhost:4096")
 	c.OpencodeAuth = os.Getenv("OPENCODE_AUTH_TOKEN")
-	c.AllowedIDs = parseIDs(os.Getenv("ALLOWED_TELEGRAM_IDS"))
-	c.AdminIDs = parseIDs(os.Getenv("ADMIN_TELEGRAM_IDS"))
+	c.AccessFile = os.Getenv("OCT_ACCESS_FILE")
+	allowed, admin, err := loadAccessIDs(c.AccessFile)
+	if err != nil {
+		log.Printf("access file ignored: %v", err)
+		allowed, admin, _ = loadAccessIDs("")
+	}
+	c.AllowedIDs, c.AdminIDs = allowed, admin
 	c.RedisURL = os.Getenv("REDIS_URL")
 	c.TelegramMode = getenvOr("TELEGRAM_MODE", "polling")
 	c.Port = getenvOr("PORT", "3000")
 	c.SessionPrefix = getenvOr("SESSION_PREFIX", "oct_")
+	c.CaseInsensitivePrefix = getenvBool("SESSION_PREFIX_CASE_INSENSITIVE", true)
 	c.BackendURL = getenvOr("OCT_BACKEND_URL", "http://localhost:8080")
+	c.DebounceMillis = clampDebounceMillis(getenvInt("DEBOUNCE_MS", DefaultDebounceMillis))
+	c.MaxAttachmentBytes = int64(getenvInt("OCT_MAX_ATTACHMENT_BYTES", 0))
+	c.OpencodeTimeout = getenvDuration("OPENCODE_TIMEOUT", 0)
+	c.OpencodeHeartbeatTimeout = getenvDuration("OPENCODE_HEARTBEAT_TIMEOUT", 0)
+	c.OpencodeSessionCacheTTL = getenvDuration("OPENCODE_SESSION_CACHE_TTL", 0)
+	c.StoreSessionTTL = getenvDuration("OCT_STORE_SESSION_TTL", 0)
+	c.EventTypes = strings.FieldsFunc(os.Getenv("OCT_EVENT_TYPES"), func(r rune) bool { return r == ',' || r == ' ' })
+	c.HTTPMaxIdleConns = getenvInt("OCT_HTTP_MAX_IDLE_CONNS", 0)
+	c.HTTPIdleTimeout = getenvDuration("OCT_HTTP_IDLE_TIMEOUT", 0)
+	c.MaxRunsPerUser = getenvInt("OCT_MAX_RUNS_PER_USER", 0)
+	c.EditRetryMax = getenvInt("OCT_EDIT_RETRY_MAX", 0)
+	c.EditRetryBaseDelay = getenvDuration("OCT_EDIT_RETRY_BASE_DELAY", 0)
+	c.ResultPollTimeout = getenvDuration("OCT_RESULT_POLL_TIMEOUT", 0)
+	c.ResultPollInterval = getenvDuration("OCT_RESULT_POLL_INTERVAL", 0)
+	c.MaxPromptChars = getenvInt("OCT_MAX_PROMPT_CHARS", 0)
+	c.MaxOutputChars = getenvInt("OCT_MAX_OUTPUT_CHARS", 0)
+	c.RequireHTTPS = getenvBool("OCT_REQUIRE_HTTPS", true)
 	return c
 }
 
+// loadAccessIDs reads the allowed and admin ID lists from the env and, when
+// path is set, lets the file's KEY=VALUE lines override them. Blank lines
+// and lines starting with # are skipped.
+func loadAccessIDs(path string) (allowed, admin map[int64]bool, err error) {
+	values := map[string]string{
+		"ALLOWED_TELEGRAM_IDS": os.Getenv("ALLOWED_TELEGRAM_IDS"),
+		"ADMIN_TELEGRAM_IDS":   os.Getenv("ADMIN_TELEGRAM_IDS"),
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			key, value, ok := strings.Cut(line, "=")
+			key = strings.TrimSpace(key)
+			if _, known := values[key]; !ok || !known {
+				return nil, nil, fmt.Errorf("%s:%d: want ALLOWED_TELEGRAM_IDS=... or ADMIN_TELEGRAM_IDS=...", path, i+1)
+			}
+			values[key] = value
+		}
+	}
+	return parseIDs(values["ALLOWED_TELEGRAM_IDS"]), parseIDs(values["ADMIN_TELEGRAM_IDS"]), nil
+}
+
 func parseIDs(s string) map[int64]bool {
 	out := make(map[int64]bool)
 	s = strings.TrimSpace(s)
@@ -57,3 +197,60 @@ func getenvOr(key, def string) string {
 	}
 	return def
 }
+
+func getenvInt(key string, def int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+func getenvDuration(key string, def time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+func getenvBool(key string, def bool) bool {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+// hasSessionPrefix reports whether title starts with SessionPrefix, ignoring
+// case when CaseInsensitivePrefix is set.
+func (c *Config) hasSessionPrefix(title string) bool {
+	if c.CaseInsensitivePrefix {
+		return strings.HasPrefix(strings.ToLower(title), strings.ToLower(c.SessionPrefix))
+	}
+	return strings.HasPrefix(title, c.SessionPrefix)
+}
+
+// clampDebounceMillis maps unset values to the default and raises values below
+// the minimum so edits cannot be sent faster than Telegram tolerates.
+func clampDebounceMillis(ms int) int {
+	if ms <= 0 {
+		return DefaultDebounceMillis
+	}
+	if ms < MinDebounceMillis {
+		return MinDebounceMillis
+	}
+	return ms
+}
@@ -1,13 +1,17 @@
 package bot
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadConfig_WithEnvVars(t *testing.T) {
 	// backup and restore
-	keys := []string{"TELEGRAM_BOT_TOKEN", "OPENCODE_BASE_URL", "OPENCODE_AUTH_TOKEN", "ALLOWED_TELEGRAM_IDS", "ADMIN_TELEGRAM_IDS", "REDIS_URL", "TELEGRAM_MODE", "PORT", "SESSION_PREFIX"}
+	keys := []string{"TELEGRAM_BOT_TOKEN", "OPENCODE_BASE_URL", "OPENCODE_AUTH_TOKEN", "ALLOWED_TELEGRAM_IDS", "ADMIN_TELEGRAM_IDS", "REDIS_URL", "TELEGRAM_MODE", "PORT", "SESSION_PREFIX", "DEBOUNCE_MS", "OPENCODE_TIMEOUT", "OCT_EVENT_TYPES", "OCT_HTTP_MAX_IDLE_CONNS", "OCT_HTTP_IDLE_TIMEOUT", "SESSION_PREFIX_CASE_INSENSITIVE", "OCT_EDIT_RETRY_MAX", "OCT_EDIT_RETRY_BASE_DELAY", "OPENCODE_SESSION_CACHE_TTL", "OCT_STORE_SESSION_TTL"}
 	old := make(map[string]*string)
 	for _, k := range keys {
 		v, ok := os.LookupEnv(k)
@@ -37,6 +41,16 @@ func TestLoadConfig_WithEnvVars(t *testing.T) {
 	_ = os.Setenv("TELEGRAM_MODE", "webhook")
 	_ = os.Setenv("PORT", "8080")
 	_ = os.Setenv("SESSION_PREFIX", "myprefix_")
+	_ = os.Setenv("DEBOUNCE_MS", "250")
+	_ = os.Setenv("OPENCODE_TIMEOUT", "5s")
+	_ = os.Setenv("OCT_EVENT_TYPES", "message.part.delta, session.idle")
+	_ = os.Setenv("OCT_HTTP_MAX_IDLE_CONNS", "8")
+	_ = os.Setenv("OCT_HTTP_IDLE_TIMEOUT", "45s")
+	_ = os.Setenv("SESSION_PREFIX_CASE_INSENSITIVE", "false")
+	_ = os.Setenv("OCT_EDIT_RETRY_MAX", "5")
+	_ = os.Setenv("OCT_EDIT_RETRY_BASE_DELAY", "250ms")
+	_ = os.Setenv("OPENCODE_SESSION_CACHE_TTL", "-1s")
+	_ = os.Setenv("OCT_STORE_SESSION_TTL", "720h")
 
 	cfg := LoadConfig()
 
@@ -64,14 +78,38 @@ func TestLoadConfig_WithEnvVars(t *testing.T) {
 	if cfg.Port != "8080" {
 		t.Fatalf("Port expected 8080, got %q", cfg.Port)
 	}
+	if cfg.OpencodeSessionCacheTTL != -time.Second {
+		t.Fatalf("OpencodeSessionCacheTTL expected -1s, got %v", cfg.OpencodeSessionCacheTTL)
+	}
+	if cfg.StoreSessionTTL != 720*time.Hour {
+		t.Fatalf("StoreSessionTTL expected 720h, got %v", cfg.StoreSessionTTL)
+	}
 	if cfg.SessionPrefix != "myprefix_" {
 		t.Fatalf("SessionPrefix expected myprefix_, got %q", cfg.SessionPrefix)
 	}
+	if cfg.DebounceMillis != 250 {
+		t.Fatalf("DebounceMillis expected 250, got %d", cfg.DebounceMillis)
+	}
+	if cfg.OpencodeTimeout != 5*time.Second {
+		t.Fatalf("OpencodeTimeout expected 5s, got %v", cfg.OpencodeTimeout)
+	}
+	if strings.Join(cfg.EventTypes, ",") != "message.part.delta,session.idle" {
+		t.Fatalf("EventTypes parsing failed: %v", cfg.EventTypes)
+	}
+	if cfg.HTTPMaxIdleConns != 8 || cfg.HTTPIdleTimeout != 45*time.Second {
+		t.Fatalf("HTTP pool settings parsing failed: %d %v", cfg.HTTPMaxIdleConns, cfg.HTTPIdleTimeout)
+	}
+	if cfg.CaseInsensitivePrefix {
+		t.Fatal("CaseInsensitivePrefix expected false")
+	}
+	if cfg.EditRetryMax != 5 || cfg.EditRetryBaseDelay != 250*time.Millisecond {
+		t.Fatalf("edit retry settings parsing failed: %d %v", cfg.EditRetryMax, cfg.EditRetryBaseDelay)
+	}
 }
 
 func TestLoadConfig_Defaults(t *testing.T) {
 	// ensure env cleared for relevant keys
-	keys := []string{"TELEGRAM_BOT_TOKEN", "OPENCODE_BASE_URL", "OPENCODE_AUTH_TOKEN", "ALLOWED_TELEGRAM_IDS", "ADMIN_TELEGRAM_IDS", "REDIS_URL", "TELEGRAM_MODE", "PORT", "SESSION_PREFIX"}
+	keys := []string{"TELEGRAM_BOT_TOKEN", "OPENCODE_BASE_URL", "OPENCODE_AUTH_TOKEN", "ALLOWED_TELEGRAM_IDS", "ADMIN_TELEGRAM_IDS", "REDIS_URL", "TELEGRAM_MODE", "PORT", "SESSION_PREFIX", "DEBOUNCE_MS", "SESSION_PREFIX_CASE_INSENSITIVE", "OCT_REQUIRE_HTTPS"}
 	saved := make(map[string]*string)
 	for _, k := range keys {
 		v, ok := os.LookupEnv(k)
@@ -122,4 +160,69 @@ func TestLoadConfig_Defaults(t *testing.T) {
 	if cfg.SessionPrefix != "oct_" {
 		t.Fatalf("SessionPrefix default mismatch: %q", cfg.SessionPrefix)
 	}
+	if cfg.DebounceMillis != DefaultDebounceMillis {
+		t.Fatalf("DebounceMillis default mismatch: %d", cfg.DebounceMillis)
+	}
+	if !cfg.CaseInsensitivePrefix {
+		t.Fatal("CaseInsensitivePrefix expected to default to true")
+	}
+	if !cfg.RequireHTTPS {
+		t.Fatal("RequireHTTPS expected to default to true")
+	}
+}
+
+func TestClampDebounceMillis(t *testing.T) {
+	cases := map[int]int{
+		-1:   DefaultDebounceMillis,
+		0:    DefaultDebounceMillis,
+		50:   MinDebounceMillis,
+		100:  100,
+		1500: 1500,
+	}
+	for in, want := range cases {
+		if got := clampDebounceMillis(in); got != want {
+			t.Fatalf("clampDebounceMillis(%d) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestBotReloadConfigSwapsAccessLists(t *testing.T) {
+	t.Setenv("ALLOWED_TELEGRAM_IDS", "1 2")
+	t.Setenv("ADMIN_TELEGRAM_IDS", "1")
+	path := filepath.Join(t.TempDir(), "access.env")
+	t.Setenv("OCT_ACCESS_FILE", path)
+	if err := os.WriteFile(path, []byte("# team\nALLOWED_TELEGRAM_IDS=1,3\n"), 0o600); err != nil {
+		t.Fatalf("write access file: %v", err)
+	}
+
+	cfg := LoadConfig()
+	if !cfg.AllowedIDs[3] || cfg.AllowedIDs[2] || !cfg.AdminIDs[1] {
+		t.Fatalf("expected file to override allowed IDs only, got allowed=%v admin=%v", cfg.AllowedIDs, cfg.AdminIDs)
+	}
+	app, _, _ := testBotApp(cfg, &mockOpencodeClient{})
+
+	if err := os.WriteFile(path, []byte("ALLOWED_TELEGRAM_IDS=3 4\nADMIN_TELEGRAM_IDS=4\n"), 0o600); err != nil {
+		t.Fatalf("write access file: %v", err)
+	}
+	if err := app.ReloadConfig(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if app.isAllowed(1) || !app.isAllowed(4) || app.isAdmin(1) || !app.isAdmin(4) {
+		t.Fatalf("expected reloaded lists, got allowed=%v admin=%v", cfg.AllowedIDs, cfg.AdminIDs)
+	}
+
+	if err := os.WriteFile(path, []byte("ALLOWED=5\n"), 0o600); err != nil {
+		t.Fatalf("write access file: %v", err)
+	}
+	if err := app.ReloadConfig(); err == nil || !strings.Contains(err.Error(), "access.env:1") {
+		t.Fatalf("expected bad line error, got %v", err)
+	}
+	if !app.isAllowed(4) || !app.isAdmin(4) {
+		t.Fatal("expected failed reload to keep the current lists")
+	}
+
+	added, removed := diffIDs(map[int64]bool{1: true, 2: true}, map[int64]bool{2: true, 5: true, 3: true})
+	if fmt.Sprint(added, removed) != "[3 5] [1]" {
+		t.Fatalf("unexpected diff: added=%v removed=%v", added, removed)
+	}
 }
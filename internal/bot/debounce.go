@@ -15,6 +15,7 @@ type Debouncer struct {
 type pendingEdit struct {
 	timer *time.Timer
 	text  string
+	fn    func(string) error
 }
 
 func NewDebouncer(delay time.Duration) *Debouncer {
@@ -36,19 +37,44 @@ func (d *Debouncer) Debounce(key string, text string, fn func(string) error) {
 	}
 
 	// schedule new timer
-	timer := time.AfterFunc(d.delay, func() {
-		d.mu.Lock()
-		pe, ok := d.pending[key]
-		d.mu.Unlock()
-
-		if ok && pe != nil {
-			_ = fn(pe.text)
+	pe := &pendingEdit{text: text, fn: fn}
+	pe.timer = time.AfterFunc(d.delay, func() {
+		if d.take(key, pe) {
+			_ = pe.fn(pe.text)
 		}
-
-		d.mu.Lock()
-		delete(d.pending, key)
-		d.mu.Unlock()
 	})
+	d.pending[key] = pe
+}
+
+// Flush runs the pending call for key immediately instead of waiting for its timer.
+func (d *Debouncer) Flush(key string) {
+	d.mu.Lock()
+	pe, ok := d.pending[key]
+	d.mu.Unlock()
+	if ok && d.take(key, pe) {
+		pe.timer.Stop()
+		_ = pe.fn(pe.text)
+	}
+}
 
-	d.pending[key] = &pendingEdit{timer: timer, text: text}
+// Cancel drops the pending call for key without running it.
+func (d *Debouncer) Cancel(key string) {
+	d.mu.Lock()
+	pe, ok := d.pending[key]
+	d.mu.Unlock()
+	if ok && d.take(key, pe) {
+		pe.timer.Stop()
+	}
+}
+
+// take removes pe if it is still pending for key, so exactly one of the timer,
+// Flush or Cancel gets to act on it.
+func (d *Debouncer) take(key string, pe *pendingEdit) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending[key] != pe {
+		return false
+	}
+	delete(d.pending, key)
+	return true
 }
@@ -150,3 +150,81 @@ func TestDebouncer_CancelsOnNewCall(t *testing.T) {
 		t.Fatal("timeout waiting for debounce callback")
 	}
 }
+
+func TestDebouncer_FlushRunsPendingNow(t *testing.T) {
+	db := NewDebouncer(time.Hour)
+
+	var calls []string
+	var mu sync.Mutex
+	fn := func(text string) error {
+		mu.Lock()
+		calls = append(calls, text)
+		mu.Unlock()
+		return nil
+	}
+
+	db.Flush("key") // nothing pending
+	db.Debounce("key", "first", fn)
+	db.Debounce("key", "latest", fn)
+	db.Flush("key")
+	db.Flush("key") // already flushed
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 1 || calls[0] != "latest" {
+		t.Fatalf("expected one immediate call with the latest text, got %v", calls)
+	}
+}
+
+func TestDebouncer_CancelDropsPending(t *testing.T) {
+	db := NewDebouncer(20 * time.Millisecond)
+
+	called := make(chan string, 2)
+	fn := func(text string) error {
+		called <- text
+		return nil
+	}
+
+	db.Debounce("dropped", "x", fn)
+	db.Cancel("dropped")
+	db.Cancel("missing")
+	db.Debounce("kept", "y", fn)
+
+	select {
+	case text := <-called:
+		if text != "y" {
+			t.Fatalf("expected only the kept key to fire, got %q", text)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("timeout waiting for debounce callback")
+	}
+	select {
+	case text := <-called:
+		t.Fatalf("cancelled call ran with %q", text)
+	case <-time.After(60 * time.Millisecond):
+	}
+}
+
+func TestDebouncer_FlushRacesTimerOnce(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		db := NewDebouncer(time.Millisecond)
+		var mu sync.Mutex
+		calls := 0
+		fn := func(string) error {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			return nil
+		}
+		db.Debounce("key", "text", fn)
+		time.Sleep(time.Millisecond)
+		db.Flush("key")
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		if calls != 1 {
+			mu.Unlock()
+			t.Fatalf("expected exactly one call when flush races the timer, got %d", calls)
+		}
+		mu.Unlock()
+	}
+}
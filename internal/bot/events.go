@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
@@ -108,15 +109,111 @@ func findSessionLikeID(root any) string {
 	return out
 }
 
+// DefaultEventTypes are the Opencode events handleEvent acts on unless
+// Config.EventTypes replaces them. Events carrying a message part (text or
+// tool) are applied without refetching the session; the rest trigger a fetch.
+var DefaultEventTypes = []string{
+	"message.part.updated",
+	"message.updated",
+	"session.message.part.updated",
+	"session.updated",
+	"session.error",
+	"tool.part.updated",
+	"tool.updated",
+}
+
+func newEventTypes(types []string) map[string]bool {
+	if len(types) == 0 {
+		types = DefaultEventTypes
+	}
+	out := make(map[string]bool, len(types))
+	for _, t := range types {
+		out[t] = true
+	}
+	return out
+}
+
+// AddEventType makes handleEvent act on eventType.
+func (a *BotApp) AddEventType(eventType string) {
+	a.eventMu.Lock()
+	defer a.eventMu.Unlock()
+	if a.eventTypes == nil {
+		a.eventTypes = newEventTypes(nil)
+	}
+	a.eventTypes[eventType] = true
+}
+
+// RemoveEventType makes handleEvent ignore eventType.
+func (a *BotApp) RemoveEventType(eventType string) {
+	a.eventMu.Lock()
+	defer a.eventMu.Unlock()
+	if a.eventTypes == nil {
+		a.eventTypes = newEventTypes(nil)
+	}
+	delete(a.eventTypes, eventType)
+}
+
+// handlesEventType reports whether handleEvent acts on eventType; a BotApp
+// without its own set uses DefaultEventTypes.
+func (a *BotApp) handlesEventType(eventType string) bool {
+	a.eventMu.RLock()
+	defer a.eventMu.RUnlock()
+	if a.eventTypes == nil {
+		for _, t := range DefaultEventTypes {
+			if t == eventType {
+				return true
+			}
+		}
+		return false
+	}
+	return a.eventTypes[eventType]
+}
+
 func isTerminalSessionEvent(eventType string, payload any, ev map[string]any) bool {
+	if eventType == "session.error" {
+		return true
+	}
 	if eventType != "session.updated" {
 		return false
 	}
+	status := sessionStatus(payload, ev)
+	return status == "completed" || status == "failed"
+}
+
+func sessionStatus(payload any, ev map[string]any) string {
 	status := strings.ToLower(findStringKeyRecursive(payload, "status"))
 	if status == "" {
 		status = strings.ToLower(findStringKeyRecursive(ev, "status"))
 	}
-	return status == "completed" || status == "failed"
+	return status
+}
+
+// sessionFailureText returns the message shown in place of a session's output
+// when the event reports that the session failed: a session.error event or a
+// session.updated event with status "failed". Opencode reports an aborted
+// message as a MessageAbortedError, which is shown as an abort rather than a
+// failure.
+func sessionFailureText(eventType string, payload any, ev map[string]any) (string, bool) {
+	if eventType != "session.error" && (eventType != "session.updated" || sessionStatus(payload, ev) != "failed") {
+		return "", false
+	}
+	var name, reason string
+	if e, ok := findMapKeyRecursive(payload, "error"); ok {
+		name, _ = e["name"].(string)
+		reason = findStringKeyRecursive(e, "message")
+	} else if e := findStringKeyRecursive(payload, "error"); e != "<nil>" {
+		reason = e
+	}
+	if strings.Contains(strings.ToLower(name), "abort") {
+		return "⏹ Session aborted.", true
+	}
+	if reason == "" {
+		reason = name
+	}
+	if reason == "" {
+		reason = "unknown error"
+	}
+	return "❌ Session failed: " + reason, true
 }
 
 // StartEventListener subscribes to opencode SSE events and updates Telegram messages
@@ -126,6 +223,12 @@ func (a *BotApp) StartEventListener() error {
 	return a.oc.SubscribeEvents(a.handleEvent)
 }
 
+// StartEventListenerContext is StartEventListener with a context that stops
+// the event stream and its reconnect loop when cancelled.
+func (a *BotApp) StartEventListenerContext(ctx context.Context) error {
+	return a.oc.SubscribeEventsContext(ctx, a.handleEvent)
+}
+
 func (a *BotApp) handleEvent(ev map[string]any) {
 	log.Printf("DEBUG: received event: %+v", ev)
 
@@ -147,7 +250,7 @@ func (a *BotApp) handleEvent(ev map[string]any) {
 	log.Printf("DEBUG: eventType=%s", eventType)
 
 	// interested events
-	if eventType == "message.part.updated" || eventType == "message.updated" || eventType == "session.message.part.updated" || eventType == "session.updated" {
+	if a.handlesEventType(eventType) {
 		// payload may be under "data" or "payload"
 		var payload any
 		if d, ok := ev["data"]; ok {
@@ -197,7 +300,8 @@ func (a *BotApp) handleEvent(ev map[string]any) {
 		}
 
 		log.Printf("DEBUG: extracted sid=%s", sid)
-		if isTerminalSessionEvent(eventType, payload, ev) {
+		terminal := isTerminalSessionEvent(eventType, payload, ev)
+		if terminal {
 			a.clearRunBySession(sid)
 		}
 
@@ -210,9 +314,33 @@ func (a *BotApp) handleEvent(ev map[string]any) {
 
 		log.Printf("DEBUG: found session mapping: chatID=%d, msgID=%d", chatID, msgID)
 
-		// Always fetch the latest session messages to ensure we get complete output
+		if failure, failed := sessionFailureText(eventType, payload, ev); failed {
+			a.dropSessionParts(sid)
+			a.editSessionMessage(sid, chatID, msgID, failure, true)
+			return
+		}
+
+		if terminal {
+			a.dropSessionParts(sid)
+		} else if part, ok := eventPart(payload, ev); ok {
+			// Part events carry the part itself; use it instead of refetching the session.
+			text := a.applySessionPart(sid, part)
+			if text == "" {
+				log.Printf("DEBUG: part event for %s has no text to show, skipping edit", sid)
+				return
+			}
+			a.editSessionMessage(sid, chatID, msgID, text, false)
+			return
+		}
+
+		// Other events fetch the latest session messages to ensure we get complete
+		// output; once the session completes, replace the latest part with the full answer.
 		log.Printf("DEBUG: fetching latest messages from session %s", sid)
-		fetched, err := a.oc.GetSessionMessages(sid)
+		fetch := a.oc.GetSessionMessages
+		if terminal {
+			fetch = a.oc.GetSessionMessagesFull
+		}
+		fetched, err := fetch(sid)
 		if err != nil {
 			log.Printf("failed to fetch session messages for %s: %v", sid, err)
 			return
@@ -225,16 +353,214 @@ func (a *BotApp) handleEvent(ev map[string]any) {
 			return
 		}
 
-		log.Printf("DEBUG: debouncing edit for session %s", sid)
-		// Use debouncer to avoid edit spam (500ms grace period)
-		a.debouncer.Debounce(sid, text, func(latestText string) error {
-			edit := tgbotapi.NewEditMessageText(chatID, msgID, latestText)
-			log.Printf("DEBUG: sending edit to telegram: %s", latestText)
-			err := a.requestWithRetry(edit)
-			if err != nil {
-				log.Printf("failed to edit telegram msg for session %s: %v", sid, err)
+		// the session is done; land the final output without waiting
+		a.editSessionMessage(sid, chatID, msgID, text, terminal)
+	}
+}
+
+// messageKey identifies a Telegram message.
+type messageKey struct {
+	chatID int64
+	msgID  int
+}
+
+// rememberEdit records text as the latest edit queued for the message and
+// reports false if it was already the latest one.
+func (a *BotApp) rememberEdit(chatID int64, msgID int, text string) bool {
+	a.editsMu.Lock()
+	defer a.editsMu.Unlock()
+	key := messageKey{chatID, msgID}
+	if last, ok := a.lastEdits[key]; ok && last == text {
+		return false
+	}
+	if a.lastEdits == nil {
+		a.lastEdits = make(map[messageKey]string)
+	}
+	a.lastEdits[key] = text
+	return true
+}
+
+// forgetEdit drops the message's remembered edit. A non-empty text only
+// drops it while text is still the remembered one.
+func (a *BotApp) forgetEdit(chatID int64, msgID int, text string) {
+	a.editsMu.Lock()
+	defer a.editsMu.Unlock()
+	key := messageKey{chatID, msgID}
+	if text != "" && a.lastEdits[key] != text {
+		return
+	}
+	delete(a.lastEdits, key)
+}
+
+func (a *BotApp) editSessionMessage(sid string, chatID int64, msgID int, text string, flush bool) {
+	if !a.rememberEdit(chatID, msgID, text) {
+		log.Printf("DEBUG: text for session %s unchanged, skipping edit", sid)
+		return
+	}
+	log.Printf("DEBUG: debouncing edit for session %s", sid)
+	// Use debouncer to avoid edit spam (500ms grace period)
+	a.debouncer.Debounce(sid, text, func(latestText string) error {
+		edit := tgbotapi.NewEditMessageText(chatID, msgID, latestText)
+		log.Printf("DEBUG: sending edit to telegram: %s", latestText)
+		err := a.requestWithRetry(edit)
+		if err != nil {
+			log.Printf("failed to edit telegram msg for session %s: %v", sid, err)
+			// let the same text be tried again
+			a.forgetEdit(chatID, msgID, latestText)
+		}
+		return err
+	})
+	if flush {
+		a.debouncer.Flush(sid)
+	}
+}
+
+// eventPart returns the message part an event carries. Tool events may send
+// the tool part itself as the payload.
+func eventPart(payload any, ev map[string]any) (map[string]any, bool) {
+	if part, ok := findMapKeyRecursive(payload, "part"); ok {
+		return part, true
+	}
+	if part, ok := findMapKeyRecursive(ev, "part"); ok {
+		return part, true
+	}
+	if m, ok := payload.(map[string]any); ok {
+		if _, isTool := m["tool"].(string); isTool {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// sessionParts accumulates a session's text parts from SSE part events, in
+// the order they first appeared, so in-progress edits need no refetch. tool
+// is the status line of the tool call currently running, if any.
+type sessionParts struct {
+	order []string
+	texts map[string]string
+	tool  string
+}
+
+// applySessionPart records part for sid and returns the text to show: the
+// latest non-empty text part, as GetSessionMessages would, followed by the
+// running tool's status line. Thinking and other non-text parts are ignored.
+func (a *BotApp) applySessionPart(sid string, part map[string]any) string {
+	partType, _ := part["type"].(string)
+	_, isTool := part["tool"].(string)
+	if partType != "" && !strings.EqualFold(partType, "text") && !strings.EqualFold(partType, "tool") {
+		return a.latestSessionPart(sid)
+	}
+
+	a.partsMu.Lock()
+	defer a.partsMu.Unlock()
+	if a.sessionParts == nil {
+		a.sessionParts = make(map[string]*sessionParts)
+	}
+	buf, ok := a.sessionParts[sid]
+	if !ok {
+		buf = &sessionParts{texts: make(map[string]string)}
+		a.sessionParts[sid] = buf
+	}
+	if strings.EqualFold(partType, "tool") || (partType == "" && isTool) {
+		buf.tool = toolStatusLine(part)
+		return buf.render()
+	}
+
+	id, _ := part["id"].(string)
+	text, hasText := part["text"].(string)
+	delta, _ := part["delta"].(string)
+	if _, seen := buf.texts[id]; !seen {
+		buf.order = append(buf.order, id)
+	}
+	if hasText {
+		buf.texts[id] = text
+	} else {
+		buf.texts[id] += delta
+	}
+	return buf.render()
+}
+
+// toolStatusLine renders a tool part as a one-line status, or "" once the
+// call has finished or its state is unknown.
+func toolStatusLine(part map[string]any) string {
+	name, _ := part["tool"].(string)
+	if name == "" {
+		name, _ = part["name"].(string)
+	}
+	status, _ := part["status"].(string)
+	if state, ok := part["state"].(map[string]any); ok {
+		if s, ok := state["status"].(string); ok {
+			status = s
+		}
+	}
+	if name == "" {
+		return ""
+	}
+	switch strings.ToLower(status) {
+	case "pending", "running":
+		return fmt.Sprintf("🔧 running %s…", name)
+	case "error":
+		return fmt.Sprintf("⚠️ %s failed", name)
+	}
+	return ""
+}
+
+func (a *BotApp) latestSessionPart(sid string) string {
+	a.partsMu.Lock()
+	defer a.partsMu.Unlock()
+	if buf, ok := a.sessionParts[sid]; ok {
+		return buf.render()
+	}
+	return ""
+}
+
+func (a *BotApp) dropSessionParts(sid string) {
+	a.partsMu.Lock()
+	defer a.partsMu.Unlock()
+	delete(a.sessionParts, sid)
+}
+
+func (p *sessionParts) render() string {
+	text := p.latest()
+	if p.tool == "" {
+		return text
+	}
+	if text == "" {
+		return p.tool
+	}
+	return text + "\n\n" + p.tool
+}
+
+func (p *sessionParts) latest() string {
+	for i := len(p.order) - 1; i >= 0; i-- {
+		if text := p.texts[p.order[i]]; text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// findMapKeyRecursive returns the first object stored under key (case
+// insensitive) anywhere in root.
+func findMapKeyRecursive(root any, key string) (map[string]any, bool) {
+	switch m := root.(type) {
+	case map[string]any:
+		for k, v := range m {
+			if vm, ok := v.(map[string]any); ok && strings.EqualFold(k, key) {
+				return vm, true
+			}
+		}
+		for _, v := range m {
+			if found, ok := findMapKeyRecursive(v, key); ok {
+				return found, true
 			}
-			return err
-		})
+		}
+	case []any:
+		for _, it := range m {
+			if found, ok := findMapKeyRecursive(it, key); ok {
+				return found, true
+			}
+		}
 	}
+	return nil, false
 }
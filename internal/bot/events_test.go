@@ -1,7 +1,10 @@
 package bot
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"strings"
 	"testing"
@@ -15,10 +18,13 @@ import (
 
 type mockOpencodeClient struct {
 	subscribeEvents    func(func(map[string]any)) error
+	subscribeEventsCtx func(context.Context, func(map[string]any)) error
 	getSessionMessages func(string) (string, error)
+	getSessionFull     func(string) (string, error)
 	listSessions       func() ([]map[string]any, error)
 	createSession      func(string) (map[string]any, error)
 	promptSession      func(string, string) (map[string]any, error)
+	promptWithOptions  func(string, string, PromptOptions) (map[string]any, error)
 	abortSession       func(string) error
 	deleteSession      func(string) error
 }
@@ -30,6 +36,13 @@ func (m *mockOpencodeClient) SubscribeEvents(handler func(map[string]any)) error
 	return nil
 }
 
+func (m *mockOpencodeClient) SubscribeEventsContext(ctx context.Context, handler func(map[string]any)) error {
+	if m.subscribeEventsCtx != nil {
+		return m.subscribeEventsCtx(ctx, handler)
+	}
+	return nil
+}
+
 func (m *mockOpencodeClient) GetSessionMessages(sessionID string) (string, error) {
 	if m.getSessionMessages != nil {
 		return m.getSessionMessages(sessionID)
@@ -37,6 +50,13 @@ func (m *mockOpencodeClient) GetSessionMessages(sessionID string) (string, error
 	return "", nil
 }
 
+func (m *mockOpencodeClient) GetSessionMessagesFull(sessionID string) (string, error) {
+	if m.getSessionFull != nil {
+		return m.getSessionFull(sessionID)
+	}
+	return m.GetSessionMessages(sessionID)
+}
+
 func (m *mockOpencodeClient) ListSessions() ([]map[string]any, error) {
 	if m.listSessions != nil {
 		return m.listSessions()
@@ -55,6 +75,32 @@ func (m *mockOpencodeClient) PromptSession(sessionID, prompt string) (map[string
 	}
 	panic("not implemented")
 }
+func (m *mockOpencodeClient) PromptSessionWithOptions(sessionID, prompt string, opts PromptOptions) (map[string]any, error) {
+	if m.promptWithOptions != nil {
+		return m.promptWithOptions(sessionID, prompt, opts)
+	}
+	return m.PromptSession(sessionID, prompt)
+}
+func (m *mockOpencodeClient) PromptSessionWithOptionsContext(ctx context.Context, sessionID, prompt string, opts PromptOptions) (map[string]any, error) {
+	return m.PromptSessionWithOptions(sessionID, prompt, opts)
+}
+
+func (m *mockOpencodeClient) ListSessionsContext(ctx context.Context) ([]map[string]any, error) {
+	return m.ListSessions()
+}
+
+func (m *mockOpencodeClient) CreateSessionContext(ctx context.Context, title string) (map[string]any, error) {
+	return m.CreateSession(title)
+}
+
+func (m *mockOpencodeClient) AbortSessionContext(ctx context.Context, sessionID string) error {
+	return m.AbortSession(sessionID)
+}
+
+func (m *mockOpencodeClient) DeleteSessionContext(ctx context.Context, sessionID string) error {
+	return m.DeleteSession(sessionID)
+}
+
 func (m *mockOpencodeClient) AbortSession(sessionID string) error {
 	if m.abortSession != nil {
 		return m.abortSession(sessionID)
@@ -96,6 +142,9 @@ func (m *mockDebouncer) Debounce(key string, text string, fn func(string) error)
 	fn(text)
 }
 
+func (m *mockDebouncer) Flush(key string)  {}
+func (m *mockDebouncer) Cancel(key string) {}
+
 func TestFindStringKeyRecursive(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -548,6 +597,94 @@ func TestBotApp_HandleEvent_MultipleProgressEventsEditSingleRunMessage(t *testin
 	}
 }
 
+func TestBotApp_HandleEvent_SessionErrorEditsAndClearsRun(t *testing.T) {
+	app, tg, st := testBotApp(&Config{}, &mockOpencodeClient{})
+	_ = st.SetSession("ses_err", 3, 30)
+	_ = st.SetSession("ses_abort", 3, 31)
+	_ = st.SetSession("ses_failed", 3, 32)
+	if err := app.tryStartRun(3, 7, "ses_err"); err != nil {
+		t.Fatal("expected run to start")
+	}
+
+	app.handleEvent(map[string]any{"type": "session.error", "properties": map[string]any{
+		"sessionID": "ses_err",
+		"error":     map[string]any{"name": "ProviderAuthError", "data": map[string]any{"message": "invalid API key"}},
+	}})
+	if app.clearRunBySession("ses_err") {
+		t.Fatal("expected the failed session to release its run")
+	}
+	if err := app.tryStartRun(3, 7, "ses_abort"); err != nil {
+		t.Fatal("expected a new run after the failure")
+	}
+	app.handleEvent(map[string]any{"type": "session.error", "properties": map[string]any{
+		"sessionID": "ses_abort",
+		"error":     map[string]any{"name": "MessageAbortedError", "data": map[string]any{"message": "aborted"}},
+	}})
+	app.handleEvent(map[string]any{"type": "session.updated", "data": map[string]any{"sessionID": "ses_failed", "status": "failed"}})
+
+	want := []string{"❌ Session failed: invalid API key", "⏹ Session aborted.", "❌ Session failed: unknown error"}
+	if len(tg.requests) != len(want) {
+		t.Fatalf("expected %d edits, got %d", len(want), len(tg.requests))
+	}
+	for i, text := range want {
+		if edit := tg.requests[i].(tgbotapi.EditMessageTextConfig); edit.Text != text || edit.MessageID != 30+i {
+			t.Fatalf("edit %d: expected %q on message %d, got %q on %d", i, text, 30+i, edit.Text, edit.MessageID)
+		}
+	}
+}
+
+func TestBotApp_HandleEvent_TerminalEventUsesFullText(t *testing.T) {
+	oc := &mockOpencodeClient{
+		getSessionMessages: func(string) (string, error) {
+			return "part 2", nil
+		},
+		getSessionFull: func(string) (string, error) {
+			return "part 1\npart 2", nil
+		},
+	}
+	app, tg, st := testBotApp(&Config{}, oc)
+	_ = st.SetSession("ses_full", 3, 30)
+
+	app.handleEvent(map[string]any{"type": "message.part.updated", "data": map[string]any{"sessionID": "ses_full"}})
+	app.handleEvent(map[string]any{"type": "session.updated", "data": map[string]any{"sessionID": "ses_full", "status": "completed"}})
+
+	if len(tg.requests) != 2 {
+		t.Fatalf("expected two edits, got %d", len(tg.requests))
+	}
+	if edit := tg.requests[0].(tgbotapi.EditMessageTextConfig); edit.Text != "part 2" {
+		t.Fatalf("expected latest part for progress event, got %q", edit.Text)
+	}
+	if edit := tg.requests[1].(tgbotapi.EditMessageTextConfig); edit.Text != "part 1\npart 2" {
+		t.Fatalf("expected full text for terminal event, got %q", edit.Text)
+	}
+}
+
+func TestBotApp_HandleEvent_TerminalEventFlushesDebouncer(t *testing.T) {
+	oc := &mockOpencodeClient{
+		getSessionMessages: func(string) (string, error) {
+			return "partial", nil
+		},
+		getSessionFull: func(string) (string, error) {
+			return "final answer", nil
+		},
+	}
+	app, tg, st := testBotApp(&Config{}, oc)
+	app.debouncer = NewDebouncer(time.Hour)
+	_ = st.SetSession("ses_flush", 3, 30)
+
+	app.handleEvent(map[string]any{"type": "message.part.updated", "data": map[string]any{"sessionID": "ses_flush"}})
+	if len(tg.requests) != 0 {
+		t.Fatalf("expected progress edit to wait for the debounce delay, got %d", len(tg.requests))
+	}
+	app.handleEvent(map[string]any{"type": "session.updated", "data": map[string]any{"sessionID": "ses_flush", "status": "completed"}})
+	if len(tg.requests) != 1 {
+		t.Fatalf("expected terminal event to edit immediately, got %d edits", len(tg.requests))
+	}
+	if edit := tg.requests[0].(tgbotapi.EditMessageTextConfig); edit.Text != "final answer" {
+		t.Fatalf("expected final text, got %q", edit.Text)
+	}
+}
+
 func TestBotApp_HandleEvent_EditRetryIsBounded(t *testing.T) {
 	oc := &mockOpencodeClient{
 		getSessionMessages: func(string) (string, error) {
@@ -574,6 +711,33 @@ func TestBotApp_HandleEvent_EditRetryIsBounded(t *testing.T) {
 	}
 }
 
+func TestBotApp_RequestWithRetry_HonorsRetryAfter(t *testing.T) {
+	app, tg, _ := testBotApp(&Config{EditRetryMax: 5, EditRetryBaseDelay: time.Second}, &mockOpencodeClient{})
+	var slept []time.Duration
+	app.sleep = func(d time.Duration) { slept = append(slept, d) }
+	tg.requestErrs = []error{
+		&tgbotapi.Error{Code: 429, Message: "Too Many Requests: retry after 7", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 7}},
+		fmt.Errorf("429 too many requests"),
+		fmt.Errorf("429 too many requests"),
+		fmt.Errorf("429 too many requests"),
+		fmt.Errorf("429 too many requests"),
+	}
+
+	err := app.requestWithRetry(tgbotapi.NewEditMessageText(1, 99, "x"))
+	if err == nil || len(tg.requests) != 5 {
+		t.Fatalf("expected 5 failed attempts, got %d err=%v", len(tg.requests), err)
+	}
+	if len(slept) != 4 || slept[0] != 7*time.Second {
+		t.Fatalf("expected retry_after honored first, got %v", slept)
+	}
+	// later waits back off from the base delay with at most 20% jitter
+	for i, want := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		if got := slept[i+1]; got < want || got >= want+want/5 {
+			t.Fatalf("expected wait %d in [%s, %s), got %s", i+1, want, want+want/5, got)
+		}
+	}
+}
+
 // TestEventListener_ExtractSessionIDFromDifferentLocations tests various event structures
 func TestEventListener_ExtractSessionID_FromPayload(t *testing.T) {
 	tests := []struct {
@@ -977,3 +1141,278 @@ func TestEventListener_EventTypeFieldOrder(t *testing.T) {
 		t.Errorf("should prefer 'type' field over 'name', got %q", eventType)
 	}
 }
+
+func TestBotApp_HandleEvent_AppliesStreamedParts(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.SetSession("ses_123", 123, 456)
+	fetches := 0
+	mockOC := &mockOpencodeClient{
+		getSessionMessages: func(sid string) (string, error) {
+			fetches++
+			return "fetched", nil
+		},
+	}
+	mockTG := &mockBot{}
+	app := &BotApp{store: st, oc: mockOC, tg: mockTG, debouncer: &mockDebouncer{}}
+	part := func(p map[string]any) map[string]any {
+		return map[string]any{
+			"type":       "message.part.updated",
+			"properties": map[string]any{"part": p},
+			"data":       map[string]any{"sessionID": "ses_123"},
+		}
+	}
+	lastEdit := func() string {
+		t.Helper()
+		edit, ok := mockTG.requests[len(mockTG.requests)-1].(tgbotapi.EditMessageTextConfig)
+		if !ok {
+			t.Fatalf("expected EditMessageTextConfig, got %T", mockTG.requests[len(mockTG.requests)-1])
+		}
+		return edit.Text
+	}
+
+	app.handleEvent(part(map[string]any{"id": "p1", "type": "text", "text": "Hel"}))
+	app.handleEvent(part(map[string]any{"id": "p1", "type": "text", "text": "Hello"}))
+	if got := lastEdit(); got != "Hello" {
+		t.Fatalf("expected replaced part text, got %q", got)
+	}
+	app.handleEvent(part(map[string]any{"id": "p2", "type": "text", "delta": "Wor"}))
+	app.handleEvent(part(map[string]any{"id": "p2", "type": "text", "delta": "ld"}))
+	if got := lastEdit(); got != "World" {
+		t.Fatalf("expected appended delta text, got %q", got)
+	}
+	edits := len(mockTG.requests)
+	app.handleEvent(part(map[string]any{"id": "p3", "type": "reasoning", "text": "thinking..."}))
+	app.handleEvent(part(map[string]any{"id": "p4", "type": "tool", "tool": "bash"}))
+	if got := lastEdit(); got != "World" {
+		t.Fatalf("expected non-text parts to keep the shown text, got %q", got)
+	}
+	if fetches != 0 {
+		t.Fatalf("expected no refetch for part events, got %d", fetches)
+	}
+	if len(mockTG.requests) != edits {
+		t.Fatalf("expected unchanged text not to be edited again, got %d requests", len(mockTG.requests))
+	}
+
+	// session.updated still refetches, and a finished session drops the cache
+	app.handleEvent(map[string]any{"type": "session.updated", "data": map[string]any{"sessionID": "ses_123"}})
+	if fetches != 1 || lastEdit() != "fetched" {
+		t.Fatalf("expected session.updated to refetch, got fetches=%d text=%q", fetches, lastEdit())
+	}
+	app.handleEvent(map[string]any{"type": "session.updated", "data": map[string]any{"sessionID": "ses_123", "status": "completed"}})
+	if _, ok := app.sessionParts["ses_123"]; ok {
+		t.Fatal("expected part cache dropped once the session completes")
+	}
+}
+
+func BenchmarkHandleEventPartUpdated(b *testing.B) {
+	prev := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(prev) })
+
+	st := store.NewMemoryStore()
+	st.SetSession("ses_123", 123, 456)
+	text := strings.Repeat("streamed output ", 64)
+	run := func(b *testing.B, ev map[string]any) {
+		fetches := 0
+		app := &BotApp{
+			store: st,
+			oc: &mockOpencodeClient{getSessionMessages: func(string) (string, error) {
+				fetches++
+				return text, nil
+			}},
+			tg:        &mockBot{},
+			debouncer: &mockDebouncer{},
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			app.tg = &mockBot{}
+			app.handleEvent(ev)
+		}
+		b.ReportMetric(float64(fetches)/float64(b.N), "fetches/op")
+	}
+
+	b.Run("part payload", func(b *testing.B) {
+		run(b, map[string]any{
+			"type":       "message.part.updated",
+			"properties": map[string]any{"part": map[string]any{"id": "p1", "sessionID": "ses_123", "type": "text", "text": text}},
+		})
+	})
+	b.Run("refetch", func(b *testing.B) {
+		run(b, map[string]any{
+			"type": "message.part.updated",
+			"data": map[string]any{"sessionID": "ses_123"},
+		})
+	})
+}
+
+func TestBotApp_HandleEvent_RendersToolStatus(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.SetSession("ses_123", 123, 456)
+	mockOC := &mockOpencodeClient{
+		getSessionMessages: func(sid string) (string, error) {
+			t.Fatalf("tool events must not refetch %s", sid)
+			return "", nil
+		},
+	}
+	mockTG := &mockBot{}
+	app := &BotApp{store: st, oc: mockOC, tg: mockTG, debouncer: &mockDebouncer{}}
+	lastEdit := func() string {
+		t.Helper()
+		edit, ok := mockTG.requests[len(mockTG.requests)-1].(tgbotapi.EditMessageTextConfig)
+		if !ok {
+			t.Fatalf("expected EditMessageTextConfig, got %T", mockTG.requests[len(mockTG.requests)-1])
+		}
+		return edit.Text
+	}
+
+	app.handleEvent(map[string]any{
+		"type": "tool.updated",
+		"data": map[string]any{"sessionID": "ses_123", "tool": "bash", "status": "running"},
+	})
+	if got := lastEdit(); got != "🔧 running bash…" {
+		t.Fatalf("expected tool status line, got %q", got)
+	}
+
+	app.handleEvent(map[string]any{
+		"type": "message.part.updated",
+		"data": map[string]any{"part": map[string]any{"id": "p1", "type": "text", "text": "Listing files", "sessionID": "ses_123"}},
+	})
+	app.handleEvent(map[string]any{
+		"type": "message.part.updated",
+		"data": map[string]any{"part": map[string]any{"id": "t1", "type": "tool", "tool": "edit", "state": map[string]any{"status": "error"}, "sessionID": "ses_123"}},
+	})
+	if got := lastEdit(); got != "Listing files\n\n⚠️ edit failed" {
+		t.Fatalf("expected text followed by tool status, got %q", got)
+	}
+
+	app.handleEvent(map[string]any{
+		"type": "tool.part.updated",
+		"data": map[string]any{"part": map[string]any{"id": "t1", "type": "tool", "tool": "edit", "state": map[string]any{"status": "completed"}, "sessionID": "ses_123"}},
+	})
+	if got := lastEdit(); got != "Listing files" {
+		t.Fatalf("expected status line cleared once the tool completes, got %q", got)
+	}
+}
+
+func TestBotApp_HandleEvent_SkipsIdenticalEdits(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.SetSession("ses_123", 123, 456)
+	mockOC := &mockOpencodeClient{getSessionMessages: func(string) (string, error) { return "same output", nil }}
+	mockTG := &mockBot{}
+	app := &BotApp{store: st, oc: mockOC, tg: mockTG, debouncer: &mockDebouncer{}}
+	progress := map[string]any{"type": "session.updated", "data": map[string]any{"sessionID": "ses_123"}}
+
+	app.handleEvent(progress)
+	app.handleEvent(progress)
+	if len(mockTG.requests) != 1 {
+		t.Fatalf("expected one edit for two identical progress events, got %d", len(mockTG.requests))
+	}
+
+	// a failed edit does not block retrying the same text
+	mockTG.requestError = true
+	app.sleep = func(time.Duration) {}
+	app.forgetEdit(123, 456, "")
+	app.handleEvent(progress)
+	mockTG.requestError = false
+	before := len(mockTG.requests)
+	app.handleEvent(progress)
+	if len(mockTG.requests) != before+1 {
+		t.Fatalf("expected the failed text to be sent again, got %d requests after %d", len(mockTG.requests), before)
+	}
+
+	// finishing the run forgets the message's last text
+	app.clearRunBySession("ses_123")
+	app.handleEvent(progress)
+	if len(mockTG.requests) != before+2 {
+		t.Fatalf("expected an edit after the cache was cleared, got %d requests", len(mockTG.requests))
+	}
+}
+
+func TestBotApp_EventTypes(t *testing.T) {
+	app := &BotApp{}
+	if !app.handlesEventType("session.updated") || app.handlesEventType("session.idle") {
+		t.Fatal("expected a BotApp without its own set to use the defaults")
+	}
+
+	app.AddEventType("session.idle")
+	app.RemoveEventType("message.updated")
+	if !app.handlesEventType("session.idle") || app.handlesEventType("message.updated") || !app.handlesEventType("tool.updated") {
+		t.Fatalf("expected add/remove to adjust the default set, got %v", app.eventTypes)
+	}
+
+	app = &BotApp{eventTypes: newEventTypes([]string{"message.part.delta"})}
+	if !app.handlesEventType("message.part.delta") || app.handlesEventType("message.part.updated") {
+		t.Fatalf("expected configured types to replace the defaults, got %v", app.eventTypes)
+	}
+
+	// a removed type no longer reaches the session lookup
+	st := store.NewMemoryStore()
+	st.SetSession("ses_123", 123, 456)
+	mockTG := &mockBot{}
+	app = &BotApp{store: st, tg: mockTG, debouncer: &mockDebouncer{}}
+	app.RemoveEventType("tool.updated")
+	app.handleEvent(map[string]any{"type": "tool.updated", "data": map[string]any{"sessionID": "ses_123", "tool": "bash", "status": "running"}})
+	if len(mockTG.requests) != 0 {
+		t.Fatalf("expected removed event type to be ignored, got %+v", mockTG.requests)
+	}
+}
+
+func TestBotHandleBindRebindsSessionMessage(t *testing.T) {
+	oc := &mockOpencodeClient{
+		listSessions: func() ([]map[string]any, error) {
+			return []map[string]any{{"id": "ses_live", "title": "oct_live"}}, nil
+		},
+		getSessionMessages: func(string) (string, error) { return "still working", nil },
+	}
+	app, tg, st := testBotApp(&Config{AdminIDs: map[int64]bool{9: true}}, oc)
+
+	app.handleBind(4, "", 7)
+	app.handleBind(4, "ses_gone", 9)
+	if len(tg.sentMessages) != 2 || tg.sentMessages[0].Text != "Usage: /bind <session_id>" || !strings.HasPrefix(tg.sentMessages[1].Text, "No session found: ses_gone.") {
+		t.Fatalf("expected usage and unknown-session replies, got %+v", tg.sentMessages)
+	}
+	if _, _, ok := st.GetSession("ses_gone"); ok {
+		t.Fatal("expected no binding for an unknown session")
+	}
+
+	// a user may not bind a session they never selected
+	tg.sentMessages = nil
+	app.handleBind(4, "ses_live", 7)
+	if len(tg.sentMessages) != 1 || !strings.HasPrefix(tg.sentMessages[0].Text, "You can only bind sessions you selected.") {
+		t.Fatalf("expected foreign session refused, got %+v", tg.sentMessages)
+	}
+	if _, _, ok := st.GetSession("ses_live"); ok {
+		t.Fatal("expected no binding for a foreign session")
+	}
+
+	tg.sentMessages = nil
+	_ = st.SetUserSession(7, "ses_live")
+	_ = st.SetUserSession(7, "ses_other")
+	app.handleBind(4, "ses_live", 7)
+	if len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != "Tracking session ses_live…" {
+		t.Fatalf("expected tracking message, got %+v", tg.sentMessages)
+	}
+	chatID, msgID, ok := st.GetSession("ses_live")
+	if !ok || chatID != 4 || msgID != tg.nextMsgID {
+		t.Fatalf("expected binding to the tracking message %d, got chat=%d msg=%d ok=%v", tg.nextMsgID, chatID, msgID, ok)
+	}
+
+	app.handleEvent(map[string]any{"type": "message.part.updated", "data": map[string]any{"sessionID": "ses_live"}})
+	if len(tg.requests) != 1 {
+		t.Fatalf("expected the next event to edit the tracking message, got %d requests", len(tg.requests))
+	}
+	if edit := tg.requests[0].(tgbotapi.EditMessageTextConfig); edit.ChatID != 4 || edit.MessageID != msgID || edit.Text != "still working" {
+		t.Fatalf("unexpected edit %+v", edit)
+	}
+
+	// another chat, even an admin's, cannot take the binding over
+	tg.sentMessages = nil
+	app.handleBind(5, "ses_live", 9)
+	if len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != "Session ses_live is already bound to another chat." {
+		t.Fatalf("expected rebinding from another chat refused, got %+v", tg.sentMessages)
+	}
+	if chatID, _, _ := st.GetSession("ses_live"); chatID != 4 {
+		t.Fatalf("expected the binding kept in chat 4, got %d", chatID)
+	}
+}
@@ -3,23 +3,78 @@ package bot
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"path"
 	"strings"
+	"sync"
+	"time"
+
+	"opencode-telegram/internal/proxy/contracts"
+)
+
+const (
+	defaultReconnectBase  = 500 * time.Millisecond
+	defaultReconnectMax   = 30 * time.Second
+	defaultRequestTimeout = 30 * time.Second
+	defaultRetryMax       = 3
+	defaultRetryBase      = 200 * time.Millisecond
+	// defaultHeartbeatTimeout is how long the event stream may stay silent,
+	// heartbeat comments included, before it is treated as dead.
+	defaultHeartbeatTimeout = 90 * time.Second
+	// defaultSessionCacheTTL is how long a fetched session list is reused.
+	defaultSessionCacheTTL = 5 * time.Second
 )
 
 type OpencodeClientInterface interface {
 	SubscribeEvents(handler func(map[string]any)) error
+	SubscribeEventsContext(ctx context.Context, handler func(map[string]any)) error
 	GetSessionMessages(sessionID string) (string, error)
+	GetSessionMessagesFull(sessionID string) (string, error)
 	ListSessions() ([]map[string]any, error)
+	ListSessionsContext(ctx context.Context) ([]map[string]any, error)
 	CreateSession(prompt string) (map[string]any, error)
+	CreateSessionContext(ctx context.Context, title string) (map[string]any, error)
 	PromptSession(sessionID, prompt string) (map[string]any, error)
+	PromptSessionWithOptions(sessionID, prompt string, opts PromptOptions) (map[string]any, error)
+	PromptSessionWithOptionsContext(ctx context.Context, sessionID, prompt string, opts PromptOptions) (map[string]any, error)
 	AbortSession(sessionID string) error
+	AbortSessionContext(ctx context.Context, sessionID string) error
 	DeleteSession(sessionID string) error
+	DeleteSessionContext(ctx context.Context, sessionID string) error
+}
+
+// PromptOptions carries optional per-message overrides for PromptSessionWithOptions.
+type PromptOptions struct {
+	Model      string
+	ProviderID string
+	// Files are sent as file parts alongside the prompt text.
+	Files []contracts.Attachment
+}
+
+// OpencodeError is returned for an Opencode API response with status 400 or
+// above, so callers can tell a missing session from a server failure.
+type OpencodeError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *OpencodeError) Error() string {
+	return fmt.Sprintf("opencode error: %d %s", e.StatusCode, e.Body)
+}
+
+// isOpencodeStatus reports whether err is an OpencodeError with status.
+func isOpencodeStatus(err error, status int) bool {
+	var oe *OpencodeError
+	return errors.As(err, &oe) && oe.StatusCode == status
 }
 
 type Session struct {
@@ -30,6 +85,27 @@ type OpencodeClient struct {
 	base  *url.URL
 	token string
 	http  *http.Client
+
+	// requestTimeout bounds each API request. The event stream is long-lived,
+	// so it is applied per request instead of as an http.Client timeout.
+	requestTimeout time.Duration
+	// retryMax and retryBase control retries of GET and DELETE requests.
+	retryMax      int
+	retryBase     time.Duration
+	reconnectBase time.Duration
+	reconnectMax  time.Duration
+	// heartbeatTimeout closes an event stream that has received nothing for
+	// this long, so a connection dropped silently is reconnected.
+	heartbeatTimeout time.Duration
+
+	// The session list is cached for sessionCacheTTL, since /sessions, the
+	// selected-session checks and session resolution all fetch it and
+	// Opencode returns every session without filtering or paging.
+	sessionCacheTTL time.Duration
+	now             func() time.Time
+	sessionsMu      sync.Mutex
+	sessions        []map[string]any
+	sessionsAt      time.Time
 }
 
 func NewOpencodeClient(baseURL, token string) (*OpencodeClient, error) {
@@ -37,28 +113,117 @@ func NewOpencodeClient(baseURL, token string) (*OpencodeClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &OpencodeClient{base: u, token: token, http: &http.Client{}}, nil
+	return &OpencodeClient{
+		base:             u,
+		token:            token,
+		http:             &http.Client{},
+		requestTimeout:   defaultRequestTimeout,
+		retryMax:         defaultRetryMax,
+		retryBase:        defaultRetryBase,
+		reconnectBase:    defaultReconnectBase,
+		reconnectMax:     defaultReconnectMax,
+		heartbeatTimeout: defaultHeartbeatTimeout,
+		sessionCacheTTL:  defaultSessionCacheTTL,
+		now:              time.Now,
+	}, nil
+}
+
+// SetRequestTimeout bounds each Opencode API request; d <= 0 restores the
+// 30 second default. The event stream is not affected.
+func (c *OpencodeClient) SetRequestTimeout(d time.Duration) {
+	if d <= 0 {
+		d = defaultRequestTimeout
+	}
+	c.requestTimeout = d
+}
+
+// SetHeartbeatTimeout sets how long the event stream may go without any
+// data, including Opencode's ":" heartbeat comments, before it is dropped
+// and reconnected. d == 0 restores the 90 second default; d < 0 disables
+// the check.
+func (c *OpencodeClient) SetHeartbeatTimeout(d time.Duration) {
+	if d == 0 {
+		d = defaultHeartbeatTimeout
+	}
+	c.heartbeatTimeout = d
+}
+
+// SetSessionCacheTTL sets how long a fetched session list is reused before
+// ListSessions asks Opencode again. d == 0 restores the 5 second default;
+// d < 0 disables the cache.
+func (c *OpencodeClient) SetSessionCacheTTL(d time.Duration) {
+	if d == 0 {
+		d = defaultSessionCacheTTL
+	}
+	c.sessionsMu.Lock()
+	defer c.sessionsMu.Unlock()
+	c.sessionCacheTTL = d
+	c.sessions = nil
+}
+
+// InvalidateSessions drops the cached session list so the next ListSessions
+// fetches it from Opencode.
+func (c *OpencodeClient) InvalidateSessions() {
+	c.sessionsMu.Lock()
+	defer c.sessionsMu.Unlock()
+	c.sessions = nil
 }
 
 func (c *OpencodeClient) doRequest(method, p string, body any) ([]byte, error) {
-	// build URL
-	u := *c.base
-	u.Path = path.Join(c.base.Path, p)
+	return c.doRequestCtx(context.Background(), method, p, body)
+}
 
-	var buf io.Reader
+// doRequestCtx sends an API request. GET and DELETE are retried on network
+// errors and 502/503/504 with jittered exponential backoff; other methods,
+// such as prompts, are sent once so they are never duplicated.
+func (c *OpencodeClient) doRequestCtx(ctx context.Context, method, p string, body any) ([]byte, error) {
+	var payload []byte
 	if body != nil {
 		b, err := json.Marshal(body)
 		if err != nil {
 			return nil, err
 		}
-		buf = bytes.NewReader(b)
+		payload = b
+	}
+	attempts := 1
+	if method == http.MethodGet || method == http.MethodDelete {
+		attempts += c.retryMax
+	}
+	for attempt := 0; ; attempt++ {
+		b, retry, err := c.doRequestOnce(ctx, method, p, payload, c.requestTimeout)
+		if err == nil || !retry || attempt+1 >= attempts {
+			return b, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(c.retryDelay(attempt)):
+		}
+	}
+}
+
+// doRequestOnce makes a single attempt bounded by timeout, if positive, and
+// reports whether a failure is worth retrying.
+func (c *OpencodeClient) doRequestOnce(ctx context.Context, method, p string, payload []byte, timeout time.Duration) ([]byte, bool, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// build URL
+	u := *c.base
+	u.Path = path.Join(c.base.Path, p)
+
+	var buf io.Reader
+	if payload != nil {
+		buf = bytes.NewReader(payload)
 	}
 
-	req, err := http.NewRequest(method, u.String(), buf)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), buf)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	if body != nil {
+	if payload != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
 	if c.token != "" {
@@ -67,21 +232,47 @@ func (c *OpencodeClient) doRequest(method, p string, body any) ([]byte, error) {
 
 	resp, err := c.http.Do(req)
 	if err != nil {
-		return nil, err
+		// a hung server is not retried; it already used the whole timeout
+		retry := !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+		return nil, retry, err
 	}
 	defer resp.Body.Close()
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, true, err
 	}
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("opencode error: %d %s", resp.StatusCode, string(b))
+		retry := resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout
+		return nil, retry, &OpencodeError{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	return b, false, nil
+}
+
+func (c *OpencodeClient) retryDelay(attempt int) time.Duration {
+	delay := c.retryBase << attempt
+	if jitterMax := int64(delay / 5); jitterMax > 0 {
+		delay += time.Duration(rand.Int63n(jitterMax))
 	}
-	return b, nil
+	return delay
 }
 
 func (c *OpencodeClient) ListSessions() ([]map[string]any, error) {
-	b, err := c.doRequest("GET", "/session", nil)
+	return c.ListSessionsContext(context.Background())
+}
+
+// ListSessionsContext returns every Opencode session, reusing the list
+// fetched within the session cache TTL. Callers get their own slice but must
+// not modify the session maps.
+func (c *OpencodeClient) ListSessionsContext(ctx context.Context) ([]map[string]any, error) {
+	c.sessionsMu.Lock()
+	if c.sessions != nil && c.sessionCacheTTL > 0 && c.now().Sub(c.sessionsAt) < c.sessionCacheTTL {
+		out := append([]map[string]any(nil), c.sessions...)
+		c.sessionsMu.Unlock()
+		return out, nil
+	}
+	c.sessionsMu.Unlock()
+
+	b, err := c.doRequestCtx(ctx, "GET", "/session", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -89,15 +280,27 @@ func (c *OpencodeClient) ListSessions() ([]map[string]any, error) {
 	if err := json.Unmarshal(b, &out); err != nil {
 		return nil, err
 	}
-	return out, nil
+	if out == nil {
+		out = []map[string]any{}
+	}
+	c.sessionsMu.Lock()
+	c.sessions = out
+	c.sessionsAt = c.now()
+	c.sessionsMu.Unlock()
+	return append([]map[string]any(nil), out...), nil
 }
 
 func (c *OpencodeClient) CreateSession(title string) (map[string]any, error) {
+	return c.CreateSessionContext(context.Background(), title)
+}
+
+func (c *OpencodeClient) CreateSessionContext(ctx context.Context, title string) (map[string]any, error) {
 	body := map[string]any{"title": title}
-	b, err := c.doRequest("POST", "/session", body)
+	b, err := c.doRequestCtx(ctx, "POST", "/session", body)
 	if err != nil {
 		return nil, err
 	}
+	c.InvalidateSessions()
 	var out map[string]any
 	if err := json.Unmarshal(b, &out); err != nil {
 		return nil, err
@@ -106,9 +309,50 @@ func (c *OpencodeClient) CreateSession(title string) (map[string]any, error) {
 }
 
 func (c *OpencodeClient) PromptSession(sessionID, text string) (map[string]any, error) {
-	body := map[string]any{"parts": []map[string]any{{"type": "text", "text": text}}}
-	p := fmt.Sprintf("/session/%s/message", sessionID)
-	b, err := c.doRequest("POST", p, body)
+	return c.PromptSessionWithOptions(sessionID, text, PromptOptions{})
+}
+
+// PromptSessionWithOptions sends a prompt, adding a model override to the
+// request body when opts.Model or opts.ProviderID is set and a data-URL file
+// part for each of opts.Files.
+func (c *OpencodeClient) PromptSessionWithOptions(sessionID, text string, opts PromptOptions) (map[string]any, error) {
+	return c.PromptSessionWithOptionsContext(context.Background(), sessionID, text, opts)
+}
+
+// PromptSessionWithOptionsContext is PromptSessionWithOptions bounded by ctx
+// alone. Opencode answers a prompt only once the reply is complete, so the
+// request timeout does not apply.
+func (c *OpencodeClient) PromptSessionWithOptionsContext(ctx context.Context, sessionID, text string, opts PromptOptions) (map[string]any, error) {
+	parts := []map[string]any{{"type": "text", "text": text}}
+	for _, f := range opts.Files {
+		data, err := base64.StdEncoding.DecodeString(f.ContentBase64)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %q: %w", f.Name, err)
+		}
+		mime, _, _ := strings.Cut(http.DetectContentType(data), ";")
+		parts = append(parts, map[string]any{
+			"type":     "file",
+			"mime":     mime,
+			"filename": f.Name,
+			"url":      "data:" + mime + ";base64," + f.ContentBase64,
+		})
+	}
+	body := map[string]any{"parts": parts}
+	if opts.Model != "" || opts.ProviderID != "" {
+		model := map[string]string{}
+		if opts.Model != "" {
+			model["modelID"] = opts.Model
+		}
+		if opts.ProviderID != "" {
+			model["providerID"] = opts.ProviderID
+		}
+		body["model"] = model
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	b, _, err := c.doRequestOnce(ctx, "POST", fmt.Sprintf("/session/%s/message", sessionID), payload, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -120,29 +364,76 @@ func (c *OpencodeClient) PromptSession(sessionID, text string) (map[string]any,
 }
 
 func (c *OpencodeClient) AbortSession(sessionID string) error {
+	return c.AbortSessionContext(context.Background(), sessionID)
+}
+
+func (c *OpencodeClient) AbortSessionContext(ctx context.Context, sessionID string) error {
 	p := fmt.Sprintf("/session/%s/abort", sessionID)
-	_, err := c.doRequest("POST", p, nil)
+	_, err := c.doRequestCtx(ctx, "POST", p, nil)
 	return err
 }
 
 // DeleteSession deletes a session by ID.
 func (c *OpencodeClient) DeleteSession(sessionID string) error {
+	return c.DeleteSessionContext(context.Background(), sessionID)
+}
+
+func (c *OpencodeClient) DeleteSessionContext(ctx context.Context, sessionID string) error {
 	p := fmt.Sprintf("/session/%s", sessionID)
-	_, err := c.doRequest("DELETE", p, nil)
+	_, err := c.doRequestCtx(ctx, "DELETE", p, nil)
+	// Even a failed delete may have removed the session.
+	c.InvalidateSessions()
 	return err
 }
 
 // SubscribeEvents connects to the Opencode SSE endpoint (/event) and calls
-// handler for each parsed event payload. This runs until the connection
-// breaks; caller may run it in a goroutine.
+// handler for each parsed event payload. It is SubscribeEventsContext with a
+// background context, so the stream is kept alive for the process lifetime.
 func (c *OpencodeClient) SubscribeEvents(handler func(map[string]any)) error {
+	return c.SubscribeEventsContext(context.Background(), handler)
+}
+
+// SubscribeEventsContext connects to /event and returns once the first
+// connection is established (or fails). Events are then read in a goroutine
+// that reconnects with capped, jittered exponential backoff whenever the
+// stream ends, until ctx is cancelled.
+func (c *OpencodeClient) SubscribeEventsContext(ctx context.Context, handler func(map[string]any)) error {
+	body, err := c.openEventStream(ctx)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		attempt := 0
+		for {
+			readEventStream(body, handler)
+			body.Close()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(c.reconnectDelay(attempt)):
+				}
+				attempt++
+				body, err = c.openEventStream(ctx)
+				if err == nil {
+					attempt = 0
+					break
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *OpencodeClient) openEventStream(ctx context.Context) (io.ReadCloser, error) {
 	// build URL
 	u := *c.base
 	u.Path = path.Join(c.base.Path, "/event")
 
-	req, err := http.NewRequest("GET", u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req.Header.Set("Accept", "text/event-stream")
 	if c.token != "" {
@@ -151,65 +442,179 @@ func (c *OpencodeClient) SubscribeEvents(handler func(map[string]any)) error {
 
 	resp, err := c.http.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if resp.StatusCode >= 400 {
 		resp.Body.Close()
-		return fmt.Errorf("HTTP %d", resp.StatusCode)
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if c.heartbeatTimeout > 0 {
+		return newIdleTimeoutReader(resp.Body, c.heartbeatTimeout), nil
 	}
+	return resp.Body, nil
+}
 
-	// parse SSE using a buffered reader, handling multiple "data:" lines per event
-	go func() {
-		defer resp.Body.Close()
-		reader := bufio.NewReader(resp.Body)
-		var dataLines []string
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				if err == io.EOF {
-					return
-				}
-				return
-			}
-			line = strings.TrimRight(line, "\r\n")
-			if line == "" {
-				// event delimiter — join data lines
-				if len(dataLines) > 0 {
-					payload := strings.Join(dataLines, "\n")
-					var ev map[string]any
-					if err := json.Unmarshal([]byte(payload), &ev); err == nil {
-						handler(ev)
-					}
+// idleTimeoutReader closes the wrapped stream once no data has arrived for
+// timeout, which ends the pending read so the stream is reconnected.
+type idleTimeoutReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func newIdleTimeoutReader(body io.ReadCloser, timeout time.Duration) *idleTimeoutReader {
+	r := &idleTimeoutReader{body: body, timeout: timeout}
+	r.timer = time.AfterFunc(timeout, func() {
+		log.Printf("opencode event stream silent for %s; reconnecting", timeout)
+		_ = body.Close()
+	})
+	return r
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+func (r *idleTimeoutReader) Close() error {
+	r.timer.Stop()
+	return r.body.Close()
+}
+
+// eventParseLogInterval rate-limits the log of event frames that are not
+// valid JSON objects.
+const eventParseLogInterval = 30 * time.Second
+
+// readEventStream parses SSE from body until EOF or a read error, handling
+// multiple "data:" lines per event and lines of any length. An "event:" field
+// supplies the event type when the JSON has none, and an event still pending
+// when the stream ends is delivered. Frames that do not parse are logged at
+// most once per eventParseLogInterval.
+func readEventStream(body io.Reader, handler func(map[string]any)) {
+	reader := bufio.NewReader(body)
+	var dataLines []string
+	var eventType string
+	var lastLog time.Time
+	dropped := 0
+	dispatch := func() {
+		defer func() {
+			dataLines = dataLines[:0]
+			eventType = ""
+		}()
+		if len(dataLines) == 0 {
+			return
+		}
+		payload := strings.Join(dataLines, "\n")
+		var ev map[string]any
+		if err := json.Unmarshal([]byte(payload), &ev); err != nil || ev == nil {
+			dropped++
+			if time.Since(lastLog) >= eventParseLogInterval {
+				if len(payload) > 200 {
+					payload = payload[:200] + "... (truncated)"
 				}
-				dataLines = dataLines[:0]
-				continue
+				log.Printf("dropped %d unparsable opencode event(s), latest (event=%q): %v: %s", dropped, eventType, err, payload)
+				lastLog = time.Now()
+				dropped = 0
 			}
-			if strings.HasPrefix(line, "data:") {
-				data := strings.TrimSpace(line[len("data:"):])
-				dataLines = append(dataLines, data)
+			return
+		}
+		if _, ok := ev["type"]; !ok && eventType != "" {
+			ev["type"] = eventType
+		}
+		handler(ev)
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if err != nil {
+				dispatch()
+				return
 			}
-			// ignore other SSE fields (id:, event:, retry:)
+			// event delimiter — join data lines
+			dispatch()
+			continue
 		}
-	}()
-	return nil
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "data":
+			dataLines = append(dataLines, value)
+		case "event":
+			eventType = strings.TrimSpace(value)
+		}
+		// ignore comments and other SSE fields (id:, retry:)
+		if err != nil {
+			dispatch()
+			return
+		}
+	}
+}
+
+func (c *OpencodeClient) reconnectDelay(attempt int) time.Duration {
+	base, max := c.reconnectBase, c.reconnectMax
+	if base <= 0 {
+		base = defaultReconnectBase
+	}
+	if max <= 0 {
+		max = defaultReconnectMax
+	}
+	delta := max
+	if attempt < 16 {
+		if d := base << attempt; d < max {
+			delta = d
+		}
+	}
+	jitterMax := int64(delta / 5)
+	if jitterMax <= 0 {
+		return delta
+	}
+	return delta + time.Duration(rand.Int63n(jitterMax))
 }
 
-// GetSessionMessages fetches messages for a session and concatenates text parts,
-// filtering out thinking parts to return only the final output.
+// GetSessionMessages fetches messages for a session and returns the latest
+// non-thinking text part, used for debounced in-progress updates.
 func (c *OpencodeClient) GetSessionMessages(sessionID string) (string, error) {
+	texts, lastThinking, err := c.sessionTextParts(sessionID)
+	if err != nil {
+		return "", err
+	}
+	if len(texts) > 0 {
+		return texts[len(texts)-1], nil
+	}
+	return lastThinking, nil
+}
+
+// GetSessionMessagesFull fetches messages for a session and joins every
+// non-thinking text part in order, so multi-part answers are not truncated.
+func (c *OpencodeClient) GetSessionMessagesFull(sessionID string) (string, error) {
+	texts, lastThinking, err := c.sessionTextParts(sessionID)
+	if err != nil {
+		return "", err
+	}
+	if len(texts) > 0 {
+		return strings.Join(texts, "\n"), nil
+	}
+	return lastThinking, nil
+}
+
+// sessionTextParts returns the non-thinking text parts of a session in order,
+// plus the most recent thinking part as a fallback when there are none.
+func (c *OpencodeClient) sessionTextParts(sessionID string) ([]string, string, error) {
 	p := fmt.Sprintf("/session/%s/message", sessionID)
 	b, err := c.doRequest("GET", p, nil)
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 	// The response is typically an array of { info, parts }
 	var arr []map[string]any
 	if err := json.Unmarshal(b, &arr); err != nil {
-		return "", err
+		return nil, "", err
 	}
-	// Collect the last non-thinking text part and return it as the final output.
-	// If no non-thinking part exists, fall back to the most recent thinking part.
-	var lastNonThinking string
+	var texts []string
 	var lastThinking string
 	for _, item := range arr {
 		if parts, ok := item["parts"]; ok {
@@ -235,16 +640,12 @@ func (c *OpencodeClient) GetSessionMessages(sessionID string) (string, error) {
 						}
 
 						if text != "" {
-							lastNonThinking = text
+							texts = append(texts, text)
 						}
 					}
 				}
 			}
 		}
 	}
-
-	if lastNonThinking != "" {
-		return lastNonThinking, nil
-	}
-	return lastThinking, nil
+	return texts, lastThinking, nil
 }
@@ -1,11 +1,16 @@
 package bot
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+
+	"opencode-telegram/internal/proxy/contracts"
 )
 
 // TestOpencodeClient_NewOpencodeClient tests client creation
@@ -213,6 +218,56 @@ func TestOpencodeClient_GetSessionMessages_MixedContent(t *testing.T) {
 	}
 }
 
+// TestOpencodeClient_GetSessionMessagesFull tests joining all text parts in order
+func TestOpencodeClient_GetSessionMessagesFull(t *testing.T) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/session/multi/message", func(w http.ResponseWriter, r *http.Request) {
+		resp := []map[string]any{
+			{"parts": []map[string]any{{"type": "text", "text": "First paragraph"}}},
+			{
+				"parts": []map[string]any{
+					{"type": "thinking", "text": "Let me think..."},
+					{"type": "text", "text": "Second paragraph"},
+					{"type": "text", "text": "Third paragraph"},
+				},
+			},
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("/session/thinking/message", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]any{{"parts": []map[string]any{{"type": "thinking", "text": "Processing..."}}}})
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client, err := NewOpencodeClient(srv.URL, "")
+	if err != nil {
+		t.Fatalf("NewOpencodeClient: %v", err)
+	}
+
+	text, err := client.GetSessionMessagesFull("multi")
+	if err != nil {
+		t.Errorf("GetSessionMessagesFull error: %v", err)
+	}
+	if text != "First paragraph\nSecond paragraph\nThird paragraph" {
+		t.Errorf("unexpected full text %q", text)
+	}
+	if latest, _ := client.GetSessionMessages("multi"); latest != "Third paragraph" {
+		t.Errorf("expected latest part only, got %q", latest)
+	}
+
+	text, err = client.GetSessionMessagesFull("thinking")
+	if err != nil || text != "Processing..." {
+		t.Errorf("expected thinking fallback, got %q err=%v", text, err)
+	}
+
+	if _, err := client.GetSessionMessagesFull("missing"); err == nil {
+		t.Error("expected error for missing session")
+	}
+}
+
 // TestOpencodeClient_GetSessionMessages_ThinkingOnly tests fallback to thinking content
 func TestOpencodeClient_GetSessionMessages_ThinkingOnly(t *testing.T) {
 	mux := http.NewServeMux()
@@ -417,8 +472,35 @@ func TestOpencodeClient_ErrorHandling(t *testing.T) {
 	}
 
 	_, err = client.ListSessions()
-	if err == nil {
-		t.Errorf("expected error for 500 status, got nil")
+	var oe *OpencodeError
+	if !errors.As(err, &oe) || oe.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected OpencodeError with status 500, got %v", err)
+	}
+	if !strings.Contains(oe.Body, "Internal Server Error") {
+		t.Fatalf("expected response body kept, got %q", oe.Body)
+	}
+}
+
+func TestOpencodeClient_NotFoundIsTyped(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/session/gone", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+	mux.HandleFunc("/session/gone/abort", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client, err := NewOpencodeClient(srv.URL, "")
+	if err != nil {
+		t.Fatalf("NewOpencodeClient: %v", err)
+	}
+	if err := client.DeleteSession("gone"); !isOpencodeStatus(err, http.StatusNotFound) {
+		t.Fatalf("expected 404 from delete, got %v", err)
+	}
+	if err := client.AbortSession("gone"); !isOpencodeStatus(err, http.StatusNotFound) {
+		t.Fatalf("expected 404 from abort, got %v", err)
 	}
 }
 
@@ -508,4 +590,31 @@ func TestOpencodeClient_PromptSession_RequestBody(t *testing.T) {
 	if !ok || len(parts) == 0 {
 		t.Errorf("expected parts in request body")
 	}
+	if _, ok := receivedBody["model"]; ok {
+		t.Errorf("expected no model override without options, got %v", receivedBody["model"])
+	}
+
+	if _, err := client.PromptSessionWithOptions("ses_test", "test prompt", PromptOptions{Model: "claude-sonnet", ProviderID: "anthropic"}); err != nil {
+		t.Fatalf("PromptSessionWithOptions error: %v", err)
+	}
+	model, ok := receivedBody["model"].(map[string]any)
+	if !ok || model["modelID"] != "claude-sonnet" || model["providerID"] != "anthropic" {
+		t.Errorf("expected model override in request body, got %v", receivedBody["model"])
+	}
+
+	files := []contracts.Attachment{{Name: "notes.txt", ContentBase64: base64.StdEncoding.EncodeToString([]byte("hello"))}}
+	if _, err := client.PromptSessionWithOptions("ses_test", "test prompt", PromptOptions{Files: files}); err != nil {
+		t.Fatalf("PromptSessionWithOptions with files error: %v", err)
+	}
+	parts, _ = receivedBody["parts"].([]any)
+	if len(parts) != 2 {
+		t.Fatalf("expected text and file parts, got %v", parts)
+	}
+	file, _ := parts[1].(map[string]any)
+	if file["type"] != "file" || file["filename"] != "notes.txt" || file["url"] != "data:text/plain;base64,aGVsbG8=" {
+		t.Errorf("unexpected file part: %v", file)
+	}
+	if _, err := client.PromptSessionWithOptions("ses_test", "test prompt", PromptOptions{Files: []contracts.Attachment{{Name: "bad", ContentBase64: "!"}}}); err == nil {
+		t.Error("expected invalid attachment content to fail")
+	}
 }
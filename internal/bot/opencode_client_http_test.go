@@ -1,12 +1,16 @@
 package bot
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestOpencodeClient_HTTPHeadersAndSessionActions(t *testing.T) {
@@ -108,3 +112,206 @@ func TestOpencodeClient_HTTPHeadersAndSessionActions(t *testing.T) {
 		t.Fatalf("unexpected sessions: %v", sess)
 	}
 }
+
+func TestOpencodeClient_RequestTimeoutAndContext(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	client, err := NewOpencodeClient(srv.URL, "")
+	if err != nil {
+		t.Fatalf("NewOpencodeClient: %v", err)
+	}
+	if client.requestTimeout != defaultRequestTimeout {
+		t.Fatalf("expected default timeout, got %v", client.requestTimeout)
+	}
+	client.SetRequestTimeout(50 * time.Millisecond)
+	start := time.Now()
+	if _, err := client.ListSessions(); err == nil {
+		t.Fatal("expected hung request to time out")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("request outlived its timeout: %v", time.Since(start))
+	}
+
+	// a prompt outlives the request timeout and ends only with its context
+	promptCtx, promptCancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		promptCancel()
+	}()
+	if _, err := client.PromptSessionWithOptionsContext(promptCtx, "ses_1", "hi", PromptOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected prompt to run until cancelled, got %v", err)
+	}
+
+	client.SetRequestTimeout(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if err := client.DeleteSessionContext(ctx, "ses_1"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancelled request, got %v", err)
+	}
+}
+
+func TestOpencodeClient_RetriesIdempotentRequests(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.Method]++
+		n := calls[r.Method]
+		mu.Unlock()
+		if n <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	client, err := NewOpencodeClient(srv.URL, "")
+	if err != nil {
+		t.Fatalf("NewOpencodeClient: %v", err)
+	}
+	client.retryBase = time.Millisecond
+
+	if _, err := client.ListSessions(); err != nil {
+		t.Fatalf("expected GET to succeed after retries, got %v", err)
+	}
+	if calls["GET"] != 3 {
+		t.Fatalf("expected 3 GET attempts, got %d", calls["GET"])
+	}
+	if _, err := client.PromptSession("ses_1", "hi"); err == nil {
+		t.Fatal("expected POST to fail without retrying")
+	}
+	if calls["POST"] != 1 {
+		t.Fatalf("expected a single POST attempt, got %d", calls["POST"])
+	}
+
+	client.retryMax = 1
+	if err := client.DeleteSession("ses_1"); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("expected DELETE to give up after retryMax, got %v", err)
+	}
+	if calls["DELETE"] != 2 {
+		t.Fatalf("expected 2 DELETE attempts, got %d", calls["DELETE"])
+	}
+}
+
+func TestOpencodeClient_CachesSessionList(t *testing.T) {
+	var mu sync.Mutex
+	fetches := 0
+	sessions := []map[string]any{{"id": "ses_1", "title": "oct_one"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/session":
+			fetches++
+			_ = json.NewEncoder(w).Encode(sessions)
+		case r.Method == "POST" && r.URL.Path == "/session":
+			sessions = append(sessions, map[string]any{"id": "ses_2", "title": "oct_two"})
+			_ = json.NewEncoder(w).Encode(sessions[len(sessions)-1])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewOpencodeClient(srv.URL, "")
+	if err != nil {
+		t.Fatalf("NewOpencodeClient: %v", err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	client.now = func() time.Time { return now }
+	fetchCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return fetches
+	}
+
+	// miss then hit
+	for i := 0; i < 2; i++ {
+		if got, err := client.ListSessions(); err != nil || len(got) != 1 {
+			t.Fatalf("list %d: got %v, %v", i, got, err)
+		}
+	}
+	if n := fetchCount(); n != 1 {
+		t.Fatalf("expected one fetch within the TTL, got %d", n)
+	}
+
+	// expiry
+	now = now.Add(defaultSessionCacheTTL)
+	if _, err := client.ListSessions(); err != nil {
+		t.Fatalf("list after expiry: %v", err)
+	}
+	if n := fetchCount(); n != 2 {
+		t.Fatalf("expected a refetch once the TTL passed, got %d fetches", n)
+	}
+
+	// creating a session invalidates the cache
+	if _, err := client.CreateSession("oct_two"); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if got, err := client.ListSessions(); err != nil || len(got) != 2 || fetchCount() != 3 {
+		t.Fatalf("expected fresh list after create, got %v, %v after %d fetches", got, err, fetchCount())
+	}
+
+	// a negative TTL disables the cache
+	client.SetSessionCacheTTL(-1)
+	_, _ = client.ListSessions()
+	_, _ = client.ListSessions()
+	if n := fetchCount(); n != 5 {
+		t.Fatalf("expected every list to fetch with the cache disabled, got %d", n)
+	}
+}
+
+func TestBotSessionExistsUsesSessionCache(t *testing.T) {
+	var mu sync.Mutex
+	fetches := 0
+	sessions := []map[string]any{{"id": "ses_1"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		_ = json.NewEncoder(w).Encode(sessions)
+	}))
+	defer srv.Close()
+
+	client, err := NewOpencodeClient(srv.URL, "")
+	if err != nil {
+		t.Fatalf("NewOpencodeClient: %v", err)
+	}
+	app, _, _ := testBotApp(&Config{}, client)
+
+	for i := 0; i < 2; i++ {
+		if ok, err := app.sessionExists("ses_1"); err != nil || !ok {
+			t.Fatalf("expected ses_1 to exist, got %v, %v", ok, err)
+		}
+	}
+	mu.Lock()
+	if fetches != 1 {
+		t.Fatalf("expected cached hits to skip fetching, got %d fetches", fetches)
+	}
+	// a session created elsewhere is found by refetching on a miss
+	sessions = append(sessions, map[string]any{"id": "ses_2"})
+	mu.Unlock()
+	if ok, err := app.sessionExists("ses_2"); err != nil || !ok {
+		t.Fatalf("expected ses_2 found after refetch, got %v, %v", ok, err)
+	}
+	if ok, err := app.sessionExists("ses_gone"); err != nil || ok {
+		t.Fatalf("expected unknown session missing, got %v, %v", ok, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fetches != 3 {
+		t.Fatalf("expected one refetch per miss, got %d fetches", fetches)
+	}
+}
@@ -1,11 +1,13 @@
 package bot
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -245,3 +247,185 @@ func TestOpencodeClient_SubscribeEvents_Success(t *testing.T) {
 		t.Error("timeout waiting for event")
 	}
 }
+
+func TestReadEventStream_MultiLineAndOversizedEvents(t *testing.T) {
+	big := strings.Repeat("x", 256*1024)
+	stream := strings.Join([]string{
+		": heartbeat",
+		"data: {\"type\":\"session.updated\",",
+		"data:  \"data\":{\"sessionID\":\"ses_multi\"}}",
+		"",
+		"data: not json",
+		"",
+		"event: message.part.updated",
+		"data: {\"data\":{\"sessionID\":\"ses_big\",\"text\":\"" + big + "\"}}",
+		"",
+		// the last event is delivered even without a trailing blank line
+		"data: {\"type\":\"session.idle\"}",
+	}, "\r\n")
+
+	var events []map[string]any
+	readEventStream(strings.NewReader(stream), func(ev map[string]any) {
+		events = append(events, ev)
+	})
+
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	if events[0]["type"] != "session.updated" || findStringKeyRecursive(events[0], "sessionID") != "ses_multi" {
+		t.Fatalf("expected multi-line data joined into one event, got %+v", events[0])
+	}
+	if events[1]["type"] != "message.part.updated" {
+		t.Fatalf("expected the event field to supply the type, got %v", events[1]["type"])
+	}
+	if data, _ := events[1]["data"].(map[string]any); data["text"] != big {
+		t.Fatalf("expected the oversized event intact, got %d chars", len(fmt.Sprint(data["text"])))
+	}
+	if events[2]["type"] != "session.idle" {
+		t.Fatalf("expected the unterminated last event, got %+v", events[2])
+	}
+}
+
+func TestOpencodeClient_SubscribeEventsContext_DetectsStall(t *testing.T) {
+	var mu sync.Mutex
+	connections := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/event", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		connections++
+		n := connections
+		mu.Unlock()
+		w.WriteHeader(200)
+		if n == 1 {
+			// heartbeats keep the stream alive for a while, then it goes
+			// silent without closing, like a connection dropped by a proxy
+			for i := 0; i < 3; i++ {
+				w.Write([]byte(":\n"))
+				w.(http.Flusher).Flush()
+				time.Sleep(30 * time.Millisecond)
+			}
+			w.Write([]byte("data: {\"type\":\"first\"}\n\n"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("data: {\"type\":\"second\"}\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c, err := NewOpencodeClient(srv.URL, "")
+	if err != nil {
+		t.Fatalf("NewOpencodeClient: %v", err)
+	}
+	c.reconnectBase = 5 * time.Millisecond
+	c.SetHeartbeatTimeout(60 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan string, 4)
+	start := time.Now()
+	if err := c.SubscribeEventsContext(ctx, func(ev map[string]any) {
+		events <- fmt.Sprint(ev["type"])
+	}); err != nil {
+		t.Fatalf("SubscribeEventsContext: %v", err)
+	}
+	for _, want := range []string{"first", "second"} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("expected event %q, got %q", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for event %q", want)
+		}
+	}
+	// heartbeats spaced below the timeout must not have cut the first stream
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("expected heartbeats to keep the first stream open, reconnected after %s", elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if connections != 2 {
+		t.Fatalf("expected exactly one reconnect after the stall, got %d connections", connections)
+	}
+}
+
+func TestOpencodeClient_SubscribeEventsContext_Reconnects(t *testing.T) {
+	var mu sync.Mutex
+	connections := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/event", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		connections++
+		n := connections
+		mu.Unlock()
+		switch n {
+		case 1:
+			// drop the stream after one event
+			w.WriteHeader(200)
+			w.Write([]byte("data: {\"type\":\"first\"}\n\n"))
+		case 2:
+			// opencode still restarting
+			w.WriteHeader(503)
+		default:
+			w.WriteHeader(200)
+			w.Write([]byte("data: {\"type\":\"second\"}\n\n"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c, err := NewOpencodeClient(srv.URL, "")
+	if err != nil {
+		t.Fatalf("NewOpencodeClient: %v", err)
+	}
+	c.reconnectBase = 5 * time.Millisecond
+	c.reconnectMax = 20 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan string, 4)
+	if err := c.SubscribeEventsContext(ctx, func(ev map[string]any) {
+		events <- fmt.Sprint(ev["type"])
+	}); err != nil {
+		t.Fatalf("SubscribeEventsContext: %v", err)
+	}
+	for _, want := range []string{"first", "second"} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("expected event %q, got %q", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for event %q", want)
+		}
+	}
+
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	seen := connections
+	mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if connections != seen {
+		t.Fatalf("expected no reconnects after cancel, got %d -> %d", seen, connections)
+	}
+}
+
+func TestOpencodeClient_ReconnectDelay(t *testing.T) {
+	c := &OpencodeClient{reconnectBase: 100 * time.Millisecond, reconnectMax: time.Second}
+	if d := c.reconnectDelay(0); d < 100*time.Millisecond || d >= 120*time.Millisecond {
+		t.Fatalf("unexpected first delay: %v", d)
+	}
+	if d := c.reconnectDelay(2); d < 400*time.Millisecond || d >= 480*time.Millisecond {
+		t.Fatalf("unexpected third delay: %v", d)
+	}
+	if d := c.reconnectDelay(60); d < time.Second || d >= 1200*time.Millisecond {
+		t.Fatalf("expected capped delay, got %v", d)
+	}
+}
@@ -1,16 +1,26 @@
 package bot
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"opencode-telegram/internal/proxy/contracts"
 	"opencode-telegram/pkg/store"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -23,15 +33,23 @@ type TelegramBotInterface interface {
 
 type DebouncerInterface interface {
 	Debounce(key string, text string, fn func(string) error)
+	Flush(key string)
+	Cancel(key string)
 }
 
-var newTelegramBot = func(token string) (TelegramBotInterface, error) {
-	return tgbotapi.NewBotAPI(token)
+// newTelegramBot connects to the Bot API at apiURL, or to Telegram's own
+// when apiURL is empty.
+var newTelegramBot = func(token, apiURL string) (TelegramBotInterface, error) {
+	if apiURL == "" {
+		return tgbotapi.NewBotAPI(token)
+	}
+	return tgbotapi.NewBotAPIWithAPIEndpoint(token, strings.TrimRight(apiURL, "/")+"/bot%s/%s")
 }
 
 type BotApp struct {
 	tg           TelegramBotInterface
 	cfg          *Config
+	accessMu     sync.RWMutex // guards cfg.AllowedIDs and cfg.AdminIDs
 	oc           OpencodeClientInterface
 	store        store.Store
 	debouncer    DebouncerInterface
@@ -39,15 +57,52 @@ type BotApp struct {
 	runMu        sync.Mutex
 	activeRuns   map[string]string
 	runOwners    map[string]string
+	userRuns     map[int64]int // runs in progress per user, capped by maxRunsPerUser
 	sleep        func(time.Duration)
 
+	// eventTypes are the Opencode event types handleEvent acts on; nil
+	// means DefaultEventTypes.
+	eventMu    sync.RWMutex
+	eventTypes map[string]bool
+
+	// sessionParts caches the text parts streamed for each mapped session.
+	partsMu      sync.Mutex
+	sessionParts map[string]*sessionParts
+
+	// lastEdits holds the text last queued for each session message, so an
+	// identical edit is not sent again.
+	editsMu   sync.Mutex
+	lastEdits map[messageKey]string
+
 	// Backend client for command routing
 	backendURL string
 	httpClient *http.Client
 
 	listProjectsFn func(userID int64) ([]projectRecord, error)
+
+	// ctx is the StartPollingContext context, cancelled on shutdown.
+	ctx context.Context
+
+	// fileEndpoint formats Telegram file download URLs from the bot token and
+	// file path; empty means tgbotapi.FileEndpoint.
+	fileEndpoint string
+
+	// typingInterval is how often the typing action is resent while a
+	// run_task is pending; zero means defaultTypingInterval.
+	typingInterval time.Duration
 }
 
+// Telegram shows a chat action for about five seconds, so it is refreshed a
+// little sooner. maxTypingDuration bounds it if no result ever arrives.
+const (
+	defaultTypingInterval = 4 * time.Second
+	maxTypingDuration     = 10 * time.Minute
+)
+
+// broadcastInterval spaces /broadcast sends to stay well under Telegram's
+// limit of about 30 messages per second.
+const broadcastInterval = 50 * time.Millisecond
+
 type approvalDecision struct {
 	Decision  string     `json:"decision"`
 	ExpiresAt *time.Time `json:"expires_at"`
@@ -76,6 +131,20 @@ type commandRecord struct {
 	ProjectID string    `json:"project_id"`
 	Alias     string    `json:"alias"`
 	CreatedAt time.Time `json:"created_at"`
+	// Ping marks a status command sent by /ping; its result is relayed as
+	// the round-trip time since CreatedAt.
+	Ping bool `json:"ping,omitempty"`
+}
+
+// historyEntry mirrors the backend's command history record.
+type historyEntry struct {
+	CommandID string    `json:"command_id"`
+	Type      string    `json:"type"`
+	ProjectID string    `json:"project_id"`
+	Alias     string    `json:"alias"`
+	CreatedAt time.Time `json:"created_at"`
+	Status    string    `json:"status"`
+	ErrorCode string    `json:"error_code"`
 }
 
 type storedCommands struct {
@@ -83,7 +152,26 @@ type storedCommands struct {
 }
 
 func NewBotApp(cfg *Config, oc OpencodeClientInterface, st store.Store) (*BotApp, error) {
-	bot, err := newTelegramBot(cfg.TelegramToken)
+	if cfg.RequireHTTPS {
+		if err := contracts.CheckSecureURL(cfg.OpencodeBase); err != nil {
+			return nil, fmt.Errorf("OPENCODE_BASE_URL: %w (set OCT_REQUIRE_HTTPS=false to allow it)", err)
+		}
+		if err := contracts.CheckSecureURL(cfg.BackendURL); err != nil {
+			return nil, fmt.Errorf("OCT_BACKEND_URL: %w (set OCT_REQUIRE_HTTPS=false to allow it)", err)
+		}
+		if cfg.TelegramAPIEndpoint != "" {
+			if err := contracts.CheckSecureURL(cfg.TelegramAPIEndpoint); err != nil {
+				return nil, fmt.Errorf("TELEGRAM_API_ENDPOINT: %w (set OCT_REQUIRE_HTTPS=false to allow it)", err)
+			}
+		}
+	}
+	// A self-hosted Bot API server serves files too; Telegram's own file
+	// host would not know tokens or files of another server.
+	var fileEndpoint string
+	if cfg.TelegramAPIEndpoint != "" {
+		fileEndpoint = strings.TrimRight(cfg.TelegramAPIEndpoint, "/") + "/file/bot%s/%s"
+	}
+	bot, err := newTelegramBot(cfg.TelegramToken, cfg.TelegramAPIEndpoint)
 	if err != nil {
 		return nil, err
 	}
@@ -92,12 +180,14 @@ func NewBotApp(cfg *Config, oc OpencodeClientInterface, st store.Store) (*BotApp
 		cfg:            cfg,
 		oc:             oc,
 		store:          st,
-		debouncer:      NewDebouncer(500 * time.Millisecond),
+		debouncer:      NewDebouncer(time.Duration(clampDebounceMillis(cfg.DebounceMillis)) * time.Millisecond),
 		activeRuns:     make(map[string]string),
 		runOwners:      make(map[string]string),
+		eventTypes:     newEventTypes(cfg.EventTypes),
 		sleep:          time.Sleep,
+		fileEndpoint:   fileEndpoint,
 		backendURL:     cfg.BackendURL,
-		httpClient:     &http.Client{Timeout: 30 * time.Second},
+		httpClient:     &http.Client{Timeout: 30 * time.Second, Transport: contracts.NewHTTPTransport(cfg.HTTPMaxIdleConns, cfg.HTTPIdleTimeout)},
 		listProjectsFn: nil,
 	}
 
@@ -111,7 +201,7 @@ func NewBotApp(cfg *Config, oc OpencodeClientInterface, st store.Store) (*BotApp
 	prefix := cfg.SessionPrefix
 
 	for _, s := range sessions {
-		if title, ok := s["title"].(string); ok && strings.HasPrefix(title, prefix) {
+		if title, ok := s["title"].(string); ok && cfg.hasSessionPrefix(title) {
 			if id, ok := s["id"].(string); ok {
 				foundID = id
 				break
@@ -137,101 +227,173 @@ func NewBotApp(cfg *Config, oc OpencodeClientInterface, st store.Store) (*BotApp
 	return app, nil
 }
 
+// StartPolling handles Telegram updates until the update channel closes.
 func (a *BotApp) StartPolling() error {
+	return a.StartPollingContext(context.Background())
+}
+
+// StartPollingContext is StartPolling that also stops when ctx is cancelled.
+// Opencode requests made by command handlers use ctx, so shutdown interrupts
+// them instead of waiting for the request timeout.
+func (a *BotApp) StartPollingContext(ctx context.Context) error {
+	a.ctx = ctx
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 	updates := a.tg.GetUpdatesChan(u)
-	for upd := range updates {
-		if upd.CallbackQuery != nil {
-			a.handleCallbackQuery(upd.CallbackQuery)
-			continue
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case upd, ok := <-updates:
+			if !ok {
+				return nil
+			}
+			a.handleUpdate(upd)
 		}
+	}
+}
 
-		if upd.Message == nil {
-			continue
-		}
-		if upd.Message.From == nil {
-			continue
-		}
+func (a *BotApp) handleUpdate(upd tgbotapi.Update) {
+	if upd.CallbackQuery != nil {
+		a.handleCallbackQuery(upd.CallbackQuery)
+		return
+	}
+
+	if upd.Message == nil {
+		return
+	}
+	if upd.Message.From == nil {
+		return
+	}
 
-		userID := upd.Message.From.ID
-		if upd.Message.IsCommand() {
-			cmd := upd.Message.Command()
-			args := upd.Message.CommandArguments()
+	userID := upd.Message.From.ID
+	if upd.Message.IsCommand() {
+		cmd := upd.Message.Command()
+		args := upd.Message.CommandArguments()
 
-			if !a.isAllowed(userID) && cmd != "start" && cmd != "help" {
-				a.sendAccessGuidance(upd.Message.Chat.ID)
-				continue
-			}
+		if !a.isAllowed(userID) && cmd != "start" && cmd != "help" && cmd != "whoami" {
+			a.sendAccessGuidance(upd.Message.Chat.ID)
+			return
+		}
 
-			switch cmd {
-			case "start":
-				a.handleStart(upd.Message.Chat.ID)
-			case "help":
-				a.handleHelp(upd.Message.Chat.ID)
-			case "settings":
-				a.handleSettings(upd.Message.Chat.ID)
-			case "language":
-				a.handleLanguage(upd.Message.Chat.ID)
-			case "mute":
-				a.handleMute(upd.Message.Chat.ID)
-			case "unmute":
-				a.handleUnmute(upd.Message.Chat.ID)
-			case "createsession":
-				a.handleCreateSession(upd.Message.Chat.ID, args, userID)
-			case "deletesession":
-				a.handleDeleteSession(upd.Message.Chat.ID, args, userID)
-			case "selectsession":
-				a.handleSelectSession(upd.Message.Chat.ID, args, userID)
-			case "mysession":
-				a.handleMySession(upd.Message.Chat.ID, userID)
-			case "status":
-				a.handleAgentStatus(upd.Message.Chat.ID, userID)
-			case "sessions":
-				a.handleSessions(upd.Message.Chat.ID)
-			case "run":
-				a.handleRun(upd.Message.Chat.ID, args, userID)
-			case "abort":
-				a.handleAbort(upd.Message.Chat.ID, args, userID)
-			case "project":
-				// Handle /project add/list subcommand
-				fields := strings.Fields(args)
-				if len(fields) == 0 {
-					a.tg.Send(tgbotapi.NewMessage(upd.Message.Chat.ID, "Usage: /project add <ABS_PATH> | /project list"))
-					break
-				}
-				sub := fields[0]
-				rest := strings.TrimSpace(strings.TrimPrefix(args, sub))
-				switch sub {
-				case "add":
-					a.handleProjectAdd(upd.Message.Chat.ID, rest, userID)
-				case "list":
-					a.handleProjectList(upd.Message.Chat.ID, userID)
-				default:
-					a.tg.Send(tgbotapi.NewMessage(upd.Message.Chat.ID, "Usage: /project add <ABS_PATH> | /project list"))
-				}
-			case "start_server":
-				a.handleStartServer(upd.Message.Chat.ID, args, userID)
-			case "pair":
-				a.startPairing(upd.Message.Chat.ID, userID)
-			case "agent_status":
-				a.handleAgentStatus(upd.Message.Chat.ID, userID)
-			default:
-				a.tg.Send(tgbotapi.NewMessage(upd.Message.Chat.ID, "Unknown command"))
+		switch cmd {
+		case "start":
+			a.handleStart(upd.Message.Chat.ID)
+		case "help":
+			a.handleHelp(upd.Message.Chat.ID)
+		case "whoami":
+			a.handleWhoami(upd.Message.Chat.ID, userID)
+		case "settings":
+			a.handleSettings(upd.Message.Chat.ID)
+		case "language":
+			a.handleLanguage(upd.Message.Chat.ID)
+		case "mute":
+			a.handleMute(upd.Message.Chat.ID)
+		case "unmute":
+			a.handleUnmute(upd.Message.Chat.ID)
+		case "createsession":
+			a.handleCreateSession(upd.Message.Chat.ID, args, userID)
+		case "deletesession":
+			a.handleDeleteSession(upd.Message.Chat.ID, args, userID)
+		case "broadcast":
+			a.handleBroadcast(upd.Message.Chat.ID, args, userID)
+		case "selectsession":
+			a.handleSelectSession(upd.Message.Chat.ID, args, userID)
+		case "mysession":
+			a.handleMySession(upd.Message.Chat.ID, userID)
+		case "bind":
+			a.handleBind(upd.Message.Chat.ID, args, userID)
+		case "last":
+			a.handleLast(upd.Message.Chat.ID, userID)
+		case "status":
+			a.handleAgentStatus(upd.Message.Chat.ID, userID)
+		case "sessions":
+			a.handleSessions(upd.Message.Chat.ID, userID)
+		case "run":
+			a.handleRun(upd.Message.Chat.ID, args, userID)
+		case "new":
+			a.handleNewRun(upd.Message.Chat.ID, args, userID)
+		case "runbatch":
+			a.handleRunBatch(upd.Message.Chat.ID, args, userID)
+		case "model":
+			a.handleModel(upd.Message.Chat.ID, args, userID)
+		case "abort":
+			a.handleAbort(upd.Message.Chat.ID, args, userID)
+		case "policy":
+			a.handlePolicy(upd.Message.Chat.ID, args, userID)
+		case "project":
+			// Handle /project add/list/delete subcommand
+			fields := strings.Fields(args)
+			if len(fields) == 0 {
+				a.tg.Send(tgbotapi.NewMessage(upd.Message.Chat.ID, projectUsage))
+				break
 			}
-		} else if upd.Message.Text != "" {
-			if !a.isAllowed(userID) {
-				a.sendAccessGuidance(upd.Message.Chat.ID)
-				continue
+			sub := fields[0]
+			rest := strings.TrimSpace(strings.TrimPrefix(args, sub))
+			switch sub {
+			case "add":
+				a.handleProjectAdd(upd.Message.Chat.ID, rest, userID)
+			case "list":
+				a.handleProjectList(upd.Message.Chat.ID, rest, userID)
+			case "delete":
+				a.handleProjectDelete(upd.Message.Chat.ID, rest, userID)
+			default:
+				a.tg.Send(tgbotapi.NewMessage(upd.Message.Chat.ID, projectUsage))
 			}
-			// treat any non-command message as a prompt
-			a.handleRun(upd.Message.Chat.ID, upd.Message.Text, userID)
+		case "projects":
+			a.handleProjectList(upd.Message.Chat.ID, args, userID)
+		case "start_server":
+			a.handleStartServer(upd.Message.Chat.ID, args, userID)
+		case "stop_server":
+			a.handleStopServer(upd.Message.Chat.ID, args, userID)
+		case "pair":
+			a.handlePair(upd.Message.Chat.ID, args, userID)
+		case "repair":
+			a.handleRepair(upd.Message.Chat.ID, userID)
+		case "unpair":
+			a.handleUnpair(upd.Message.Chat.ID, args, userID)
+		case "ping":
+			a.handlePing(upd.Message.Chat.ID, userID)
+		case "agent_status":
+			a.handleAgentStatus(upd.Message.Chat.ID, userID)
+		case "agent":
+			a.handleAgentPresence(upd.Message.Chat.ID, userID)
+		case "history":
+			a.handleHistory(upd.Message.Chat.ID, userID)
+		case "queue":
+			a.handleQueue(upd.Message.Chat.ID, userID)
+		case "cancel":
+			a.handleCancel(upd.Message.Chat.ID, args, userID)
+		default:
+			a.tg.Send(tgbotapi.NewMessage(upd.Message.Chat.ID, "Unknown command. Use /help to see available commands."))
+		}
+	} else if upd.Message.Document != nil || len(upd.Message.Photo) > 0 {
+		if !a.isAllowed(userID) {
+			a.sendAccessGuidance(upd.Message.Chat.ID)
+			return
+		}
+		a.handleAttachment(upd.Message, userID)
+	} else if upd.Message.Text != "" {
+		if !a.isAllowed(userID) {
+			a.sendAccessGuidance(upd.Message.Chat.ID)
+			return
 		}
+		// treat any non-command message as a prompt
+		a.handleRun(upd.Message.Chat.ID, upd.Message.Text, userID)
 	}
-	return nil
+}
+
+// requestContext is the context for Opencode requests made by handlers.
+func (a *BotApp) requestContext() context.Context {
+	if a.ctx != nil {
+		return a.ctx
+	}
+	return context.Background()
 }
 
 func (a *BotApp) isAllowed(userID int64) bool {
+	a.accessMu.RLock()
+	defer a.accessMu.RUnlock()
 	if len(a.cfg.AllowedIDs) == 0 {
 		return true
 	}
@@ -239,22 +401,141 @@ func (a *BotApp) isAllowed(userID int64) bool {
 }
 
 func (a *BotApp) isAdmin(userID int64) bool {
+	a.accessMu.RLock()
+	defer a.accessMu.RUnlock()
 	return a.cfg.AdminIDs[userID]
 }
 
+// ReloadConfig re-reads ALLOWED_TELEGRAM_IDS and ADMIN_TELEGRAM_IDS from the
+// env and the optional access file, swaps them in and logs the changes. On
+// error the current lists are kept.
+func (a *BotApp) ReloadConfig() error {
+	allowed, admin, err := loadAccessIDs(a.cfg.AccessFile)
+	if err != nil {
+		return err
+	}
+	a.accessMu.Lock()
+	oldAllowed, oldAdmin := a.cfg.AllowedIDs, a.cfg.AdminIDs
+	a.cfg.AllowedIDs, a.cfg.AdminIDs = allowed, admin
+	a.accessMu.Unlock()
+
+	added, removed := diffIDs(oldAllowed, allowed)
+	log.Printf("access reloaded: allowed added=%v removed=%v", added, removed)
+	added, removed = diffIDs(oldAdmin, admin)
+	log.Printf("access reloaded: admin added=%v removed=%v", added, removed)
+	return nil
+}
+
+// diffIDs returns the IDs only in next and only in prev, sorted.
+func diffIDs(prev, next map[int64]bool) (added, removed []int64) {
+	for id := range next {
+		if !prev[id] {
+			added = append(added, id)
+		}
+	}
+	for id := range prev {
+		if !next[id] {
+			removed = append(removed, id)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+	sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+	return added, removed
+}
+
 func (a *BotApp) sendAccessGuidance(chatID int64) {
-	a.tg.Send(tgbotapi.NewMessage(chatID, "Access required. Ask an admin to add your Telegram ID to ALLOWED_TELEGRAM_IDS."))
+	a.tg.Send(tgbotapi.NewMessage(chatID, "Access required. Ask an admin to add your Telegram ID to ALLOWED_TELEGRAM_IDS; /whoami shows it."))
+}
+
+const projectUsage = "Usage: /project add <ABS_PATH> | /project list [page] | /project delete <project>"
+
+// projectPageSize is how many projects one /project list message shows.
+const projectPageSize = 20
+
+// botCommand describes a supported command for /help output.
+type botCommand struct {
+	Usage       string
+	Description string
+	AdminOnly   bool
+}
+
+var botCommands = []botCommand{
+	{Usage: "/start", Description: "show welcome message and command list"},
+	{Usage: "/help", Description: "show this command list"},
+	{Usage: "/whoami", Description: "show your Telegram ID, access and pairing"},
+	{Usage: "/settings", Description: "open settings menu"},
+	{Usage: "/language", Description: "show current language"},
+	{Usage: "/mute", Description: "mute notifications"},
+	{Usage: "/unmute", Description: "unmute notifications"},
+	{Usage: "/status", Description: "query paired agent status"},
+	{Usage: "/agent_status", Description: "alias for /status"},
+	{Usage: "/ping", Description: "measure the round trip to your paired agent"},
+	{Usage: "/agent", Description: "show whether your paired agent is online and its queue"},
+	{Usage: "/history", Description: "show your recent backend commands and their status"},
+	{Usage: "/queue", Description: "list commands still waiting for your agent"},
+	{Usage: "/cancel <command_id>", Description: "cancel a running run_task"},
+	{Usage: "/pair", Description: "start agent pairing and show the pairing code"},
+	{Usage: "/pair status", Description: "show whether pairing completed, finishing it with a pending code"},
+	{Usage: "/repair", Description: "replace your unclaimed pairing code with a new one"},
+	{Usage: "/unpair [telegram_id]", Description: "revoke your agent key; admins may name another user"},
+	{Usage: "/project add <ABS_PATH>", Description: "register a project on the paired agent"},
+	{Usage: "/project list [page]", Description: "list registered projects"},
+	{Usage: "/projects [page]", Description: "alias for /project list"},
+	{Usage: "/project delete <project>", Description: "remove a registered project"},
+	{Usage: "/policy <project> [allow <start|run>... [ttl] | deny]", Description: "show or set a project's policy"},
+	{Usage: "/start_server <project>", Description: "start Opencode server for a project"},
+	{Usage: "/stop_server <project>", Description: "stop Opencode server for a project"},
+	{Usage: "/run <project> <prompt>", Description: "run a task in a project"},
+	{Usage: "/new [project] <prompt>", Description: "create and select a new session, then run the prompt in it"},
+	{Usage: "/runbatch <project> <prompts>", Description: "run prompts separated by blank lines in order, in one session"},
+	{Usage: "/model [provider/model|default]", Description: "show or set the model used by /run"},
+	{Usage: "/sessions", Description: "list sessions matching SESSION_PREFIX"},
+	{Usage: "/createsession [title]", Description: "create and select a new session"},
+	{Usage: "/selectsession <session_id|title_prefix>", Description: "select a session"},
+	{Usage: "/mysession", Description: "show your selected session"},
+	{Usage: "/last", Description: "resend the latest output of your selected session"},
+	{Usage: "/bind <session_id>", Description: "show a running session's live output in a new message here"},
+	{Usage: "/deletesession <session_id>", Description: "delete a session", AdminOnly: true},
+	{Usage: "/abort [project] <session_id>", Description: "abort a running session (project required once paired)", AdminOnly: true},
+	{Usage: "/broadcast <message>", Description: "send a message to every user with a session or agent key", AdminOnly: true},
+}
+
+func helpText() string {
+	var b strings.Builder
+	b.WriteString("Commands:\n")
+	for _, c := range botCommands {
+		b.WriteString(c.Usage)
+		b.WriteString(" - ")
+		b.WriteString(c.Description)
+		if c.AdminOnly {
+			b.WriteString(" [admin]")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\nCommands marked [admin] require your Telegram ID in ADMIN_TELEGRAM_IDS.")
+	return b.String()
 }
 
 func (a *BotApp) handleStart(chatID int64) {
-	a.tg.Send(tgbotapi.NewMessage(chatID, "Welcome. Use /help to see available commands."))
+	a.tg.Send(tgbotapi.NewMessage(chatID, "Welcome.\n\n"+helpText()))
+}
+
+// handleWhoami is available to everyone so new users can find the ID an
+// admin needs for ALLOWED_TELEGRAM_IDS.
+func (a *BotApp) handleWhoami(chatID int64, userID int64) {
+	agentKey, _ := a.store.GetUserAgentKey(userID)
+	yesNo := func(v bool) string {
+		if v {
+			return "yes"
+		}
+		return "no"
+	}
+	a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("ID: %d\nAllowed: %s\nAdmin: %s\nPaired: %s",
+		userID, yesNo(a.isAllowed(userID)), yesNo(a.isAdmin(userID)), yesNo(agentKey != ""))))
 }
 
 func (a *BotApp) handleHelp(chatID int64) {
-	text := "Commands:\n" +
-		"/start, /help, /settings, /status, /language, /run <prompt>, /abort <session_id>, /mute, /unmute\n\n" +
-		"Advanced: /sessions, /createsession, /deletesession, /selectsession, /mysession"
-	a.tg.Send(tgbotapi.NewMessage(chatID, text))
+	a.tg.Send(tgbotapi.NewMessage(chatID, helpText()))
 }
 
 func (a *BotApp) handleSettings(chatID int64) {
@@ -351,9 +632,25 @@ func (a *BotApp) handleApprovalDecision(cb *tgbotapi.CallbackQuery) {
 	}
 	agentKey, ok := a.store.GetUserAgentKey(cb.From.ID)
 	if !ok || agentKey == "" {
-		a.tg.Send(tgbotapi.NewMessage(cb.Message.Chat.ID, "You are not paired. Use /project add to pair first."))
+		a.tg.Send(tgbotapi.NewMessage(cb.Message.Chat.ID, "You are not paired. Use /pair to pair an agent first."))
+		return
+	}
+	if err := a.enqueueProjectPolicy(cb.From.ID, agentKey, project, decision, scopes, expiresAt); err != nil {
+		a.tg.Send(tgbotapi.NewMessage(cb.Message.Chat.ID, "Failed to queue approval: "+err.Error()))
 		return
 	}
+	// Replace the prompt with the outcome so its buttons cannot be tapped again.
+	text := fmt.Sprintf("Policy updated for %s: %s.", project.Alias, describePolicy(decision, scopes, expiresAt))
+	if err := a.requestWithRetry(tgbotapi.NewEditMessageText(cb.Message.Chat.ID, cb.Message.MessageID, text)); err != nil {
+		a.tg.Send(tgbotapi.NewMessage(cb.Message.Chat.ID, text))
+	}
+	// Optimistically update local view
+	a.updateLocalPolicy(cb.From.ID, project.ProjectID, decision, scopes, expiresAt)
+}
+
+// enqueueProjectPolicy queues an apply_project_policy command for project
+// and records it in the user's command history.
+func (a *BotApp) enqueueProjectPolicy(userID int64, agentKey string, project *projectRecord, decision string, scopes []string, expiresAt *time.Time) error {
 	commandID := fmt.Sprintf("cmd-%d", time.Now().UnixNano())
 	payload := map[string]any{
 		"project_id": project.ProjectID,
@@ -374,23 +671,136 @@ func (a *BotApp) handleApprovalDecision(cb *tgbotapi.CallbackQuery) {
 	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/v1/command", a.backendURL), bytes.NewBuffer(cmdBody))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+agentKey)
-	req.Header.Set("X-Telegram-User-ID", strconv.FormatInt(cb.From.ID, 10))
+	req.Header.Set("X-Telegram-User-ID", strconv.FormatInt(userID, 10))
 	resp, err := a.httpClient.Do(req)
 	if err != nil {
-		a.tg.Send(tgbotapi.NewMessage(cb.Message.Chat.ID, "Failed to send approval: "+err.Error()))
-		return
+		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusAccepted {
-		var errResp map[string]any
-		json.NewDecoder(resp.Body).Decode(&errResp)
-		a.tg.Send(tgbotapi.NewMessage(cb.Message.Chat.ID, fmt.Sprintf("Failed to queue approval: %v", errResp)))
+		return errors.New(backendErrorText(resp.Body))
+	}
+	a.storeCommand(userID, commandRecord{CommandID: commandID, Type: contracts.CommandTypeApplyProjectPolicy, ProjectID: project.ProjectID, Alias: project.Alias, CreatedAt: time.Now().UTC()})
+	return nil
+}
+
+const policyUsage = "Usage: /policy <project> [allow <start|run>... [ttl] | deny]\nExample: /policy myapp allow run 1h"
+
+// handlePolicy shows a project's current policy, or queues a new one when a
+// decision follows the project.
+func (a *BotApp) handlePolicy(chatID int64, args string, userID int64) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		a.tg.Send(tgbotapi.NewMessage(chatID, policyUsage))
 		return
 	}
-	a.storeCommand(cb.From.ID, commandRecord{CommandID: commandID, Type: contracts.CommandTypeApplyProjectPolicy, ProjectID: project.ProjectID, Alias: project.Alias, CreatedAt: time.Now().UTC()})
-	a.tg.Send(tgbotapi.NewMessage(cb.Message.Chat.ID, fmt.Sprintf("Policy updated for %s.", project.Alias)))
-	// Optimistically update local view
-	a.updateLocalPolicy(cb.From.ID, project.ProjectID, decision, scopes, expiresAt)
+	project, err := a.resolveProject(userID, fields[0])
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to resolve project: "+err.Error()))
+		return
+	}
+	if project == nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Unknown project alias %q. Use /project list.", fields[0])))
+		return
+	}
+	if len(fields) == 1 {
+		a.tg.Send(tgbotapi.NewMessage(chatID, formatProjectPolicy(project)))
+		return
+	}
+	decision, scopes, ttl, err := parsePolicyArgs(fields[1:])
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Invalid policy: "+err.Error()+"\n"+policyUsage))
+		return
+	}
+	agentKey, ok := a.store.GetUserAgentKey(userID)
+	if !ok || agentKey == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "You are not paired. Use /pair to pair an agent first."))
+		return
+	}
+	var expiresAt *time.Time
+	if ttl > 0 {
+		exp := time.Now().UTC().Add(ttl)
+		expiresAt = &exp
+	}
+	if err := a.enqueueProjectPolicy(userID, agentKey, project, decision, scopes, expiresAt); err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to queue policy: "+err.Error()))
+		return
+	}
+	a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Policy update queued for %s: %s.", project.Alias, describePolicy(decision, scopes, expiresAt))))
+}
+
+func formatProjectPolicy(project *projectRecord) string {
+	decision := project.Policy.Decision
+	if decision == "" {
+		decision = contracts.DecisionDeny
+	}
+	scope := "none"
+	if len(project.Policy.Scope) > 0 {
+		scope = strings.Join(project.Policy.Scope, ", ")
+	}
+	expires := "never"
+	if project.Policy.ExpiresAt != nil {
+		expires = project.Policy.ExpiresAt.UTC().Format(time.RFC3339)
+		if time.Now().After(*project.Policy.ExpiresAt) {
+			expires += " (expired)"
+		}
+	}
+	return fmt.Sprintf("Policy for %s (%s):\nDecision: %s\nScope: %s\nExpires: %s", project.Alias, project.ProjectID, decision, scope, expires)
+}
+
+// parsePolicyArgs parses "allow <scope>... [ttl]" or "deny". Scopes are
+// start/run or their contract names; ttl is a positive Go duration.
+func parsePolicyArgs(fields []string) (decision string, scopes []string, ttl time.Duration, err error) {
+	switch strings.ToLower(fields[0]) {
+	case "deny":
+		if len(fields) > 1 {
+			return "", nil, 0, errors.New("deny takes no scope or ttl")
+		}
+		return contracts.DecisionDeny, []string{}, 0, nil
+	case "allow":
+	default:
+		return "", nil, 0, fmt.Errorf("unknown decision %q", fields[0])
+	}
+	seen := make(map[string]bool)
+	for _, tok := range fields[1:] {
+		var scope string
+		switch strings.ToUpper(tok) {
+		case "START", contracts.ScopeStartServer:
+			scope = contracts.ScopeStartServer
+		case "RUN", contracts.ScopeRunTask:
+			scope = contracts.ScopeRunTask
+		}
+		if scope != "" {
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+			continue
+		}
+		d, perr := time.ParseDuration(tok)
+		if perr != nil || d <= 0 {
+			return "", nil, 0, fmt.Errorf("invalid scope or duration %q", tok)
+		}
+		if ttl > 0 {
+			return "", nil, 0, errors.New("only one duration is allowed")
+		}
+		ttl = d
+	}
+	if len(scopes) == 0 {
+		return "", nil, 0, errors.New("allow needs at least one scope")
+	}
+	return contracts.DecisionAllow, scopes, ttl, nil
+}
+
+func describePolicy(decision string, scopes []string, expiresAt *time.Time) string {
+	if decision != contracts.DecisionAllow || len(scopes) == 0 {
+		return "denied"
+	}
+	until := "until revoked"
+	if expiresAt != nil {
+		until = "until " + expiresAt.UTC().Format("15:04 UTC")
+	}
+	return fmt.Sprintf("allowed %s %s", strings.Join(scopes, " + "), until)
 }
 
 func (a *BotApp) updateLocalPolicy(userID int64, projectID string, decision string, scopes []string, expiresAt *time.Time) {
@@ -414,19 +824,46 @@ func (a *BotApp) isRetryableTelegramErr(err error) bool {
 	return strings.Contains(msg, "too many requests") || strings.Contains(msg, "429") || strings.Contains(msg, "retry after")
 }
 
+func (a *BotApp) editRetryMax() int {
+	if a.cfg != nil && a.cfg.EditRetryMax > 0 {
+		return a.cfg.EditRetryMax
+	}
+	return DefaultEditRetryMax
+}
+
+func (a *BotApp) editRetryBaseDelay() time.Duration {
+	if a.cfg != nil && a.cfg.EditRetryBaseDelay > 0 {
+		return a.cfg.EditRetryBaseDelay
+	}
+	return DefaultEditRetryBaseDelay
+}
+
+// requestWithRetry retries rate-limited requests up to editRetryMax attempts.
+// It waits as long as Telegram's retry_after asks, or else an exponential
+// backoff with up to 20% jitter so sessions do not retry in lockstep.
 func (a *BotApp) requestWithRetry(c tgbotapi.Chattable) error {
-	backoff := 100 * time.Millisecond
+	attempts := a.editRetryMax()
+	backoff := a.editRetryBaseDelay()
 	var lastErr error
-	for i := 0; i < 3; i++ {
+	for i := 0; i < attempts; i++ {
 		_, err := a.tg.Request(c)
 		if err == nil {
 			return nil
 		}
 		lastErr = err
-		if !a.isRetryableTelegramErr(err) || i == 2 {
+		if !a.isRetryableTelegramErr(err) || i == attempts-1 {
 			break
 		}
-		a.sleep(backoff)
+		var tgErr *tgbotapi.Error
+		if errors.As(err, &tgErr) && tgErr.RetryAfter > 0 {
+			a.sleep(time.Duration(tgErr.RetryAfter) * time.Second)
+		} else {
+			delay := backoff
+			if jitterMax := int64(delay / 5); jitterMax > 0 {
+				delay += time.Duration(rand.Int63n(jitterMax))
+			}
+			a.sleep(delay)
+		}
 		backoff *= 2
 	}
 	return lastErr
@@ -436,7 +873,94 @@ func (a *BotApp) runKey(chatID, userID int64) string {
 	return strconv.FormatInt(chatID, 10) + ":" + strconv.FormatInt(userID, 10)
 }
 
-func (a *BotApp) tryStartRun(chatID, userID int64, sessionID string) bool {
+// runKeyUser returns the user ID encoded in a runKey.
+func runKeyUser(key string) int64 {
+	_, user, _ := strings.Cut(key, ":")
+	userID, _ := strconv.ParseInt(user, 10, 64)
+	return userID
+}
+
+func (a *BotApp) maxRunsPerUser() int {
+	if a.cfg != nil && a.cfg.MaxRunsPerUser > 0 {
+		return a.cfg.MaxRunsPerUser
+	}
+	return DefaultMaxRunsPerUser
+}
+
+func (a *BotApp) maxPromptChars() int {
+	if a.cfg != nil && a.cfg.MaxPromptChars > 0 {
+		return a.cfg.MaxPromptChars
+	}
+	return DefaultMaxPromptChars
+}
+
+// promptTooLong replies in chatID and reports true when prompt has more
+// characters than the configured maximum. Characters are counted as runes so
+// multibyte text is not penalized, but a prompt over the backend's
+// contracts.MaxPromptBytes is refused here too rather than by the backend.
+func (a *BotApp) promptTooLong(chatID int64, prompt string) bool {
+	n, limit := utf8.RuneCountInString(prompt), a.maxPromptChars()
+	if n > limit {
+		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Prompt too long (%d/%d chars), shorten it and try again.", n, limit)))
+		return true
+	}
+	if len(prompt) > contracts.MaxPromptBytes {
+		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Prompt too long (%d/%d bytes), shorten it and try again.", len(prompt), contracts.MaxPromptBytes)))
+		return true
+	}
+	return false
+}
+
+// acquireUserRunLocked counts a run against userID's cap, reporting false
+// when the cap is already reached. runMu must be held.
+func (a *BotApp) acquireUserRunLocked(userID int64) bool {
+	if a.userRuns == nil {
+		a.userRuns = make(map[int64]int)
+	}
+	if a.userRuns[userID] >= a.maxRunsPerUser() {
+		return false
+	}
+	a.userRuns[userID]++
+	return true
+}
+
+// releaseUserRunLocked gives back a run counted by acquireUserRunLocked.
+// runMu must be held.
+func (a *BotApp) releaseUserRunLocked(userID int64) {
+	if a.userRuns[userID] <= 1 {
+		delete(a.userRuns, userID)
+		return
+	}
+	a.userRuns[userID]--
+}
+
+// acquireUserRun reserves one of userID's concurrent runs; the returned
+// release func is safe to call more than once.
+func (a *BotApp) acquireUserRun(userID int64) (func(), bool) {
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+	if !a.acquireUserRunLocked(userID) {
+		return nil, false
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.runMu.Lock()
+			defer a.runMu.Unlock()
+			a.releaseUserRunLocked(userID)
+		})
+	}, true
+}
+
+var (
+	errRunInProgress = errors.New("run already in progress")
+	errTooManyRuns   = errors.New("too many concurrent runs")
+)
+
+// tryStartRun marks a run of sessionID as active for the chat and user. It
+// returns errRunInProgress when that chat and user already have one and
+// errTooManyRuns when the user is at their concurrent-run cap.
+func (a *BotApp) tryStartRun(chatID, userID int64, sessionID string) error {
 	key := a.runKey(chatID, userID)
 	a.runMu.Lock()
 	defer a.runMu.Unlock()
@@ -447,19 +971,34 @@ func (a *BotApp) tryStartRun(chatID, userID int64, sessionID string) bool {
 		a.runOwners = make(map[string]string)
 	}
 	if _, exists := a.activeRuns[key]; exists {
-		return false
+		return errRunInProgress
+	}
+	if !a.acquireUserRunLocked(userID) {
+		return errTooManyRuns
 	}
 	a.activeRuns[key] = sessionID
 	a.runOwners[sessionID] = key
-	return true
+	return nil
+}
+
+// runRefusal is the reply for a run tryStartRun refused with err.
+func (a *BotApp) runRefusal(err error) string {
+	if errors.Is(err, errTooManyRuns) {
+		return fmt.Sprintf("Too many concurrent runs (limit %d), wait for one to finish.", a.maxRunsPerUser())
+	}
+	return "A run is already in progress, wait for it to finish."
 }
 
 func (a *BotApp) clearRun(chatID, userID int64) {
 	key := a.runKey(chatID, userID)
 	a.runMu.Lock()
 	defer a.runMu.Unlock()
-	sid := a.activeRuns[key]
+	sid, ok := a.activeRuns[key]
+	if !ok {
+		return
+	}
 	delete(a.activeRuns, key)
+	a.releaseUserRunLocked(userID)
 	if sid != "" {
 		if ownerKey, ok := a.runOwners[sid]; ok && ownerKey == key {
 			delete(a.runOwners, sid)
@@ -468,6 +1007,9 @@ func (a *BotApp) clearRun(chatID, userID int64) {
 }
 
 func (a *BotApp) clearRunBySession(sessionID string) bool {
+	if chatID, msgID, ok := a.store.GetSession(sessionID); ok {
+		a.forgetEdit(chatID, msgID, "")
+	}
 	a.runMu.Lock()
 	defer a.runMu.Unlock()
 	key, ok := a.runOwners[sessionID]
@@ -475,12 +1017,36 @@ func (a *BotApp) clearRunBySession(sessionID string) bool {
 		return false
 	}
 	delete(a.runOwners, sessionID)
-	delete(a.activeRuns, key)
+	if _, active := a.activeRuns[key]; active {
+		delete(a.activeRuns, key)
+		a.releaseUserRunLocked(runKeyUser(key))
+	}
 	return true
 }
 
+// sessionCache is implemented by clients that cache the session list.
+type sessionCache interface {
+	InvalidateSessions()
+}
+
+// sessionExists reports whether Opencode knows sessionID. A hit in a cached
+// session list is trusted; a miss is confirmed against a fresh list, since
+// the session may have been created after the cache was filled.
 func (a *BotApp) sessionExists(sessionID string) (bool, error) {
-	sessions, err := a.oc.ListSessions()
+	found, err := a.sessionListed(sessionID)
+	if found || err != nil {
+		return found, err
+	}
+	cache, ok := a.oc.(sessionCache)
+	if !ok {
+		return false, nil
+	}
+	cache.InvalidateSessions()
+	return a.sessionListed(sessionID)
+}
+
+func (a *BotApp) sessionListed(sessionID string) (bool, error) {
+	sessions, err := a.oc.ListSessionsContext(a.requestContext())
 	if err != nil {
 		return false, err
 	}
@@ -505,7 +1071,7 @@ func (a *BotApp) resolveUserSession(userID int64) (string, bool, error) {
 	}
 
 	fallbackTitle := fmt.Sprintf("%suser_%d", a.cfg.SessionPrefix, userID)
-	sessions, err := a.oc.ListSessions()
+	sessions, err := a.oc.ListSessionsContext(a.requestContext())
 	if err != nil {
 		return "", false, err
 	}
@@ -519,16 +1085,26 @@ func (a *BotApp) resolveUserSession(userID int64) (string, bool, error) {
 		}
 	}
 
-	created, err := a.oc.CreateSession(fallbackTitle)
+	id, err := a.createUserSession(userID, fallbackTitle)
 	if err != nil {
 		return "", false, err
 	}
+	return id, false, nil
+}
+
+// createUserSession creates an Opencode session titled title and selects it
+// for userID.
+func (a *BotApp) createUserSession(userID int64, title string) (string, error) {
+	created, err := a.oc.CreateSessionContext(a.requestContext(), title)
+	if err != nil {
+		return "", err
+	}
 	id, _ := created["id"].(string)
 	if id == "" {
-		return "", false, fmt.Errorf("session id not found in response")
+		return "", fmt.Errorf("session id not found in response")
 	}
 	_ = a.store.SetUserSession(userID, id)
-	return id, false, nil
+	return id, nil
 }
 
 func (a *BotApp) handleStatus(chatID int64) {
@@ -536,8 +1112,10 @@ func (a *BotApp) handleStatus(chatID int64) {
 	a.tg.Send(tgbotapi.NewMessage(chatID, msg))
 }
 
-func (a *BotApp) handleSessions(chatID int64) {
-	sessions, err := a.oc.ListSessions()
+// handleSessions lists the prefixed Opencode sessions, preceded by the
+// user's own recently selected sessions that still exist.
+func (a *BotApp) handleSessions(chatID int64, userID int64) {
+	sessions, err := a.oc.ListSessionsContext(a.requestContext())
 	if err != nil {
 		a.tg.Send(tgbotapi.NewMessage(chatID, "Error listing sessions: "+err.Error()))
 		return
@@ -547,14 +1125,33 @@ func (a *BotApp) handleSessions(chatID int64) {
 		return
 	}
 	var b string
-	prefix := a.cfg.SessionPrefix
+	titles := make(map[string]string, len(sessions))
 	for _, s := range sessions {
 		title, _ := s["title"].(string)
-		if prefix == "" || strings.HasPrefix(title, prefix) {
+		if id, ok := s["id"].(string); ok {
+			titles[id] = title
+		}
+		if a.cfg.hasSessionPrefix(title) {
 			id := s["id"]
 			b += fmt.Sprintf("%v - %v\n", id, title)
 		}
 	}
+	current, _ := a.store.GetUserSession(userID)
+	var recent string
+	for _, id := range a.store.ListUserSessions(userID) {
+		title, ok := titles[id]
+		if !ok {
+			continue
+		}
+		line := fmt.Sprintf("%s - %s", id, title)
+		if id == current {
+			line += " (selected)"
+		}
+		recent += line + "\n"
+	}
+	if recent != "" {
+		b = "Your recent sessions:\n" + recent + "\nAll sessions:\n" + b
+	}
 	a.tg.Send(tgbotapi.NewMessage(chatID, b))
 }
 
@@ -562,7 +1159,7 @@ func (a *BotApp) handleCreateSession(chatID int64, title string, userID int64) {
 	if title == "" {
 		title = fmt.Sprintf("%s%d", a.cfg.SessionPrefix, time.Now().Unix())
 	}
-	session, err := a.oc.CreateSession(title)
+	session, err := a.oc.CreateSessionContext(a.requestContext(), title)
 	if err != nil {
 		a.tg.Send(tgbotapi.NewMessage(chatID, "Error creating session: "+err.Error()))
 		return
@@ -584,15 +1181,65 @@ func (a *BotApp) handleDeleteSession(chatID int64, args string, userID int64) {
 		a.tg.Send(tgbotapi.NewMessage(chatID, "Only admins can delete sessions."))
 		return
 	}
-	if err := a.oc.DeleteSession(args); err != nil {
-		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to delete session: "+err.Error()))
+	err := a.oc.DeleteSessionContext(a.requestContext(), args)
+	gone := isOpencodeStatus(err, http.StatusNotFound)
+	if err != nil && !gone {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to delete session: "+describeOpencodeError(err)))
 		return
 	}
 	// remove from store mapping(s)
 	_ = a.store.DeleteSession(args)
+	if gone {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Session already gone: "+args))
+		return
+	}
 	a.tg.Send(tgbotapi.NewMessage(chatID, "Deleted session: "+args))
 }
 
+// handleBroadcast sends message to every user the store knows, in the
+// background so the update loop is not held up, and reports the outcome to
+// the admin when done.
+func (a *BotApp) handleBroadcast(chatID int64, args string, userID int64) {
+	if !a.isAdmin(userID) {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Only admins can broadcast."))
+		return
+	}
+	message := strings.TrimSpace(args)
+	if message == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /broadcast <message>"))
+		return
+	}
+	users, err := a.store.KnownUsers()
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to list users: "+err.Error()))
+		return
+	}
+	if len(users) == 0 {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "No known users to broadcast to."))
+		return
+	}
+	a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Broadcasting to %d users...", len(users))))
+	go a.broadcast(chatID, users, message)
+}
+
+// broadcast sends message to each user's private chat, pausing
+// broadcastInterval between sends, then reports the counts to chatID.
+func (a *BotApp) broadcast(chatID int64, users []int64, message string) {
+	sent, failed := 0, 0
+	for i, user := range users {
+		if i > 0 {
+			a.sleep(broadcastInterval)
+		}
+		if _, err := a.tg.Send(tgbotapi.NewMessage(user, message)); err != nil {
+			log.Printf("broadcast to %d failed: %v", user, err)
+			failed++
+			continue
+		}
+		sent++
+	}
+	a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Broadcast sent to %d users, %d failed.", sent, failed)))
+}
+
 func (a *BotApp) handleSelectSession(chatID int64, args string, userID int64) {
 	if args == "" {
 		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /selectsession <session_id|title_prefix>"))
@@ -605,7 +1252,7 @@ func (a *BotApp) handleSelectSession(chatID int64, args string, userID int64) {
 		return
 	}
 	// otherwise, try to find a session by title prefix
-	sessions, err := a.oc.ListSessions()
+	sessions, err := a.oc.ListSessionsContext(a.requestContext())
 	if err != nil {
 		a.tg.Send(tgbotapi.NewMessage(chatID, "Error listing sessions: "+err.Error()))
 		return
@@ -622,66 +1269,312 @@ func (a *BotApp) handleSelectSession(chatID int64, args string, userID int64) {
 	a.tg.Send(tgbotapi.NewMessage(chatID, "No session found matching: "+args))
 }
 
-func (a *BotApp) handleMySession(chatID int64, userID int64) {
-	if sid, ok := a.store.GetUserSession(userID); ok {
-		a.tg.Send(tgbotapi.NewMessage(chatID, "Your selected session: "+sid))
+// handleBind points a session's live edits at a fresh message in this chat,
+// for when the mapping was lost, e.g. by a restart with the memory store,
+// while the session kept running in Opencode. Users may bind only sessions
+// they selected, admins any; a session bound to another chat stays there.
+func (a *BotApp) handleBind(chatID int64, args string, userID int64) {
+	sid := strings.TrimSpace(args)
+	if sid == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /bind <session_id>"))
 		return
 	}
-	a.tg.Send(tgbotapi.NewMessage(chatID, "You have not selected a session. Use /selectsession <id|title_prefix>"))
-}
-
-// handleRun now routes to backend run_task command.
-
-func (a *BotApp) handleAbort(chatID int64, args string, userID int64) {
-	if args == "" {
-		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /abort <session_id>"))
+	if !a.isAdmin(userID) && !a.ownsSession(userID, sid) {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "You can only bind sessions you selected. Use /selectsession first."))
 		return
 	}
-	// only allow the user if they're admin or the allowed list contains them
-	if !a.isAdmin(userID) {
-		a.tg.Send(tgbotapi.NewMessage(chatID, "Only admins can abort sessions."))
+	if boundChat, _, ok := a.store.GetSession(sid); ok && boundChat != chatID {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Session "+sid+" is already bound to another chat."))
 		return
 	}
-	err := a.oc.AbortSession(args)
+	exists, err := a.sessionExists(sid)
 	if err != nil {
-		a.tg.Send(tgbotapi.NewMessage(chatID, "Abort failed: "+err.Error()))
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to check session: "+describeOpencodeError(err)))
 		return
 	}
-	a.tg.Send(tgbotapi.NewMessage(chatID, "Aborted session: "+args))
-}
-
-// handleProjectAdd initiates pairing and registers a project
-func (a *BotApp) handleProjectAdd(chatID int64, args string, userID int64) {
-	// Check if user is already paired
-	agentKey, ok := a.store.GetUserAgentKey(userID)
-	if ok && agentKey != "" {
-		if strings.TrimSpace(args) == "" {
-			a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /project add <ABS_PATH>"))
+	if !exists {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "No session found: "+sid+". Use /sessions to list sessions."))
+		return
+	}
+	msg, err := a.tg.Send(tgbotapi.NewMessage(chatID, "Tracking session "+sid+"…"))
+	if err != nil {
+		log.Printf("bind %s: send tracking message: %v", sid, err)
+		return
+	}
+	if err := a.store.SetSession(sid, chatID, msg.MessageID); err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to bind session: "+err.Error()))
+	}
+}
+
+// ownsSession reports whether sid is the user's selected session or one of
+// their recent ones.
+func (a *BotApp) ownsSession(userID int64, sid string) bool {
+	if selected, ok := a.store.GetUserSession(userID); ok && selected == sid {
+		return true
+	}
+	for _, recent := range a.store.ListUserSessions(userID) {
+		if recent == sid {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *BotApp) handleMySession(chatID int64, userID int64) {
+	if sid, ok := a.store.GetUserSession(userID); ok {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Your selected session: "+sid))
+		return
+	}
+	a.tg.Send(tgbotapi.NewMessage(chatID, "You have not selected a session. Use /selectsession <id|title_prefix>"))
+}
+
+// telegramMessageLimit is the most characters sent in one Telegram message;
+// Telegram's limit is 4096.
+const telegramMessageLimit = 4000
+
+// handleLast resends the latest output of the user's selected session as
+// new messages, for when the live edits were missed. It is read-only and
+// does not create a session or touch run slots.
+func (a *BotApp) handleLast(chatID int64, userID int64) {
+	selected, ok := a.store.GetUserSession(userID)
+	if !ok {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "You have not selected a session. Use /selectsession <id|title_prefix> or /new <prompt>"))
+		return
+	}
+	sid, missing, err := a.resolveUserSession(userID)
+	if err != nil {
+		if missing {
+			a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Your selected session %s is no longer available. Use /sessions to pick another.", selected)))
+			return
+		}
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to check your session: "+describeOpencodeError(err)))
+		return
+	}
+	text, err := a.oc.GetSessionMessages(sid)
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to fetch session output: "+describeOpencodeError(err)))
+		return
+	}
+	if strings.TrimSpace(text) == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Session "+sid+" has no output yet."))
+		return
+	}
+	a.sendChunked(chatID, text)
+}
+
+// splitMessageText splits text into pieces of at most limit runes, breaking
+// after the last newline in the second half of a piece when there is one.
+func splitMessageText(text string, limit int) []string {
+	var chunks []string
+	runes := []rune(text)
+	for len(runes) > limit {
+		cut := limit
+		for i := limit - 1; i >= limit/2; i-- {
+			if runes[i] == '\n' {
+				cut = i + 1
+				break
+			}
+		}
+		chunks = append(chunks, string(runes[:cut]))
+		runes = runes[cut:]
+	}
+	if len(runes) > 0 {
+		chunks = append(chunks, string(runes))
+	}
+	return chunks
+}
+
+// handleModel shows or changes the model passed to run_task for this user.
+func (a *BotApp) handleModel(chatID int64, args string, userID int64) {
+	model := strings.TrimSpace(args)
+	if model == "" {
+		if current, ok := a.store.GetUserModel(userID); ok {
+			a.tg.Send(tgbotapi.NewMessage(chatID, "Current model: "+current))
 			return
 		}
-		projectPath := strings.TrimSpace(args)
-		a.enqueueProjectRegister(chatID, userID, agentKey, projectPath)
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Using the default model. Set one with /model <provider/model>."))
+		return
+	}
+	if strings.ContainsAny(model, " \t\n") {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /model [provider/model|default]"))
+		return
+	}
+	if model == "default" {
+		model = ""
+	}
+	if err := a.store.SetUserModel(userID, model); err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to save model: "+err.Error()))
+		return
+	}
+	if model == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Model reset to default."))
 		return
 	}
+	a.tg.Send(tgbotapi.NewMessage(chatID, "Model set to "+model+"."))
+}
 
-	// Not paired yet - either claim existing pairing code or start new
-	telegramUserID := strconv.FormatInt(userID, 10)
-	if code, ok := a.store.GetPairingCode(telegramUserID); ok && code != "" {
-		a.claimPairing(chatID, userID, code)
+// handleRun now routes to backend run_task command.
+
+func (a *BotApp) handleAbort(chatID int64, args string, userID int64) {
+	if args == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /abort <session_id>"))
+		return
+	}
+	// only allow the user if they're admin or the allowed list contains them
+	if !a.isAdmin(userID) {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Only admins can abort sessions."))
+		return
+	}
+	// paired users have no direct Opencode access; the agent aborts the
+	// session on the project's server
+	if agentKey, ok := a.store.GetUserAgentKey(userID); ok && agentKey != "" {
+		a.queueAbortSession(chatID, args, userID, agentKey)
+		return
+	}
+	err := a.oc.AbortSessionContext(a.requestContext(), args)
+	if isOpencodeStatus(err, http.StatusNotFound) {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Session already gone: "+args))
+		return
+	}
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Abort failed: "+describeOpencodeError(err)))
+		return
+	}
+	a.tg.Send(tgbotapi.NewMessage(chatID, "Aborted session: "+args))
+}
+
+// describeOpencodeError turns an Opencode API failure into a chat reply,
+// naming the failure class rather than echoing a raw response body.
+func describeOpencodeError(err error) string {
+	var oe *OpencodeError
+	if !errors.As(err, &oe) {
+		return err.Error()
+	}
+	switch {
+	case oe.StatusCode == http.StatusNotFound:
+		return "session not found"
+	case oe.StatusCode == http.StatusUnauthorized || oe.StatusCode == http.StatusForbidden:
+		return fmt.Sprintf("Opencode rejected the bot's credentials (%d)", oe.StatusCode)
+	case oe.StatusCode >= 500:
+		return fmt.Sprintf("Opencode server error (%d)", oe.StatusCode)
+	}
+	return err.Error()
+}
+
+func (a *BotApp) queueAbortSession(chatID int64, args string, userID int64, agentKey string) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /abort <project> <session_id>"))
+		return
+	}
+	project, err := a.resolveProject(userID, fields[0])
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to resolve project: "+err.Error()))
+		return
+	}
+	if project == nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Unknown project alias. Use /project list."))
+		return
+	}
+	sessionID := fields[1]
+	commandID := fmt.Sprintf("cmd-%d", time.Now().UnixNano())
+	cmd := map[string]any{
+		"type":            contracts.CommandTypeAbortSession,
+		"command_id":      commandID,
+		"idempotency_key": fmt.Sprintf("key-%d", time.Now().UnixNano()),
+		"created_at":      time.Now().UTC().Format(time.RFC3339Nano),
+		"priority":        contracts.PriorityHigh,
+		"payload": map[string]string{
+			"project_id": project.ProjectID,
+			"session_id": sessionID,
+		},
+	}
+	cmdBody, _ := json.Marshal(cmd)
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/v1/command", a.backendURL), bytes.NewBuffer(cmdBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+agentKey)
+	req.Header.Set("X-Telegram-User-ID", strconv.FormatInt(userID, 10))
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to send command: "+err.Error()))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to queue command: "+backendErrorText(resp.Body)))
+		return
+	}
+	a.storeCommand(userID, commandRecord{CommandID: commandID, Type: contracts.CommandTypeAbortSession, ProjectID: project.ProjectID, Alias: project.Alias, CreatedAt: time.Now().UTC()})
+	a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("abort_session queued for %s on %s.", sessionID, project.Alias)))
+	a.pollAndRelayResult(chatID, userID, commandID)
+}
+
+// handleProjectAdd registers a project on the paired agent; pairing itself
+// is done with /pair.
+func (a *BotApp) handleProjectAdd(chatID int64, args string, userID int64) {
+	agentKey, ok := a.store.GetUserAgentKey(userID)
+	if !ok || agentKey == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "You are not paired. Use /pair to pair an agent first."))
+		return
+	}
+	if strings.TrimSpace(args) == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /project add <ABS_PATH>"))
 		return
 	}
-	// initiate pairing flow
-	a.startPairing(chatID, userID)
+	projectPath := strings.TrimSpace(args)
+	a.enqueueProjectRegister(chatID, userID, agentKey, projectPath)
+}
+
+// handlePair starts pairing, or with "status" reports how far it got.
+func (a *BotApp) handlePair(chatID int64, args string, userID int64) {
+	switch strings.TrimSpace(args) {
+	case "":
+		a.startPairing(chatID, userID)
+	case "status":
+		a.handlePairStatus(chatID, userID)
+	default:
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /pair [status]"))
+	}
 }
 
 func (a *BotApp) startPairing(chatID int64, userID int64) {
+	a.requestPairingCode(chatID, userID, "/v1/pair/start", "Pairing initiated!")
+}
+
+// handlePairStatus reports whether the caller is paired. A pending code is
+// claimed on the spot, which is the step that completes pairing; a failed
+// claim, for example of an expired code, is reported as is.
+func (a *BotApp) handlePairStatus(chatID int64, userID int64) {
+	if key, ok := a.store.GetUserAgentKey(userID); ok && key != "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Paired: your agent key is stored. Use /project add <ABS_PATH> to register a project, /agent to check the agent."))
+		return
+	}
+	code, ok := a.store.GetPairingCode(strconv.FormatInt(userID, 10))
+	if !ok || code == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Not paired and no pairing in progress. Use /pair to get a pairing code."))
+		return
+	}
+	a.claimPairing(chatID, userID, code)
+}
+
+// handleRepair replaces the caller's unclaimed pairing codes with a fresh
+// one, for when a code leaked or was lost before it was claimed.
+func (a *BotApp) handleRepair(chatID int64, userID int64) {
+	a.requestPairingCode(chatID, userID, "/v1/pair/rotate", "New pairing code issued; earlier codes no longer work.")
+}
+
+// requestPairingCode asks the backend endpoint for a pairing code, stores it
+// and shows it with the agent command that claims it.
+func (a *BotApp) requestPairingCode(chatID int64, userID int64, endpoint string, heading string) {
 	telegramUserID := strconv.FormatInt(userID, 10)
 	reqBody, _ := json.Marshal(map[string]string{"telegram_user_id": telegramUserID})
-	resp, err := a.httpClient.Post(
-		fmt.Sprintf("%s/v1/pair/start", a.backendURL),
-		"application/json",
-		bytes.NewBuffer(reqBody),
-	)
+	req, _ := http.NewRequest(http.MethodPost, a.backendURL+endpoint, bytes.NewBuffer(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	// The backend only rotates a paired user's codes for their agent's key.
+	if agentKey, ok := a.store.GetUserAgentKey(userID); ok && agentKey != "" {
+		req.Header.Set("Authorization", "Bearer "+agentKey)
+	}
+	resp, err := a.httpClient.Do(req)
 	if err != nil {
 		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to initiate pairing: "+err.Error()))
 		return
@@ -689,9 +1582,7 @@ func (a *BotApp) startPairing(chatID int64, userID int64) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		var errResp map[string]any
-		json.NewDecoder(resp.Body).Decode(&errResp)
-		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Pairing failed: %v", errResp)))
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Pairing failed: "+backendErrorText(resp.Body)))
 		return
 	}
 
@@ -705,11 +1596,75 @@ func (a *BotApp) startPairing(chatID int64, userID int64) {
 	expiresAt, _ := pairResp["expires_at"].(string)
 	_ = a.store.SetPairingCode(telegramUserID, pairingCode)
 
-	msg := fmt.Sprintf("Pairing initiated!\n\nPairing Code: `%s`\n\nExpires at: %s\n\nRun the following on your machine to complete pairing:\n\n`oct-agent pair %s`",
-		pairingCode, expiresAt, pairingCode)
+	msg := fmt.Sprintf("%s\n\nPairing Code: `%s`\n\nExpires at: %s\n\nRun the following on your machine to complete pairing:\n\n`oct-agent pair %s`\n\nThen send /pair status to finish.",
+		heading, pairingCode, expiresAt, pairingCode)
 	a.tg.Send(tgbotapi.NewMessage(chatID, msg))
 }
 
+// handleUnpair revokes the caller's agent key, or another user's when an
+// admin names their Telegram ID, and forgets the key the bot stored.
+func (a *BotApp) handleUnpair(chatID int64, args string, userID int64) {
+	target := userID
+	if arg := strings.TrimSpace(args); arg != "" {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /unpair [telegram_id]"))
+			return
+		}
+		if id != userID && !a.isAdmin(userID) {
+			a.tg.Send(tgbotapi.NewMessage(chatID, "Only admins can unpair other users."))
+			return
+		}
+		target = id
+	}
+	reqBody, _ := json.Marshal(contracts.PairRevokeRequest{TelegramUserID: strconv.FormatInt(target, 10)})
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v1/pair/revoke", a.backendURL), bytes.NewBuffer(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	// The backend only revokes a pairing for the key of the agent being revoked.
+	if agentKey, ok := a.store.GetUserAgentKey(target); ok && agentKey != "" {
+		req.Header.Set("Authorization", "Bearer "+agentKey)
+	}
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to unpair: "+err.Error()))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to unpair: "+backendErrorText(resp.Body)))
+		return
+	}
+	// Forget the local key and pairing code even if the backend had no
+	// binding, so /pair starts afresh.
+	_ = a.store.SetUserAgentKey(target, "")
+	_ = a.store.SetPairingCode(strconv.FormatInt(target, 10), "")
+	if resp.StatusCode == http.StatusNotFound {
+		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("No agent is paired for %d.", target)))
+		return
+	}
+	a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Agent for %d unpaired; its key no longer works. Use /pair to pair again.", target)))
+}
+
+// backendErrorText renders a backend error body as its code and message,
+// naming the failing field when the backend reports one.
+func backendErrorText(body io.Reader) string {
+	data, _ := io.ReadAll(body)
+	var errResp struct {
+		Error contracts.APIError `json:"error"`
+	}
+	if err := json.Unmarshal(data, &errResp); err != nil || errResp.Error.Code == "" {
+		if text := strings.TrimSpace(string(data)); text != "" {
+			return text
+		}
+		return "no error details"
+	}
+	text := errResp.Error.Error()
+	if errResp.Error.Field != "" {
+		text += " (field: " + errResp.Error.Field + ")"
+	}
+	return text
+}
+
 func (a *BotApp) claimPairing(chatID int64, userID int64, pairingCode string) {
 	reqBody, _ := json.Marshal(map[string]string{"pairing_code": pairingCode, "device_info": "telegram"})
 	resp, err := a.httpClient.Post(
@@ -723,9 +1678,7 @@ func (a *BotApp) claimPairing(chatID int64, userID int64, pairingCode string) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		var errResp map[string]any
-		json.NewDecoder(resp.Body).Decode(&errResp)
-		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Pairing claim failed: %v", errResp)))
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Pairing claim failed: "+backendErrorText(resp.Body)))
 		return
 	}
 	var claimResp map[string]any
@@ -773,9 +1726,7 @@ func (a *BotApp) enqueueProjectRegister(chatID int64, userID int64, agentKey str
 		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Project registration queued for %s (alias: %s).", projectPath, alias)))
 		return
 	}
-	var errResp map[string]any
-	json.NewDecoder(resp.Body).Decode(&errResp)
-	a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Failed to queue project registration: %v", errResp)))
+	a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to queue project registration: "+backendErrorText(resp.Body)))
 }
 
 func projectAliasFromPath(path string) string {
@@ -791,16 +1742,30 @@ func projectAliasFromPath(path string) string {
 	return strings.TrimSpace(parts[len(parts)-1])
 }
 
-func (a *BotApp) handleProjectList(chatID int64, userID int64) {
-	entries, err := a.listProjects(userID)
+func (a *BotApp) handleProjectList(chatID int64, args string, userID int64) {
+	page := 1
+	if raw := strings.TrimSpace(args); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /project list [page]"))
+			return
+		}
+		page = n
+	}
+	offset := (page - 1) * projectPageSize
+	entries, total, err := a.listProjectsPage(userID, offset, projectPageSize)
 	if err != nil {
 		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to load projects: "+err.Error()))
 		return
 	}
-	if len(entries) == 0 {
+	if total == 0 {
 		a.tg.Send(tgbotapi.NewMessage(chatID, "No projects registered yet."))
 		return
 	}
+	if len(entries) == 0 {
+		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("No projects on page %d; there are %d.", page, total)))
+		return
+	}
 	var b strings.Builder
 	for _, p := range entries {
 		policy := p.Policy.Decision
@@ -809,9 +1774,52 @@ func (a *BotApp) handleProjectList(chatID int64, userID int64) {
 		}
 		b.WriteString(fmt.Sprintf("%s (%s) - %s\n", p.Alias, p.ProjectID, policy))
 	}
+	if len(entries) < total {
+		b.WriteString(fmt.Sprintf("Showing %d-%d of %d.", offset+1, offset+len(entries), total))
+		if offset+len(entries) < total {
+			b.WriteString(fmt.Sprintf(" Next: /project list %d", page+1))
+		}
+		b.WriteString("\n")
+	}
 	a.tg.Send(tgbotapi.NewMessage(chatID, b.String()))
 }
 
+func (a *BotApp) handleProjectDelete(chatID int64, alias string, userID int64) {
+	alias = strings.TrimSpace(alias)
+	if alias == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /project delete <project>"))
+		return
+	}
+	agentKey, ok := a.store.GetUserAgentKey(userID)
+	if !ok || agentKey == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "You are not paired. Use /pair to pair an agent first."))
+		return
+	}
+	project, err := a.resolveProject(userID, alias)
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to resolve project: "+err.Error()))
+		return
+	}
+	if project == nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Unknown project alias %q. Use /project list.", alias)))
+		return
+	}
+	req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/v1/projects?telegram_user_id=%d&project_id=%s", a.backendURL, userID, url.QueryEscape(project.ProjectID)), nil)
+	req.Header.Set("Authorization", "Bearer "+agentKey)
+	req.Header.Set("X-Telegram-User-ID", strconv.FormatInt(userID, 10))
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to delete project: "+err.Error()))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to delete project: "+backendErrorText(resp.Body)))
+		return
+	}
+	a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Project %s (%s) deleted.", project.Alias, project.ProjectID)))
+}
+
 func (a *BotApp) handleStartServer(chatID int64, args string, userID int64) {
 	if strings.TrimSpace(args) == "" {
 		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /start_server <project>"))
@@ -819,7 +1827,7 @@ func (a *BotApp) handleStartServer(chatID int64, args string, userID int64) {
 	}
 	agentKey, ok := a.store.GetUserAgentKey(userID)
 	if !ok || agentKey == "" {
-		a.tg.Send(tgbotapi.NewMessage(chatID, "You are not paired. Use /project add to pair first."))
+		a.tg.Send(tgbotapi.NewMessage(chatID, "You are not paired. Use /pair to pair an agent first."))
 		return
 	}
 	projectAlias := strings.TrimSpace(args)
@@ -858,9 +1866,7 @@ func (a *BotApp) handleStartServer(chatID int64, args string, userID int64) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusAccepted {
-		var errResp map[string]any
-		json.NewDecoder(resp.Body).Decode(&errResp)
-		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Failed to queue command: %v", errResp)))
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to queue command: "+backendErrorText(resp.Body)))
 		return
 	}
 	a.storeCommand(userID, commandRecord{CommandID: commandID, Type: contracts.CommandTypeStartServer, ProjectID: project.ProjectID, Alias: project.Alias, CreatedAt: time.Now().UTC()})
@@ -868,27 +1874,17 @@ func (a *BotApp) handleStartServer(chatID int64, args string, userID int64) {
 	a.pollAndRelayResult(chatID, userID, commandID)
 }
 
-func (a *BotApp) handleRun(chatID int64, prompt string, userID int64) {
-	if prompt == "" {
-		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /run <project> <prompt>"))
-		return
-	}
-	parts := strings.Fields(prompt)
-	if len(parts) < 2 {
-		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /run <project> <prompt>"))
-		return
-	}
-	projectAlias := parts[0]
-	userPrompt := strings.TrimSpace(strings.TrimPrefix(prompt, projectAlias))
-	if userPrompt == "" {
-		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /run <project> <prompt>"))
+func (a *BotApp) handleStopServer(chatID int64, args string, userID int64) {
+	if strings.TrimSpace(args) == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /stop_server <project>"))
 		return
 	}
 	agentKey, ok := a.store.GetUserAgentKey(userID)
 	if !ok || agentKey == "" {
-		a.tg.Send(tgbotapi.NewMessage(chatID, "You are not paired. Use /project add to pair first."))
+		a.tg.Send(tgbotapi.NewMessage(chatID, "You are not paired. Use /pair to pair an agent first."))
 		return
 	}
+	projectAlias := strings.TrimSpace(args)
 	project, err := a.resolveProject(userID, projectAlias)
 	if err != nil {
 		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to resolve project: "+err.Error()))
@@ -898,19 +1894,14 @@ func (a *BotApp) handleRun(chatID int64, prompt string, userID int64) {
 		a.tg.Send(tgbotapi.NewMessage(chatID, "Unknown project alias. Use /project list."))
 		return
 	}
-	if !a.policyAllows(project.Policy, contracts.ScopeRunTask) {
-		a.promptApproval(chatID, userID, project, []string{contracts.ScopeRunTask})
-		return
-	}
 	commandID := fmt.Sprintf("cmd-%d", time.Now().UnixNano())
 	cmd := map[string]any{
-		"type":            contracts.CommandTypeRunTask,
+		"type":            contracts.CommandTypeStopServer,
 		"command_id":      commandID,
 		"idempotency_key": fmt.Sprintf("key-%d", time.Now().UnixNano()),
 		"created_at":      time.Now().UTC().Format(time.RFC3339Nano),
 		"payload": map[string]string{
 			"project_id": project.ProjectID,
-			"prompt":     strings.TrimSpace(userPrompt),
 		},
 	}
 	cmdBody, _ := json.Marshal(cmd)
@@ -925,30 +1916,382 @@ func (a *BotApp) handleRun(chatID int64, prompt string, userID int64) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusAccepted {
-		var errResp map[string]any
-		json.NewDecoder(resp.Body).Decode(&errResp)
-		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Failed to queue command: %v", errResp)))
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to queue command: "+backendErrorText(resp.Body)))
 		return
 	}
-	a.storeCommand(userID, commandRecord{CommandID: commandID, Type: contracts.CommandTypeRunTask, ProjectID: project.ProjectID, Alias: project.Alias, CreatedAt: time.Now().UTC()})
-	a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("run_task queued for %s.", project.Alias)))
+	a.storeCommand(userID, commandRecord{CommandID: commandID, Type: contracts.CommandTypeStopServer, ProjectID: project.ProjectID, Alias: project.Alias, CreatedAt: time.Now().UTC()})
+	a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("stop_server queued for %s.", project.Alias)))
 	a.pollAndRelayResult(chatID, userID, commandID)
 }
 
-func (a *BotApp) listProjects(userID int64) ([]projectRecord, error) {
-	if a.listProjectsFn != nil {
-		return a.listProjectsFn(userID)
+func (a *BotApp) handleCancel(chatID int64, args string, userID int64) {
+	targetID := strings.TrimSpace(args)
+	if targetID == "" || len(strings.Fields(targetID)) != 1 {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Usage: /cancel <command_id>"))
+		return
 	}
-	resp, err := a.httpClient.Get(fmt.Sprintf("%s/v1/projects?telegram_user_id=%d", a.backendURL, userID))
+	agentKey, ok := a.store.GetUserAgentKey(userID)
+	if !ok || agentKey == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "You are not paired. Use /pair to pair an agent first."))
+		return
+	}
+	commandID := fmt.Sprintf("cmd-%d", time.Now().UnixNano())
+	cmd := map[string]any{
+		"type":            contracts.CommandTypeCancelTask,
+		"command_id":      commandID,
+		"idempotency_key": fmt.Sprintf("key-%d", time.Now().UnixNano()),
+		"created_at":      time.Now().UTC().Format(time.RFC3339Nano),
+		"priority":        contracts.PriorityHigh,
+		"payload": map[string]string{
+			"command_id": targetID,
+		},
+	}
+	cmdBody, _ := json.Marshal(cmd)
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/v1/command", a.backendURL), bytes.NewBuffer(cmdBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+agentKey)
+	req.Header.Set("X-Telegram-User-ID", strconv.FormatInt(userID, 10))
+	resp, err := a.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to send command: "+err.Error()))
+		return
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("backend status %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusAccepted {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to queue command: "+backendErrorText(resp.Body)))
+		return
 	}
-	var out struct {
-		Projects []projectRecord `json:"projects"`
+	a.storeCommand(userID, commandRecord{CommandID: commandID, Type: contracts.CommandTypeCancelTask, CreatedAt: time.Now().UTC()})
+	a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("cancel_task queued for %s.", targetID)))
+	a.pollAndRelayResult(chatID, userID, commandID)
+}
+
+func (a *BotApp) handleRun(chatID int64, prompt string, userID int64) {
+	a.runTask(chatID, prompt, userID, nil, false, "")
+}
+
+// handleNewRun creates a fresh prefixed session, selects it and runs prompt
+// in one step. Paired users queue the prompt as a run_task like /run; others
+// prompt the new session on Opencode directly and get the reply through the
+// event stream.
+func (a *BotApp) handleNewRun(chatID int64, prompt string, userID int64) {
+	prompt = strings.TrimSpace(prompt)
+	agentKey, paired := a.store.GetUserAgentKey(userID)
+	paired = paired && agentKey != ""
+	usage := "Usage: /new <prompt>"
+	if paired {
+		usage = "Usage: /new <project> <prompt>"
+	}
+	if prompt == "" || (paired && len(strings.Fields(prompt)) < 2) {
+		a.tg.Send(tgbotapi.NewMessage(chatID, usage))
+		return
+	}
+	userPrompt := prompt
+	if paired {
+		userPrompt = strings.TrimSpace(strings.TrimPrefix(prompt, strings.Fields(prompt)[0]))
+	}
+	if a.promptTooLong(chatID, userPrompt) {
+		return
+	}
+	title := fmt.Sprintf("%s%d", a.cfg.SessionPrefix, time.Now().Unix())
+	if paired {
+		// The agent's Opencode starts the session; one created here on the
+		// bot's own Opencode would never be used.
+		a.runTask(chatID, prompt, userID, nil, false, title)
+		return
+	}
+	sid, err := a.createUserSession(userID, title)
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Error creating session: "+describeOpencodeError(err)))
+		return
+	}
+	a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Created session: %s - %s", sid, title)))
+	if err := a.tryStartRun(chatID, userID, sid); err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, a.runRefusal(err)))
+		return
+	}
+	placeholder, err := a.tg.Send(tgbotapi.NewMessage(chatID, "Running..."))
+	if err != nil {
+		a.clearRun(chatID, userID)
+		return
+	}
+	_ = a.store.SetSession(sid, chatID, placeholder.MessageID)
+	var opts PromptOptions
+	if model, ok := a.store.GetUserModel(userID); ok {
+		opts.Model = model
+		if provider, name, found := strings.Cut(model, "/"); found {
+			opts.ProviderID, opts.Model = provider, name
+		}
+	}
+	go func() {
+		// The reply can take far longer than one API request may.
+		if _, err := a.oc.PromptSessionWithOptionsContext(a.requestContext(), sid, prompt, opts); err != nil {
+			a.clearRun(chatID, userID)
+			a.tg.Send(tgbotapi.NewMessage(chatID, "Prompt failed: "+describeOpencodeError(err)))
+		}
+	}()
+}
+
+const runBatchUsage = "Usage: /runbatch <project>\n<prompt>\n\n<next prompt>..."
+
+// handleRunBatch queues the prompts after the project alias, separated by
+// blank lines, as one run_task whose prompts run in order in one session.
+func (a *BotApp) handleRunBatch(chatID int64, args string, userID int64) {
+	a.runTask(chatID, args, userID, nil, true, "")
+}
+
+// splitPrompts splits text on blank lines, dropping empty chunks.
+func splitPrompts(text string) []string {
+	var prompts []string
+	var current []string
+	flush := func() {
+		if p := strings.TrimSpace(strings.Join(current, "\n")); p != "" {
+			prompts = append(prompts, p)
+		}
+		current = current[:0]
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		current = append(current, line)
+	}
+	flush()
+	return prompts
+}
+
+// runTask queues a run_task with optional attachments and relays its result.
+// With batch set the prompt text is split on blank lines into prompts. A
+// non-empty title names the new session the agent starts.
+func (a *BotApp) runTask(chatID int64, prompt string, userID int64, attachments []contracts.Attachment, batch bool, title string) {
+	usage := "Usage: /run <project> <prompt>"
+	if batch {
+		usage = runBatchUsage
+	}
+	if prompt == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, usage))
+		return
+	}
+	parts := strings.Fields(prompt)
+	if len(parts) < 2 {
+		a.tg.Send(tgbotapi.NewMessage(chatID, usage))
+		return
+	}
+	projectAlias := parts[0]
+	userPrompt := strings.TrimSpace(strings.TrimPrefix(prompt, projectAlias))
+	if userPrompt == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, usage))
+		return
+	}
+	if a.promptTooLong(chatID, userPrompt) {
+		return
+	}
+	var prompts []string
+	if batch {
+		prompts = splitPrompts(userPrompt)
+		if len(prompts) > contracts.MaxBatchPrompts {
+			a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("At most %d prompts per batch.", contracts.MaxBatchPrompts)))
+			return
+		}
+	}
+	agentKey, ok := a.store.GetUserAgentKey(userID)
+	if !ok || agentKey == "" {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "You are not paired. Use /pair to pair an agent first."))
+		return
+	}
+	project, err := a.resolveProject(userID, projectAlias)
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to resolve project: "+err.Error()))
+		return
+	}
+	if project == nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Unknown project alias. Use /project list."))
+		return
+	}
+	if !a.policyAllows(project.Policy, contracts.ScopeRunTask) {
+		a.promptApproval(chatID, userID, project, []string{contracts.ScopeRunTask})
+		return
+	}
+	release, ok := a.acquireUserRun(userID)
+	if !ok {
+		a.tg.Send(tgbotapi.NewMessage(chatID, a.runRefusal(errTooManyRuns)))
+		return
+	}
+	payload := map[string]any{
+		"project_id": project.ProjectID,
+		"prompt":     strings.TrimSpace(userPrompt),
+	}
+	if len(prompts) > 1 {
+		delete(payload, "prompt")
+		payload["prompts"] = prompts
+	}
+	if model, ok := a.store.GetUserModel(userID); ok {
+		payload["model"] = model
+	}
+	if len(attachments) > 0 {
+		payload["attachments"] = attachments
+	}
+	if title != "" {
+		payload["title"] = title
+	}
+	commandID := fmt.Sprintf("cmd-%d", time.Now().UnixNano())
+	cmd := map[string]any{
+		"type":            contracts.CommandTypeRunTask,
+		"command_id":      commandID,
+		"idempotency_key": fmt.Sprintf("key-%d", time.Now().UnixNano()),
+		"created_at":      time.Now().UTC().Format(time.RFC3339Nano),
+		"payload":         payload,
+	}
+	cmdBody, _ := json.Marshal(cmd)
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/v1/command", a.backendURL), bytes.NewBuffer(cmdBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+agentKey)
+	req.Header.Set("X-Telegram-User-ID", strconv.FormatInt(userID, 10))
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		release()
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to send command: "+err.Error()))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		release()
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to queue command: "+backendErrorText(resp.Body)))
+		return
+	}
+	a.storeCommand(userID, commandRecord{CommandID: commandID, Type: contracts.CommandTypeRunTask, ProjectID: project.ProjectID, Alias: project.Alias, CreatedAt: time.Now().UTC()})
+	queued := "run_task"
+	if len(prompts) > 1 {
+		queued = fmt.Sprintf("run_task with %d prompts", len(prompts))
+	}
+	a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("%s queued for %s. Cancel with /cancel %s", queued, project.Alias, commandID)))
+	a.relayResultAsync(chatID, userID, commandID, a.startTyping(chatID), release)
+}
+
+// startTyping shows the typing action in chatID until the returned func is
+// called, the bot shuts down, or maxTypingDuration passes.
+func (a *BotApp) startTyping(chatID int64) context.CancelFunc {
+	interval := a.typingInterval
+	if interval <= 0 {
+		interval = defaultTypingInterval
+	}
+	ctx, cancel := context.WithTimeout(a.requestContext(), maxTypingDuration)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			// Best-effort: a failed chat action only costs the indicator.
+			_, _ = a.tg.Request(tgbotapi.NewChatAction(chatID, tgbotapi.ChatTyping))
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return cancel
+}
+
+// handleAttachment runs a task with the document or photo in msg attached.
+// The caption holds the usual "<project> <prompt>", optionally after /run.
+func (a *BotApp) handleAttachment(msg *tgbotapi.Message, userID int64) {
+	chatID := msg.Chat.ID
+	caption := strings.TrimSpace(msg.Caption)
+	if fields := strings.Fields(caption); len(fields) > 0 && (fields[0] == "/run" || strings.HasPrefix(fields[0], "/run@")) {
+		caption = strings.TrimSpace(strings.TrimPrefix(caption, fields[0]))
+	}
+	if len(strings.Fields(caption)) < 2 {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Add a caption to run a task with this file: <project> <prompt>"))
+		return
+	}
+	var fileID, name string
+	var size int
+	if msg.Document != nil {
+		fileID, name, size = msg.Document.FileID, msg.Document.FileName, msg.Document.FileSize
+		if name == "" {
+			name = "document"
+		}
+	} else {
+		// Telegram lists photo sizes smallest first
+		photo := msg.Photo[len(msg.Photo)-1]
+		fileID, name, size = photo.FileID, "photo.jpg", photo.FileSize
+	}
+	limit := a.maxAttachmentBytes()
+	if int64(size) > limit {
+		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("File too large: %d bytes (limit %d).", size, limit)))
+		return
+	}
+	if err := contracts.ValidateAttachmentName(name); err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "File not allowed: "+err.Error()))
+		return
+	}
+	data, err := a.downloadFile(fileID, limit)
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to download file: "+err.Error()))
+		return
+	}
+	a.runTask(chatID, caption, userID, []contracts.Attachment{{Name: name, ContentBase64: base64.StdEncoding.EncodeToString(data)}}, false, "")
+}
+
+func (a *BotApp) maxAttachmentBytes() int64 {
+	if a.cfg != nil && a.cfg.MaxAttachmentBytes > 0 {
+		return a.cfg.MaxAttachmentBytes
+	}
+	return contracts.DefaultMaxAttachmentBytes
+}
+
+// downloadFile fetches a file through Telegram's file API, failing once it
+// grows past limit since the reported size is optional.
+func (a *BotApp) downloadFile(fileID string, limit int64) ([]byte, error) {
+	resp, err := a.tg.Request(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		return nil, err
+	}
+	var file tgbotapi.File
+	if err := json.Unmarshal(resp.Result, &file); err != nil {
+		return nil, err
+	}
+	endpoint := a.fileEndpoint
+	if endpoint == "" {
+		endpoint = tgbotapi.FileEndpoint
+	}
+	token := ""
+	if a.cfg != nil {
+		token = a.cfg.TelegramToken
+	}
+	httpResp, err := a.httpClient.Get(fmt.Sprintf(endpoint, token, file.FilePath))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("telegram file API returned %d", httpResp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(httpResp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("file is larger than %d bytes", limit)
+	}
+	return data, nil
+}
+
+func (a *BotApp) listProjects(userID int64) ([]projectRecord, error) {
+	if a.listProjectsFn != nil {
+		return a.listProjectsFn(userID)
+	}
+	resp, err := a.httpClient.Get(fmt.Sprintf("%s/v1/projects?telegram_user_id=%d", a.backendURL, userID))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("backend status %d", resp.StatusCode)
+	}
+	var out struct {
+		Projects []projectRecord `json:"projects"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
 		return nil, err
@@ -956,6 +2299,44 @@ func (a *BotApp) listProjects(userID int64) ([]projectRecord, error) {
 	return out.Projects, nil
 }
 
+// listProjectsPage returns up to limit projects starting at offset, sorted by
+// alias, and the user's total project count.
+func (a *BotApp) listProjectsPage(userID int64, offset, limit int) ([]projectRecord, int, error) {
+	if a.listProjectsFn != nil {
+		all, err := a.listProjectsFn(userID)
+		if err != nil {
+			return nil, 0, err
+		}
+		projects := append([]projectRecord(nil), all...)
+		sort.Slice(projects, func(i, j int) bool { return projects[i].Alias < projects[j].Alias })
+		total := len(projects)
+		if offset > total {
+			offset = total
+		}
+		projects = projects[offset:]
+		if limit < len(projects) {
+			projects = projects[:limit]
+		}
+		return projects, total, nil
+	}
+	resp, err := a.httpClient.Get(fmt.Sprintf("%s/v1/projects?telegram_user_id=%d&offset=%d&limit=%d", a.backendURL, userID, offset, limit))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("backend status %d", resp.StatusCode)
+	}
+	var out struct {
+		Projects []projectRecord `json:"projects"`
+		Total    int             `json:"total"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, 0, err
+	}
+	return out.Projects, out.Total, nil
+}
+
 func (a *BotApp) resolveProject(userID int64, aliasOrID string) (*projectRecord, error) {
 	projects, err := a.listProjects(userID)
 	if err != nil {
@@ -999,18 +2380,32 @@ func (a *BotApp) storeCommand(userID int64, cmd commandRecord) {
 	_ = a.store.SetPairingCode(key, string(bytes))
 }
 
-func (a *BotApp) getLastCommand(userID int64, commandType string, projectAlias string) (commandRecord, bool) {
+func (a *BotApp) storedCommands(userID int64) []commandRecord {
 	key := fmt.Sprintf("oct.commands.%d", userID)
 	raw, ok := a.store.GetPairingCode(key)
 	if !ok {
-		return commandRecord{}, false
+		return nil
 	}
 	var rec storedCommands
 	if err := json.Unmarshal([]byte(raw), &rec); err != nil {
-		return commandRecord{}, false
+		return nil
 	}
-	for i := len(rec.Commands) - 1; i >= 0; i-- {
-		c := rec.Commands[i]
+	return rec.Commands
+}
+
+func (a *BotApp) findCommand(userID int64, commandID string) (commandRecord, bool) {
+	for _, c := range a.storedCommands(userID) {
+		if c.CommandID == commandID {
+			return c, true
+		}
+	}
+	return commandRecord{}, false
+}
+
+func (a *BotApp) getLastCommand(userID int64, commandType string, projectAlias string) (commandRecord, bool) {
+	commands := a.storedCommands(userID)
+	for i := len(commands) - 1; i >= 0; i-- {
+		c := commands[i]
 		if c.Type != commandType {
 			continue
 		}
@@ -1041,15 +2436,221 @@ func (a *BotApp) promptApproval(chatID int64, userID int64, project *projectReco
 	a.tg.Send(msg)
 }
 
-// handleStartServer queues a start_server command to the backend.
+// handleHistory lists the caller's recent commands with their status, as
+// the backend keeps them.
+func (a *BotApp) handleHistory(chatID int64, userID int64) {
+	resp, err := a.httpClient.Get(fmt.Sprintf("%s/v1/commands?telegram_user_id=%d", a.backendURL, userID))
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to load history: "+err.Error()))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Failed to load history: backend status %d", resp.StatusCode)))
+		return
+	}
+	var out struct {
+		Commands []historyEntry `json:"commands"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to load history: "+err.Error()))
+		return
+	}
+	if len(out.Commands) == 0 {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "No commands yet."))
+		return
+	}
+	var b strings.Builder
+	b.WriteString("Recent commands:\n")
+	for _, c := range out.Commands {
+		b.WriteString(fmt.Sprintf("%s %s", c.CreatedAt.UTC().Format("2006-01-02 15:04"), c.Type))
+		if c.Alias != "" {
+			b.WriteString(" " + c.Alias)
+		}
+		b.WriteString(" - " + c.Status)
+		if c.ErrorCode != "" {
+			b.WriteString(" (" + c.ErrorCode + ")")
+		}
+		b.WriteString("\n")
+	}
+	a.tg.Send(tgbotapi.NewMessage(chatID, b.String()))
+}
+
+// handleAgentPresence reports when the paired agent last polled the backend,
+// without queuing a command.
+func (a *BotApp) handleAgentPresence(chatID int64, userID int64) {
+	resp, err := a.httpClient.Get(fmt.Sprintf("%s/v1/agent/status?telegram_user_id=%d", a.backendURL, userID))
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to get agent status: "+err.Error()))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "You are not paired. Use /pair to pair an agent."))
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Failed to get agent status: backend status %d", resp.StatusCode)))
+		return
+	}
+	var status contracts.AgentStatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to get agent status: "+err.Error()))
+		return
+	}
+	text := "Agent offline: it has not connected since the backend started."
+	if status.LastSeen != nil {
+		state := "offline"
+		if status.Online {
+			state = "online"
+		}
+		ago := time.Since(*status.LastSeen).Truncate(time.Second)
+		if ago < 0 {
+			ago = 0
+		}
+		text = fmt.Sprintf("Agent %s, last seen %s ago", state, ago)
+		if status.PollTimeoutSeconds > 0 {
+			text += fmt.Sprintf(" (long-poll timeout %ds)", status.PollTimeoutSeconds)
+		}
+		text += "."
+	}
+	if stats, ok := a.fetchQueueStats(userID); ok {
+		text += fmt.Sprintf(" Queue: %d queued, %d in flight.", stats.Queued, stats.Inflight)
+	}
+	a.tg.Send(tgbotapi.NewMessage(chatID, text))
+}
 
-// handleAgentStatus queues a status command to the backend
+// handleQueue lists the commands that have no final result yet, so a user
+// can tell an offline agent from an empty queue. It queues nothing.
+func (a *BotApp) handleQueue(chatID int64, userID int64) {
+	resp, err := a.httpClient.Get(fmt.Sprintf("%s/v1/agent/queue?telegram_user_id=%d", a.backendURL, userID))
+	if err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to load queue: "+err.Error()))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "You are not paired. Use /pair to pair an agent."))
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Failed to load queue: backend status %d", resp.StatusCode)))
+		return
+	}
+	var stats contracts.QueueStatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to load queue: "+err.Error()))
+		return
+	}
+	agentState := ""
+	if status, ok := a.fetchAgentStatus(userID); ok {
+		agentState = "Agent offline"
+		if status.Online {
+			agentState = "Agent online"
+		}
+	}
+	if len(stats.Commands) == 0 {
+		text := "Queue is empty."
+		if agentState != "" {
+			text += " " + agentState + "."
+		}
+		a.tg.Send(tgbotapi.NewMessage(chatID, text))
+		return
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Pending commands (%d queued, %d in flight):\n", stats.Queued, stats.Inflight))
+	for _, c := range stats.Commands {
+		state := "queued"
+		if c.Inflight {
+			state = "in flight"
+		}
+		age := time.Since(c.CreatedAt).Truncate(time.Second)
+		if age < 0 {
+			age = 0
+		}
+		b.WriteString(fmt.Sprintf("%s - %s, %s ago\n", c.Type, state, age))
+	}
+	if more := stats.Queued + stats.Inflight - len(stats.Commands); more > 0 {
+		b.WriteString(fmt.Sprintf("...and %d more\n", more))
+	}
+	if agentState == "Agent offline" {
+		b.WriteString("Agent offline; queued commands run once it reconnects.")
+	} else if agentState != "" {
+		b.WriteString(agentState + ".")
+	}
+	a.tg.Send(tgbotapi.NewMessage(chatID, strings.TrimRight(b.String(), "\n")))
+}
+
+// fetchAgentStatus is best-effort; /queue still answers without it.
+func (a *BotApp) fetchAgentStatus(userID int64) (contracts.AgentStatusResponse, bool) {
+	var status contracts.AgentStatusResponse
+	resp, err := a.httpClient.Get(fmt.Sprintf("%s/v1/agent/status?telegram_user_id=%d", a.backendURL, userID))
+	if err != nil {
+		return status, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return status, false
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return status, false
+	}
+	return status, true
+}
+
+// fetchQueueStats is best-effort; /agent still answers without it.
+func (a *BotApp) fetchQueueStats(userID int64) (contracts.QueueStatsResponse, bool) {
+	var stats contracts.QueueStatsResponse
+	resp, err := a.httpClient.Get(fmt.Sprintf("%s/v1/agent/queue?telegram_user_id=%d", a.backendURL, userID))
+	if err != nil {
+		return stats, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return stats, false
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return stats, false
+	}
+	return stats, true
+}
+
+// handleAgentStatus queues a status command to the backend.
 func (a *BotApp) handleAgentStatus(chatID int64, userID int64) {
+	commandID, ok := a.queueStatusCommand(chatID, userID)
+	if !ok {
+		return
+	}
+	a.storeCommand(userID, commandRecord{CommandID: commandID, Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC()})
+	a.tg.Send(tgbotapi.NewMessage(chatID, "Status command queued."))
+	a.pollAndRelayResult(chatID, userID, commandID)
+}
+
+// handlePing measures the round trip bot -> backend -> agent -> backend -> bot
+// with a status command. An agent the backend already sees as offline is
+// reported at once instead of waiting for the result to time out.
+func (a *BotApp) handlePing(chatID int64, userID int64) {
+	if status, ok := a.fetchAgentStatus(userID); ok && !status.Online {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Agent offline, not pinging. Start oct-agent and try again."))
+		return
+	}
+	start := time.Now().UTC()
+	commandID, ok := a.queueStatusCommand(chatID, userID)
+	if !ok {
+		return
+	}
+	a.storeCommand(userID, commandRecord{CommandID: commandID, Type: contracts.CommandTypeStatus, Ping: true, CreatedAt: start})
+	a.pollAndRelayResult(chatID, userID, commandID)
+}
+
+// queueStatusCommand enqueues a status command with the user's agent key and
+// returns its ID. Failures are reported to chatID.
+func (a *BotApp) queueStatusCommand(chatID int64, userID int64) (string, bool) {
 	// Get agent key from store
 	agentKey, ok := a.store.GetUserAgentKey(userID)
 	if !ok || agentKey == "" {
-		a.tg.Send(tgbotapi.NewMessage(chatID, "You are not paired. Use /project add to pair first."))
-		return
+		a.tg.Send(tgbotapi.NewMessage(chatID, "You are not paired. Use /pair to pair an agent first."))
+		return "", false
 	}
 
 	// Create command
@@ -1058,6 +2659,7 @@ func (a *BotApp) handleAgentStatus(chatID int64, userID int64) {
 		"command_id":      fmt.Sprintf("cmd-%d", time.Now().UnixNano()),
 		"idempotency_key": fmt.Sprintf("key-%d", time.Now().UnixNano()),
 		"created_at":      time.Now().UTC().Format(time.RFC3339Nano),
+		"priority":        contracts.PriorityHigh,
 		"payload":         map[string]any{},
 	}
 
@@ -1070,26 +2672,47 @@ func (a *BotApp) handleAgentStatus(chatID int64, userID int64) {
 	resp, err := a.httpClient.Do(req)
 	if err != nil {
 		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to send command: "+err.Error()))
-		return
+		return "", false
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusAccepted {
-		commandID := cmd["command_id"].(string)
-		a.storeCommand(userID, commandRecord{CommandID: commandID, Type: contracts.CommandTypeStatus, CreatedAt: time.Now().UTC()})
-		a.tg.Send(tgbotapi.NewMessage(chatID, "Status command queued."))
-		a.pollAndRelayResult(chatID, userID, commandID)
-	} else {
-		var errResp map[string]any
-		json.NewDecoder(resp.Body).Decode(&errResp)
-		a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Failed to queue command: %v", errResp)))
+	if resp.StatusCode != http.StatusAccepted {
+		a.tg.Send(tgbotapi.NewMessage(chatID, "Failed to queue command: "+backendErrorText(resp.Body)))
+		return "", false
 	}
+	return cmd["command_id"].(string), true
 }
 
+// resultStreamTimeout stays below the HTTP client timeout so the stream is
+// ended by its own context rather than the client.
+const resultStreamTimeout = 25 * time.Second
+
 func (a *BotApp) pollAndRelayResult(chatID int64, userID int64, commandID string) {
+	a.relayResultAsync(chatID, userID, commandID, nil, nil)
+}
+
+// relayResultAsync waits for the result of commandID in the background and
+// relays it. stopTyping, when set, is called on the first progress update and
+// again once waiting ends; done, when set, is called once waiting ends.
+func (a *BotApp) relayResultAsync(chatID int64, userID int64, commandID string, stopTyping context.CancelFunc, done func()) {
 	go func() {
-		timeout := time.After(2 * time.Second)
-		ticker := time.NewTicker(200 * time.Millisecond)
+		if done != nil {
+			defer done()
+		}
+		if stopTyping != nil {
+			defer stopTyping()
+		}
+		progress := &progressMessage{app: a, chatID: chatID, stopTyping: stopTyping}
+		res, err := a.streamResult(userID, commandID, progress.update)
+		if err == nil {
+			a.relayCommandResult(chatID, userID, commandID, res)
+			return
+		}
+		// Backends without /v1/result/stream, and streams that end before
+		// the final result, fall back to polling until a terminal result
+		// arrives or resultPollTimeout passes.
+		timeout := time.After(a.resultPollTimeout())
+		ticker := time.NewTicker(a.resultPollInterval())
 		defer ticker.Stop()
 		for {
 			select {
@@ -1100,18 +2723,140 @@ func (a *BotApp) pollAndRelayResult(chatID int64, userID int64, commandID string
 				if err != nil || res == nil {
 					continue
 				}
-				if res.OK {
-					a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Result: %s", formatSummary(res))))
-				} else {
-					a.tg.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Result error: %s", res.ErrorCode)))
+				if !res.IsTerminal() {
+					progress.update(res)
+					continue
 				}
+				a.relayCommandResult(chatID, userID, commandID, res)
 				return
 			}
 		}
 	}()
 }
 
-func formatSummary(res *contracts.CommandResult) string {
+func (a *BotApp) resultPollTimeout() time.Duration {
+	if a.cfg != nil && a.cfg.ResultPollTimeout > 0 {
+		return a.cfg.ResultPollTimeout
+	}
+	return DefaultResultPollTimeout
+}
+
+func (a *BotApp) resultPollInterval() time.Duration {
+	if a.cfg != nil && a.cfg.ResultPollInterval > 0 {
+		return a.cfg.ResultPollInterval
+	}
+	return DefaultResultPollInterval
+}
+
+// relayCommandResult relays res and, when the agent refused the command on
+// policy grounds (for example because a grant expired), follows up with the
+// approval buttons for the command's project.
+func (a *BotApp) relayCommandResult(chatID int64, userID int64, commandID string, res *contracts.CommandResult) {
+	rec, found := a.findCommand(userID, commandID)
+	if found && rec.Ping && res.OK {
+		a.tg.Send(tgbotapi.NewMessage(chatID, formatPong(time.Since(rec.CreatedAt), res)))
+		return
+	}
+	a.relayResult(chatID, res)
+	if res.ErrorCode != contracts.ErrPolicyDenied {
+		return
+	}
+	if !found || rec.ProjectID == "" {
+		return
+	}
+	scope := contracts.ScopeStartServer
+	if rec.Type == contracts.CommandTypeRunTask {
+		scope = contracts.ScopeRunTask
+	}
+	a.promptApproval(chatID, userID, &projectRecord{ProjectID: rec.ProjectID, Alias: rec.Alias}, []string{scope})
+}
+
+// formatPong reports a /ping round trip, rounded to 0.1s, and the agent that
+// answered when its status result names it.
+func formatPong(elapsed time.Duration, res *contracts.CommandResult) string {
+	text := fmt.Sprintf("pong in %.1fs", elapsed.Seconds())
+	if agentID, _ := res.Meta["agent_id"].(string); agentID != "" {
+		text += " via agent " + agentID
+	}
+	return text
+}
+
+func (a *BotApp) relayResult(chatID int64, res *contracts.CommandResult) {
+	if res.OK {
+		text := fmt.Sprintf("Result: %s", formatSummary(res, a.maxOutputChars()))
+		if ports := formatPortUsage(res.Meta); ports != "" {
+			text += "\n" + ports
+		}
+		a.sendChunked(chatID, text)
+		return
+	}
+	// A cancelled command is not a failure; show it with whatever it
+	// produced before it stopped.
+	text := fmt.Sprintf("Result error: %s", res.ErrorCode)
+	if res.ErrorCode == contracts.ErrCancelled {
+		text = "Cancelled."
+	}
+	if secs, ok := res.Meta["timeout_seconds"].(float64); ok && res.ErrorCode == contracts.ErrStartTimeout {
+		// The agent tags which deadline passed; results from agents that
+		// do not are treated as a slow server start.
+		if phase, _ := res.Meta["timeout_phase"].(string); phase == "run" {
+			text += fmt.Sprintf("\ntimed out after %ds — the task ran longer than its timeout.", int(secs))
+		} else {
+			text += fmt.Sprintf("\ntimed out after %ds — the server may be slow to start, try again.", int(secs))
+		}
+	}
+	if details := formatSummary(res, a.maxOutputChars()); details != "" {
+		text += "\n" + details
+	}
+	a.sendChunked(chatID, text)
+}
+
+// sendChunked sends text as one message, or several when it is longer than
+// Telegram allows.
+func (a *BotApp) sendChunked(chatID int64, text string) {
+	for _, chunk := range splitMessageText(text, telegramMessageLimit) {
+		a.tg.Send(tgbotapi.NewMessage(chatID, chunk))
+	}
+}
+
+// progressMessage shows partial run_task output in a single Telegram message
+// that is edited as newer progress arrives.
+type progressMessage struct {
+	app       *BotApp
+	chatID    int64
+	messageID int
+	lastText  string
+	// stopTyping ends the typing action once output starts to arrive.
+	stopTyping context.CancelFunc
+}
+
+func (p *progressMessage) update(res *contracts.CommandResult) {
+	if p.stopTyping != nil {
+		p.stopTyping()
+	}
+	text := fmt.Sprintf("In progress: %s", formatSummary(res, p.app.maxOutputChars()))
+	// Progress is a single edited message, so it cannot be split into
+	// chunks like the final result; cut it to what Telegram accepts.
+	text = truncateOutput(text, telegramMessageLimit-len("..."))
+	// Telegram rejects edits that do not change the text.
+	if text == p.lastText {
+		return
+	}
+	p.lastText = text
+	if p.messageID == 0 {
+		msg, err := p.app.tg.Send(tgbotapi.NewMessage(p.chatID, text))
+		if err == nil {
+			p.messageID = msg.MessageID
+		}
+		return
+	}
+	// Progress is best-effort; the final result is sent as its own message.
+	_ = p.app.requestWithRetry(tgbotapi.NewEditMessageText(p.chatID, p.messageID, text))
+}
+
+// formatSummary renders a result's summary, exit code and output, each
+// output stream truncated to maxOutput characters.
+func formatSummary(res *contracts.CommandResult, maxOutput int) string {
 	if res == nil {
 		return ""
 	}
@@ -1119,21 +2864,102 @@ func formatSummary(res *contracts.CommandResult) string {
 	if res.Summary != "" {
 		parts = append(parts, res.Summary)
 	}
+	if res.ExitCode != nil {
+		parts = append(parts, fmt.Sprintf("exit code: %d", *res.ExitCode))
+	}
 	if res.Stdout != "" {
-		parts = append(parts, truncateOutput(res.Stdout))
+		parts = append(parts, truncateOutput(res.Stdout, maxOutput))
 	}
 	if res.Stderr != "" {
-		parts = append(parts, truncateOutput(res.Stderr))
+		parts = append(parts, truncateOutput(res.Stderr, maxOutput))
 	}
 	return strings.Join(parts, "\n")
 }
 
-func truncateOutput(s string) string {
-	const max = 2048
-	if len(s) <= max {
+// formatPortUsage renders the ports_used and port_range a status result
+// carries, or "" when meta has neither.
+func formatPortUsage(meta map[string]any) string {
+	used, hasUsed := meta["ports_used"].([]any)
+	portRange, _ := meta["port_range"].(string)
+	if !hasUsed && portRange == "" {
+		return ""
+	}
+	ports := make([]string, 0, len(used))
+	for _, p := range used {
+		if n, ok := p.(float64); ok {
+			ports = append(ports, strconv.Itoa(int(n)))
+		}
+	}
+	text := "Ports in use: none"
+	if len(ports) > 0 {
+		text = fmt.Sprintf("Ports in use (%d): %s", len(ports), strings.Join(ports, ", "))
+	}
+	if portRange != "" {
+		text += " (range " + portRange + ")"
+	}
+	return text
+}
+
+// truncateOutput cuts s to its first max characters plus "...". It counts
+// runes so multibyte output is never split inside a character.
+func truncateOutput(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
 		return s
 	}
-	return s[:max] + "..."
+	return string([]rune(s)[:max]) + "..."
+}
+
+func (a *BotApp) maxOutputChars() int {
+	if a.cfg != nil && a.cfg.MaxOutputChars > 0 {
+		return a.cfg.MaxOutputChars
+	}
+	return DefaultMaxOutputChars
+}
+
+// errResultStreamEnded reports a result stream that closed or timed out
+// before the final result, so the caller falls back to polling.
+var errResultStreamEnded = errors.New("result stream ended without a final result")
+
+// streamResult waits on the backend's server-sent-events endpoint for the
+// command result, passing progress updates to onProgress when it is non-nil.
+// A stream that ends first returns errResultStreamEnded.
+func (a *BotApp) streamResult(userID int64, commandID string, onProgress func(*contracts.CommandResult)) (*contracts.CommandResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), resultStreamTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/v1/result/stream?command_id=%s", a.backendURL, url.QueryEscape(commandID)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("X-Telegram-User-ID", strconv.FormatInt(userID, 10))
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("backend status %d", resp.StatusCode)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var result contracts.CommandResult
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &result); err != nil {
+			return nil, err
+		}
+		if !result.IsTerminal() {
+			if onProgress != nil {
+				onProgress(&result)
+			}
+			continue
+		}
+		return &result, nil
+	}
+	return nil, errResultStreamEnded
 }
 
 func (a *BotApp) fetchResult(userID int64, commandID string) (*contracts.CommandResult, error) {
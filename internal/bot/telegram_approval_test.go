@@ -72,10 +72,20 @@ func TestBotApprovalDecision_BackendPathsAndSuccess(t *testing.T) {
 	}
 	_ = st.SetUserAgentKey(7, "agent-key")
 
-	cb := &tgbotapi.CallbackQuery{ID: "cb", Data: "approve:allow30:both|demo", Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1}}, From: &tgbotapi.User{ID: 7}}
+	cb := &tgbotapi.CallbackQuery{ID: "cb", Data: "approve:allow30:both|demo", Message: &tgbotapi.Message{MessageID: 55, Chat: &tgbotapi.Chat{ID: 1}}, From: &tgbotapi.User{ID: 7}}
 	app.handleApprovalDecision(cb)
-	if len(tg.sentMessages) == 0 || !strings.Contains(tg.sentMessages[len(tg.sentMessages)-1].Text, "Policy updated") {
-		t.Fatalf("expected success message, got %+v", tg.sentMessages)
+	if len(tg.requests) != 1 {
+		t.Fatalf("expected the approval prompt to be edited, got %+v", tg.requests)
+	}
+	edit, ok := tg.requests[0].(tgbotapi.EditMessageTextConfig)
+	if !ok || edit.MessageID != 55 || !strings.Contains(edit.Text, "Policy updated for demo: allowed START_SERVER + RUN_TASK until") {
+		t.Fatalf("expected decision edit of message 55, got %+v", tg.requests[0])
+	}
+	if edit.ReplyMarkup != nil {
+		t.Fatalf("expected buttons removed, got %+v", edit.ReplyMarkup)
+	}
+	if len(tg.sentMessages) != 0 {
+		t.Fatalf("expected no extra message when the edit succeeds, got %+v", tg.sentMessages)
 	}
 	if lastPayload["type"] != contracts.CommandTypeApplyProjectPolicy {
 		t.Fatalf("expected apply policy command, got %+v", lastPayload)
@@ -92,6 +102,12 @@ func TestBotApprovalDecision_BackendPathsAndSuccess(t *testing.T) {
 		t.Fatalf("expected expires_at for allow30 option, got %+v", payload)
 	}
 
+	tg.requestErrs = []error{errSentinel("message is too old")}
+	app.handleApprovalDecision(&tgbotapi.CallbackQuery{ID: "cb", Data: "approve:deny|demo", Message: &tgbotapi.Message{MessageID: 56, Chat: &tgbotapi.Chat{ID: 1}}, From: &tgbotapi.User{ID: 7}})
+	if len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != "Policy updated for demo: denied." {
+		t.Fatalf("expected fallback message when the edit fails, got %+v", tg.sentMessages)
+	}
+
 	tg.sentMessages = nil
 	status = http.StatusBadRequest
 	app.handleApprovalDecision(cb)
@@ -100,6 +116,82 @@ func TestBotApprovalDecision_BackendPathsAndSuccess(t *testing.T) {
 	}
 }
 
+func TestBotHandlePolicy(t *testing.T) {
+	var lastPayload map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/command", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&lastPayload)
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, st := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	exp := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	app.listProjectsFn = func(userID int64) ([]projectRecord, error) {
+		return []projectRecord{
+			{Alias: "demo", ProjectID: "p1", Policy: approvalDecision{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeRunTask}, ExpiresAt: &exp}},
+			{Alias: "fresh", ProjectID: "p2"},
+		}, nil
+	}
+
+	last := func() string { return tg.sentMessages[len(tg.sentMessages)-1].Text }
+
+	app.handlePolicy(1, "demo", 7)
+	if want := "Policy for demo (p1):\nDecision: ALLOW\nScope: RUN_TASK\nExpires: 2030-01-02T03:04:05Z"; last() != want {
+		t.Fatalf("expected current policy, got %q", last())
+	}
+	app.handlePolicy(1, "fresh", 7)
+	if !strings.Contains(last(), "Decision: DENY\nScope: none\nExpires: never") {
+		t.Fatalf("expected default deny policy, got %q", last())
+	}
+
+	for _, args := range []string{"", "demo allow", "demo allow build", "demo allow run 0s", "demo allow run 1h 2h", "demo deny run", "demo maybe"} {
+		tg.sentMessages = nil
+		app.handlePolicy(1, args, 7)
+		if !strings.Contains(last(), "Usage: /policy") {
+			t.Fatalf("expected usage for %q, got %q", args, last())
+		}
+	}
+	app.handlePolicy(1, "ghost allow run", 7)
+	if !strings.Contains(last(), `Unknown project alias "ghost"`) {
+		t.Fatalf("expected unknown alias, got %q", last())
+	}
+	app.handlePolicy(1, "demo allow run 1h", 7)
+	if !strings.Contains(last(), "not paired") {
+		t.Fatalf("expected pairing hint, got %q", last())
+	}
+
+	_ = st.SetUserAgentKey(7, "agent-key")
+	app.handlePolicy(1, "demo allow run start run 1h", 7)
+	if !strings.HasPrefix(last(), "Policy update queued for demo: allowed RUN_TASK + START_SERVER until") {
+		t.Fatalf("expected queued policy, got %q", last())
+	}
+	if lastPayload["type"] != contracts.CommandTypeApplyProjectPolicy {
+		t.Fatalf("expected apply policy command, got %+v", lastPayload)
+	}
+	payload, _ := lastPayload["payload"].(map[string]any)
+	scopes, _ := payload["scope"].([]any)
+	if payload["project_id"] != "p1" || payload["decision"] != contracts.DecisionAllow || len(scopes) != 2 {
+		t.Fatalf("unexpected policy payload: %+v", payload)
+	}
+	expiresAt, err := time.Parse(time.RFC3339Nano, payload["expires_at"].(string))
+	if err != nil || time.Until(expiresAt) < 59*time.Minute || time.Until(expiresAt) > time.Hour {
+		t.Fatalf("expected expiry about an hour out, got %v err=%v", payload["expires_at"], err)
+	}
+
+	app.handlePolicy(1, "demo deny", 7)
+	payload, _ = lastPayload["payload"].(map[string]any)
+	if last() != "Policy update queued for demo: denied." || payload["decision"] != contracts.DecisionDeny {
+		t.Fatalf("expected deny queued, got %q %+v", last(), payload)
+	}
+	if _, ok := payload["expires_at"]; ok {
+		t.Fatalf("expected no expiry for deny, got %+v", payload)
+	}
+}
+
 func TestBotUpdateLocalPolicyNoopCoverage(t *testing.T) {
 	app, _, _ := testBotApp(&Config{}, &mockOpencodeClient{})
 	app.listProjectsFn = func(userID int64) ([]projectRecord, error) {
@@ -115,3 +207,29 @@ func TestBotUpdateLocalPolicyNoopCoverage(t *testing.T) {
 type errSentinel string
 
 func (e errSentinel) Error() string { return string(e) }
+
+func TestBotRelayCommandResultPromptsApprovalOnPolicyDenied(t *testing.T) {
+	app, tg, _ := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.storeCommand(7, commandRecord{CommandID: "run-1", Type: contracts.CommandTypeRunTask, ProjectID: "p1", Alias: "demo"})
+	app.storeCommand(7, commandRecord{CommandID: "status-1", Type: contracts.CommandTypeStatus})
+
+	app.relayCommandResult(1, 7, "run-1", &contracts.CommandResult{CommandID: "run-1", ErrorCode: contracts.ErrPolicyDenied, Summary: "policy denied"})
+	if len(tg.sentMessages) != 2 {
+		t.Fatalf("expected result plus approval prompt, got %+v", tg.sentMessages)
+	}
+	prompt := tg.sentMessages[1]
+	if prompt.Text != "Approval required for demo." {
+		t.Fatalf("unexpected prompt: %+v", prompt)
+	}
+	markup, ok := prompt.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	if !ok || len(markup.InlineKeyboard) == 0 || *markup.InlineKeyboard[0][0].CallbackData != "approve:deny|demo" {
+		t.Fatalf("expected approval buttons, got %+v", prompt.ReplyMarkup)
+	}
+
+	tg.sentMessages = nil
+	app.relayCommandResult(1, 7, "status-1", &contracts.CommandResult{CommandID: "status-1", ErrorCode: contracts.ErrPolicyDenied})
+	app.relayCommandResult(1, 7, "run-1", &contracts.CommandResult{CommandID: "run-1", ErrorCode: contracts.ErrInternal})
+	if len(tg.sentMessages) != 2 {
+		t.Fatalf("expected only the results without prompts, got %+v", tg.sentMessages)
+	}
+}
@@ -2,14 +2,21 @@ package bot
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"opencode-telegram/internal/proxy/contracts"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 func TestBotProjectAddPairingAndRegistrationFlow(t *testing.T) {
@@ -40,20 +47,25 @@ func TestBotProjectAddPairingAndRegistrationFlow(t *testing.T) {
 	app.backendURL = srv.URL
 	app.httpClient = &http.Client{Timeout: 200 * time.Millisecond}
 
-	// First call starts pairing
+	// Adding a project before pairing points at /pair instead of pairing
 	app.handleProjectAdd(1, "/tmp/demo", 7)
+	if len(tg.sentMessages) != 1 || !strings.Contains(tg.sentMessages[0].Text, "Use /pair") {
+		t.Fatalf("expected not paired message, got %+v", tg.sentMessages)
+	}
+
+	tg.sentMessages = nil
+	app.handlePair(1, "", 7)
 	if len(tg.sentMessages) == 0 || !strings.Contains(tg.sentMessages[0].Text, "Pairing initiated") {
 		t.Fatalf("expected pairing initiated message, got %+v", tg.sentMessages)
 	}
 
-	// Second call claims pairing using stored code
+	// /pair status claims the stored code
 	tg.sentMessages = nil
-	app.handleProjectAdd(1, "/tmp/demo", 7)
+	app.handlePair(1, "status", 7)
 	if len(tg.sentMessages) == 0 || !strings.Contains(tg.sentMessages[0].Text, "Pairing completed") {
 		t.Fatalf("expected pairing completed message, got %+v", tg.sentMessages)
 	}
 
-	// Third call queues register command
 	tg.sentMessages = nil
 	app.handleProjectAdd(1, "/tmp/demo", 7)
 	if len(tg.sentMessages) == 0 || !strings.Contains(tg.sentMessages[0].Text, "registration queued") {
@@ -76,7 +88,7 @@ func TestBotProjectListResolveAndHelpers(t *testing.T) {
 		return projects, nil
 	}
 
-	app.handleProjectList(10, 9)
+	app.handleProjectList(10, "", 9)
 	if len(tg.sentMessages) != 1 || !strings.Contains(tg.sentMessages[0].Text, "demo") {
 		t.Fatalf("expected project list message, got %+v", tg.sentMessages)
 	}
@@ -100,6 +112,26 @@ func TestBotProjectListResolveAndHelpers(t *testing.T) {
 	}
 }
 
+func TestBotRelayResultHonorsMaxOutputChars(t *testing.T) {
+	if got := truncateOutput("żółw żółw", 3); got != "żół..." || !utf8.ValidString(got) {
+		t.Fatalf("expected truncation on a rune boundary, got %q", got)
+	}
+
+	app, tg, _ := testBotApp(&Config{MaxOutputChars: 5}, &mockOpencodeClient{})
+	app.relayResult(1, &contracts.CommandResult{CommandID: "c1", OK: true, Summary: "done", Stdout: "日本語のテキスト", Stderr: "warn"})
+	if len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != "Result: done\n日本語のテ...\nwarn" {
+		t.Fatalf("expected stdout cut at 5 characters, got %+v", tg.sentMessages)
+	}
+
+	// a limit above Telegram's message size splits the result across messages
+	app.cfg.MaxOutputChars = 10000
+	tg.sentMessages = nil
+	app.relayResult(1, &contracts.CommandResult{CommandID: "c2", OK: true, Summary: "done", Stdout: strings.Repeat("é", 6000)})
+	if len(tg.sentMessages) != 2 || !strings.HasSuffix(tg.sentMessages[1].Text, "é") {
+		t.Fatalf("expected the full output in 2 messages, got %d", len(tg.sentMessages))
+	}
+}
+
 func TestBotCommandStorageAndFormattingHelpers(t *testing.T) {
 	app, _, _ := testBotApp(&Config{}, &mockOpencodeClient{})
 	now := time.Now().UTC()
@@ -111,13 +143,18 @@ func TestBotCommandStorageAndFormattingHelpers(t *testing.T) {
 	}
 
 	long := strings.Repeat("x", 3000)
-	if len(truncateOutput(long)) != 2051 { // 2048 + "..."
-		t.Fatalf("truncateOutput length mismatch: %d", len(truncateOutput(long)))
+	if len(truncateOutput(long, DefaultMaxOutputChars)) != 2051 { // 2048 + "..."
+		t.Fatalf("truncateOutput length mismatch: %d", len(truncateOutput(long, DefaultMaxOutputChars)))
 	}
-	formatted := formatSummary(&contracts.CommandResult{Summary: "ok", Stdout: "out", Stderr: "err"})
+	formatted := formatSummary(&contracts.CommandResult{Summary: "ok", Stdout: "out", Stderr: "err"}, DefaultMaxOutputChars)
 	if !strings.Contains(formatted, "ok") || !strings.Contains(formatted, "out") || !strings.Contains(formatted, "err") {
 		t.Fatalf("unexpected formatted summary: %q", formatted)
 	}
+	exitCode := 139
+	formatted = formatSummary(&contracts.CommandResult{Summary: "exit status 139", ExitCode: &exitCode}, DefaultMaxOutputChars)
+	if formatted != "exit status 139\nexit code: 139" {
+		t.Fatalf("expected exit code in summary, got %q", formatted)
+	}
 }
 
 func TestBotFetchResultAndPollRelay(t *testing.T) {
@@ -147,8 +184,8 @@ func TestBotFetchResultAndPollRelay(t *testing.T) {
 
 	app.pollAndRelayResult(42, 1, "c1")
 	time.Sleep(250 * time.Millisecond)
-	if len(tg.sentMessages) == 0 || !strings.Contains(tg.sentMessages[len(tg.sentMessages)-1].Text, "Result:") {
-		t.Fatalf("expected relayed result message, got %+v", tg.sentMessages)
+	if sent := tg.messages(); len(sent) == 0 || !strings.Contains(sent[len(sent)-1].Text, "Result:") {
+		t.Fatalf("expected relayed result message, got %+v", sent)
 	}
 }
 
@@ -173,15 +210,16 @@ func TestBotStartServerAndRunPaths(t *testing.T) {
 
 	app.handleStartServer(1, "demo", 7)
 	app.handleRun(1, "demo hello world", 7)
+	app.handleStopServer(1, "demo", 7)
 
-	if len(tg.sentMessages) < 2 {
+	if len(tg.sentMessages) < 3 {
 		t.Fatalf("expected start/run queue messages, got %+v", tg.sentMessages)
 	}
 	joined := ""
 	for _, m := range tg.sentMessages {
 		joined += m.Text + "\n"
 	}
-	if !strings.Contains(joined, "start_server queued") || !strings.Contains(joined, "run_task queued") {
+	if !strings.Contains(joined, "start_server queued") || !strings.Contains(joined, "run_task queued") || !strings.Contains(joined, "stop_server queued") {
 		t.Fatalf("expected queued confirmations, got %s", joined)
 	}
 
@@ -189,10 +227,11 @@ func TestBotStartServerAndRunPaths(t *testing.T) {
 	tg.sentMessages = nil
 	app.handleStartServer(1, "", 7)
 	app.handleRun(1, "demo", 7)
-	if len(tg.sentMessages) != 2 {
-		t.Fatalf("expected two usage errors, got %+v", tg.sentMessages)
+	app.handleStopServer(1, "", 7)
+	if len(tg.sentMessages) != 3 {
+		t.Fatalf("expected three usage errors, got %+v", tg.sentMessages)
 	}
-	if !strings.Contains(tg.sentMessages[0].Text, "Usage: /start_server") || !strings.Contains(tg.sentMessages[1].Text, "Usage: /run") {
+	if !strings.Contains(tg.sentMessages[0].Text, "Usage: /start_server") || !strings.Contains(tg.sentMessages[1].Text, "Usage: /run") || !strings.Contains(tg.sentMessages[2].Text, "Usage: /stop_server") {
 		t.Fatalf("unexpected usage responses: %+v", tg.sentMessages)
 	}
 }
@@ -207,14 +246,14 @@ func TestBotSessionRunHelpers(t *testing.T) {
 		},
 	})
 
-	if !app.tryStartRun(1, 2, "ses_1") {
+	if err := app.tryStartRun(1, 2, "ses_1"); err != nil {
 		t.Fatal("expected first run lock to succeed")
 	}
-	if app.tryStartRun(1, 2, "ses_2") {
-		t.Fatal("expected second run lock to fail for same key")
+	if err := app.tryStartRun(1, 2, "ses_2"); !errors.Is(err, errRunInProgress) {
+		t.Fatalf("expected second run lock to fail for same key, got %v", err)
 	}
 	app.clearRun(1, 2)
-	if !app.tryStartRun(1, 2, "ses_3") {
+	if err := app.tryStartRun(1, 2, "ses_3"); err != nil {
 		t.Fatal("expected lock after clear")
 	}
 	if !app.clearRunBySession("ses_3") {
@@ -233,3 +272,1055 @@ func TestBotSessionRunHelpers(t *testing.T) {
 		t.Fatal("expected resolveUserSession to fail when list sessions fails")
 	}
 }
+
+func TestBotStreamResult(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/result/stream", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("command_id") != "cmd-1" || r.Header.Get("X-Telegram-User-ID") != "7" {
+			t.Errorf("unexpected stream request: %s user=%q", r.URL.RawQuery, r.Header.Get("X-Telegram-User-ID"))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, "event: result\ndata: {\"command_id\":\"cmd-1\",\"ok\":false,\"error_code\":\"E_INTERNAL\",\"summary\":\"boom\"}\n\n")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, _ := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	app.httpClient = &http.Client{Timeout: time.Second}
+
+	res, err := app.streamResult(7, "cmd-1", nil)
+	if err != nil || res == nil || res.CommandID != "cmd-1" || res.OK {
+		t.Fatalf("unexpected stream result res=%+v err=%v", res, err)
+	}
+	app.relayResult(1, res)
+	if len(tg.sentMessages) != 1 || !strings.Contains(tg.sentMessages[0].Text, "Result error: E_INTERNAL") || !strings.Contains(tg.sentMessages[0].Text, "boom") {
+		t.Fatalf("unexpected relayed message: %+v", tg.sentMessages)
+	}
+
+	app.backendURL = srv.URL + "/nope"
+	if _, err := app.streamResult(7, "cmd-1", nil); err == nil {
+		t.Fatal("expected error when stream endpoint is missing")
+	}
+}
+
+func TestBotRelayResultAsyncPollsAfterStreamEnds(t *testing.T) {
+	mux := http.NewServeMux()
+	// the stream closes after a progress update, as on a stream timeout
+	mux.HandleFunc("/v1/result/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, "event: result\ndata: {\"command_id\":\"cmd-1\",\"in_progress\":true}\n\n")
+	})
+	mux.HandleFunc("/v1/result/status", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(contracts.CommandResult{CommandID: "cmd-1", OK: true, Summary: "finished"})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, _ := testBotApp(&Config{ResultPollInterval: 10 * time.Millisecond}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	if _, err := app.streamResult(7, "cmd-1", nil); !errors.Is(err, errResultStreamEnded) {
+		t.Fatalf("expected errResultStreamEnded, got %v", err)
+	}
+
+	done := make(chan struct{})
+	app.relayResultAsync(1, 7, "cmd-1", nil, func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay did not finish")
+	}
+	relayed := false
+	for _, msg := range tg.sentMessages {
+		if strings.Contains(msg.Text, "finished") {
+			relayed = true
+		}
+	}
+	if !relayed {
+		t.Fatalf("expected the polled result relayed, got %+v", tg.sentMessages)
+	}
+}
+
+func TestBotRelayResultCancelled(t *testing.T) {
+	app, tg, _ := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.relayResult(1, &contracts.CommandResult{CommandID: "cmd-1", ErrorCode: contracts.ErrCancelled, Summary: "task cancelled"})
+	if len(tg.sentMessages) != 1 {
+		t.Fatalf("expected one message, got %+v", tg.sentMessages)
+	}
+	if text := tg.sentMessages[0].Text; !strings.HasPrefix(text, "Cancelled.") || strings.Contains(text, "Result error") || !strings.Contains(text, "task cancelled") {
+		t.Fatalf("expected cancellation message, got %q", text)
+	}
+}
+
+func TestBotRelayResultStartTimeout(t *testing.T) {
+	app, tg, _ := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.relayResult(1, &contracts.CommandResult{CommandID: "cmd-1", ErrorCode: contracts.ErrStartTimeout, Summary: "start timeout", Meta: map[string]any{"timeout_seconds": float64(10)}})
+	if len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != "Result error: ERR_START_TIMEOUT\ntimed out after 10s — the server may be slow to start, try again.\nstart timeout" {
+		t.Fatalf("expected retry guidance, got %+v", tg.sentMessages)
+	}
+
+	// a run that outlived its deadline is not blamed on the server start
+	app.relayResult(1, &contracts.CommandResult{CommandID: "cmd-2", ErrorCode: contracts.ErrStartTimeout, Summary: "command timeout", Meta: map[string]any{"timeout_seconds": float64(60), "timeout_phase": "run"}})
+	if len(tg.sentMessages) != 2 || tg.sentMessages[1].Text != "Result error: ERR_START_TIMEOUT\ntimed out after 60s — the task ran longer than its timeout.\ncommand timeout" {
+		t.Fatalf("expected run timeout wording, got %+v", tg.sentMessages)
+	}
+}
+
+func TestBotRelayResultShowsPortUsage(t *testing.T) {
+	app, tg, _ := testBotApp(&Config{}, &mockOpencodeClient{})
+	// meta arrives decoded from JSON, so numbers are float64
+	var res contracts.CommandResult
+	_ = json.Unmarshal([]byte(`{"command_id":"cmd-1","ok":true,"summary":"agent healthy","meta":{"ports_used":[20000,20003],"port_range":"20000-20100"}}`), &res)
+	app.relayResult(1, &res)
+	app.relayResult(1, &contracts.CommandResult{CommandID: "cmd-2", OK: true, Summary: "agent healthy", Meta: map[string]any{"ports_used": []any{}, "port_range": "20000-20100"}})
+	app.relayResult(1, &contracts.CommandResult{CommandID: "cmd-3", OK: true, Summary: "task completed"})
+
+	want := []string{
+		"Result: agent healthy\nPorts in use (2): 20000, 20003 (range 20000-20100)",
+		"Result: agent healthy\nPorts in use: none (range 20000-20100)",
+		"Result: task completed",
+	}
+	if len(tg.sentMessages) != len(want) {
+		t.Fatalf("unexpected replies: %+v", tg.sentMessages)
+	}
+	for i, w := range want {
+		if tg.sentMessages[i].Text != w {
+			t.Fatalf("reply %d: expected %q, got %q", i, w, tg.sentMessages[i].Text)
+		}
+	}
+}
+
+func TestBotStreamResultRelaysProgress(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/result/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, "event: result\ndata: {\"command_id\":\"cmd-1\",\"ok\":false,\"in_progress\":true,\"stdout\":\"one\"}\n\n")
+		_, _ = fmt.Fprint(w, "event: result\ndata: {\"command_id\":\"cmd-1\",\"ok\":false,\"in_progress\":true,\"stdout\":\"one\"}\n\n")
+		_, _ = fmt.Fprint(w, "event: result\ndata: {\"command_id\":\"cmd-1\",\"ok\":false,\"in_progress\":true,\"stdout\":\"one\\ntwo\"}\n\n")
+		_, _ = fmt.Fprint(w, "event: result\ndata: {\"command_id\":\"cmd-1\",\"ok\":true,\"summary\":\"task completed\"}\n\n")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, _ := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	app.httpClient = &http.Client{Timeout: time.Second}
+
+	progress := &progressMessage{app: app, chatID: 1}
+	res, err := app.streamResult(7, "cmd-1", progress.update)
+	if err != nil || res == nil || !res.OK || res.InProgress {
+		t.Fatalf("expected final result, got res=%+v err=%v", res, err)
+	}
+	if len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != "In progress: one" {
+		t.Fatalf("expected one progress message, got %+v", tg.sentMessages)
+	}
+	// the duplicate update is skipped; only the changed text is edited in
+	if len(tg.requests) != 1 {
+		t.Fatalf("expected one progress edit, got %d", len(tg.requests))
+	}
+	edit, ok := tg.requests[0].(tgbotapi.EditMessageTextConfig)
+	if !ok || edit.MessageID != progress.messageID || edit.Text != "In progress: one\ntwo" {
+		t.Fatalf("unexpected progress edit: %+v", tg.requests[0])
+	}
+
+	// progress larger than one message is cut to Telegram's limit
+	app.cfg.MaxOutputChars = 3 * telegramMessageLimit
+	big := &progressMessage{app: app, chatID: 1}
+	big.update(&contracts.CommandResult{CommandID: "cmd-2", InProgress: true, Stdout: strings.Repeat("x", 2*telegramMessageLimit)})
+	last := tg.sentMessages[len(tg.sentMessages)-1].Text
+	if n := utf8.RuneCountInString(last); n != telegramMessageLimit || !strings.HasSuffix(last, "...") {
+		t.Fatalf("expected progress clamped to %d characters, got %d", telegramMessageLimit, n)
+	}
+}
+
+func TestBotHandleProjectDelete(t *testing.T) {
+	var deleted []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/projects", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.Header.Get("Authorization") != "Bearer k1" {
+			t.Errorf("unexpected delete request: %s auth=%q", r.Method, r.Header.Get("Authorization"))
+		}
+		deleted = append(deleted, r.URL.Query().Get("project_id"))
+		if r.URL.Query().Get("project_id") == "p2" {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "error": map[string]string{"code": contracts.ErrProjectNotFound}})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, st := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	app.listProjectsFn = func(userID int64) ([]projectRecord, error) {
+		return []projectRecord{{Alias: "demo", ProjectID: "p1"}, {Alias: "gone", ProjectID: "p2"}}, nil
+	}
+
+	app.handleProjectDelete(1, "demo", 7)
+	_ = st.SetUserAgentKey(7, "k1")
+	app.handleProjectDelete(1, "", 7)
+	app.handleProjectDelete(1, "missing", 7)
+	app.handleProjectDelete(1, "DEMO", 7)
+	app.handleProjectDelete(1, "gone", 7)
+
+	if len(tg.sentMessages) != 5 {
+		t.Fatalf("expected 5 replies, got %+v", tg.sentMessages)
+	}
+	if !strings.Contains(tg.sentMessages[0].Text, "not paired") ||
+		!strings.HasPrefix(tg.sentMessages[1].Text, "Usage: /project delete") ||
+		tg.sentMessages[2].Text != `Unknown project alias "missing". Use /project list.` ||
+		tg.sentMessages[3].Text != "Project demo (p1) deleted." ||
+		!strings.Contains(tg.sentMessages[4].Text, contracts.ErrProjectNotFound) {
+		t.Fatalf("unexpected /project delete replies: %+v", tg.sentMessages)
+	}
+	if strings.Join(deleted, ",") != "p1,p2" {
+		t.Fatalf("unexpected delete calls: %v", deleted)
+	}
+}
+
+func TestBotHandleAgentPresence(t *testing.T) {
+	responses := map[string]func(w http.ResponseWriter){
+		"1": func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotFound) },
+		"2": func(w http.ResponseWriter) { _ = json.NewEncoder(w).Encode(contracts.AgentStatusResponse{}) },
+		"3": func(w http.ResponseWriter) {
+			seen := time.Now().Add(-10 * time.Second)
+			_ = json.NewEncoder(w).Encode(contracts.AgentStatusResponse{Online: true, LastSeen: &seen, PollTimeoutSeconds: 25})
+		},
+		"4": func(w http.ResponseWriter) {
+			seen := time.Now().Add(-time.Hour)
+			_ = json.NewEncoder(w).Encode(contracts.AgentStatusResponse{LastSeen: &seen})
+		},
+		"5": func(w http.ResponseWriter) { w.WriteHeader(http.StatusInternalServerError) },
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/agent/status", func(w http.ResponseWriter, r *http.Request) {
+		responses[r.URL.Query().Get("telegram_user_id")](w)
+	})
+	mux.HandleFunc("/v1/agent/queue", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("telegram_user_id") != "3" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(contracts.QueueStatsResponse{Queued: 2, Inflight: 1})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, _ := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	for userID := int64(1); userID <= 5; userID++ {
+		app.handleAgentPresence(1, userID)
+	}
+	if len(tg.sentMessages) != 5 {
+		t.Fatalf("expected 5 replies, got %+v", tg.sentMessages)
+	}
+	if !strings.Contains(tg.sentMessages[0].Text, "not paired") ||
+		!strings.Contains(tg.sentMessages[1].Text, "has not connected") ||
+		!strings.HasPrefix(tg.sentMessages[2].Text, "Agent online, last seen 1") ||
+		!strings.HasSuffix(tg.sentMessages[2].Text, "(long-poll timeout 25s). Queue: 2 queued, 1 in flight.") ||
+		!strings.HasPrefix(tg.sentMessages[3].Text, "Agent offline, last seen 1h0m") ||
+		strings.Contains(tg.sentMessages[3].Text, "Queue:") ||
+		!strings.Contains(tg.sentMessages[4].Text, "backend status 500") {
+		t.Fatalf("unexpected /agent replies: %+v", tg.sentMessages)
+	}
+}
+
+func TestBotHandleQueue(t *testing.T) {
+	created := time.Now().Add(-5 * time.Minute)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/agent/queue", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("telegram_user_id") {
+		case "1":
+			w.WriteHeader(http.StatusNotFound)
+		case "2":
+			_ = json.NewEncoder(w).Encode(contracts.QueueStatsResponse{})
+		case "3":
+			_ = json.NewEncoder(w).Encode(contracts.QueueStatsResponse{Queued: 2, Inflight: 1, Commands: []contracts.PendingCommand{
+				{CommandID: "c1", Type: contracts.CommandTypeRunTask, CreatedAt: created, Inflight: true},
+				{CommandID: "c2", Type: contracts.CommandTypeStartServer, CreatedAt: created},
+			}})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("/v1/agent/status", func(w http.ResponseWriter, r *http.Request) {
+		seen := time.Now().Add(-time.Hour)
+		_ = json.NewEncoder(w).Encode(contracts.AgentStatusResponse{Online: r.URL.Query().Get("telegram_user_id") == "2", LastSeen: &seen})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, _ := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	for userID := int64(1); userID <= 4; userID++ {
+		app.handleQueue(1, userID)
+	}
+	if len(tg.sentMessages) != 4 {
+		t.Fatalf("expected 4 replies, got %+v", tg.sentMessages)
+	}
+	want := "Pending commands (2 queued, 1 in flight):\nrun_task - in flight, 5m0s ago\nstart_server - queued, 5m0s ago\n...and 1 more\nAgent offline; queued commands run once it reconnects."
+	if !strings.Contains(tg.sentMessages[0].Text, "not paired") ||
+		tg.sentMessages[1].Text != "Queue is empty. Agent online." ||
+		tg.sentMessages[2].Text != want ||
+		!strings.Contains(tg.sentMessages[3].Text, "backend status 500") {
+		t.Fatalf("unexpected /queue replies: %+v", tg.sentMessages)
+	}
+}
+
+func TestBotHandleHistory(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/commands", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("telegram_user_id") {
+		case "1":
+			_ = json.NewEncoder(w).Encode(map[string]any{"commands": []historyEntry{}})
+		case "2":
+			created := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
+			_ = json.NewEncoder(w).Encode(map[string]any{"commands": []historyEntry{
+				{CommandID: "c2", Type: contracts.CommandTypeRunTask, Alias: "demo", CreatedAt: created, Status: "error", ErrorCode: contracts.ErrStartTimeout},
+				{CommandID: "c1", Type: contracts.CommandTypeStatus, CreatedAt: created, Status: "ok"},
+			}})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, _ := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	app.handleHistory(1, 1)
+	app.handleHistory(1, 2)
+	app.handleHistory(1, 3)
+	if len(tg.sentMessages) != 3 {
+		t.Fatalf("expected 3 replies, got %+v", tg.sentMessages)
+	}
+	want := "Recent commands:\n2026-02-10 10:00 run_task demo - error (ERR_START_TIMEOUT)\n2026-02-10 10:00 status - ok\n"
+	if tg.sentMessages[0].Text != "No commands yet." || tg.sentMessages[1].Text != want || !strings.Contains(tg.sentMessages[2].Text, "backend status 400") {
+		t.Fatalf("unexpected /history replies: %+v", tg.sentMessages)
+	}
+}
+
+func TestBotHandleModelThreadsIntoRun(t *testing.T) {
+	var payloads []map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/command", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if p, ok := body["payload"].(map[string]any); ok {
+			payloads = append(payloads, p)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, st := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	app.listProjectsFn = func(userID int64) ([]projectRecord, error) {
+		return []projectRecord{{Alias: "demo", ProjectID: "p1", Policy: approvalDecision{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeRunTask}}}}, nil
+	}
+	_ = st.SetUserAgentKey(7, "k1")
+
+	app.handleModel(1, "", 7)
+	app.handleModel(1, "anthropic/claude-sonnet", 7)
+	app.handleModel(1, "", 7)
+	app.handleModel(1, "two words", 7)
+	if len(tg.sentMessages) != 4 ||
+		!strings.Contains(tg.sentMessages[0].Text, "default model") ||
+		tg.sentMessages[1].Text != "Model set to anthropic/claude-sonnet." ||
+		tg.sentMessages[2].Text != "Current model: anthropic/claude-sonnet" ||
+		!strings.HasPrefix(tg.sentMessages[3].Text, "Usage: /model") {
+		t.Fatalf("unexpected /model replies: %+v", tg.sentMessages)
+	}
+
+	app.handleRun(1, "demo hello", 7)
+	app.handleModel(1, "default", 7)
+	app.handleRun(1, "demo again", 7)
+	if len(payloads) != 2 || payloads[0]["model"] != "anthropic/claude-sonnet" {
+		t.Fatalf("expected model in first run payload, got %+v", payloads)
+	}
+	if _, ok := payloads[1]["model"]; ok {
+		t.Fatalf("expected no model after reset, got %+v", payloads[1])
+	}
+}
+
+func TestBotHandleCancel(t *testing.T) {
+	var bodies []map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/command", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/v1/result/status", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, st := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	app.httpClient = &http.Client{Timeout: 200 * time.Millisecond}
+
+	app.handleCancel(1, "", 7)
+	app.handleCancel(1, "cmd-1", 7)
+	_ = st.SetUserAgentKey(7, "k1")
+	app.handleCancel(1, "cmd-1", 7)
+	if len(tg.sentMessages) != 3 ||
+		!strings.HasPrefix(tg.sentMessages[0].Text, "Usage: /cancel") ||
+		!strings.Contains(tg.sentMessages[1].Text, "not paired") ||
+		tg.sentMessages[2].Text != "cancel_task queued for cmd-1." {
+		t.Fatalf("unexpected /cancel replies: %+v", tg.sentMessages)
+	}
+	if len(bodies) != 1 || bodies[0]["type"] != contracts.CommandTypeCancelTask || bodies[0]["priority"] != float64(contracts.PriorityHigh) {
+		t.Fatalf("expected one cancel_task command, got %+v", bodies)
+	}
+	if payload, _ := bodies[0]["payload"].(map[string]any); payload["command_id"] != "cmd-1" {
+		t.Fatalf("expected target command id in payload, got %+v", bodies[0]["payload"])
+	}
+}
+
+func TestBotHandleAbortQueuesForPairedAdmin(t *testing.T) {
+	var bodies []map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/projects", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"projects": []projectRecord{{Alias: "demo", ProjectID: "p1"}}})
+	})
+	mux.HandleFunc("/v1/command", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/v1/result/status", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	oc := &mockOpencodeClient{abortSession: func(string) error {
+		t.Error("paired abort must not call Opencode directly")
+		return nil
+	}}
+	app, tg, st := testBotApp(&Config{AdminIDs: map[int64]bool{7: true}}, oc)
+	app.backendURL = srv.URL
+	app.httpClient = &http.Client{Timeout: 200 * time.Millisecond}
+	_ = st.SetUserAgentKey(7, "k1")
+	_ = st.SetUserAgentKey(8, "k2")
+
+	app.handleAbort(1, "ses_1", 8)
+	app.handleAbort(1, "ses_1", 7)
+	app.handleAbort(1, "nope ses_1", 7)
+	app.handleAbort(1, "demo ses_1", 7)
+	if len(tg.sentMessages) != 4 ||
+		tg.sentMessages[0].Text != "Only admins can abort sessions." ||
+		!strings.HasPrefix(tg.sentMessages[1].Text, "Usage: /abort <project> <session_id>") ||
+		!strings.HasPrefix(tg.sentMessages[2].Text, "Unknown project alias") ||
+		tg.sentMessages[3].Text != "abort_session queued for ses_1 on demo." {
+		t.Fatalf("unexpected /abort replies: %+v", tg.sentMessages)
+	}
+	if len(bodies) != 1 || bodies[0]["type"] != contracts.CommandTypeAbortSession || bodies[0]["priority"] != float64(contracts.PriorityHigh) {
+		t.Fatalf("expected one abort_session command, got %+v", bodies)
+	}
+	if payload, _ := bodies[0]["payload"].(map[string]any); payload["project_id"] != "p1" || payload["session_id"] != "ses_1" {
+		t.Fatalf("unexpected abort payload: %+v", bodies[0]["payload"])
+	}
+}
+
+func TestBotHandleAttachment(t *testing.T) {
+	var bodies []map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/command", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/file/bottok/docs/notes.txt", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("/file/bottok/docs/big.txt", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("far too large"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, st := testBotApp(&Config{TelegramToken: "tok", MaxAttachmentBytes: 8}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	app.fileEndpoint = srv.URL + "/file/bot%s/%s"
+	app.httpClient = &http.Client{Timeout: 200 * time.Millisecond}
+	app.listProjectsFn = func(userID int64) ([]projectRecord, error) {
+		return []projectRecord{{Alias: "demo", ProjectID: "p1", Policy: approvalDecision{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeRunTask}}}}, nil
+	}
+	_ = st.SetUserAgentKey(7, "k1")
+	doc := func(name string, size int, caption string) *tgbotapi.Message {
+		return &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1}, Caption: caption, Document: &tgbotapi.Document{FileID: "f1", FileName: name, FileSize: size}}
+	}
+
+	app.handleAttachment(doc("notes.txt", 5, ""), 7)
+	app.handleAttachment(doc("notes.txt", 9, "demo summarise"), 7)
+	app.handleAttachment(doc("..", 5, "demo summarise"), 7)
+	tg.requestResult = json.RawMessage(`{"file_id":"f1","file_path":"docs/big.txt"}`)
+	app.handleAttachment(doc("big.txt", 0, "demo summarise"), 7)
+	if len(tg.sentMessages) != 4 ||
+		!strings.HasPrefix(tg.sentMessages[0].Text, "Add a caption") ||
+		tg.sentMessages[1].Text != "File too large: 9 bytes (limit 8)." ||
+		!strings.HasPrefix(tg.sentMessages[2].Text, "File not allowed") ||
+		!strings.HasPrefix(tg.sentMessages[3].Text, "Failed to download file") {
+		t.Fatalf("unexpected rejection replies: %+v", tg.sentMessages)
+	}
+	if len(bodies) != 0 {
+		t.Fatalf("expected no commands for rejected files, got %+v", bodies)
+	}
+
+	tg.requestResult = json.RawMessage(`{"file_id":"f1","file_path":"docs/notes.txt"}`)
+	app.handleAttachment(doc("notes.txt", 5, "/run demo summarise this"), 7)
+	if len(bodies) != 1 {
+		t.Fatalf("expected one run_task command, got %+v", bodies)
+	}
+	payload, _ := bodies[0]["payload"].(map[string]any)
+	attachments, _ := payload["attachments"].([]any)
+	if payload["prompt"] != "summarise this" || len(attachments) != 1 {
+		t.Fatalf("unexpected run_task payload: %+v", payload)
+	}
+	if att, _ := attachments[0].(map[string]any); att["name"] != "notes.txt" || att["content_base64"] != "aGVsbG8=" {
+		t.Fatalf("unexpected attachment: %+v", attachments[0])
+	}
+}
+
+func TestBotHandleProjectListPages(t *testing.T) {
+	var queries []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/projects", func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		page := []projectRecord{}
+		for i := offset; i < 45 && i < offset+limit; i++ {
+			page = append(page, projectRecord{Alias: fmt.Sprintf("p%02d", i), ProjectID: fmt.Sprintf("id%02d", i)})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"projects": page, "total": 45, "offset": offset})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, _ := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	app.handleProjectList(1, "", 7)
+	app.handleProjectList(1, "3", 7)
+	app.handleProjectList(1, "4", 7)
+	app.handleProjectList(1, "x", 7)
+	if len(tg.sentMessages) != 4 {
+		t.Fatalf("expected 4 replies, got %+v", tg.sentMessages)
+	}
+	if !strings.HasSuffix(tg.sentMessages[0].Text, "Showing 1-20 of 45. Next: /project list 2\n") {
+		t.Fatalf("unexpected first page footer: %q", tg.sentMessages[0].Text)
+	}
+	if !strings.HasPrefix(tg.sentMessages[1].Text, "p40 (id40)") || !strings.HasSuffix(tg.sentMessages[1].Text, "Showing 41-45 of 45.\n") {
+		t.Fatalf("unexpected last page: %q", tg.sentMessages[1].Text)
+	}
+	if tg.sentMessages[2].Text != "No projects on page 4; there are 45." || !strings.HasPrefix(tg.sentMessages[3].Text, "Usage: /project list") {
+		t.Fatalf("unexpected replies: %+v", tg.sentMessages[2:])
+	}
+	if len(queries) != 3 || !strings.Contains(queries[1], "offset=40&limit=20") {
+		t.Fatalf("unexpected backend queries: %v", queries)
+	}
+}
+
+// chatActionBot counts chat actions; it is safe for the typing goroutine.
+type chatActionBot struct {
+	recordingTelegramBot
+	mu      sync.Mutex
+	actions int
+}
+
+func (b *chatActionBot) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	if action, ok := c.(tgbotapi.ChatActionConfig); ok && action.Action == tgbotapi.ChatTyping {
+		b.mu.Lock()
+		b.actions++
+		b.mu.Unlock()
+	}
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (b *chatActionBot) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.actions
+}
+
+func TestBotTypingIndicatorUntilProgress(t *testing.T) {
+	app, _, _ := testBotApp(&Config{}, &mockOpencodeClient{})
+	tg := &chatActionBot{}
+	app.tg = tg
+	app.typingInterval = 5 * time.Millisecond
+
+	stop := app.startTyping(1)
+	deadline := time.Now().Add(time.Second)
+	for tg.count() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if tg.count() < 3 {
+		t.Fatalf("expected repeated typing actions, got %d", tg.count())
+	}
+
+	progress := &progressMessage{app: app, chatID: 1, stopTyping: stop}
+	progress.update(&contracts.CommandResult{CommandID: "c1", InProgress: true, Stdout: "first"})
+	time.Sleep(10 * time.Millisecond)
+	stopped := tg.count()
+	time.Sleep(30 * time.Millisecond)
+	if got := tg.count(); got != stopped {
+		t.Fatalf("expected typing to stop after first progress, went from %d to %d", stopped, got)
+	}
+}
+
+func TestBotHandleUnpair(t *testing.T) {
+	paired := map[string]string{"7": "k1", "8": "k2"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/pair/revoke", func(w http.ResponseWriter, r *http.Request) {
+		var req contracts.PairRevokeRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		key, ok := paired[req.TelegramUserID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+key {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"ok":false,"error":{"code":"ERR_AUTH_UNAUTHORIZED","message":"missing bearer token"}}`))
+			return
+		}
+		delete(paired, req.TelegramUserID)
+		_ = json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, st := testBotApp(&Config{AdminIDs: map[int64]bool{7: true}}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	app.httpClient = &http.Client{Timeout: 200 * time.Millisecond}
+	_ = st.SetUserAgentKey(7, "k1")
+	_ = st.SetUserAgentKey(8, "k2")
+	_ = st.SetPairingCode("8", "PAIR-8")
+	paired["9"] = "k3"
+
+	app.handleUnpair(1, "9", 7)
+	app.handleUnpair(1, "7", 8)
+	app.handleUnpair(1, "abc", 8)
+	app.handleUnpair(1, "", 8)
+	app.handleUnpair(1, "8", 7)
+	app.handleUnpair(1, "", 7)
+	want := []string{
+		"Failed to unpair: ERR_AUTH_UNAUTHORIZED: missing bearer token",
+		"Only admins can unpair other users.",
+		"Usage: /unpair [telegram_id]",
+		"Agent for 8 unpaired; its key no longer works. Use /pair to pair again.",
+		"No agent is paired for 8.",
+		"Agent for 7 unpaired; its key no longer works. Use /pair to pair again.",
+	}
+	if len(tg.sentMessages) != len(want) {
+		t.Fatalf("unexpected /unpair replies: %+v", tg.sentMessages)
+	}
+	for i, w := range want {
+		if tg.sentMessages[i].Text != w {
+			t.Fatalf("reply %d: expected %q, got %q", i, w, tg.sentMessages[i].Text)
+		}
+	}
+	if _, ok := st.GetUserAgentKey(8); ok {
+		t.Fatal("expected stored agent key cleared")
+	}
+	if _, ok := st.GetPairingCode("8"); ok {
+		t.Fatal("expected stored pairing code cleared")
+	}
+	if _, ok := st.GetUserAgentKey(7); ok {
+		t.Fatal("expected admin's own key cleared")
+	}
+}
+
+func TestBotRunCapPerUser(t *testing.T) {
+	projects := []projectRecord{{Alias: "demo", ProjectID: "p1", Policy: approvalDecision{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeRunTask}}}}
+	var mu sync.Mutex
+	queued := 0
+	unblock := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/command", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queued++
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	})
+	// Results stay pending until the test unblocks them, keeping runs active.
+	mux.HandleFunc("/v1/result/stream", func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(w, "event: result\ndata: {\"command_id\":%q,\"ok\":true}\n\n", r.URL.Query().Get("command_id"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, st := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	app.listProjectsFn = func(userID int64) ([]projectRecord, error) { return projects, nil }
+	_ = st.SetUserAgentKey(7, "agent-key")
+	_ = st.SetUserAgentKey(8, "agent-key")
+
+	for i := 0; i < DefaultMaxRunsPerUser+1; i++ {
+		app.handleRun(1, fmt.Sprintf("demo task %d", i), 7)
+	}
+	app.handleRun(2, "demo other user", 8)
+
+	mu.Lock()
+	got := queued
+	mu.Unlock()
+	if got != DefaultMaxRunsPerUser+1 {
+		t.Fatalf("expected %d commands queued, got %d", DefaultMaxRunsPerUser+1, got)
+	}
+	refused := 0
+	for _, msg := range tg.messages() {
+		if msg.Text == fmt.Sprintf("Too many concurrent runs (limit %d), wait for one to finish.", DefaultMaxRunsPerUser) {
+			refused++
+			if msg.ChatID != 1 {
+				t.Fatalf("expected only user 7 refused, got %+v", msg)
+			}
+		}
+	}
+	if refused != 1 {
+		t.Fatalf("expected exactly one refusal, got %+v", tg.messages())
+	}
+	if err := app.tryStartRun(3, 7, "ses_direct"); !errors.Is(err, errTooManyRuns) {
+		t.Fatalf("expected a session run to count against the same cap, got %v", err)
+	}
+	if msg := app.runRefusal(errTooManyRuns); !strings.Contains(msg, "Too many concurrent runs (limit") {
+		t.Fatalf("unexpected cap refusal %q", msg)
+	}
+
+	close(unblock)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		app.runMu.Lock()
+		active := app.userRuns[7]
+		app.runMu.Unlock()
+		if active == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected runs released once results were relayed, %d still active", active)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := app.tryStartRun(3, 7, "ses_direct"); err != nil {
+		t.Fatal("expected a run slot after earlier runs finished")
+	}
+	if !app.clearRunBySession("ses_direct") {
+		t.Fatal("expected session run cleared")
+	}
+	app.runMu.Lock()
+	defer app.runMu.Unlock()
+	if app.userRuns[7] != 0 {
+		t.Fatalf("expected clearRunBySession to release the slot, got %d", app.userRuns[7])
+	}
+}
+
+func TestBotHandleRunBatch(t *testing.T) {
+	projects := []projectRecord{{Alias: "demo", ProjectID: "p1", Policy: approvalDecision{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeRunTask}}}}
+	var payloads []map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/command", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Payload map[string]any `json:"payload"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		payloads = append(payloads, body.Payload)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/v1/result/status", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, st := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	app.httpClient = &http.Client{Timeout: 200 * time.Millisecond}
+	app.listProjectsFn = func(userID int64) ([]projectRecord, error) { return projects, nil }
+	_ = st.SetUserAgentKey(7, "agent-key")
+
+	app.handleRunBatch(1, "demo", 7)
+	app.handleRunBatch(1, "demo fix the tests\nthen lint\n\n  \n\nwrite a summary", 7)
+	app.handleRunBatch(1, "demo just one", 7)
+	app.handleRunBatch(1, "demo "+strings.Repeat("p\n\n", contracts.MaxBatchPrompts+1), 7)
+
+	if len(tg.sentMessages) != 4 ||
+		tg.sentMessages[0].Text != runBatchUsage ||
+		!strings.HasPrefix(tg.sentMessages[1].Text, "run_task with 2 prompts queued for demo.") ||
+		!strings.HasPrefix(tg.sentMessages[2].Text, "run_task queued for demo.") ||
+		tg.sentMessages[3].Text != fmt.Sprintf("At most %d prompts per batch.", contracts.MaxBatchPrompts) {
+		t.Fatalf("unexpected /runbatch replies: %+v", tg.sentMessages)
+	}
+	if len(payloads) != 2 {
+		t.Fatalf("expected two queued commands, got %+v", payloads)
+	}
+	prompts, _ := payloads[0]["prompts"].([]any)
+	if _, hasPrompt := payloads[0]["prompt"]; hasPrompt || len(prompts) != 2 || prompts[0] != "fix the tests\nthen lint" || prompts[1] != "write a summary" {
+		t.Fatalf("expected prompts split on blank lines, got %+v", payloads[0])
+	}
+	if payloads[1]["prompt"] != "just one" || payloads[1]["prompts"] != nil {
+		t.Fatalf("expected a single prompt to stay a plain run_task, got %+v", payloads[1])
+	}
+}
+
+func TestBotPollRelaysOnlyTerminalResult(t *testing.T) {
+	var mu sync.Mutex
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/result/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/v1/result/status", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		polls++
+		n := polls
+		mu.Unlock()
+		switch {
+		case n == 1:
+			_ = json.NewEncoder(w).Encode(contracts.CommandResult{CommandID: "c1", InProgress: true, Summary: "step 1"})
+		case n == 2:
+			_ = json.NewEncoder(w).Encode(contracts.CommandResult{CommandID: "c1", InProgress: true, Summary: "step 2"})
+		default:
+			_ = json.NewEncoder(w).Encode(contracts.CommandResult{CommandID: "c1", OK: true, Summary: "done"})
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, _ := testBotApp(&Config{ResultPollTimeout: 5 * time.Second, ResultPollInterval: 10 * time.Millisecond}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	done := make(chan struct{})
+	app.relayResultAsync(1, 7, "c1", nil, func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("relay did not finish")
+	}
+
+	if len(tg.sentMessages) != 2 || tg.sentMessages[0].Text != "In progress: step 1" || tg.sentMessages[1].Text != "Result: done" {
+		t.Fatalf("expected one progress message then the final result, got %+v", tg.sentMessages)
+	}
+	if len(tg.requests) != 1 {
+		t.Fatalf("expected the progress message edited once, got %+v", tg.requests)
+	}
+	if edit, ok := tg.requests[0].(tgbotapi.EditMessageTextConfig); !ok || edit.Text != "In progress: step 2" {
+		t.Fatalf("expected progress edit to step 2, got %+v", tg.requests[0])
+	}
+}
+
+func TestBotHandleNewRun(t *testing.T) {
+	t.Run("direct", func(t *testing.T) {
+		prompted := make(chan PromptOptions, 1)
+		oc := &mockOpencodeClient{
+			createSession: func(title string) (map[string]any, error) {
+				if !strings.HasPrefix(title, "oct_") {
+					t.Errorf("expected prefixed title, got %q", title)
+				}
+				return map[string]any{"id": "ses_new"}, nil
+			},
+			promptWithOptions: func(sid, prompt string, opts PromptOptions) (map[string]any, error) {
+				if sid != "ses_new" || prompt != "fix the build" {
+					t.Errorf("unexpected prompt %q to %q", prompt, sid)
+				}
+				prompted <- opts
+				return map[string]any{}, nil
+			},
+		}
+		app, tg, st := testBotApp(&Config{SessionPrefix: "oct_"}, oc)
+		_ = st.SetUserModel(7, "anthropic/claude-sonnet")
+
+		app.handleNewRun(1, "  ", 7)
+		app.handleNewRun(1, "fix the build", 7)
+		select {
+		case opts := <-prompted:
+			if opts.ProviderID != "anthropic" || opts.Model != "claude-sonnet" {
+				t.Fatalf("expected split model override, got %+v", opts)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected the prompt to be sent to the new session")
+		}
+		if len(tg.sentMessages) != 3 ||
+			tg.sentMessages[0].Text != "Usage: /new <prompt>" ||
+			!strings.HasPrefix(tg.sentMessages[1].Text, "Created session: ses_new - oct_") ||
+			tg.sentMessages[2].Text != "Running..." {
+			t.Fatalf("unexpected /new replies: %+v", tg.sentMessages)
+		}
+		if sid, ok := st.GetUserSession(7); !ok || sid != "ses_new" {
+			t.Fatalf("expected new session selected, got %q", sid)
+		}
+		if chatID, msgID, ok := st.GetSession("ses_new"); !ok || chatID != 1 || msgID == 0 {
+			t.Fatalf("expected session mapped to the placeholder, got %d/%d", chatID, msgID)
+		}
+		if !app.clearRunBySession("ses_new") {
+			t.Fatal("expected the new session to own the active run")
+		}
+	})
+
+	t.Run("backend", func(t *testing.T) {
+		var mu sync.Mutex
+		var payloads []map[string]any
+		mux := http.NewServeMux()
+		mux.HandleFunc("/v1/command", func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]any
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if p, ok := body["payload"].(map[string]any); ok {
+				mu.Lock()
+				payloads = append(payloads, p)
+				mu.Unlock()
+			}
+			w.WriteHeader(http.StatusAccepted)
+		})
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		created := 0
+		oc := &mockOpencodeClient{
+			createSession: func(title string) (map[string]any, error) {
+				created++
+				return map[string]any{"id": "ses_paired"}, nil
+			},
+		}
+		app, tg, st := testBotApp(&Config{SessionPrefix: "oct_"}, oc)
+		app.backendURL = srv.URL
+		app.listProjectsFn = func(userID int64) ([]projectRecord, error) {
+			return []projectRecord{{Alias: "demo", ProjectID: "p1", Policy: approvalDecision{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeRunTask}}}}, nil
+		}
+		_ = st.SetUserAgentKey(7, "k1")
+
+		app.handleNewRun(1, "demo", 7)
+		if created != 0 || len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != "Usage: /new <project> <prompt>" {
+			t.Fatalf("expected usage without creating a session, got %d created, %+v", created, tg.sentMessages)
+		}
+		app.handleNewRun(1, "demo fix the build", 7)
+		if _, ok := st.GetUserSession(7); ok || created != 0 {
+			t.Fatalf("expected no session on the bot's Opencode, got %d created", created)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if len(payloads) != 1 || payloads[0]["project_id"] != "p1" || payloads[0]["prompt"] != "fix the build" {
+			t.Fatalf("expected one run_task for the prompt, got %+v", payloads)
+		}
+		if title, _ := payloads[0]["title"].(string); !strings.HasPrefix(title, "oct_") {
+			t.Fatalf("expected the session title passed to run_task, got %+v", payloads[0])
+		}
+	})
+}
+
+func TestBotRejectsPromptOverMaxChars(t *testing.T) {
+	var mu sync.Mutex
+	var payloads []map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/command", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if p, ok := body["payload"].(map[string]any); ok {
+			mu.Lock()
+			payloads = append(payloads, p)
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, st := testBotApp(&Config{MaxPromptChars: 5}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	app.listProjectsFn = func(userID int64) ([]projectRecord, error) {
+		return []projectRecord{{Alias: "demo", ProjectID: "p1", Policy: approvalDecision{Decision: contracts.DecisionAllow, Scope: []string{contracts.ScopeRunTask}}}}, nil
+	}
+
+	// unpaired /new is rejected before a session is created
+	app.handleNewRun(1, "ééééé!", 7)
+	_ = st.SetUserAgentKey(7, "k1")
+	app.handleRun(1, "demo ééééé!", 7)
+	if len(tg.sentMessages) != 2 ||
+		tg.sentMessages[0].Text != "Prompt too long (6/5 chars), shorten it and try again." ||
+		tg.sentMessages[1].Text != tg.sentMessages[0].Text {
+		t.Fatalf("expected over-limit prompts rejected, got %+v", tg.sentMessages)
+	}
+
+	// five two-byte runes are exactly at the limit
+	app.handleRun(1, "demo ééééé", 7)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(payloads) != 1 || payloads[0]["prompt"] != "ééééé" {
+		t.Fatalf("expected the at-limit prompt queued, got %+v", payloads)
+	}
+
+	// CJK text within the character limit can still exceed the byte limit
+	cjk, cjkTG, cjkStore := testBotApp(&Config{}, &mockOpencodeClient{})
+	cjk.backendURL = srv.URL
+	cjk.listProjectsFn = app.listProjectsFn
+	_ = cjkStore.SetUserAgentKey(7, "k1")
+	prompt := strings.Repeat("漢", contracts.MaxPromptBytes/3+1)
+	cjk.handleRun(1, "demo "+prompt, 7)
+	want := fmt.Sprintf("Prompt too long (%d/%d bytes), shorten it and try again.", len(prompt), contracts.MaxPromptBytes)
+	if len(cjkTG.sentMessages) != 1 || cjkTG.sentMessages[0].Text != want || len(payloads) != 1 {
+		t.Fatalf("expected the CJK prompt refused by size, got %+v", cjkTG.sentMessages)
+	}
+}
+
+func TestBotHandlePing(t *testing.T) {
+	online := true
+	var queued []string
+	unblock := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/agent/status", func(w http.ResponseWriter, r *http.Request) {
+		seen := time.Now()
+		_ = json.NewEncoder(w).Encode(contracts.AgentStatusResponse{Online: online, LastSeen: &seen})
+	})
+	mux.HandleFunc("/v1/command", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Type string `json:"type"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		queued = append(queued, body.Type)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	// The result is relayed by the test itself; the stream stays open.
+	mux.HandleFunc("/v1/result/stream", func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	defer close(unblock)
+
+	app, tg, st := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	_ = st.SetUserAgentKey(7, "agent-key")
+
+	app.handlePing(1, 7)
+	if len(queued) != 1 || queued[0] != contracts.CommandTypeStatus || len(tg.sentMessages) != 0 {
+		t.Fatalf("expected one silent status command, got queued=%v sent=%+v", queued, tg.sentMessages)
+	}
+	rec, ok := app.getLastCommand(7, contracts.CommandTypeStatus, "")
+	if !ok || !rec.Ping {
+		t.Fatalf("expected ping command recorded, got %+v ok=%v", rec, ok)
+	}
+	app.relayCommandResult(1, 7, rec.CommandID, &contracts.CommandResult{CommandID: rec.CommandID, OK: true, Summary: "agent healthy", Meta: map[string]any{"agent_id": "agent-1"}})
+	if len(tg.sentMessages) != 1 || !regexp.MustCompile(`^pong in \d+\.\ds via agent agent-1$`).MatchString(tg.sentMessages[0].Text) {
+		t.Fatalf("expected pong latency message, got %+v", tg.sentMessages)
+	}
+	if got := formatPong(1200*time.Millisecond, &contracts.CommandResult{Meta: map[string]any{"agent_id": "X"}}); got != "pong in 1.2s via agent X" {
+		t.Fatalf("unexpected pong text %q", got)
+	}
+
+	online = false
+	tg.sentMessages = nil
+	app.handlePing(1, 7)
+	if len(queued) != 1 || len(tg.sentMessages) != 1 || !strings.HasPrefix(tg.sentMessages[0].Text, "Agent offline") {
+		t.Fatalf("expected offline agent reported without queuing, got queued=%v sent=%+v", queued, tg.sentMessages)
+	}
+}
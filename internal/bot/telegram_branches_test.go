@@ -142,7 +142,21 @@ func TestBotPollAndRelayErrorResultBranch(t *testing.T) {
 
 	app.pollAndRelayResult(42, 7, "c1")
 	time.Sleep(250 * time.Millisecond)
-	if len(tg.sentMessages) == 0 || !strings.Contains(tg.sentMessages[len(tg.sentMessages)-1].Text, "Result error") {
-		t.Fatalf("expected error result relay message, got %+v", tg.sentMessages)
+	if sent := tg.messages(); len(sent) == 0 || !strings.Contains(sent[len(sent)-1].Text, "Result error") {
+		t.Fatalf("expected error result relay message, got %+v", sent)
+	}
+}
+
+func TestBackendErrorText(t *testing.T) {
+	cases := map[string]string{
+		`{"ok":false,"error":{"code":"ERR_VALIDATION_REQUIRED_FIELD","message":"prompt is required","field":"prompt"}}`: "ERR_VALIDATION_REQUIRED_FIELD: prompt is required (field: prompt)",
+		`{"ok":false,"error":{"code":"ERR_AUTH_UNAUTHORIZED","message":"agent not paired"}}`:                            "ERR_AUTH_UNAUTHORIZED: agent not paired",
+		"upstream timeout\n": "upstream timeout",
+		"":                   "no error details",
+	}
+	for body, want := range cases {
+		if got := backendErrorText(strings.NewReader(body)); got != want {
+			t.Fatalf("backendErrorText(%q) = %q, want %q", body, got, want)
+		}
 	}
 }
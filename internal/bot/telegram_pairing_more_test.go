@@ -102,3 +102,104 @@ func TestBotHandleMySessionSelectedPath(t *testing.T) {
 
 	app.handleCallbackQuery(&tgbotapi.CallbackQuery{ID: "cb", Data: "approve:deny|demo", Message: nil, From: &tgbotapi.User{ID: 7}})
 }
+
+func TestBotHandleRepair(t *testing.T) {
+	status := http.StatusOK
+	mux := http.NewServeMux()
+	var auth string
+	mux.HandleFunc("/v1/pair/rotate", func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			_, _ = w.Write([]byte(`{"ok":false,"error":{"code":"ERR_VALIDATION_REQUIRED_FIELD","message":"telegram_user_id is required"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"pairing_code":"PAIR-2","expires_at":"soon"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, st := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+	_ = st.SetPairingCode("7", "PAIR-1")
+
+	app.handleRepair(1, 7)
+	if len(tg.sentMessages) != 1 || !strings.HasPrefix(tg.sentMessages[0].Text, "New pairing code issued; earlier codes no longer work.") || !strings.Contains(tg.sentMessages[0].Text, "oct-agent pair PAIR-2") {
+		t.Fatalf("expected new pairing code message, got %+v", tg.sentMessages)
+	}
+	if code, _ := st.GetPairingCode("7"); code != "PAIR-2" {
+		t.Fatalf("expected stored code replaced, got %q", code)
+	}
+	if auth != "" {
+		t.Fatalf("expected no key sent before pairing, got %q", auth)
+	}
+	_ = st.SetUserAgentKey(7, "k7")
+	app.handleRepair(1, 7)
+	if auth != "Bearer k7" {
+		t.Fatalf("expected the stored agent key sent, got %q", auth)
+	}
+
+	status = http.StatusBadRequest
+	app.handleRepair(1, 7)
+	if !strings.Contains(tg.sentMessages[len(tg.sentMessages)-1].Text, "Pairing failed") {
+		t.Fatalf("expected failure message, got %+v", tg.sentMessages)
+	}
+}
+
+func TestBotHandlePairStartAndStatus(t *testing.T) {
+	claims := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/pair/start", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"pairing_code":"PAIR-9","expires_at":"2026-10-15T12:10:00Z"}`))
+	})
+	mux.HandleFunc("/v1/pair/claim", func(w http.ResponseWriter, r *http.Request) {
+		claims++
+		_, _ = w.Write([]byte(`{"agent_id":"a1","agent_key":"k9"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, tg, st := testBotApp(&Config{}, &mockOpencodeClient{})
+	app.backendURL = srv.URL
+
+	app.handlePair(1, "status", 7)
+	if len(tg.sentMessages) != 1 || !strings.HasPrefix(tg.sentMessages[0].Text, "Not paired and no pairing in progress.") {
+		t.Fatalf("expected no-pairing status, got %+v", tg.sentMessages)
+	}
+
+	tg.sentMessages = nil
+	app.handlePair(1, "", 7)
+	if len(tg.sentMessages) != 1 {
+		t.Fatalf("expected one pairing message, got %+v", tg.sentMessages)
+	}
+	text := tg.sentMessages[0].Text
+	for _, want := range []string{"Pairing Code: `PAIR-9`", "Expires at: 2026-10-15T12:10:00Z", "`oct-agent pair PAIR-9`", "/pair status"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in pairing message, got %q", want, text)
+		}
+	}
+	if claims != 0 {
+		t.Fatal("expected /pair not to claim the code")
+	}
+
+	tg.sentMessages = nil
+	app.handlePair(1, "status", 7)
+	if claims != 1 || len(tg.sentMessages) != 1 || !strings.Contains(tg.sentMessages[0].Text, "Pairing completed") {
+		t.Fatalf("expected pending code claimed, got claims=%d %+v", claims, tg.sentMessages)
+	}
+	if key, _ := st.GetUserAgentKey(7); key != "k9" {
+		t.Fatalf("expected agent key stored, got %q", key)
+	}
+
+	tg.sentMessages = nil
+	app.handlePair(1, "status", 7)
+	if claims != 1 || len(tg.sentMessages) != 1 || !strings.HasPrefix(tg.sentMessages[0].Text, "Paired:") {
+		t.Fatalf("expected paired status without another claim, got claims=%d %+v", claims, tg.sentMessages)
+	}
+
+	tg.sentMessages = nil
+	app.handlePair(1, "bogus", 7)
+	if len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != "Usage: /pair [status]" {
+		t.Fatalf("expected usage, got %+v", tg.sentMessages)
+	}
+}
@@ -1,9 +1,11 @@
 package bot
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -52,9 +54,30 @@ func TestBotStartPolling_CommandRoutingCoverage(t *testing.T) {
 	updates <- tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1}, From: &tgbotapi.User{ID: 1}, Text: "/pair", Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: 5}}}}
 	updates <- tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1}, From: &tgbotapi.User{ID: 1}, Text: "/agent_status", Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: 13}}}}
 	updates <- tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1}, From: &tgbotapi.User{ID: 1}, Text: "/nope", Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: 5}}}}
+	updates <- tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1}, From: &tgbotapi.User{ID: 1}, Photo: []tgbotapi.PhotoSize{{FileID: "ph1"}}}}
 	close(updates)
 
 	if err := app.StartPolling(); err != nil {
 		t.Fatalf("start polling: %v", err)
 	}
 }
+
+func TestBotStartPollingContext_StopsOnCancel(t *testing.T) {
+	app, tg, _ := testBotApp(&Config{}, &mockOpencodeClient{})
+	tg.updates = make(chan tgbotapi.Update)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- app.StartPollingContext(ctx) }()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected clean stop, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("polling did not stop after cancel")
+	}
+	if app.requestContext() != ctx {
+		t.Fatal("expected handlers to use the polling context")
+	}
+}
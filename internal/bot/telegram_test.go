@@ -1,10 +1,12 @@
 package bot
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,15 +15,23 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+// recordingTelegramBot records what the bot sends. Runs relay results and
+// send typing actions from their own goroutines, so it is locked; tests that
+// read while those may still be sending use messages().
 type recordingTelegramBot struct {
+	mu           sync.Mutex
 	updates      tgbotapi.UpdatesChannel
 	sentMessages []tgbotapi.MessageConfig
 	requests     []tgbotapi.Chattable
 	nextMsgID    int
 	requestErrs  []error
+	// requestResult is returned as the Result of successful requests.
+	requestResult json.RawMessage
 }
 
 func (m *recordingTelegramBot) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if msg, ok := c.(tgbotapi.MessageConfig); ok {
 		m.sentMessages = append(m.sentMessages, msg)
 	}
@@ -30,6 +40,8 @@ func (m *recordingTelegramBot) Send(c tgbotapi.Chattable) (tgbotapi.Message, err
 }
 
 func (m *recordingTelegramBot) GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.updates == nil {
 		m.updates = make(chan tgbotapi.Update)
 	}
@@ -37,6 +49,8 @@ func (m *recordingTelegramBot) GetUpdatesChan(config tgbotapi.UpdateConfig) tgbo
 }
 
 func (m *recordingTelegramBot) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.requests = append(m.requests, c)
 	if len(m.requestErrs) > 0 {
 		err := m.requestErrs[0]
@@ -45,7 +59,14 @@ func (m *recordingTelegramBot) Request(c tgbotapi.Chattable) (*tgbotapi.APIRespo
 			return nil, err
 		}
 	}
-	return &tgbotapi.APIResponse{}, nil
+	return &tgbotapi.APIResponse{Ok: true, Result: m.requestResult}, nil
+}
+
+// messages returns a copy of the messages sent so far.
+func (m *recordingTelegramBot) messages() []tgbotapi.MessageConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]tgbotapi.MessageConfig(nil), m.sentMessages...)
 }
 
 func testBotApp(cfg *Config, oc OpencodeClientInterface) (*BotApp, *recordingTelegramBot, *store.MemoryStore) {
@@ -67,7 +88,7 @@ func testBotApp(cfg *Config, oc OpencodeClientInterface) (*BotApp, *recordingTel
 	return app, tg, st
 }
 
-func withMockTelegramFactory(t *testing.T, factory func(token string) (TelegramBotInterface, error)) {
+func withMockTelegramFactory(t *testing.T, factory func(token, apiURL string) (TelegramBotInterface, error)) {
 	t.Helper()
 	original := newTelegramBot
 	newTelegramBot = factory
@@ -77,7 +98,7 @@ func withMockTelegramFactory(t *testing.T, factory func(token string) (TelegramB
 }
 
 func TestNewBotApp(t *testing.T) {
-	withMockTelegramFactory(t, func(token string) (TelegramBotInterface, error) {
+	withMockTelegramFactory(t, func(token, apiURL string) (TelegramBotInterface, error) {
 		return &recordingTelegramBot{}, nil
 	})
 
@@ -113,8 +134,51 @@ func TestNewBotApp(t *testing.T) {
 		}
 	})
 
+	t.Run("matches mixed-case prefix and keeps configured casing", func(t *testing.T) {
+		var created string
+		oc := &mockOpencodeClient{
+			listSessions: func() ([]map[string]any, error) {
+				return []map[string]any{{"id": "ses_upper", "title": "OCT_existing"}}, nil
+			},
+			createSession: func(title string) (map[string]any, error) {
+				created = title
+				return map[string]any{"id": "ses_created"}, nil
+			},
+		}
+		app, err := NewBotApp(&Config{TelegramToken: "token", SessionPrefix: "oct_", CaseInsensitivePrefix: true}, oc, st)
+		if err != nil || app.octSessionID != "ses_upper" {
+			t.Fatalf("expected mixed-case session reused, got %q err=%v", app.octSessionID, err)
+		}
+
+		oc.listSessions = func() ([]map[string]any, error) { return nil, nil }
+		if _, err := NewBotApp(&Config{TelegramToken: "token", SessionPrefix: "Oct_", CaseInsensitivePrefix: true}, oc, st); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.HasPrefix(created, "Oct_") {
+			t.Fatalf("expected created title to keep configured casing, got %q", created)
+		}
+	})
+
+	t.Run("requires https for remote URLs", func(t *testing.T) {
+		oc := &mockOpencodeClient{listSessions: func() ([]map[string]any, error) {
+			return []map[string]any{{"id": "ses_existing", "title": "oct_existing"}}, nil
+		}}
+		remote := &Config{TelegramToken: "token", SessionPrefix: "oct_", RequireHTTPS: true, OpencodeBase: "http://opencode.example.com", BackendURL: "https://backend.example.com"}
+		if _, err := NewBotApp(remote, oc, st); err == nil || !strings.Contains(err.Error(), "OPENCODE_BASE_URL") {
+			t.Fatalf("expected plaintext opencode URL rejected, got %v", err)
+		}
+		remote = &Config{TelegramToken: "token", RequireHTTPS: true, OpencodeBase: "https://opencode.example.com", BackendURL: "http://10.0.0.5:8080"}
+		if _, err := NewBotApp(remote, oc, st); err == nil || !strings.Contains(err.Error(), "OCT_BACKEND_URL") {
+			t.Fatalf("expected plaintext backend URL rejected, got %v", err)
+		}
+		local := &Config{TelegramToken: "token", SessionPrefix: "oct_", RequireHTTPS: true, OpencodeBase: "http://localhost:4096", BackendURL: "http://127.0.0.1:8080"}
+		if _, err := NewBotApp(local, oc, st); err != nil {
+			t.Fatalf("expected localhost URLs exempt, got %v", err)
+		}
+	})
+
 	t.Run("fails when bot init fails", func(t *testing.T) {
-		withMockTelegramFactory(t, func(token string) (TelegramBotInterface, error) {
+		withMockTelegramFactory(t, func(token, apiURL string) (TelegramBotInterface, error) {
 			return nil, fmt.Errorf("bad token")
 		})
 		oc := &mockOpencodeClient{listSessions: func() ([]map[string]any, error) { return nil, nil }}
@@ -144,6 +208,39 @@ func TestNewBotApp(t *testing.T) {
 	})
 }
 
+func TestNewBotAppUsesTelegramAPIEndpoint(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"id":42,"is_bot":true,"first_name":"stub","username":"stub_bot"}}`))
+	}))
+	defer srv.Close()
+
+	oc := &mockOpencodeClient{listSessions: func() ([]map[string]any, error) {
+		return []map[string]any{{"id": "ses_existing", "title": "oct_existing"}}, nil
+	}}
+	cfg := &Config{TelegramToken: "token", TelegramAPIEndpoint: srv.URL + "/", SessionPrefix: "oct_"}
+	app, err := NewBotApp(cfg, oc, store.NewMemoryStore())
+	if err != nil {
+		t.Fatalf("expected bot to start against the stub API, got %v", err)
+	}
+	api, ok := app.tg.(*tgbotapi.BotAPI)
+	if !ok || api.Self.UserName != "stub_bot" {
+		t.Fatalf("expected bot identity from the stub, got %+v", app.tg)
+	}
+	if len(paths) != 1 || paths[0] != "/bottoken/getMe" {
+		t.Fatalf("expected getMe on the stub endpoint, got %v", paths)
+	}
+	if got := fmt.Sprintf(app.fileEndpoint, "token", "photos/a.jpg"); got != srv.URL+"/file/bottoken/photos/a.jpg" {
+		t.Fatalf("expected file downloads from the stub endpoint, got %q", got)
+	}
+
+	cfg = &Config{TelegramToken: "token", TelegramAPIEndpoint: "http://botapi.example.com", RequireHTTPS: true, OpencodeBase: "http://localhost:4096", BackendURL: "http://localhost:8080"}
+	if _, err := NewBotApp(cfg, oc, store.NewMemoryStore()); err == nil || !strings.Contains(err.Error(), "TELEGRAM_API_ENDPOINT") {
+		t.Fatalf("expected plaintext Bot API endpoint rejected, got %v", err)
+	}
+}
+
 func TestBotApp_AccessChecks(t *testing.T) {
 	app, _, _ := testBotApp(&Config{AllowedIDs: map[int64]bool{1: true}, AdminIDs: map[int64]bool{9: true}}, &mockOpencodeClient{})
 
@@ -182,7 +279,7 @@ func TestBotApp_HandleSessions(t *testing.T) {
 	t.Run("error path", func(t *testing.T) {
 		oc := &mockOpencodeClient{listSessions: func() ([]map[string]any, error) { return nil, fmt.Errorf("boom") }}
 		app, tg, _ := testBotApp(&Config{SessionPrefix: "oct_"}, oc)
-		app.handleSessions(1)
+		app.handleSessions(1, 1)
 
 		if len(tg.sentMessages) != 1 || !strings.Contains(tg.sentMessages[0].Text, "Error listing sessions") {
 			t.Fatalf("expected error message, got %+v", tg.sentMessages)
@@ -192,7 +289,7 @@ func TestBotApp_HandleSessions(t *testing.T) {
 	t.Run("no sessions", func(t *testing.T) {
 		oc := &mockOpencodeClient{listSessions: func() ([]map[string]any, error) { return []map[string]any{}, nil }}
 		app, tg, _ := testBotApp(&Config{SessionPrefix: "oct_"}, oc)
-		app.handleSessions(1)
+		app.handleSessions(1, 1)
 
 		if len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != "No sessions" {
 			t.Fatalf("expected no sessions message, got %+v", tg.sentMessages)
@@ -204,7 +301,7 @@ func TestBotApp_HandleSessions(t *testing.T) {
 			return []map[string]any{{"id": "ses_1", "title": "oct_alpha"}, {"id": "ses_2", "title": "other"}}, nil
 		}}
 		app, tg, _ := testBotApp(&Config{SessionPrefix: "oct_"}, oc)
-		app.handleSessions(1)
+		app.handleSessions(1, 1)
 
 		if len(tg.sentMessages) != 1 {
 			t.Fatalf("expected one message, got %d", len(tg.sentMessages))
@@ -213,6 +310,40 @@ func TestBotApp_HandleSessions(t *testing.T) {
 			t.Fatalf("did not expect non-prefixed session in output: %q", tg.sentMessages[0].Text)
 		}
 	})
+
+	t.Run("case-insensitive prefix", func(t *testing.T) {
+		oc := &mockOpencodeClient{listSessions: func() ([]map[string]any, error) {
+			return []map[string]any{{"id": "ses_1", "title": "Oct_Alpha"}, {"id": "ses_2", "title": "OCT_beta"}, {"id": "ses_3", "title": "other"}}, nil
+		}}
+		app, tg, _ := testBotApp(&Config{SessionPrefix: "oct_", CaseInsensitivePrefix: true}, oc)
+		app.handleSessions(1, 1)
+
+		if len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != "ses_1 - Oct_Alpha\nses_2 - OCT_beta\n" {
+			t.Fatalf("expected mixed-case titles listed, got %+v", tg.sentMessages)
+		}
+
+		app, tg, _ = testBotApp(&Config{SessionPrefix: "oct_"}, oc)
+		app.handleSessions(1, 1)
+		if len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != "" {
+			t.Fatalf("expected case-sensitive match to hide mixed-case titles, got %+v", tg.sentMessages)
+		}
+	})
+
+	t.Run("recent sessions listed first", func(t *testing.T) {
+		oc := &mockOpencodeClient{listSessions: func() ([]map[string]any, error) {
+			return []map[string]any{{"id": "ses_1", "title": "oct_alpha"}, {"id": "ses_2", "title": "oct_beta"}}, nil
+		}}
+		app, tg, st := testBotApp(&Config{SessionPrefix: "oct_"}, oc)
+		_ = st.SetUserSession(7, "ses_gone")
+		_ = st.SetUserSession(7, "ses_2")
+		_ = st.SetUserSession(7, "ses_1")
+		app.handleSessions(1, 7)
+
+		want := "Your recent sessions:\nses_1 - oct_alpha (selected)\nses_2 - oct_beta\n\nAll sessions:\nses_1 - oct_alpha\nses_2 - oct_beta\n"
+		if len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != want {
+			t.Fatalf("unexpected sessions output: %+v", tg.sentMessages)
+		}
+	})
 }
 
 func TestBotApp_HandleCreateSession(t *testing.T) {
@@ -256,6 +387,92 @@ func TestBotApp_HandleDeleteSession(t *testing.T) {
 			t.Fatalf("expected failure message, got %+v", tg.sentMessages)
 		}
 	})
+
+	t.Run("already gone", func(t *testing.T) {
+		oc := &mockOpencodeClient{deleteSession: func(string) error {
+			return &OpencodeError{StatusCode: http.StatusNotFound, Body: "not found"}
+		}}
+		app, tg, st := testBotApp(&Config{AdminIDs: map[int64]bool{1: true}}, oc)
+		_ = st.SetUserSession(1, "ses_x")
+		app.handleDeleteSession(1, "ses_x", 1)
+		if len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != "Session already gone: ses_x" {
+			t.Fatalf("expected already-gone message, got %+v", tg.sentMessages)
+		}
+		if sid, ok := st.GetUserSession(1); ok {
+			t.Fatalf("expected stale session mapping removed, got %q", sid)
+		}
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		oc := &mockOpencodeClient{deleteSession: func(string) error {
+			return &OpencodeError{StatusCode: http.StatusInternalServerError, Body: "boom"}
+		}}
+		app, tg, _ := testBotApp(&Config{AdminIDs: map[int64]bool{1: true}}, oc)
+		app.handleDeleteSession(1, "ses_x", 1)
+		if len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != "Failed to delete session: Opencode server error (500)" {
+			t.Fatalf("expected server error message, got %+v", tg.sentMessages)
+		}
+	})
+}
+
+// failingSendBot fails every Send to failChat.
+type failingSendBot struct {
+	*recordingTelegramBot
+	failChat int64
+}
+
+func (b *failingSendBot) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if msg, ok := c.(tgbotapi.MessageConfig); ok && msg.ChatID == b.failChat {
+		return tgbotapi.Message{}, fmt.Errorf("Forbidden: bot was blocked by the user")
+	}
+	return b.recordingTelegramBot.Send(c)
+}
+
+func TestBotApp_HandleBroadcast(t *testing.T) {
+	t.Run("admin only and usage", func(t *testing.T) {
+		app, tg, st := testBotApp(&Config{AdminIDs: map[int64]bool{9: true}}, &mockOpencodeClient{})
+		_ = st.SetUserAgentKey(1, "key-1")
+		app.handleBroadcast(100, "maintenance", 1)
+		app.handleBroadcast(109, "  ", 9)
+		if len(tg.sentMessages) != 2 ||
+			tg.sentMessages[0].Text != "Only admins can broadcast." ||
+			tg.sentMessages[1].Text != "Usage: /broadcast <message>" {
+			t.Fatalf("unexpected replies: %+v", tg.sentMessages)
+		}
+	})
+
+	t.Run("one message per known user", func(t *testing.T) {
+		app, tg, st := testBotApp(&Config{AdminIDs: map[int64]bool{9: true}}, &mockOpencodeClient{})
+		bot := &failingSendBot{recordingTelegramBot: tg, failChat: 4}
+		app.tg = bot
+		var pauses []time.Duration
+		app.sleep = func(d time.Duration) { pauses = append(pauses, d) }
+		_ = st.SetUserSession(1, "ses_1")
+		_ = st.SetUserAgentKey(2, "key-2")
+		_ = st.SetUserSession(3, "ses_3")
+		_ = st.SetUserAgentKey(3, "key-3")
+		_ = st.SetUserAgentKey(4, "key-4")
+
+		users, _ := st.KnownUsers()
+		app.broadcast(109, users, "backend restarting in 5 min")
+
+		perUser := map[int64]int{}
+		for _, msg := range tg.sentMessages[:len(tg.sentMessages)-1] {
+			if msg.Text != "backend restarting in 5 min" {
+				t.Fatalf("unexpected broadcast text: %+v", msg)
+			}
+			perUser[msg.ChatID]++
+		}
+		if len(perUser) != 3 || perUser[1] != 1 || perUser[2] != 1 || perUser[3] != 1 {
+			t.Fatalf("expected one message each for users 1-3, got %v", perUser)
+		}
+		if last := tg.sentMessages[len(tg.sentMessages)-1]; last.ChatID != 109 || last.Text != "Broadcast sent to 3 users, 1 failed." {
+			t.Fatalf("unexpected summary: %+v", last)
+		}
+		if len(pauses) != 3 || pauses[0] != broadcastInterval {
+			t.Fatalf("expected a pause between each send, got %v", pauses)
+		}
+	})
 }
 
 func TestBotApp_HandleSelectSession(t *testing.T) {
@@ -354,6 +571,17 @@ func TestBotApp_HandleMySessionAndAbort(t *testing.T) {
 			t.Fatalf("expected abort failure message, got %q", tg.sentMessages[2].Text)
 		}
 	})
+
+	t.Run("abort already gone", func(t *testing.T) {
+		oc := &mockOpencodeClient{abortSession: func(string) error {
+			return &OpencodeError{StatusCode: http.StatusNotFound, Body: "not found"}
+		}}
+		app, tg, _ := testBotApp(&Config{AdminIDs: map[int64]bool{7: true}}, oc)
+		app.handleAbort(1, "ses_1", 7)
+		if len(tg.sentMessages) != 1 || tg.sentMessages[0].Text != "Session already gone: ses_1" {
+			t.Fatalf("expected already-gone message, got %+v", tg.sentMessages)
+		}
+	})
 }
 
 func TestBotApp_HandleRun(t *testing.T) {
@@ -499,3 +727,88 @@ func TestBotApp_HandleCallbackQuery_UnknownActionFallbackRemains(t *testing.T) {
 		t.Fatalf("expected unknown-action fallback message, got %+v", tg.sentMessages)
 	}
 }
+
+func TestBotApp_HandleHelpAndStart(t *testing.T) {
+	app, tg, _ := testBotApp(&Config{}, &mockOpencodeClient{})
+
+	app.handleHelp(1)
+	app.handleStart(1)
+
+	if len(tg.sentMessages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(tg.sentMessages))
+	}
+	help := tg.sentMessages[0].Text
+	for _, c := range botCommands {
+		if !strings.Contains(help, c.Usage) {
+			t.Fatalf("expected help to list %q, got %q", c.Usage, help)
+		}
+	}
+	if !strings.Contains(help, "/abort [project] <session_id> - abort a running session (project required once paired) [admin]") {
+		t.Fatalf("expected admin marker for /abort, got %q", help)
+	}
+	if strings.Contains(help, "/run <project> <prompt> - run a task in a project [admin]") {
+		t.Fatalf("did not expect admin marker for /run, got %q", help)
+	}
+	if !strings.HasPrefix(tg.sentMessages[1].Text, "Welcome.") || !strings.Contains(tg.sentMessages[1].Text, help) {
+		t.Fatalf("expected start to include help text, got %q", tg.sentMessages[1].Text)
+	}
+}
+
+func TestBotApp_HandleWhoami(t *testing.T) {
+	app, tg, st := testBotApp(&Config{AllowedIDs: map[int64]bool{7: true}, AdminIDs: map[int64]bool{7: true}}, &mockOpencodeClient{})
+	_ = st.SetUserAgentKey(7, "agent-key")
+
+	app.handleWhoami(1, 7)
+	app.handleWhoami(1, 8)
+
+	if len(tg.sentMessages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(tg.sentMessages))
+	}
+	if got := tg.sentMessages[0].Text; got != "ID: 7\nAllowed: yes\nAdmin: yes\nPaired: yes" {
+		t.Fatalf("unexpected whoami for admin: %q", got)
+	}
+	if got := tg.sentMessages[1].Text; got != "ID: 8\nAllowed: no\nAdmin: no\nPaired: no" {
+		t.Fatalf("unexpected whoami for stranger: %q", got)
+	}
+}
+
+func TestBotApp_HandleLast(t *testing.T) {
+	output := ""
+	oc := &mockOpencodeClient{
+		listSessions: func() ([]map[string]any, error) {
+			return []map[string]any{{"id": "ses_1", "title": "oct_one"}}, nil
+		},
+		getSessionMessages: func(sid string) (string, error) {
+			if sid != "ses_1" {
+				t.Errorf("unexpected session %q", sid)
+			}
+			return output, nil
+		},
+	}
+	app, tg, st := testBotApp(&Config{SessionPrefix: "oct_"}, oc)
+
+	app.handleLast(1, 7)
+	_ = st.SetUserSession(7, "ses_gone")
+	app.handleLast(1, 7)
+	_ = st.SetUserSession(7, "ses_1")
+	app.handleLast(1, 7)
+	if len(tg.sentMessages) != 3 ||
+		!strings.HasPrefix(tg.sentMessages[0].Text, "You have not selected a session") ||
+		tg.sentMessages[1].Text != "Your selected session ses_gone is no longer available. Use /sessions to pick another." ||
+		tg.sentMessages[2].Text != "Session ses_1 has no output yet." {
+		t.Fatalf("unexpected replies: %+v", tg.sentMessages)
+	}
+
+	first := strings.Repeat("é", telegramMessageLimit-10) + "\n"
+	output = first + strings.Repeat("b", 20)
+	tg.sentMessages = nil
+	app.handleLast(1, 7)
+	if len(tg.sentMessages) != 2 || tg.sentMessages[0].Text != first || tg.sentMessages[1].Text != strings.Repeat("b", 20) {
+		t.Fatalf("expected output split after the newline into 2 messages, got %d", len(tg.sentMessages))
+	}
+	app.runMu.Lock()
+	defer app.runMu.Unlock()
+	if len(app.activeRuns) != 0 || app.userRuns[7] != 0 {
+		t.Fatal("expected /last not to take a run slot")
+	}
+}
@@ -2,9 +2,12 @@ package contracts
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -13,8 +16,11 @@ const (
 	CommandTypeRegisterProject    = "register_project"
 	CommandTypeApplyProjectPolicy = "apply_project_policy"
 	CommandTypeStartServer        = "start_server"
+	CommandTypeStopServer         = "stop_server"
 	CommandTypeRunTask            = "run_task"
 	CommandTypeStatus             = "status"
+	CommandTypeCancelTask         = "cancel_task"
+	CommandTypeAbortSession       = "abort_session"
 )
 
 const (
@@ -39,14 +45,28 @@ const (
 	ErrPolicyDenied             = "ERR_POLICY_DENIED"
 	ErrPathForbidden            = "ERR_PATH_FORBIDDEN"
 	ErrPathInvalid              = "ERR_PATH_INVALID"
+	ErrProjectNotFound          = "ERR_PROJECT_NOT_FOUND"
 	ErrPortExhausted            = "ERR_PORT_EXHAUSTED"
 	ErrStartTimeout             = "ERR_START_TIMEOUT"
+	ErrCancelled                = "ERR_CANCELLED"
+	ErrCheckoutFailed           = "ERR_CHECKOUT_FAILED"
+	ErrRateLimited              = "ERR_RATE_LIMITED"
 	ErrInternal                 = "ERR_INTERNAL"
 )
 
+// APIError is the error body of every backend response. Field names the
+// request field that failed validation and Details carries extra context,
+// such as a size limit; both are omitted when unset.
 type APIError struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Field   string         `json:"field,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// RequiredFieldError reports that field is missing or blank.
+func RequiredFieldError(field string) APIError {
+	return APIError{Code: ErrValidationRequiredField, Message: field + " is required", Field: field}
 }
 
 func (e APIError) Error() string {
@@ -56,22 +76,77 @@ func (e APIError) Error() string {
 	return e.Code + ": " + e.Message
 }
 
+// RequestIDHeader carries the correlation ID of a backend request. The
+// backend echoes it on every response and in error payloads as request_id.
+const RequestIDHeader = "X-Request-ID"
+
+// APIVersion is the "major.minor" version of the backend JSON contracts.
+// Minor bumps only add optional fields; a major bump breaks compatibility.
+const APIVersion = "1.0"
+
+// APIVersionHeader carries the client's APIVersion. The backend echoes its
+// own version on every response.
+const APIVersionHeader = "X-OCT-API-Version"
+
+// CheckAPIVersion rejects a client version whose major part differs from
+// APIVersion. An empty version is accepted so clients that predate the
+// header keep working.
+func CheckAPIVersion(version string) error {
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return nil
+	}
+	major, _, _ := strings.Cut(version, ".")
+	ours, _, _ := strings.Cut(APIVersion, ".")
+	if _, err := strconv.Atoi(major); err != nil {
+		return APIError{Code: ErrValidationInvalidRequest, Message: fmt.Sprintf("malformed %s %q", APIVersionHeader, version), Field: APIVersionHeader}
+	}
+	if major != ours {
+		return APIError{Code: ErrValidationInvalidRequest, Message: fmt.Sprintf("incompatible API version %s, backend speaks %s", version, APIVersion), Field: APIVersionHeader, Details: map[string]any{"api_version": APIVersion}}
+	}
+	return nil
+}
+
 type Command struct {
 	CommandID      string          `json:"command_id"`
 	IdempotencyKey string          `json:"idempotency_key"`
 	Type           string          `json:"type"`
 	CreatedAt      time.Time       `json:"created_at"`
 	Payload        json.RawMessage `json:"payload"`
+	// Priority orders delivery: higher is sooner, equal keeps FIFO order.
+	Priority int `json:"priority,omitempty"`
+	// DryRun asks the agent to validate the command, check its policy and
+	// resolve its project without executing it. Only start_server and
+	// run_task support it.
+	DryRun bool `json:"dry_run,omitempty"`
 }
 
+// Command priorities range from PriorityNormal to MaxPriority. Quick
+// interactive commands use PriorityHigh so they skip queued tasks.
+const (
+	PriorityNormal = 0
+	PriorityHigh   = 5
+	MaxPriority    = 9
+)
+
+// CommandResult is the outcome of a command. Results with InProgress set are
+// partial updates that a later result for the same command replaces.
 type CommandResult struct {
-	CommandID string         `json:"command_id"`
-	OK        bool           `json:"ok"`
-	ErrorCode string         `json:"error_code,omitempty"`
-	Summary   string         `json:"summary,omitempty"`
-	Stdout    string         `json:"stdout,omitempty"`
-	Stderr    string         `json:"stderr,omitempty"`
-	Meta      map[string]any `json:"meta,omitempty"`
+	CommandID  string         `json:"command_id"`
+	OK         bool           `json:"ok"`
+	InProgress bool           `json:"in_progress,omitempty"`
+	ErrorCode  string         `json:"error_code,omitempty"`
+	Summary    string         `json:"summary,omitempty"`
+	Stdout     string         `json:"stdout,omitempty"`
+	Stderr     string         `json:"stderr,omitempty"`
+	ExitCode   *int           `json:"exit_code,omitempty"`
+	Meta       map[string]any `json:"meta,omitempty"`
+}
+
+// IsTerminal reports whether r is a command's final result rather than a
+// progress update.
+func (r CommandResult) IsTerminal() bool {
+	return !r.InProgress
 }
 
 type PairStartRequest struct {
@@ -93,12 +168,100 @@ type PairClaimResponse struct {
 	AgentKey string `json:"agent_key"`
 }
 
+// PairRotateRequest asks the backend to replace the user's unclaimed
+// pairing codes with a fresh one; the response is a PairStartResponse.
+type PairRotateRequest struct {
+	TelegramUserID string `json:"telegram_user_id"`
+}
+
+// PairRevokeRequest asks the backend to revoke the user's agent key.
+type PairRevokeRequest struct {
+	TelegramUserID string `json:"telegram_user_id"`
+}
+
 type PollResponse struct {
 	Command *Command `json:"command"`
 }
 
+type AgentStatusResponse struct {
+	Online             bool       `json:"online"`
+	LastSeen           *time.Time `json:"last_seen,omitempty"`
+	PollTimeoutSeconds int        `json:"poll_timeout_seconds,omitempty"`
+}
+
+// QueueStatsResponse counts an agent's commands waiting for delivery and
+// delivered commands awaiting a result. Commands lists up to
+// MaxPendingCommands of them, inflight first, then in delivery order.
+type QueueStatsResponse struct {
+	Queued   int              `json:"queued"`
+	Inflight int              `json:"inflight"`
+	Commands []PendingCommand `json:"commands,omitempty"`
+}
+
+// MaxPendingCommands bounds QueueStatsResponse.Commands.
+const MaxPendingCommands = 20
+
+// PendingCommand is a command without a final result: delivered to the
+// agent when Inflight is set, otherwise still queued.
+type PendingCommand struct {
+	CommandID string    `json:"command_id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Inflight  bool      `json:"inflight"`
+}
+
 type RegisterProjectPayload struct {
 	ProjectPathRaw string `json:"project_path_raw"`
+	// Env is added to the environment of the project's serve and run
+	// processes. Values are secrets and are never echoed back.
+	Env map[string]string `json:"env,omitempty"`
+	// RunTimeoutSeconds bounds each run_task in the project in place of the
+	// agent's global command timeout; zero keeps the global one.
+	RunTimeoutSeconds int `json:"run_timeout_seconds,omitempty"`
+}
+
+// Size limits for free-text payload fields. MaxPromptBytes applies to each
+// prompt of a batch.
+const (
+	MaxProjectPathBytes = 4096
+	MaxPromptBytes      = 32 << 10
+	MaxTitleBytes       = 200
+)
+
+// MaxBatchPrompts caps the prompts of one batched run_task.
+const MaxBatchPrompts = 10
+
+// MaxProjectEnvVars caps the number of environment variables per project.
+const MaxProjectEnvVars = 32
+
+// MaxRunTimeoutSeconds caps a project's run_timeout_seconds at six hours.
+const MaxRunTimeoutSeconds = 6 * 60 * 60
+
+// ValidateRunTimeout checks a project's run_timeout_seconds.
+func ValidateRunTimeout(seconds int) error {
+	if seconds < 0 || seconds > MaxRunTimeoutSeconds {
+		return fmt.Errorf("run_timeout_seconds must be 0-%d", MaxRunTimeoutSeconds)
+	}
+	return nil
+}
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,127}$`)
+
+// ValidateProjectEnv checks the variable count, that every key is a plain
+// shell identifier, and that no value contains a NUL byte.
+func ValidateProjectEnv(env map[string]string) error {
+	if len(env) > MaxProjectEnvVars {
+		return fmt.Errorf("at most %d env variables are allowed", MaxProjectEnvVars)
+	}
+	for key, value := range env {
+		if !envKeyPattern.MatchString(key) {
+			return fmt.Errorf("env key %q is not allowed", key)
+		}
+		if strings.ContainsRune(value, 0) {
+			return fmt.Errorf("env value for %q contains a NUL byte", key)
+		}
+	}
+	return nil
 }
 
 type ApplyProjectPolicyPayload struct {
@@ -110,15 +273,88 @@ type ApplyProjectPolicyPayload struct {
 
 type StartServerPayload struct {
 	ProjectID string `json:"project_id"`
+	// TimeoutSeconds, when set, waits this long for the server to become
+	// ready instead of the agent's start timeout, which it may not exceed.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+}
+
+type StopServerPayload struct {
+	ProjectID string `json:"project_id"`
 }
 
 type RunTaskPayload struct {
 	ProjectID string `json:"project_id"`
 	Prompt    string `json:"prompt"`
+	// Prompts replaces Prompt to run several prompts in order in one
+	// session; the two are mutually exclusive.
+	Prompts     []string     `json:"prompts,omitempty"`
+	Model       string       `json:"model,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// Branch, when set, is checked out in the project before the task runs.
+	Branch string `json:"branch,omitempty"`
+	// Subdir, when set, runs the task in this directory relative to the
+	// project root instead of the root itself. It must stay inside the
+	// project.
+	Subdir string `json:"subdir,omitempty"`
+	// TimeoutSeconds, when set, shortens the task's deadline below the
+	// project's run timeout, which it may not exceed.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// Title, when set, names the new session the task starts.
+	Title string `json:"title,omitempty"`
+}
+
+var branchPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,199}$`)
+
+// ValidateBranchName accepts branch names that git allows and that cannot be
+// mistaken for an option: letters, digits, '.', '_', '-' and '/', starting
+// with a letter or digit, without "..", "//", a trailing '/' or '.', or a
+// ".lock" suffix.
+func ValidateBranchName(name string) error {
+	if !branchPattern.MatchString(name) ||
+		strings.Contains(name, "..") || strings.Contains(name, "//") ||
+		strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") || strings.HasSuffix(name, ".lock") {
+		return fmt.Errorf("branch %q is not a safe ref name", name)
+	}
+	return nil
+}
+
+// Attachment is a file sent along with a run_task prompt.
+type Attachment struct {
+	Name          string `json:"name"`
+	ContentBase64 string `json:"content_base64"`
+}
+
+// DefaultMaxAttachmentBytes caps the decoded size of all attachments of one
+// run_task.
+const DefaultMaxAttachmentBytes = 10 << 20
+
+// ValidateAttachmentName rejects names that are empty or are not a single
+// plain file name, so an attachment cannot be written outside its directory.
+func ValidateAttachmentName(name string) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return errors.New("attachment name is required")
+	case len(name) > 255:
+		return fmt.Errorf("attachment name is longer than 255 bytes")
+	case name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00"):
+		return fmt.Errorf("attachment name %q is not allowed", name)
+	}
+	return nil
 }
 
 type StatusPayload struct{}
 
+// CancelTaskPayload names the run_task command to cancel.
+type CancelTaskPayload struct {
+	CommandID string `json:"command_id"`
+}
+
+// AbortSessionPayload names an Opencode session on the project's server.
+type AbortSessionPayload struct {
+	ProjectID string `json:"project_id"`
+	SessionID string `json:"session_id"`
+}
+
 func DecodeStrictJSON(data []byte, out any) error {
 	dec := json.NewDecoder(bytes.NewReader(data))
 	dec.DisallowUnknownFields()
@@ -139,15 +375,41 @@ func DecodeRequestStrict[T any](data []byte) (T, error) {
 	return out, nil
 }
 
+// Idempotency keys index the agent's replay cache, so they are kept short and
+// printable.
+const (
+	minIdempotencyKeyLen = 8
+	maxIdempotencyKeyLen = 128
+)
+
+func validIdempotencyKey(key string) bool {
+	if len(key) < minIdempotencyKeyLen || len(key) > maxIdempotencyKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
 func ValidateCommand(cmd Command) error {
 	if strings.TrimSpace(cmd.CommandID) == "" {
-		return APIError{Code: ErrValidationRequiredField, Message: "command_id is required"}
+		return RequiredFieldError("command_id")
 	}
 	if strings.TrimSpace(cmd.IdempotencyKey) == "" {
-		return APIError{Code: ErrValidationRequiredField, Message: "idempotency_key is required"}
+		return RequiredFieldError("idempotency_key")
+	}
+	if !validIdempotencyKey(cmd.IdempotencyKey) {
+		return APIError{Code: ErrValidationInvalidRequest, Message: fmt.Sprintf("idempotency_key must be %d-%d characters of [A-Za-z0-9_-]", minIdempotencyKeyLen, maxIdempotencyKeyLen)}
 	}
 	if cmd.CreatedAt.IsZero() {
-		return APIError{Code: ErrValidationRequiredField, Message: "created_at is required"}
+		return RequiredFieldError("created_at")
+	}
+	if cmd.Priority < PriorityNormal || cmd.Priority > MaxPriority {
+		return APIError{Code: ErrValidationInvalidRequest, Message: fmt.Sprintf("priority must be %d-%d", PriorityNormal, MaxPriority)}
 	}
 	if err := validatePayload(cmd.Type, cmd.Payload); err != nil {
 		return err
@@ -155,6 +417,89 @@ func ValidateCommand(cmd Command) error {
 	return nil
 }
 
+// FreshnessWindow bounds how far a command's CreatedAt may lie behind or
+// ahead of the validating clock. A zero field disables that bound.
+type FreshnessWindow struct {
+	MaxAge        time.Duration
+	MaxFutureSkew time.Duration
+}
+
+// DefaultFreshnessWindow rejects commands more than 2 minutes in the future.
+// It sets no MaxAge: a command may legitimately wait in the queue for an
+// offline agent or be redelivered long after it was created.
+var DefaultFreshnessWindow = FreshnessWindow{MaxFutureSkew: 2 * time.Minute}
+
+// FreshnessWindowFromEnv overrides DefaultFreshnessWindow with
+// OCT_COMMAND_MAX_AGE and OCT_COMMAND_MAX_FUTURE_SKEW as read by getenv (Go
+// durations; 0 disables).
+func FreshnessWindowFromEnv(getenv func(string) string) (FreshnessWindow, error) {
+	window := DefaultFreshnessWindow
+	for _, v := range []struct {
+		name   string
+		target *time.Duration
+	}{
+		{"OCT_COMMAND_MAX_AGE", &window.MaxAge},
+		{"OCT_COMMAND_MAX_FUTURE_SKEW", &window.MaxFutureSkew},
+	} {
+		raw := getenv(v.name)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return FreshnessWindow{}, fmt.Errorf("invalid %s: %w", v.name, err)
+		}
+		*v.target = d
+	}
+	return window, nil
+}
+
+// ValidateCommandAt is ValidateCommand plus a freshness check of CreatedAt
+// against now, so replayed or long-dead commands are rejected.
+func ValidateCommandAt(cmd Command, now time.Time, window FreshnessWindow) error {
+	if err := ValidateCommand(cmd); err != nil {
+		return err
+	}
+	age := now.Sub(cmd.CreatedAt)
+	if window.MaxAge > 0 && age > window.MaxAge {
+		return APIError{Code: ErrValidationInvalidRequest, Message: fmt.Sprintf("created_at is older than %s", window.MaxAge)}
+	}
+	if window.MaxFutureSkew > 0 && -age > window.MaxFutureSkew {
+		return APIError{Code: ErrValidationInvalidRequest, Message: fmt.Sprintf("created_at is more than %s in the future", window.MaxFutureSkew)}
+	}
+	return nil
+}
+
+// validatePrompts checks that a run_task carries either one prompt or a
+// batch of up to MaxBatchPrompts non-blank prompts, each within
+// MaxPromptBytes.
+func validatePrompts(p RunTaskPayload) error {
+	if len(p.Prompts) == 0 {
+		if strings.TrimSpace(p.Prompt) == "" {
+			return RequiredFieldError("prompt")
+		}
+		if len(p.Prompt) > MaxPromptBytes {
+			return APIError{Code: ErrValidationInvalidPayload, Message: fmt.Sprintf("prompt exceeds %d bytes", MaxPromptBytes), Field: "prompt", Details: map[string]any{"max_bytes": MaxPromptBytes}}
+		}
+		return nil
+	}
+	if p.Prompt != "" {
+		return APIError{Code: ErrValidationInvalidPayload, Message: "prompt and prompts are mutually exclusive", Field: "prompts"}
+	}
+	if len(p.Prompts) > MaxBatchPrompts {
+		return APIError{Code: ErrValidationInvalidPayload, Message: fmt.Sprintf("prompts exceeds %d entries", MaxBatchPrompts), Field: "prompts", Details: map[string]any{"max_prompts": MaxBatchPrompts}}
+	}
+	for i, prompt := range p.Prompts {
+		if strings.TrimSpace(prompt) == "" {
+			return APIError{Code: ErrValidationInvalidPayload, Message: fmt.Sprintf("prompts[%d] is blank", i), Field: "prompts"}
+		}
+		if len(prompt) > MaxPromptBytes {
+			return APIError{Code: ErrValidationInvalidPayload, Message: fmt.Sprintf("prompts[%d] exceeds %d bytes", i, MaxPromptBytes), Field: "prompts", Details: map[string]any{"max_bytes": MaxPromptBytes}}
+		}
+	}
+	return nil
+}
+
 func validatePayload(commandType string, payload json.RawMessage) error {
 	switch commandType {
 	case CommandTypeRegisterProject:
@@ -163,7 +508,16 @@ func validatePayload(commandType string, payload json.RawMessage) error {
 			return APIError{Code: ErrValidationInvalidPayload, Message: err.Error()}
 		}
 		if strings.TrimSpace(p.ProjectPathRaw) == "" {
-			return APIError{Code: ErrValidationRequiredField, Message: "project_path_raw is required"}
+			return RequiredFieldError("project_path_raw")
+		}
+		if len(p.ProjectPathRaw) > MaxProjectPathBytes {
+			return APIError{Code: ErrValidationInvalidPayload, Message: fmt.Sprintf("project_path_raw exceeds %d bytes", MaxProjectPathBytes), Field: "project_path_raw", Details: map[string]any{"max_bytes": MaxProjectPathBytes}}
+		}
+		if err := ValidateProjectEnv(p.Env); err != nil {
+			return APIError{Code: ErrValidationInvalidPayload, Message: err.Error()}
+		}
+		if err := ValidateRunTimeout(p.RunTimeoutSeconds); err != nil {
+			return APIError{Code: ErrValidationInvalidPayload, Message: err.Error(), Field: "run_timeout_seconds", Details: map[string]any{"max_seconds": MaxRunTimeoutSeconds}}
 		}
 		return nil
 	case CommandTypeApplyProjectPolicy:
@@ -172,7 +526,7 @@ func validatePayload(commandType string, payload json.RawMessage) error {
 			return APIError{Code: ErrValidationInvalidPayload, Message: err.Error()}
 		}
 		if strings.TrimSpace(p.ProjectID) == "" {
-			return APIError{Code: ErrValidationRequiredField, Message: "project_id is required"}
+			return RequiredFieldError("project_id")
 		}
 		if p.Decision != DecisionAllow && p.Decision != DecisionDeny {
 			return APIError{Code: ErrValidationInvalidPayload, Message: "decision must be ALLOW or DENY"}
@@ -189,7 +543,19 @@ func validatePayload(commandType string, payload json.RawMessage) error {
 			return APIError{Code: ErrValidationInvalidPayload, Message: err.Error()}
 		}
 		if strings.TrimSpace(p.ProjectID) == "" {
-			return APIError{Code: ErrValidationRequiredField, Message: "project_id is required"}
+			return RequiredFieldError("project_id")
+		}
+		if p.TimeoutSeconds < 0 {
+			return APIError{Code: ErrValidationInvalidPayload, Message: "timeout_seconds must not be negative", Field: "timeout_seconds"}
+		}
+		return nil
+	case CommandTypeStopServer:
+		var p StopServerPayload
+		if err := DecodeStrictJSON(payload, &p); err != nil {
+			return APIError{Code: ErrValidationInvalidPayload, Message: err.Error()}
+		}
+		if strings.TrimSpace(p.ProjectID) == "" {
+			return RequiredFieldError("project_id")
 		}
 		return nil
 	case CommandTypeRunTask:
@@ -198,10 +564,34 @@ func validatePayload(commandType string, payload json.RawMessage) error {
 			return APIError{Code: ErrValidationInvalidPayload, Message: err.Error()}
 		}
 		if strings.TrimSpace(p.ProjectID) == "" {
-			return APIError{Code: ErrValidationRequiredField, Message: "project_id is required"}
+			return RequiredFieldError("project_id")
 		}
-		if strings.TrimSpace(p.Prompt) == "" {
-			return APIError{Code: ErrValidationRequiredField, Message: "prompt is required"}
+		if err := validatePrompts(p); err != nil {
+			return err
+		}
+		if len(p.Title) > MaxTitleBytes {
+			return APIError{Code: ErrValidationInvalidPayload, Message: fmt.Sprintf("title exceeds %d bytes", MaxTitleBytes), Field: "title", Details: map[string]any{"max_bytes": MaxTitleBytes}}
+		}
+		if p.TimeoutSeconds < 0 {
+			return APIError{Code: ErrValidationInvalidPayload, Message: "timeout_seconds must not be negative", Field: "timeout_seconds"}
+		}
+		if p.Branch != "" {
+			if err := ValidateBranchName(p.Branch); err != nil {
+				return APIError{Code: ErrValidationInvalidPayload, Message: err.Error()}
+			}
+		}
+		seen := make(map[string]bool, len(p.Attachments))
+		for _, a := range p.Attachments {
+			if err := ValidateAttachmentName(a.Name); err != nil {
+				return APIError{Code: ErrValidationInvalidPayload, Message: err.Error()}
+			}
+			if seen[a.Name] {
+				return APIError{Code: ErrValidationInvalidPayload, Message: fmt.Sprintf("duplicate attachment name %q", a.Name)}
+			}
+			seen[a.Name] = true
+			if _, err := base64.StdEncoding.DecodeString(a.ContentBase64); err != nil {
+				return APIError{Code: ErrValidationInvalidPayload, Message: fmt.Sprintf("attachment %q is not valid base64", a.Name)}
+			}
 		}
 		return nil
 	case CommandTypeStatus:
@@ -213,6 +603,27 @@ func validatePayload(commandType string, payload json.RawMessage) error {
 			return APIError{Code: ErrValidationInvalidPayload, Message: err.Error()}
 		}
 		return nil
+	case CommandTypeCancelTask:
+		var p CancelTaskPayload
+		if err := DecodeStrictJSON(payload, &p); err != nil {
+			return APIError{Code: ErrValidationInvalidPayload, Message: err.Error()}
+		}
+		if strings.TrimSpace(p.CommandID) == "" {
+			return RequiredFieldError("command_id")
+		}
+		return nil
+	case CommandTypeAbortSession:
+		var p AbortSessionPayload
+		if err := DecodeStrictJSON(payload, &p); err != nil {
+			return APIError{Code: ErrValidationInvalidPayload, Message: err.Error()}
+		}
+		if strings.TrimSpace(p.ProjectID) == "" {
+			return RequiredFieldError("project_id")
+		}
+		if strings.TrimSpace(p.SessionID) == "" {
+			return RequiredFieldError("session_id")
+		}
+		return nil
 	default:
 		return APIError{Code: ErrValidationInvalidType, Message: "unsupported command type"}
 	}
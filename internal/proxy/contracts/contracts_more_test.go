@@ -2,6 +2,7 @@ package contracts
 
 import (
 	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 )
@@ -19,11 +20,11 @@ func TestDecodeRequestStrictSuccess(t *testing.T) {
 func TestValidateCommandInvalidJSONPayloadBranches(t *testing.T) {
 	now := time.Now().UTC()
 	cases := []Command{
-		{CommandID: "c1", IdempotencyKey: "k1", Type: CommandTypeRegisterProject, CreatedAt: now, Payload: json.RawMessage(`{bad`)},
-		{CommandID: "c2", IdempotencyKey: "k2", Type: CommandTypeApplyProjectPolicy, CreatedAt: now, Payload: json.RawMessage(`{bad`)},
-		{CommandID: "c3", IdempotencyKey: "k3", Type: CommandTypeStartServer, CreatedAt: now, Payload: json.RawMessage(`{bad`)},
-		{CommandID: "c4", IdempotencyKey: "k4", Type: CommandTypeRunTask, CreatedAt: now, Payload: json.RawMessage(`{bad`)},
-		{CommandID: "c5", IdempotencyKey: "k5", Type: CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{bad`)},
+		{CommandID: "c1", IdempotencyKey: "k1-00000", Type: CommandTypeRegisterProject, CreatedAt: now, Payload: json.RawMessage(`{bad`)},
+		{CommandID: "c2", IdempotencyKey: "k2-00000", Type: CommandTypeApplyProjectPolicy, CreatedAt: now, Payload: json.RawMessage(`{bad`)},
+		{CommandID: "c3", IdempotencyKey: "k3-00000", Type: CommandTypeStartServer, CreatedAt: now, Payload: json.RawMessage(`{bad`)},
+		{CommandID: "c4", IdempotencyKey: "k4-00000", Type: CommandTypeRunTask, CreatedAt: now, Payload: json.RawMessage(`{bad`)},
+		{CommandID: "c5", IdempotencyKey: "k5-00000", Type: CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{bad`)},
 	}
 	for _, tc := range cases {
 		err := ValidateCommand(tc)
@@ -39,3 +40,18 @@ func TestValidateCommandInvalidJSONPayloadBranches(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckAPIVersion(t *testing.T) {
+	for _, v := range []string{"", APIVersion, "1.7", " 1 "} {
+		if err := CheckAPIVersion(v); err != nil {
+			t.Fatalf("expected %q accepted, got %v", v, err)
+		}
+	}
+	for _, v := range []string{"2.0", "0.9", "v1", "x.1"} {
+		err := CheckAPIVersion(v)
+		var apiErr APIError
+		if !errors.As(err, &apiErr) || apiErr.Code != ErrValidationInvalidRequest || apiErr.Field != APIVersionHeader {
+			t.Fatalf("expected %q rejected with invalid request, got %v", v, err)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package contracts
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"strings"
 	"testing"
 	"time"
@@ -25,7 +27,7 @@ func TestACMVP01StrictUnknownFieldFails(t *testing.T) {
 func TestACMVP01UnknownCommandTypeRejected(t *testing.T) {
 	cmd := Command{
 		CommandID:      "c1",
-		IdempotencyKey: "i1",
+		IdempotencyKey: "i1-00000",
 		Type:           "not_allowed",
 		CreatedAt:      time.Now().UTC(),
 		Payload:        json.RawMessage(`{}`),
@@ -46,7 +48,7 @@ func TestACMVP01UnknownCommandTypeRejected(t *testing.T) {
 func TestACMVP01RunTaskPayloadValidation(t *testing.T) {
 	cmd := Command{
 		CommandID:      "c2",
-		IdempotencyKey: "i2",
+		IdempotencyKey: "i2-00000",
 		Type:           CommandTypeRunTask,
 		CreatedAt:      time.Now().UTC(),
 		Payload:        json.RawMessage(`{"project_id":"p1"}`),
@@ -94,11 +96,14 @@ func TestDecodeStrictJSON(t *testing.T) {
 func TestValidateCommand_AllPayloadTypes(t *testing.T) {
 	now := time.Now().UTC()
 	validCases := []Command{
-		{CommandID: "1", IdempotencyKey: "k1", Type: CommandTypeRegisterProject, CreatedAt: now, Payload: json.RawMessage(`{"project_path_raw":"/tmp/p"}`)},
-		{CommandID: "2", IdempotencyKey: "k2", Type: CommandTypeApplyProjectPolicy, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","decision":"ALLOW","scope":["START_SERVER","RUN_TASK"]}`)},
-		{CommandID: "3", IdempotencyKey: "k3", Type: CommandTypeStartServer, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1"}`)},
-		{CommandID: "4", IdempotencyKey: "k4", Type: CommandTypeRunTask, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","prompt":"hello"}`)},
-		{CommandID: "5", IdempotencyKey: "k5", Type: CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{}`)},
+		{CommandID: "1", IdempotencyKey: "k1-00000", Type: CommandTypeRegisterProject, CreatedAt: now, Payload: json.RawMessage(`{"project_path_raw":"/tmp/p"}`)},
+		{CommandID: "2", IdempotencyKey: "k2-00000", Type: CommandTypeApplyProjectPolicy, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","decision":"ALLOW","scope":["START_SERVER","RUN_TASK"]}`)},
+		{CommandID: "3", IdempotencyKey: "k3-00000", Type: CommandTypeStartServer, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1"}`)},
+		{CommandID: "4", IdempotencyKey: "k4-00000", Type: CommandTypeRunTask, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","prompt":"hello"}`)},
+		{CommandID: "5", IdempotencyKey: "k5-00000", Type: CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{}`)},
+		{CommandID: "6", IdempotencyKey: "k6-00000", Type: CommandTypeStopServer, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1"}`)},
+		{CommandID: "7", IdempotencyKey: "k7-00000", Type: CommandTypeCancelTask, CreatedAt: now, Payload: json.RawMessage(`{"command_id":"4"}`)},
+		{CommandID: "8", IdempotencyKey: "k8-00000", Type: CommandTypeAbortSession, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","session_id":"ses_1"}`)},
 	}
 	for _, tc := range validCases {
 		if err := ValidateCommand(tc); err != nil {
@@ -111,7 +116,7 @@ func TestValidateCommand_ErrorBranches(t *testing.T) {
 	now := time.Now().UTC()
 
 	t.Run("missing envelope fields", func(t *testing.T) {
-		err := ValidateCommand(Command{IdempotencyKey: "k", Type: CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{}`)})
+		err := ValidateCommand(Command{IdempotencyKey: "k-000000", Type: CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{}`)})
 		if err == nil {
 			t.Fatal("expected missing command_id")
 		}
@@ -119,25 +124,25 @@ func TestValidateCommand_ErrorBranches(t *testing.T) {
 		if err == nil {
 			t.Fatal("expected missing idempotency_key")
 		}
-		err = ValidateCommand(Command{CommandID: "c", IdempotencyKey: "k", Type: CommandTypeStatus, Payload: json.RawMessage(`{}`)})
+		err = ValidateCommand(Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeStatus, Payload: json.RawMessage(`{}`)})
 		if err == nil {
 			t.Fatal("expected missing created_at")
 		}
 	})
 
 	t.Run("apply policy validation", func(t *testing.T) {
-		err := ValidateCommand(Command{CommandID: "c", IdempotencyKey: "k", Type: CommandTypeApplyProjectPolicy, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","decision":"MAYBE","scope":[]}`)})
+		err := ValidateCommand(Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeApplyProjectPolicy, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","decision":"MAYBE","scope":[]}`)})
 		if err == nil {
 			t.Fatal("expected invalid decision")
 		}
-		err = ValidateCommand(Command{CommandID: "c", IdempotencyKey: "k", Type: CommandTypeApplyProjectPolicy, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","decision":"ALLOW","scope":["X"]}`)})
+		err = ValidateCommand(Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeApplyProjectPolicy, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","decision":"ALLOW","scope":["X"]}`)})
 		if err == nil {
 			t.Fatal("expected invalid scope")
 		}
 	})
 
 	t.Run("status payload required", func(t *testing.T) {
-		err := ValidateCommand(Command{CommandID: "c", IdempotencyKey: "k", Type: CommandTypeStatus, CreatedAt: now, Payload: nil})
+		err := ValidateCommand(Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeStatus, CreatedAt: now, Payload: nil})
 		if err == nil {
 			t.Fatal("expected payload required")
 		}
@@ -145,10 +150,21 @@ func TestValidateCommand_ErrorBranches(t *testing.T) {
 
 	t.Run("type specific missing fields", func(t *testing.T) {
 		cases := []Command{
-			{CommandID: "c1", IdempotencyKey: "k", Type: CommandTypeRegisterProject, CreatedAt: now, Payload: json.RawMessage(`{"project_path_raw":""}`)},
-			{CommandID: "c2", IdempotencyKey: "k", Type: CommandTypeApplyProjectPolicy, CreatedAt: now, Payload: json.RawMessage(`{"decision":"ALLOW","scope":[]}`)},
-			{CommandID: "c3", IdempotencyKey: "k", Type: CommandTypeStartServer, CreatedAt: now, Payload: json.RawMessage(`{"project_id":""}`)},
-			{CommandID: "c4", IdempotencyKey: "k", Type: CommandTypeRunTask, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","prompt":""}`)},
+			{CommandID: "c1", IdempotencyKey: "k-000000", Type: CommandTypeRegisterProject, CreatedAt: now, Payload: json.RawMessage(`{"project_path_raw":""}`)},
+			{CommandID: "c2", IdempotencyKey: "k-000000", Type: CommandTypeApplyProjectPolicy, CreatedAt: now, Payload: json.RawMessage(`{"decision":"ALLOW","scope":[]}`)},
+			{CommandID: "c3", IdempotencyKey: "k-000000", Type: CommandTypeStartServer, CreatedAt: now, Payload: json.RawMessage(`{"project_id":""}`)},
+			{CommandID: "c4", IdempotencyKey: "k-000000", Type: CommandTypeRunTask, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","prompt":""}`)},
+			{CommandID: "c5", IdempotencyKey: "k-000000", Type: CommandTypeStopServer, CreatedAt: now, Payload: json.RawMessage(`{"project_id":""}`)},
+			{CommandID: "c6", IdempotencyKey: "k-000000", Type: CommandTypeStopServer, CreatedAt: now, Payload: json.RawMessage(`{bad`)},
+			{CommandID: "c7", IdempotencyKey: "k-000000", Type: CommandTypeCancelTask, CreatedAt: now, Payload: json.RawMessage(`{"command_id":""}`)},
+			{CommandID: "c8", IdempotencyKey: "k-000000", Type: CommandTypeCancelTask, CreatedAt: now, Payload: json.RawMessage(`{bad`)},
+			{CommandID: "c9", IdempotencyKey: "k-000000", Type: CommandTypeAbortSession, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","session_id":""}`)},
+			{CommandID: "c10", IdempotencyKey: "k-000000", Type: CommandTypeAbortSession, CreatedAt: now, Payload: json.RawMessage(`{"session_id":"ses_1"}`)},
+			{CommandID: "c11", IdempotencyKey: "k-000000", Type: CommandTypeAbortSession, CreatedAt: now, Payload: json.RawMessage(`{bad`)},
+			{CommandID: "c14", IdempotencyKey: "k-000000", Type: CommandTypeRunTask, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","prompt":"hi","branch":"--orphan"}`)},
+			{CommandID: "c12", IdempotencyKey: "k-000000", Type: CommandTypeRunTask, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","prompt":"` + strings.Repeat("x", MaxPromptBytes+1) + `"}`)},
+			{CommandID: "c15", IdempotencyKey: "k-000000", Type: CommandTypeRunTask, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","prompt":"hi","title":"` + strings.Repeat("x", MaxTitleBytes+1) + `"}`)},
+			{CommandID: "c13", IdempotencyKey: "k-000000", Type: CommandTypeRegisterProject, CreatedAt: now, Payload: json.RawMessage(`{"project_path_raw":"/` + strings.Repeat("x", MaxProjectPathBytes) + `"}`)},
 		}
 		for _, tc := range cases {
 			if err := ValidateCommand(tc); err == nil {
@@ -157,3 +173,285 @@ func TestValidateCommand_ErrorBranches(t *testing.T) {
 		}
 	})
 }
+
+func TestValidateCommandIdempotencyKeyFormat(t *testing.T) {
+	now := time.Now().UTC()
+	cases := []struct {
+		name string
+		key  string
+		ok   bool
+	}{
+		{"minimum length", "abcd1234", true},
+		{"maximum length", strings.Repeat("a", 128), true},
+		{"dash and underscore", "key_01-abc", true},
+		{"too short", "abc1234", false},
+		{"too long", strings.Repeat("a", 129), false},
+		{"space", "key 0001", false},
+		{"colon", "key:0001", false},
+		{"non-ascii", "ключ-0001", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := Command{CommandID: "c", IdempotencyKey: tc.key, Type: CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{}`)}
+			err := ValidateCommand(cmd)
+			if tc.ok {
+				if err != nil {
+					t.Fatalf("expected key %q to be valid: %v", tc.key, err)
+				}
+				return
+			}
+			apiErr, ok := err.(APIError)
+			if !ok || apiErr.Code != ErrValidationInvalidRequest {
+				t.Fatalf("expected %s for key %q, got %v", ErrValidationInvalidRequest, tc.key, err)
+			}
+		})
+	}
+}
+
+func TestValidateCommandPriorityRange(t *testing.T) {
+	now := time.Now().UTC()
+	for _, tc := range []struct {
+		priority int
+		ok       bool
+	}{{PriorityNormal, true}, {PriorityHigh, true}, {MaxPriority, true}, {-1, false}, {MaxPriority + 1, false}} {
+		cmd := Command{CommandID: "c", IdempotencyKey: "key-prio", Type: CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{}`), Priority: tc.priority}
+		err := ValidateCommand(cmd)
+		if tc.ok != (err == nil) {
+			t.Fatalf("priority %d: expected ok=%v, got %v", tc.priority, tc.ok, err)
+		}
+		if apiErr, isAPI := err.(APIError); err != nil && (!isAPI || apiErr.Code != ErrValidationInvalidRequest) {
+			t.Fatalf("priority %d: expected %s, got %v", tc.priority, ErrValidationInvalidRequest, err)
+		}
+	}
+}
+
+func TestValidateRunTaskAttachments(t *testing.T) {
+	now := time.Now().UTC()
+	content := base64.StdEncoding.EncodeToString([]byte("log line\n"))
+	cases := []struct {
+		name        string
+		attachments []Attachment
+		ok          bool
+	}{
+		{"none", nil, true},
+		{"plain file", []Attachment{{Name: "build.log", ContentBase64: content}}, true},
+		{"two files", []Attachment{{Name: "a.diff", ContentBase64: content}, {Name: "b.diff", ContentBase64: content}}, true},
+		{"empty name", []Attachment{{Name: " ", ContentBase64: content}}, false},
+		{"parent dir", []Attachment{{Name: "..", ContentBase64: content}}, false},
+		{"traversal", []Attachment{{Name: "../etc/passwd", ContentBase64: content}}, false},
+		{"backslash", []Attachment{{Name: "..\\evil", ContentBase64: content}}, false},
+		{"absolute", []Attachment{{Name: "/tmp/x", ContentBase64: content}}, false},
+		{"duplicate", []Attachment{{Name: "a.txt", ContentBase64: content}, {Name: "a.txt", ContentBase64: content}}, false},
+		{"bad base64", []Attachment{{Name: "a.txt", ContentBase64: "!!!"}}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			payload, _ := json.Marshal(RunTaskPayload{ProjectID: "p1", Prompt: "look", Attachments: tc.attachments})
+			err := ValidateCommand(Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeRunTask, CreatedAt: now, Payload: payload})
+			if tc.ok {
+				if err != nil {
+					t.Fatalf("expected attachments to be valid: %v", err)
+				}
+				return
+			}
+			apiErr, ok := err.(APIError)
+			if !ok || apiErr.Code != ErrValidationInvalidPayload {
+				t.Fatalf("expected %s, got %v", ErrValidationInvalidPayload, err)
+			}
+		})
+	}
+}
+
+func TestValidateRegisterProjectEnv(t *testing.T) {
+	now := time.Now().UTC()
+	tooMany := make(map[string]string, MaxProjectEnvVars+1)
+	for i := 0; i <= MaxProjectEnvVars; i++ {
+		tooMany["VAR_"+strings.Repeat("X", i)] = "1"
+	}
+	cases := []struct {
+		name string
+		env  map[string]string
+		ok   bool
+	}{
+		{"none", nil, true},
+		{"plain keys", map[string]string{"API_KEY": "secret", "_PATH2": "/opt/bin"}, true},
+		{"leading digit", map[string]string{"1KEY": "x"}, false},
+		{"equals sign", map[string]string{"A=B": "x"}, false},
+		{"empty key", map[string]string{"": "x"}, false},
+		{"nul value", map[string]string{"KEY": "a\x00b"}, false},
+		{"too many", tooMany, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			payload, _ := json.Marshal(RegisterProjectPayload{ProjectPathRaw: "/tmp/p", Env: tc.env})
+			err := ValidateCommand(Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeRegisterProject, CreatedAt: now, Payload: payload})
+			if tc.ok {
+				if err != nil {
+					t.Fatalf("expected env to be valid: %v", err)
+				}
+				return
+			}
+			apiErr, ok := err.(APIError)
+			if !ok || apiErr.Code != ErrValidationInvalidPayload {
+				t.Fatalf("expected %s, got %v", ErrValidationInvalidPayload, err)
+			}
+		})
+	}
+}
+
+func TestValidateCommandAtFreshness(t *testing.T) {
+	now := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
+	window := FreshnessWindow{MaxAge: 10 * time.Minute, MaxFutureSkew: 2 * time.Minute}
+	cmdAt := func(createdAt time.Time) Command {
+		return Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeStatus, CreatedAt: createdAt, Payload: json.RawMessage(`{}`)}
+	}
+
+	for _, createdAt := range []time.Time{now, now.Add(-10 * time.Minute), now.Add(2 * time.Minute)} {
+		if err := ValidateCommandAt(cmdAt(createdAt), now, window); err != nil {
+			t.Fatalf("expected %s to be fresh: %v", createdAt, err)
+		}
+	}
+	for _, createdAt := range []time.Time{now.Add(-11 * time.Minute), now.Add(3 * time.Minute)} {
+		err := ValidateCommandAt(cmdAt(createdAt), now, window)
+		apiErr, ok := err.(APIError)
+		if !ok || apiErr.Code != ErrValidationInvalidRequest {
+			t.Fatalf("expected %s for created_at %s, got %v", ErrValidationInvalidRequest, createdAt, err)
+		}
+	}
+	if err := ValidateCommandAt(cmdAt(now.Add(-48*time.Hour)), now, FreshnessWindow{}); err != nil {
+		t.Fatalf("expected zero window to disable the check: %v", err)
+	}
+	if err := ValidateCommandAt(Command{CommandID: "c", CreatedAt: now}, now, window); err == nil {
+		t.Fatal("expected envelope validation to still apply")
+	}
+}
+
+func TestFreshnessWindowFromEnv(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(name string) string { return env[name] }
+	window, err := FreshnessWindowFromEnv(getenv)
+	if err != nil || window != DefaultFreshnessWindow || window.MaxAge != 0 {
+		t.Fatalf("expected default window without max age, got %+v err=%v", window, err)
+	}
+	env["OCT_COMMAND_MAX_AGE"] = "24h"
+	env["OCT_COMMAND_MAX_FUTURE_SKEW"] = "0"
+	window, err = FreshnessWindowFromEnv(getenv)
+	if err != nil || window.MaxAge != 24*time.Hour || window.MaxFutureSkew != 0 {
+		t.Fatalf("expected overrides applied, got %+v err=%v", window, err)
+	}
+	env["OCT_COMMAND_MAX_AGE"] = "soon"
+	if _, err := FreshnessWindowFromEnv(getenv); err == nil || !strings.Contains(err.Error(), "OCT_COMMAND_MAX_AGE") {
+		t.Fatalf("expected error naming the variable, got %v", err)
+	}
+}
+
+func TestCommandResultExitCodeOmitEmpty(t *testing.T) {
+	b, err := json.Marshal(CommandResult{CommandID: "c1", OK: true})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if string(b) != `{"command_id":"c1","ok":true}` {
+		t.Fatalf("expected unchanged payload without exit code, got %s", b)
+	}
+	code := 1
+	b, _ = json.Marshal(CommandResult{CommandID: "c1", ExitCode: &code})
+	if !strings.Contains(string(b), `"exit_code":1`) {
+		t.Fatalf("expected exit_code in payload, got %s", b)
+	}
+}
+
+func TestValidateBranchName(t *testing.T) {
+	for _, name := range []string{"main", "feature/x", "release-1.2", "v2_fix"} {
+		if err := ValidateBranchName(name); err != nil {
+			t.Fatalf("expected %q accepted: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "-b", "--orphan", "a..b", "a//b", "topic/", "topic.", "topic.lock", "a b", "a~1", "a^", "x:y", "@{-1}"} {
+		if err := ValidateBranchName(name); err == nil {
+			t.Fatalf("expected %q rejected", name)
+		}
+	}
+}
+
+func TestValidateCommandRequiredFieldNamesField(t *testing.T) {
+	now := time.Now().UTC()
+	cases := []struct {
+		field string
+		cmd   Command
+	}{
+		{"command_id", Command{IdempotencyKey: "k-000000", Type: CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{}`)}},
+		{"idempotency_key", Command{CommandID: "c", Type: CommandTypeStatus, CreatedAt: now, Payload: json.RawMessage(`{}`)}},
+		{"created_at", Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeStatus, Payload: json.RawMessage(`{}`)}},
+		{"project_path_raw", Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeRegisterProject, CreatedAt: now, Payload: json.RawMessage(`{"project_path_raw":" "}`)}},
+		{"project_id", Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeApplyProjectPolicy, CreatedAt: now, Payload: json.RawMessage(`{"decision":"ALLOW"}`)}},
+		{"project_id", Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeStartServer, CreatedAt: now, Payload: json.RawMessage(`{}`)}},
+		{"project_id", Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeStopServer, CreatedAt: now, Payload: json.RawMessage(`{}`)}},
+		{"project_id", Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeRunTask, CreatedAt: now, Payload: json.RawMessage(`{"prompt":"hi"}`)}},
+		{"prompt", Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeRunTask, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1"}`)}},
+		{"command_id", Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeCancelTask, CreatedAt: now, Payload: json.RawMessage(`{}`)}},
+		{"project_id", Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeAbortSession, CreatedAt: now, Payload: json.RawMessage(`{"session_id":"ses_1"}`)}},
+		{"session_id", Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeAbortSession, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1"}`)}},
+	}
+	for _, tc := range cases {
+		var apiErr APIError
+		if err := ValidateCommand(tc.cmd); !errors.As(err, &apiErr) || apiErr.Code != ErrValidationRequiredField || apiErr.Field != tc.field {
+			t.Fatalf("%s: expected required %s, got %+v", tc.cmd.Type, tc.field, err)
+		}
+	}
+
+	var apiErr APIError
+	err := ValidateCommand(Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeRunTask, CreatedAt: now, Payload: json.RawMessage(`{"project_id":"p1","prompt":"` + strings.Repeat("x", MaxPromptBytes+1) + `"}`)})
+	if !errors.As(err, &apiErr) || apiErr.Field != "prompt" || apiErr.Details["max_bytes"] != MaxPromptBytes {
+		t.Fatalf("expected prompt size error with limit, got %+v", err)
+	}
+
+	// unset Field and Details stay out of the JSON body
+	data, _ := json.Marshal(APIError{Code: ErrInternal, Message: "boom"})
+	if strings.Contains(string(data), "field") || strings.Contains(string(data), "details") {
+		t.Fatalf("expected field and details omitted, got %s", data)
+	}
+}
+
+func TestValidateRunTaskPrompts(t *testing.T) {
+	now := time.Now().UTC()
+	validate := func(p RunTaskPayload) error {
+		p.ProjectID = "p1"
+		payload, _ := json.Marshal(p)
+		return ValidateCommand(Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeRunTask, CreatedAt: now, Payload: payload})
+	}
+	if err := validate(RunTaskPayload{Prompts: []string{"one", "two"}}); err != nil {
+		t.Fatalf("expected batch accepted, got %v", err)
+	}
+	many := make([]string, MaxBatchPrompts+1)
+	for i := range many {
+		many[i] = "p"
+	}
+	for name, p := range map[string]RunTaskPayload{
+		"both set":  {Prompt: "one", Prompts: []string{"two"}},
+		"blank":     {Prompts: []string{"one", " "}},
+		"too many":  {Prompts: many},
+		"too large": {Prompts: []string{strings.Repeat("x", MaxPromptBytes+1)}},
+	} {
+		var apiErr APIError
+		if err := validate(p); !errors.As(err, &apiErr) || apiErr.Code != ErrValidationInvalidPayload || apiErr.Field != "prompts" {
+			t.Fatalf("%s: expected invalid prompts, got %v", name, err)
+		}
+	}
+}
+
+func TestValidateTimeoutSecondsNotNegative(t *testing.T) {
+	now := time.Now().UTC()
+	run, _ := json.Marshal(RunTaskPayload{ProjectID: "p1", Prompt: "look", TimeoutSeconds: -1})
+	start, _ := json.Marshal(StartServerPayload{ProjectID: "p1", TimeoutSeconds: -1})
+	for typ, payload := range map[string]json.RawMessage{CommandTypeRunTask: run, CommandTypeStartServer: start} {
+		var apiErr APIError
+		err := ValidateCommand(Command{CommandID: "c", IdempotencyKey: "k-000000", Type: typ, CreatedAt: now, Payload: payload})
+		if !errors.As(err, &apiErr) || apiErr.Code != ErrValidationInvalidPayload || apiErr.Field != "timeout_seconds" {
+			t.Fatalf("%s: expected negative timeout rejected, got %v", typ, err)
+		}
+	}
+	ok, _ := json.Marshal(RunTaskPayload{ProjectID: "p1", Prompt: "look", TimeoutSeconds: 30})
+	if err := ValidateCommand(Command{CommandID: "c", IdempotencyKey: "k-000000", Type: CommandTypeRunTask, CreatedAt: now, Payload: ok}); err != nil {
+		t.Fatalf("expected timeout accepted, got %v", err)
+	}
+}
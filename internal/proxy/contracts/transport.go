@@ -0,0 +1,63 @@
+package contracts
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Connection pool defaults for HTTP clients that talk to the backend.
+const (
+	DefaultMaxIdleConnsPerHost = 32
+	DefaultIdleConnTimeout     = 90 * time.Second
+)
+
+// NewHTTPTransport returns a keep-alive transport that keeps up to
+// maxIdlePerHost idle connections per host and closes them after
+// idleTimeout, so bursts of backend calls reuse connections instead of
+// dialing each time. Values below 1 use the defaults.
+func NewHTTPTransport(maxIdlePerHost int, idleTimeout time.Duration) *http.Transport {
+	if maxIdlePerHost <= 0 {
+		maxIdlePerHost = DefaultMaxIdleConnsPerHost
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleConnTimeout
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DisableKeepAlives = false
+	t.MaxIdleConnsPerHost = maxIdlePerHost
+	if t.MaxIdleConns < maxIdlePerHost {
+		t.MaxIdleConns = maxIdlePerHost
+	}
+	t.IdleConnTimeout = idleTimeout
+	return t
+}
+
+// CheckSecureURL rejects base URLs that would send agent keys and prompts in
+// plaintext over a network: anything but https, unless the host is localhost
+// or a loopback address.
+func CheckSecureURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q is not an absolute URL", raw)
+	}
+	if u.Scheme == "https" || isLoopbackHost(u.Hostname()) {
+		return nil
+	}
+	return fmt.Errorf("%q must use https unless it points at localhost", raw)
+}
+
+func isLoopbackHost(host string) bool {
+	host = strings.ToLower(host)
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,91 @@
+package contracts
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingServer returns a server that counts the connections clients open.
+func countingServer(tb testing.TB) (*httptest.Server, *int64) {
+	var conns int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.Start()
+	tb.Cleanup(srv.Close)
+	return srv, &conns
+}
+
+func getAndDrain(tb testing.TB, client *http.Client, url string) {
+	resp, err := client.Get(url)
+	if err != nil {
+		tb.Fatalf("get: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func TestNewHTTPTransport(t *testing.T) {
+	tr := NewHTTPTransport(0, 0)
+	if tr.MaxIdleConnsPerHost != DefaultMaxIdleConnsPerHost || tr.IdleConnTimeout != DefaultIdleConnTimeout || tr.DisableKeepAlives {
+		t.Fatalf("expected defaults, got per-host=%d idle=%v keepalives-off=%v", tr.MaxIdleConnsPerHost, tr.IdleConnTimeout, tr.DisableKeepAlives)
+	}
+	tr = NewHTTPTransport(500, time.Minute)
+	if tr.MaxIdleConnsPerHost != 500 || tr.MaxIdleConns < 500 || tr.IdleConnTimeout != time.Minute {
+		t.Fatalf("expected tuned transport, got per-host=%d total=%d idle=%v", tr.MaxIdleConnsPerHost, tr.MaxIdleConns, tr.IdleConnTimeout)
+	}
+
+	srv, conns := countingServer(t)
+	client := &http.Client{Transport: NewHTTPTransport(0, 0)}
+	for i := 0; i < 20; i++ {
+		getAndDrain(t, client, srv.URL)
+	}
+	if n := atomic.LoadInt64(conns); n != 1 {
+		t.Fatalf("expected sequential requests to share one connection, got %d", n)
+	}
+}
+
+func BenchmarkHTTPTransportReuse(b *testing.B) {
+	run := func(b *testing.B, tr *http.Transport) {
+		srv, conns := countingServer(b)
+		client := &http.Client{Transport: tr}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			getAndDrain(b, client, srv.URL)
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(atomic.LoadInt64(conns))/float64(b.N), "conns/op")
+		tr.CloseIdleConnections()
+	}
+	b.Run("pooled", func(b *testing.B) {
+		run(b, NewHTTPTransport(0, 0))
+	})
+	b.Run("no-keepalive", func(b *testing.B) {
+		tr := NewHTTPTransport(0, 0)
+		tr.DisableKeepAlives = true
+		run(b, tr)
+	})
+}
+
+func TestCheckSecureURL(t *testing.T) {
+	for _, raw := range []string{"https://backend.example.com", "http://localhost:8080", "http://LOCALHOST", "http://api.localhost:3000", "http://127.0.0.1:4096", "http://127.1.2.3", "http://[::1]:8080"} {
+		if err := CheckSecureURL(raw); err != nil {
+			t.Fatalf("expected %q accepted, got %v", raw, err)
+		}
+	}
+	for _, raw := range []string{"http://backend.example.com", "http://10.0.0.5:8080", "http://localhost.evil.com", "ws://remote:1", "backend:8080", "::bad"} {
+		if err := CheckSecureURL(raw); err == nil {
+			t.Fatalf("expected %q rejected", raw)
+		}
+	}
+}
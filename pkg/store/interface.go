@@ -1,5 +1,8 @@
 package store
 
+// MaxRecentSessions bounds how many sessions ListUserSessions returns.
+const MaxRecentSessions = 5
+
 // Store defines the interface for session persistence
 type Store interface {
 	SetSession(sessionID string, chatID int64, messageID int) error
@@ -9,10 +12,19 @@ type Store interface {
 	SetUserSession(userID int64, sessionID string) error
 	GetUserSession(userID int64) (sessionID string, ok bool)
 	DeleteUserSession(userID int64) error
-	// Agent key management for backend pairing
+	// ListUserSessions returns the user's most recently selected sessions,
+	// most recent first and at most MaxRecentSessions
+	ListUserSessions(userID int64) []string
+	// Agent key management for backend pairing; an empty key clears it
 	SetUserAgentKey(userID int64, agentKey string) error
 	GetUserAgentKey(userID int64) (agentKey string, ok bool)
-	// Pairing code management
+	// Pairing code management; an empty code clears it
 	SetPairingCode(telegramUserID string, code string) error
 	GetPairingCode(telegramUserID string) (code string, ok bool)
+	// Per-user model override for runs; empty model clears it
+	SetUserModel(userID int64, model string) error
+	GetUserModel(userID int64) (model string, ok bool)
+	// KnownUsers lists users that currently have a selected session or an
+	// agent key, in ascending order
+	KnownUsers() ([]int64, error)
 }
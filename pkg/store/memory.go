@@ -1,6 +1,9 @@
 package store
 
-import "sync"
+import (
+	"sort"
+	"sync"
+)
 
 // MemoryStore is a simple in-memory implementation of Store for session -> telegram message mapping
 type MemoryStore struct {
@@ -8,10 +11,14 @@ type MemoryStore struct {
 	m  map[string]sessionRef
 	// per-user selection: map[userID]sessionID
 	um map[int64]string
+	// recently selected sessions per user, most recent first
+	rs map[int64][]string
 	// agent key management: map[userID]agentKey
 	ak map[int64]string
 	// pairing code management: map[telegramUserID]code
 	pc map[string]string
+	// model selection: map[userID]model
+	md map[int64]string
 }
 
 type sessionRef struct {
@@ -20,7 +27,7 @@ type sessionRef struct {
 }
 
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{m: make(map[string]sessionRef), um: make(map[int64]string), ak: make(map[int64]string), pc: make(map[string]string)}
+	return &MemoryStore{m: make(map[string]sessionRef), um: make(map[int64]string), rs: make(map[int64][]string), ak: make(map[int64]string), pc: make(map[string]string), md: make(map[int64]string)}
 }
 
 func (s *MemoryStore) SetSession(sessionID string, chatID int64, messageID int) error {
@@ -50,6 +57,9 @@ func (s *MemoryStore) DeleteSession(sessionID string) error {
 			delete(s.um, uid)
 		}
 	}
+	for uid, recent := range s.rs {
+		s.rs[uid] = removeSession(recent, sessionID)
+	}
 	return nil
 }
 
@@ -57,6 +67,7 @@ func (s *MemoryStore) SetUserSession(userID int64, sessionID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.um[userID] = sessionID
+	s.rs[userID] = pushRecent(s.rs[userID], sessionID)
 	return nil
 }
 
@@ -67,6 +78,12 @@ func (s *MemoryStore) GetUserSession(userID int64) (string, bool) {
 	return sid, ok
 }
 
+func (s *MemoryStore) ListUserSessions(userID int64) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.rs[userID]...)
+}
+
 func (s *MemoryStore) DeleteUserSession(userID int64) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -77,6 +94,10 @@ func (s *MemoryStore) DeleteUserSession(userID int64) error {
 func (s *MemoryStore) SetUserAgentKey(userID int64, agentKey string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if agentKey == "" {
+		delete(s.ak, userID)
+		return nil
+	}
 	s.ak[userID] = agentKey
 	return nil
 }
@@ -91,6 +112,10 @@ func (s *MemoryStore) GetUserAgentKey(userID int64) (string, bool) {
 func (s *MemoryStore) SetPairingCode(telegramUserID string, code string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if code == "" {
+		delete(s.pc, telegramUserID)
+		return nil
+	}
 	s.pc[telegramUserID] = code
 	return nil
 }
@@ -101,3 +126,64 @@ func (s *MemoryStore) GetPairingCode(telegramUserID string) (string, bool) {
 	code, ok := s.pc[telegramUserID]
 	return code, ok
 }
+
+func (s *MemoryStore) SetUserModel(userID int64, model string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if model == "" {
+		delete(s.md, userID)
+		return nil
+	}
+	s.md[userID] = model
+	return nil
+}
+
+func (s *MemoryStore) GetUserModel(userID int64) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	model, ok := s.md[userID]
+	return model, ok
+}
+
+func (s *MemoryStore) KnownUsers() ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := make(map[int64]bool, len(s.um)+len(s.ak))
+	users := make([]int64, 0, len(s.um)+len(s.ak))
+	for _, m := range []map[int64]string{s.um, s.ak} {
+		for userID := range m {
+			if !seen[userID] {
+				seen[userID] = true
+				users = append(users, userID)
+			}
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+	return users, nil
+}
+
+// pushRecent moves sessionID to the front of recent and drops entries past
+// MaxRecentSessions.
+func pushRecent(recent []string, sessionID string) []string {
+	out := make([]string, 0, MaxRecentSessions)
+	out = append(out, sessionID)
+	for _, sid := range recent {
+		if len(out) == MaxRecentSessions {
+			break
+		}
+		if sid != sessionID {
+			out = append(out, sid)
+		}
+	}
+	return out
+}
+
+func removeSession(recent []string, sessionID string) []string {
+	out := recent[:0]
+	for _, sid := range recent {
+		if sid != sessionID {
+			out = append(out, sid)
+		}
+	}
+	return out
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -90,6 +91,12 @@ func TestMemoryStore_AgentKeyManagement(t *testing.T) {
 	if ok {
 		t.Fatalf("expected no agent key for non-existent user")
 	}
+
+	// Empty key clears it
+	_ = s.SetUserAgentKey(uid, "")
+	if _, ok := s.GetUserAgentKey(uid); ok {
+		t.Fatalf("expected agent key cleared")
+	}
 }
 
 func TestMemoryStore_PairingCodeManagement(t *testing.T) {
@@ -111,4 +118,65 @@ func TestMemoryStore_PairingCodeManagement(t *testing.T) {
 	if ok {
 		t.Fatalf("expected no pairing code for non-existent user")
 	}
+
+	// Empty code clears it
+	_ = s.SetPairingCode(telegramUserID, "")
+	if _, ok := s.GetPairingCode(telegramUserID); ok {
+		t.Fatalf("expected pairing code cleared")
+	}
+}
+
+func TestMemoryStore_UserModel(t *testing.T) {
+	s := NewMemoryStore()
+	if _, ok := s.GetUserModel(1); ok {
+		t.Fatalf("expected no model before set")
+	}
+	if err := s.SetUserModel(1, "anthropic/claude-sonnet"); err != nil {
+		t.Fatalf("SetUserModel error: %v", err)
+	}
+	if got, ok := s.GetUserModel(1); !ok || got != "anthropic/claude-sonnet" {
+		t.Fatalf("GetUserModel unexpected: got %q ok=%v", got, ok)
+	}
+	if err := s.SetUserModel(1, ""); err != nil {
+		t.Fatalf("SetUserModel clear error: %v", err)
+	}
+	if _, ok := s.GetUserModel(1); ok {
+		t.Fatalf("expected model cleared")
+	}
+}
+
+func TestMemoryStore_KnownUsers(t *testing.T) {
+	s := NewMemoryStore()
+	_ = s.SetUserSession(3, "ses_1")
+	_ = s.SetUserAgentKey(1, "key-1")
+	_ = s.SetUserAgentKey(3, "key-3")
+	_ = s.SetUserModel(5, "anthropic/claude-sonnet")
+	users, err := s.KnownUsers()
+	if err != nil || len(users) != 2 || users[0] != 1 || users[1] != 3 {
+		t.Fatalf("expected users [1 3], got %v err=%v", users, err)
+	}
+}
+
+func TestMemoryStore_ListUserSessions(t *testing.T) {
+	s := NewMemoryStore()
+	if got := s.ListUserSessions(1); len(got) != 0 {
+		t.Fatalf("expected no recent sessions, got %v", got)
+	}
+	for _, sid := range []string{"ses_1", "ses_2", "ses_3", "ses_4", "ses_5", "ses_6", "ses_3"} {
+		_ = s.SetUserSession(1, sid)
+	}
+	got := s.ListUserSessions(1)
+	want := []string{"ses_3", "ses_6", "ses_5", "ses_4", "ses_2"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if other := s.ListUserSessions(2); len(other) != 0 {
+		t.Fatalf("expected recent sessions to be per user, got %v", other)
+	}
+
+	_ = s.DeleteSession("ses_6")
+	got = s.ListUserSessions(1)
+	if strings.Join(got, ",") != "ses_3,ses_5,ses_4,ses_2" {
+		t.Fatalf("expected deleted session dropped, got %v", got)
+	}
 }
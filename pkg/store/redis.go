@@ -0,0 +1,269 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redisKeyPrefix = "oct:store:"
+
+// maxSessionUsers bounds the users remembered per session for DeleteSession.
+const maxSessionUsers = 100
+
+// selectSessionScript stores the user's selected session (KEYS[1]) and moves
+// it to the front of the recent sessions (KEYS[2]), the user to the front of
+// the session's users (KEYS[3]) and of the users list (KEYS[4]). ARGV holds
+// the session ID, the user ID, the TTL in milliseconds (0 keeps the keys)
+// and the limits of the recent and session users lists. The users list is
+// not expired, since it also indexes agent keys, which never expire. One
+// script keeps concurrent selections from losing each other's updates.
+const selectSessionScript = `
+local ttl = tonumber(ARGV[3])
+local function push(key, value, limit, expire)
+  redis.call('LREM', key, 0, value)
+  redis.call('LPUSH', key, value)
+  if limit > 0 then
+    redis.call('LTRIM', key, 0, limit - 1)
+  end
+  if expire and ttl > 0 then
+    redis.call('PEXPIRE', key, ttl)
+  end
+end
+if ttl > 0 then
+  redis.call('SET', KEYS[1], ARGV[1], 'PX', ttl)
+else
+  redis.call('SET', KEYS[1], ARGV[1])
+end
+push(KEYS[2], ARGV[1], tonumber(ARGV[4]), true)
+push(KEYS[3], ARGV[2], tonumber(ARGV[5]), true)
+push(KEYS[4], ARGV[2], 0, false)
+return 1
+`
+
+// rememberUserScript moves the user ID (ARGV[1]) to the front of the users
+// list (KEYS[1]), so each user is listed once.
+const rememberUserScript = `
+redis.call('LREM', KEYS[1], 0, ARGV[1])
+redis.call('LPUSH', KEYS[1], ARGV[1])
+return 1
+`
+
+// forgetSessionScript drops the session ID (ARGV[1]) from a user's recent
+// sessions (KEYS[2]) and clears the user's selection (KEYS[1]) if it still
+// points to that session.
+const forgetSessionScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  redis.call('DEL', KEYS[1])
+end
+redis.call('LREM', KEYS[2], 0, ARGV[1])
+return 1
+`
+
+// RedisClient is the subset of Redis operations RedisStore needs. The
+// backend's RedisClient implementations satisfy it.
+type RedisClient interface {
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+	Get(ctx context.Context, key string) (string, error)
+	Del(ctx context.Context, keys ...string) error
+	LRange(ctx context.Context, key string, start, stop int64) ([]string, error)
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// RedisStore is a Store backed by Redis so session mappings survive bot restarts.
+type RedisStore struct {
+	client     RedisClient
+	sessionTTL time.Duration
+}
+
+func NewRedisStore(client RedisClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// SetSessionTTL expires session -> message mappings, user selections and
+// the recent and per-session user lists ttl after their last write; zero
+// keeps them forever. Agent keys, pairing codes and models never expire.
+func (s *RedisStore) SetSessionTTL(ttl time.Duration) {
+	if ttl < 0 {
+		ttl = 0
+	}
+	s.sessionTTL = ttl
+}
+
+func (s *RedisStore) sessionKey(sessionID string) string {
+	return redisKeyPrefix + "session:" + sessionID
+}
+
+// sessionUsersKey lists users that selected a session, so DeleteSession can clear their selection.
+func (s *RedisStore) sessionUsersKey(sessionID string) string {
+	return redisKeyPrefix + "session_users:" + sessionID
+}
+
+func (s *RedisStore) userSessionKey(userID int64) string {
+	return redisKeyPrefix + "user_session:" + strconv.FormatInt(userID, 10)
+}
+
+// recentSessionsKey lists the user's recently selected sessions, most
+// recent first.
+func (s *RedisStore) recentSessionsKey(userID int64) string {
+	return redisKeyPrefix + "recent_sessions:" + strconv.FormatInt(userID, 10)
+}
+
+func (s *RedisStore) agentKeyKey(userID int64) string {
+	return redisKeyPrefix + "agent_key:" + strconv.FormatInt(userID, 10)
+}
+
+func (s *RedisStore) pairingCodeKey(telegramUserID string) string {
+	return redisKeyPrefix + "pairing_code:" + telegramUserID
+}
+
+func (s *RedisStore) userModelKey(userID int64) string {
+	return redisKeyPrefix + "user_model:" + strconv.FormatInt(userID, 10)
+}
+
+// usersKey lists every user that ever selected a session or stored an agent
+// key, once each.
+func (s *RedisStore) usersKey() string {
+	return redisKeyPrefix + "users"
+}
+
+func (s *RedisStore) get(key string) (string, bool) {
+	val, err := s.client.Get(context.Background(), key)
+	if err != nil {
+		return "", false
+	}
+	return val, true
+}
+
+func (s *RedisStore) SetSession(sessionID string, chatID int64, messageID int) error {
+	return s.client.Set(context.Background(), s.sessionKey(sessionID), fmt.Sprintf("%d:%d", chatID, messageID), s.sessionTTL)
+}
+
+func (s *RedisStore) GetSession(sessionID string) (int64, int, bool) {
+	val, ok := s.get(s.sessionKey(sessionID))
+	if !ok {
+		return 0, 0, false
+	}
+	chatRaw, msgRaw, found := strings.Cut(val, ":")
+	if !found {
+		return 0, 0, false
+	}
+	chatID, err := strconv.ParseInt(chatRaw, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	messageID, err := strconv.Atoi(msgRaw)
+	if err != nil {
+		return 0, 0, false
+	}
+	return chatID, messageID, true
+}
+
+func (s *RedisStore) DeleteSession(sessionID string) error {
+	ctx := context.Background()
+	users, err := s.client.LRange(ctx, s.sessionUsersKey(sessionID), 0, -1)
+	if err != nil {
+		return err
+	}
+	// also remove any user selections that still point to this session
+	for _, raw := range users {
+		userID, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			continue
+		}
+		keys := []string{s.userSessionKey(userID), s.recentSessionsKey(userID)}
+		if _, err := s.client.Eval(ctx, forgetSessionScript, keys, sessionID); err != nil {
+			return err
+		}
+	}
+	return s.client.Del(ctx, s.sessionKey(sessionID), s.sessionUsersKey(sessionID))
+}
+
+func (s *RedisStore) SetUserSession(userID int64, sessionID string) error {
+	keys := []string{s.userSessionKey(userID), s.recentSessionsKey(userID), s.sessionUsersKey(sessionID), s.usersKey()}
+	_, err := s.client.Eval(context.Background(), selectSessionScript, keys,
+		sessionID, strconv.FormatInt(userID, 10), s.sessionTTL.Milliseconds(), MaxRecentSessions, maxSessionUsers)
+	return err
+}
+
+func (s *RedisStore) GetUserSession(userID int64) (string, bool) {
+	return s.get(s.userSessionKey(userID))
+}
+
+func (s *RedisStore) ListUserSessions(userID int64) []string {
+	recent, err := s.client.LRange(context.Background(), s.recentSessionsKey(userID), 0, MaxRecentSessions-1)
+	if err != nil || len(recent) == 0 {
+		return nil
+	}
+	return recent
+}
+
+func (s *RedisStore) DeleteUserSession(userID int64) error {
+	return s.client.Del(context.Background(), s.userSessionKey(userID))
+}
+
+func (s *RedisStore) SetUserAgentKey(userID int64, agentKey string) error {
+	if agentKey == "" {
+		return s.client.Del(context.Background(), s.agentKeyKey(userID))
+	}
+	ctx := context.Background()
+	if err := s.client.Set(ctx, s.agentKeyKey(userID), agentKey, 0); err != nil {
+		return err
+	}
+	_, err := s.client.Eval(ctx, rememberUserScript, []string{s.usersKey()}, strconv.FormatInt(userID, 10))
+	return err
+}
+
+func (s *RedisStore) GetUserAgentKey(userID int64) (string, bool) {
+	return s.get(s.agentKeyKey(userID))
+}
+
+func (s *RedisStore) SetPairingCode(telegramUserID string, code string) error {
+	if code == "" {
+		return s.client.Del(context.Background(), s.pairingCodeKey(telegramUserID))
+	}
+	return s.client.Set(context.Background(), s.pairingCodeKey(telegramUserID), code, 0)
+}
+
+func (s *RedisStore) GetPairingCode(telegramUserID string) (string, bool) {
+	return s.get(s.pairingCodeKey(telegramUserID))
+}
+
+func (s *RedisStore) SetUserModel(userID int64, model string) error {
+	if model == "" {
+		return s.client.Del(context.Background(), s.userModelKey(userID))
+	}
+	return s.client.Set(context.Background(), s.userModelKey(userID), model, 0)
+}
+
+func (s *RedisStore) GetUserModel(userID int64) (string, bool) {
+	return s.get(s.userModelKey(userID))
+}
+
+// KnownUsers reads the users list and keeps those that still have a selected
+// session or an agent key.
+func (s *RedisStore) KnownUsers() ([]int64, error) {
+	raw, err := s.client.LRange(context.Background(), s.usersKey(), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int64]bool, len(raw))
+	users := make([]int64, 0, len(raw))
+	for _, item := range raw {
+		userID, err := strconv.ParseInt(item, 10, 64)
+		if err != nil || seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		_, hasSession := s.GetUserSession(userID)
+		_, hasKey := s.GetUserAgentKey(userID)
+		if hasSession || hasKey {
+			users = append(users, userID)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+	return users, nil
+}
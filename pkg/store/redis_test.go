@@ -0,0 +1,320 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a minimal in-memory RedisClient with controllable time.
+type fakeRedis struct {
+	mu       sync.Mutex
+	values   map[string]string
+	lists    map[string][]string
+	expiries map[string]time.Time
+	now      time.Time
+	lastTTL  map[string]time.Duration
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{
+		values:   make(map[string]string),
+		lists:    make(map[string][]string),
+		expiries: make(map[string]time.Time),
+		lastTTL:  make(map[string]time.Duration),
+		now:      time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC),
+	}
+}
+
+func (f *fakeRedis) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[key] = fmt.Sprintf("%v", value)
+	f.lastTTL[key] = expiration
+	delete(f.expiries, key)
+	if expiration > 0 {
+		f.expiries[key] = f.now.Add(expiration)
+	}
+	return nil
+}
+
+// expireLocked drops key once its expiry has passed.
+func (f *fakeRedis) expireLocked(key string) {
+	if exp, ok := f.expiries[key]; ok && !f.now.Before(exp) {
+		delete(f.values, key)
+		delete(f.lists, key)
+		delete(f.expiries, key)
+	}
+}
+
+func (f *fakeRedis) Get(ctx context.Context, key string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expireLocked(key)
+	val, ok := f.values[key]
+	if !ok {
+		return "", errors.New("redis: nil")
+	}
+	return val, nil
+}
+
+func (f *fakeRedis) Del(ctx context.Context, keys ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range keys {
+		delete(f.values, key)
+		delete(f.lists, key)
+		delete(f.expiries, key)
+	}
+	return nil
+}
+
+func (f *fakeRedis) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expireLocked(key)
+	list := f.lists[key]
+	if stop < 0 || stop >= int64(len(list)) {
+		stop = int64(len(list)) - 1
+	}
+	if start > stop {
+		return nil, nil
+	}
+	return append([]string(nil), list[start:stop+1]...), nil
+}
+
+// Eval emulates the RedisStore scripts in Go under one lock, the way Redis
+// runs a script atomically.
+func (f *fakeRedis) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range keys {
+		f.expireLocked(key)
+	}
+	arg := func(i int) string { return fmt.Sprintf("%v", args[i]) }
+	expire := func(key string, ttl time.Duration) {
+		f.lastTTL[key] = ttl
+		delete(f.expiries, key)
+		if ttl > 0 {
+			f.expiries[key] = f.now.Add(ttl)
+		}
+	}
+	switch script {
+	case selectSessionScript:
+		ttl := time.Duration(args[2].(int64)) * time.Millisecond
+		f.values[keys[0]] = arg(0)
+		expire(keys[0], ttl)
+		f.pushLocked(keys[1], arg(0), args[3].(int))
+		expire(keys[1], ttl)
+		f.pushLocked(keys[2], arg(1), args[4].(int))
+		expire(keys[2], ttl)
+		f.pushLocked(keys[3], arg(1), 0)
+	case rememberUserScript:
+		f.pushLocked(keys[0], arg(0), 0)
+	case forgetSessionScript:
+		if f.values[keys[0]] == arg(0) {
+			delete(f.values, keys[0])
+		}
+		f.lists[keys[1]] = removeSession(f.lists[keys[1]], arg(0))
+	default:
+		return nil, errors.New("eval: unsupported script")
+	}
+	return int64(1), nil
+}
+
+// pushLocked moves value to the front of key's list, keeping at most limit
+// entries when limit is positive.
+func (f *fakeRedis) pushLocked(key, value string, limit int) {
+	list := append([]string{value}, removeSession(f.lists[key], value)...)
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	f.lists[key] = list
+}
+
+func TestRedisStore_Sessions(t *testing.T) {
+	rc := newFakeRedis()
+	s := NewRedisStore(rc)
+
+	if err := s.SetSession("ses_1", 123, 456); err != nil {
+		t.Fatalf("SetSession error: %v", err)
+	}
+	chat, msg, ok := s.GetSession("ses_1")
+	if !ok || chat != 123 || msg != 456 {
+		t.Fatalf("GetSession unexpected: (%d,%d) ok=%v", chat, msg, ok)
+	}
+	for key := range rc.values {
+		if !strings.HasPrefix(key, "oct:store:") {
+			t.Fatalf("expected namespaced key, got %q", key)
+		}
+	}
+
+	if err := s.SetUserSession(1, "ses_1"); err != nil {
+		t.Fatalf("SetUserSession error: %v", err)
+	}
+	if err := s.SetUserSession(2, "ses_1"); err != nil {
+		t.Fatalf("SetUserSession error: %v", err)
+	}
+	// user 2 moves on; deleting ses_1 must not clear the new selection
+	if err := s.SetUserSession(2, "ses_2"); err != nil {
+		t.Fatalf("SetUserSession error: %v", err)
+	}
+	if err := s.DeleteSession("ses_1"); err != nil {
+		t.Fatalf("DeleteSession error: %v", err)
+	}
+	if _, _, ok := s.GetSession("ses_1"); ok {
+		t.Fatal("expected session mapping removed")
+	}
+	if _, ok := s.GetUserSession(1); ok {
+		t.Fatal("expected user 1 selection cleared with its session")
+	}
+	if sid, ok := s.GetUserSession(2); !ok || sid != "ses_2" {
+		t.Fatalf("expected user 2 selection kept, got %q ok=%v", sid, ok)
+	}
+	if err := s.DeleteUserSession(2); err != nil {
+		t.Fatalf("DeleteUserSession error: %v", err)
+	}
+	if _, ok := s.GetUserSession(2); ok {
+		t.Fatal("expected user 2 selection deleted")
+	}
+
+	rc.values[s.sessionKey("bad")] = "not-a-ref"
+	if _, _, ok := s.GetSession("bad"); ok {
+		t.Fatal("expected malformed mapping to be ignored")
+	}
+}
+
+func TestRedisStore_SessionTTL(t *testing.T) {
+	rc := newFakeRedis()
+	s := NewRedisStore(rc)
+	s.SetSessionTTL(time.Hour)
+
+	_ = s.SetSession("ses_ttl", 1, 2)
+	_ = s.SetUserAgentKey(1, "key")
+	if rc.lastTTL[s.sessionKey("ses_ttl")] != time.Hour {
+		t.Fatalf("expected session ttl, got %v", rc.lastTTL[s.sessionKey("ses_ttl")])
+	}
+	if rc.lastTTL[s.agentKeyKey(1)] != 0 {
+		t.Fatal("expected agent keys to never expire")
+	}
+	_ = s.SetUserSession(1, "ses_ttl")
+	for _, key := range []string{s.userSessionKey(1), s.recentSessionsKey(1), s.sessionUsersKey("ses_ttl")} {
+		if rc.lastTTL[key] != time.Hour {
+			t.Fatalf("expected %s to expire with the session ttl, got %v", key, rc.lastTTL[key])
+		}
+	}
+
+	rc.now = rc.now.Add(2 * time.Hour)
+	if _, _, ok := s.GetSession("ses_ttl"); ok {
+		t.Fatal("expected session mapping to expire")
+	}
+	if _, ok := s.GetUserSession(1); ok || len(s.ListUserSessions(1)) != 0 {
+		t.Fatal("expected the selection and recent sessions to expire")
+	}
+	if users, _ := s.KnownUsers(); len(users) != 1 || users[0] != 1 {
+		t.Fatalf("expected the paired user still listed, got %v", users)
+	}
+	if key, ok := s.GetUserAgentKey(1); !ok || key != "key" {
+		t.Fatalf("expected agent key to survive, got %q ok=%v", key, ok)
+	}
+
+	s.SetSessionTTL(-time.Second)
+	if s.sessionTTL != 0 {
+		t.Fatalf("expected negative ttl to clamp to zero, got %v", s.sessionTTL)
+	}
+}
+
+func TestRedisStore_UserValues(t *testing.T) {
+	s := NewRedisStore(newFakeRedis())
+
+	if _, ok := s.GetUserAgentKey(7); ok {
+		t.Fatal("expected no agent key before set")
+	}
+	_ = s.SetUserAgentKey(7, "agent-key")
+	if key, ok := s.GetUserAgentKey(7); !ok || key != "agent-key" {
+		t.Fatalf("GetUserAgentKey unexpected: %q ok=%v", key, ok)
+	}
+
+	_ = s.SetPairingCode("7", "PAIR-ABCD2345")
+	if code, ok := s.GetPairingCode("7"); !ok || code != "PAIR-ABCD2345" {
+		t.Fatalf("GetPairingCode unexpected: %q ok=%v", code, ok)
+	}
+	_ = s.SetUserAgentKey(7, "")
+	_ = s.SetPairingCode("7", "")
+	if _, ok := s.GetUserAgentKey(7); ok {
+		t.Fatal("expected agent key cleared")
+	}
+	if _, ok := s.GetPairingCode("7"); ok {
+		t.Fatal("expected pairing code cleared")
+	}
+
+	_ = s.SetUserModel(7, "anthropic/claude-sonnet")
+	if model, ok := s.GetUserModel(7); !ok || model != "anthropic/claude-sonnet" {
+		t.Fatalf("GetUserModel unexpected: %q ok=%v", model, ok)
+	}
+	_ = s.SetUserModel(7, "")
+	if _, ok := s.GetUserModel(7); ok {
+		t.Fatal("expected model cleared")
+	}
+}
+
+func TestRedisStore_KnownUsers(t *testing.T) {
+	f := newFakeRedis()
+	s := NewRedisStore(f)
+	_ = s.SetUserSession(3, "ses_1")
+	_ = s.SetUserSession(3, "ses_2")
+	_ = s.SetUserAgentKey(3, "key-3")
+	_ = s.SetUserAgentKey(1, "key-1")
+	_ = s.SetUserAgentKey(2, "key-2")
+	_ = s.SetUserAgentKey(2, "")
+
+	users, err := s.KnownUsers()
+	if err != nil || len(users) != 2 || users[0] != 1 || users[1] != 3 {
+		t.Fatalf("expected users [1 3], got %v err=%v", users, err)
+	}
+	if listed := f.lists[redisKeyPrefix+"users"]; len(listed) != 3 {
+		t.Fatalf("expected each user listed once, got %v", listed)
+	}
+	if listed := f.lists[s.sessionUsersKey("ses_1")]; len(listed) != 1 {
+		t.Fatalf("expected one entry per user of a session, got %v", listed)
+	}
+}
+
+func TestRedisStore_SessionUsersBounded(t *testing.T) {
+	f := newFakeRedis()
+	s := NewRedisStore(f)
+	for i := 0; i < maxSessionUsers+5; i++ {
+		_ = s.SetUserSession(int64(i), "ses_busy")
+		_ = s.SetUserSession(int64(i), "ses_busy")
+	}
+	if listed := f.lists[s.sessionUsersKey("ses_busy")]; len(listed) != maxSessionUsers || listed[0] != fmt.Sprint(maxSessionUsers+4) {
+		t.Fatalf("expected the newest %d users kept once each, got %d", maxSessionUsers, len(listed))
+	}
+	if _, err := f.Eval(context.Background(), "return 1", nil); err == nil {
+		t.Fatal("expected unsupported script error from the fake")
+	}
+}
+
+func TestRedisStore_ListUserSessions(t *testing.T) {
+	s := NewRedisStore(newFakeRedis())
+	if got := s.ListUserSessions(1); len(got) != 0 {
+		t.Fatalf("expected no recent sessions, got %v", got)
+	}
+	for _, sid := range []string{"ses_1", "ses_2", "ses_3", "ses_4", "ses_5", "ses_6", "ses_3"} {
+		_ = s.SetUserSession(1, sid)
+	}
+	got := s.ListUserSessions(1)
+	if strings.Join(got, ",") != "ses_3,ses_6,ses_5,ses_4,ses_2" {
+		t.Fatalf("expected most recent first and bounded, got %v", got)
+	}
+
+	_ = s.DeleteSession("ses_6")
+	got = s.ListUserSessions(1)
+	if strings.Join(got, ",") != "ses_3,ses_5,ses_4,ses_2" {
+		t.Fatalf("expected deleted session dropped, got %v", got)
+	}
+}